@@ -0,0 +1,348 @@
+package ixxatvci3
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Backend is a CAN interface driver behind the package functions.
+// The native backend is VCI3 on Windows and SocketCAN on Linux;
+// other backends (e.g. SLCAN serial adapters) are assigned to device numbers with UseBackend.
+// Methods have the same meaning and results as the package functions of the same name.
+// Bit 31 of msgid marks 29-bit frames in Send and Receive.
+type Backend interface {
+	SelectDevice(userselect bool, devnum uint8) (vcierr uint32)
+	SetOpMode(devnum uint8, mode OpMode) (vcierr uint32)
+	OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32)
+	OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []BitrateRegisterPair) (detected BitrateRegisterPair, err error)
+	Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32)
+	Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8)
+	GetStatus(devnum uint8) (status CANChanStatus, vcierr uint32)
+	CloseDevice(devnum uint8) (vcierr uint32)
+}
+
+// OpModeReporter is implemented by backends knowing the operating modes a device supports.
+type OpModeReporter interface {
+	SupportedOpModes(devnum uint8) (modes OpMode, vcierr uint32)
+}
+
+// ErrorFrameCounter is implemented by backends counting received error frames.
+type ErrorFrameCounter interface {
+	ErrorFrameCounts(devnum uint8) (counts ErrorFrameCounts, vcierr uint32)
+}
+
+// TimeoutSender is implemented by backends limiting the time a send waits for space in the transmit queue.
+type TimeoutSender interface {
+	SendTimeout(devnum uint8, msgid uint32, rtr bool, msgdata []byte, timeout time.Duration) (vcierr uint32)
+}
+
+// TxEchoer is implemented by backends able to receive their own sent frames after transmission.
+type TxEchoer interface {
+	SetTxEcho(devnum uint8, echo bool) (vcierr uint32)
+}
+
+// FifoConfigurer is implemented by backends with configurable channel buffers.
+type FifoConfigurer interface {
+	SetFifoConfig(devnum uint8, cfg FifoConfig) (vcierr uint32)
+	FifoStatus(devnum uint8) (status FifoStatus, vcierr uint32)
+}
+
+// AccessController is implemented by backends with channel access modes.
+type AccessController interface {
+	SetAccessMode(devnum uint8, mode AccessMode) (vcierr uint32)
+	Ownership(devnum uint8) (ownership Ownership, vcierr uint32)
+}
+
+// InterfaceNamer is implemented by backends with named channels, e.g. SocketCAN network interfaces.
+type InterfaceNamer interface {
+	SetInterfaceName(devnum uint8, name string) (vcierr uint32)
+}
+
+var (
+	muBackends sync.RWMutex
+	backends   = make(map[uint8]Backend)
+	factories  = map[string]BackendFactory{
+		"native": func(BackendConfig) (Backend, error) { return native, nil },
+	}
+)
+
+// BackendConfig is the connection of a backend created by NewBackend.
+type BackendConfig struct {
+	Address string // serial port of an SLCAN adapter, host:port of a remote server
+	Token   string // authentication token of a remote server
+}
+
+// BackendFactory creates a backend from its configuration.
+type BackendFactory func(cfg BackendConfig) (Backend, error)
+
+// RegisterBackend makes NewBackend create backends named name with f.
+// Backend packages register themselves when imported: "slcan" and "remote".
+func RegisterBackend(name string, f BackendFactory) {
+	muBackends.Lock()
+	defer muBackends.Unlock()
+	factories[name] = f
+}
+
+// NewBackend creates a backend registered as name, "native" is NativeBackend.
+// It is for selecting the backend by configuration, e.g. the Backend field of candev.Config.
+func NewBackend(name string, cfg BackendConfig) (b Backend, err error) {
+	muBackends.RLock()
+	f, ok := factories[name]
+	muBackends.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (registered: %v), import its package", name, BackendNames())
+	}
+	return f(cfg)
+}
+
+// BackendNames returns the sorted names of registered backends.
+func BackendNames() (names []string) {
+	muBackends.RLock()
+	defer muBackends.RUnlock()
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// UseBackend makes device number devnum use backend b instead of the native one.
+// Call it before OpenDevice. A nil b restores the native backend.
+func UseBackend(devnum uint8, b Backend) {
+	muBackends.Lock()
+	defer muBackends.Unlock()
+	if nil == b {
+		delete(backends, devnum)
+		return
+	}
+	backends[devnum] = b
+}
+
+// NativeBackend returns the platform backend: VCI3 on Windows, SocketCAN on Linux.
+func NativeBackend() Backend {
+	return native
+}
+
+func backendOf(devnum uint8) Backend {
+	muBackends.RLock()
+	defer muBackends.RUnlock()
+	if b, ok := backends[devnum]; ok {
+		return b
+	}
+	return native
+}
+
+// SelectDevice USB-to-CAN device select dialog.
+// assignnumber - number to assign to the device.
+// vcierr is 0 if there are no errors.
+func SelectDevice(assignnumber uint8) (vcierr uint32) {
+	vcierr = backendOf(assignnumber).SelectDevice(true, assignnumber)
+	logResult(assignnumber, "select device", vcierr)
+	return
+}
+
+// OpenDevice opens first USB-to-CAN device found.
+// assignnumber - number to assign to the device.
+// vcierr is 0 if there are no errors.
+func OpenDevice(assignnumber uint8) (vcierr uint32) {
+	vcierr = backendOf(assignnumber).SelectDevice(false, assignnumber)
+	logResult(assignnumber, "open device", vcierr)
+	return
+}
+
+// SetOperatingMode set operating mode at device with number "devnum".
+// Call it after SelectDevice but before OpenChannel.
+// opmode is parsed by ParseOpMode, comma is a separator, "" is 11-bit mode.
+// vcierr is VCI_E_INVALIDARG for unknown mode names, otherwise as of SetOpMode.
+//
+// Deprecated: use SetOpMode.
+func SetOperatingMode(devnum uint8, opmode string) (vcierr uint32) {
+	mode, err := ParseOpMode(opmode)
+	if err != nil {
+		vcierr = VCI_E_INVALIDARG
+		logResult(devnum, "set operating mode", vcierr, "mode", opmode, "error", err)
+		return
+	}
+	return SetOpMode(devnum, mode)
+}
+
+// SetOpMode sets operating mode at device with number "devnum".
+// Call it after SelectDevice but before OpenChannel.
+// 11-bit mode is a default.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the device does not support a mode of the set,
+// see SupportedOpModes; 0 if there are no errors.
+func SetOpMode(devnum uint8, mode OpMode) (vcierr uint32) {
+	if supported, e := SupportedOpModes(devnum); VCI_OK == e && mode&^supported != 0 {
+		vcierr = VCI_E_NOT_IMPLEMENTED
+		logResult(devnum, "set operating mode", vcierr, "mode", mode, "unsupported", mode&^supported)
+		return
+	}
+	vcierr = backendOf(devnum).SetOpMode(devnum, mode)
+	logResult(devnum, "set operating mode", vcierr, "mode", mode)
+	return
+}
+
+// SupportedOpModes returns the operating modes device devnum supports. Call it after SelectDevice.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend does not know them.
+func SupportedOpModes(devnum uint8) (modes OpMode, vcierr uint32) {
+	if r, ok := backendOf(devnum).(OpModeReporter); ok {
+		return r.SupportedOpModes(devnum)
+	}
+	vcierr = VCI_E_NOT_IMPLEMENTED
+	return
+}
+
+// OpenChannel opens a channel on a previously opened device with devnum number, and btr0 and btr1 speed parameters.
+// 25 kbps is 0x1F 0x16.
+// 125 кб/с is 0x03 0x1C.
+// vcierr is 0 if there are no errors.
+func OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
+	vcierr = backendOf(devnum).OpenChannel(devnum, btr0, btr1)
+	logResult(devnum, "open channel", vcierr, "bitrate", BitrateRegisterPair{Btr0: btr0, Btr1: btr1})
+	return
+}
+
+// OpenChannelDetectBitrate opens a channel on the previously opened device with devnum number, and tries to determine the bitrate in the CAN channel.
+// The bitrate is determined from the number of possible ones, specified through the bitrate array with several pairs of values for the btr0 and btr1 registers.
+// If the channel is open and the bitrate is defined, a pair of values btr0, btr1 is returned.
+func OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []BitrateRegisterPair) (detected BitrateRegisterPair, err error) {
+	detected, err = backendOf(devnum).OpenChannelDetectBitrate(devnum, timeout, bitrate)
+	if err != nil {
+		loggerOf(devnum).Error("detect bitrate failed", append(deviceFields(devnum), "op", "detect bitrate", "error", err)...)
+	} else {
+		logResult(devnum, "detect bitrate", VCI_OK, "bitrate", detected)
+	}
+	return
+}
+
+// Send sends a data packet to device devnum.
+// msgid - Identifier, 29-bit frame if bit 31 is set or the identifier is above 0x7FF.
+// rtr - Request flag, default value is false.
+// msgdata - An array of 1 to 8 bytes. If rtr = true only its length is used, as the DLC of the remote frame.
+// vcierr is 0 if there are no errors.
+func Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
+	vcierr = backendOf(devnum).Send(devnum, msgid, rtr, msgdata)
+	logSend(devnum, vcierr, msgid, rtr, msgdata)
+	return
+}
+
+// SendTimeout is Send waiting at most timeout for space in the transmit queue.
+// A zero timeout does not wait, a negative one waits forever like Send.
+// vcierr is VCI_E_TXQUEUE_FULL or VCI_E_TIMEOUT if the frame is not queued.
+// Backends without TimeoutSender wait like Send.
+func SendTimeout(devnum uint8, msgid uint32, rtr bool, msgdata []byte, timeout time.Duration) (vcierr uint32) {
+	b := backendOf(devnum)
+	if s, ok := b.(TimeoutSender); ok {
+		vcierr = s.SendTimeout(devnum, msgid, rtr, msgdata, timeout)
+	} else {
+		vcierr = b.Send(devnum, msgid, rtr, msgdata)
+	}
+	logSend(devnum, vcierr, msgid, rtr, msgdata)
+	return
+}
+
+// SetInterfaceName makes device devnum use the channel name instead of the default one,
+// e.g. SocketCAN interface "vcan0" instead of "can<devnum>". Call it before OpenDevice.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend has no named channels.
+func SetInterfaceName(devnum uint8, name string) (vcierr uint32) {
+	vcierr = VCI_E_NOT_IMPLEMENTED
+	if n, ok := backendOf(devnum).(InterfaceNamer); ok {
+		vcierr = n.SetInterfaceName(devnum, name)
+	}
+	logResult(devnum, "set interface name", vcierr, "name", name)
+	return
+}
+
+// SetTxEcho makes device devnum receive its own frames once they are transmitted on the bus,
+// so senders can confirm transmission. Call it after OpenChannel.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend cannot echo frames.
+func SetTxEcho(devnum uint8, echo bool) (vcierr uint32) {
+	vcierr = VCI_E_NOT_IMPLEMENTED
+	if e, ok := backendOf(devnum).(TxEchoer); ok {
+		vcierr = e.SetTxEcho(devnum, echo)
+	}
+	logResult(devnum, "set tx echo", vcierr, "echo", echo)
+	return
+}
+
+// SetFifoConfig sets channel buffer sizes of device devnum.
+// Call it after SelectDevice but before OpenChannel.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend has no configurable buffers.
+func SetFifoConfig(devnum uint8, cfg FifoConfig) (vcierr uint32) {
+	vcierr = VCI_E_NOT_IMPLEMENTED
+	if f, ok := backendOf(devnum).(FifoConfigurer); ok {
+		vcierr = f.SetFifoConfig(devnum, cfg)
+	}
+	logResult(devnum, "set fifo config", vcierr, "config", fmt.Sprintf("%+v", cfg))
+	return
+}
+
+// GetFifoStatus returns the buffer sizes in effect after OpenChannel and the number of receive overruns.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend has no configurable buffers.
+func GetFifoStatus(devnum uint8) (status FifoStatus, vcierr uint32) {
+	if f, ok := backendOf(devnum).(FifoConfigurer); ok {
+		return f.FifoStatus(devnum)
+	}
+	vcierr = VCI_E_NOT_IMPLEMENTED
+	return
+}
+
+// SetAccessMode sets how OpenChannel accesses the channel and the controller of device devnum.
+// Call it after SelectDevice but before OpenChannel; CloseDevice restores AccessShared.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend does not support mode.
+func SetAccessMode(devnum uint8, mode AccessMode) (vcierr uint32) {
+	if a, ok := backendOf(devnum).(AccessController); ok {
+		vcierr = a.SetAccessMode(devnum, mode)
+	} else if mode != AccessShared {
+		vcierr = VCI_E_NOT_IMPLEMENTED
+	}
+	logResult(devnum, "set access mode", vcierr, "mode", mode)
+	return
+}
+
+// GetOwnership reports the access mode of device devnum and whether this process owns the controller.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend has no access modes.
+func GetOwnership(devnum uint8) (ownership Ownership, vcierr uint32) {
+	if a, ok := backendOf(devnum).(AccessController); ok {
+		return a.Ownership(devnum)
+	}
+	vcierr = VCI_E_NOT_IMPLEMENTED
+	return
+}
+
+// Receive receives a message from a device with number "devnum".
+// You need to call this function regularly so that the hardware message buffer does not overflow.
+// Blocking call if no CAN messages are received.
+// Bit 31 of msgid is set for 29-bit frames, so 29-bit identifiers up to 0x7FF are told from 11-bit ones.
+// May return: VCI_E_OK, VCI_E_TIMEOUT, VCI_E_NO_DATA, VCI_E_INVALIDARG
+func Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+	vcierr, msgid, rtr, msgdata, msgdatasize = backendOf(devnum).Receive(devnum)
+	if VCI_OK == vcierr && msgdatasize <= 8 {
+		traceFrame(devnum, "rx", vcierr, msgid, rtr, msgdata[:msgdatasize])
+	}
+	return
+}
+
+// GetStatus returns a structure containing various information about the connection status.
+func GetStatus(devnum uint8) (status CANChanStatus, vcierr uint32) {
+	return backendOf(devnum).GetStatus(devnum)
+}
+
+// CloseDevice close channel and free device with number "devnum".
+func CloseDevice(devnum uint8) (vcierr uint32) {
+	vcierr = backendOf(devnum).CloseDevice(devnum)
+	logResult(devnum, "close device", vcierr)
+	return
+}
+
+// GetErrorFrameCounts returns numbers of error frames received by device devnum since OpenDevice.
+// vcierr is VCI_E_NOT_IMPLEMENTED if the backend does not count error frames.
+func GetErrorFrameCounts(devnum uint8) (counts ErrorFrameCounts, vcierr uint32) {
+	if c, ok := backendOf(devnum).(ErrorFrameCounter); ok {
+		return c.ErrorFrameCounts(devnum)
+	}
+	vcierr = VCI_E_NOT_IMPLEMENTED
+	return
+}
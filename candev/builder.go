@@ -15,16 +15,55 @@ type builder interface {
 type Builder struct {
 	builder
 	dev             Device
+	backend         ixxatvci3.Backend
+	backendName     string
+	backendConfig   ixxatvci3.BackendConfig
 	speed           ixxatvci3.BitrateRegisterPair
 	wantBitrateList []ixxatvci3.BitrateRegisterPair
 	foundBitrate    ixxatvci3.BitrateRegisterPair
 	detectTimeout   time.Duration
-	mode            string
+	txTimeout       time.Duration
+	fifo            *ixxatvci3.FifoConfig
+	access          ixxatvci3.AccessMode
+	filters         []Filter
+	clock           func() time.Time
+	modeErr         error
+	ifname          string
+	mode            ixxatvci3.OpMode
 	selectDevice    bool
 	detectBitrate   bool
+	txEcho          bool
+	noTimestamps    bool
+	fd              bool
 	number          uint8
 }
 
+//clone returns a builder with the same configuration to open the device again.
+func (b *Builder) clone() *Builder {
+	return &Builder{
+		backend:         b.backend,
+		backendName:     b.backendName,
+		backendConfig:   b.backendConfig,
+		speed:           b.speed,
+		wantBitrateList: append([]ixxatvci3.BitrateRegisterPair(nil), b.wantBitrateList...),
+		detectTimeout:   b.detectTimeout,
+		txTimeout:       b.txTimeout,
+		fifo:            b.fifo,
+		access:          b.access,
+		filters:         b.filters,
+		clock:           b.clock,
+		ifname:          b.ifname,
+		modeErr:         b.modeErr,
+		mode:            b.mode,
+		selectDevice:    b.selectDevice,
+		detectBitrate:   b.detectBitrate,
+		txEcho:          b.txEcho,
+		noTimestamps:    b.noTimestamps,
+		fd:              b.fd,
+		number:          b.number,
+	}
+}
+
 //Get candev.Device
 func (b *Builder) Get() (dev *Device, err error) {
 	var vcierr uint32
@@ -37,9 +76,56 @@ func (b *Builder) Get() (dev *Device, err error) {
 		if nil == err {
 			dev = &b.dev
 			dev.deviceInit(b.number)
+			dev.txTimeout = b.txTimeout
+			dev.filters = b.filters
+			dev.noTimestamps = b.noTimestamps
+			dev.clock = b.clock
+			dev.bitrate = b.speed
+			if b.detectBitrate {
+				dev.bitrate = b.foundBitrate
+			}
 		}
 	}()
 
+	if b.modeErr != nil {
+		err = fmt.Errorf("candev.Builder:%s", b.modeErr.Error())
+		return
+	}
+	if b.fd {
+		err = fmt.Errorf("candev.Builder:CAN FD is not supported")
+		return
+	}
+	be := b.backend
+	named := nil == be && b.backendName != ""
+	if named {
+		if be, err = ixxatvci3.NewBackend(b.backendName, b.backendConfig); err != nil {
+			err = fmt.Errorf("candev.Builder:%s", err.Error())
+			return
+		}
+	}
+	opened := false
+	defer func() {
+		if nil == err && ixxatvci3.VCI_OK == vcierr {
+			return
+		}
+		if opened { //a device left open fails every retry with VCI_E_ALREADY_INITIALIZED
+			ixxatvci3.CloseDevice(b.number)
+		} else if named { //do not leak the port or connection
+			be.CloseDevice(b.number)
+		}
+		if named {
+			ixxatvci3.UseBackend(b.number, nil)
+		}
+	}()
+	if be != nil {
+		ixxatvci3.UseBackend(b.number, be)
+	}
+	if b.ifname != "" {
+		vcierr = ixxatvci3.SetInterfaceName(b.number, b.ifname)
+		if ixxatvci3.VCI_OK != vcierr {
+			return
+		}
+	}
 	if b.selectDevice {
 		vcierr = ixxatvci3.SelectDevice(b.number)
 	} else {
@@ -48,13 +134,33 @@ func (b *Builder) Get() (dev *Device, err error) {
 	if ixxatvci3.VCI_OK != vcierr {
 		return
 	}
-	if b.mode == "" {
-		b.mode = "11bit"
+	opened = true
+	if 0 == b.mode {
+		b.mode = ixxatvci3.OpModeStandard
+	}
+	vcierr = ixxatvci3.SetOpMode(b.number, b.mode)
+	if ixxatvci3.VCI_E_NOT_IMPLEMENTED == vcierr {
+		if supported, e := ixxatvci3.SupportedOpModes(b.number); ixxatvci3.VCI_OK == e {
+			vcierr = ixxatvci3.VCI_OK
+			err = fmt.Errorf("candev.Builder:operating mode %s is not supported by the device", b.mode&^supported)
+			return
+		}
 	}
-	vcierr = ixxatvci3.SetOperatingMode(b.number, b.mode)
 	if ixxatvci3.VCI_OK != vcierr {
 		return
 	}
+	if b.access != ixxatvci3.AccessShared {
+		vcierr = ixxatvci3.SetAccessMode(b.number, b.access)
+		if ixxatvci3.VCI_OK != vcierr {
+			return
+		}
+	}
+	if b.fifo != nil {
+		vcierr = ixxatvci3.SetFifoConfig(b.number, *b.fifo)
+		if ixxatvci3.VCI_OK != vcierr {
+			return
+		}
+	}
 	if b.detectBitrate {
 		if 0 == b.detectTimeout {
 			b.detectTimeout = 5 * time.Second
@@ -77,6 +183,12 @@ func (b *Builder) Get() (dev *Device, err error) {
 		}
 	} else {
 		vcierr = ixxatvci3.OpenChannel(b.number, b.speed.Btr0, b.speed.Btr1)
+		if ixxatvci3.VCI_OK != vcierr {
+			return
+		}
+	}
+	if b.txEcho {
+		vcierr = ixxatvci3.SetTxEcho(b.number, true)
 	}
 
 	return
@@ -88,6 +200,79 @@ func (b *Builder) Timeout(t time.Duration) *Builder {
 	return b
 }
 
+//TxTimeout limits the time Device.Send waits for space in the transmit queue.
+//Zero (default) waits forever.
+func (b *Builder) TxTimeout(t time.Duration) *Builder {
+	b.txTimeout = t
+	return b
+}
+
+//Fifo sets channel buffer sizes, see ixxatvci3.FifoConfig.
+//The sizes in effect are returned by ixxatvci3.GetFifoStatus.
+func (b *Builder) Fifo(cfg ixxatvci3.FifoConfig) *Builder {
+	b.fifo = &cfg
+	return b
+}
+
+//Access sets the channel access mode, ixxatvci3.AccessShared by default.
+//Use ixxatvci3.AccessMonitor to receive without touching a controller run by another application,
+//Device.Ownership tells whether this process owns the controller.
+func (b *Builder) Access(mode ixxatvci3.AccessMode) *Builder {
+	b.access = mode
+	return b
+}
+
+//Backend makes the device use backend be instead of the native one, see ixxatvci3.UseBackend.
+func (b *Builder) Backend(be ixxatvci3.Backend) *Builder {
+	b.backend, b.backendName = be, ""
+	return b
+}
+
+//NamedBackend makes Get create the backend registered as name with cfg, see ixxatvci3.NewBackend.
+//It is created by every Get, e.g. an SLCAN serial port is opened there, and closed again if Get fails.
+func (b *Builder) NamedBackend(name string, cfg ixxatvci3.BackendConfig) *Builder {
+	b.backend, b.backendName, b.backendConfig = nil, name, cfg
+	return b
+}
+
+//Interface sets the channel name, e.g. SocketCAN interface "vcan0" instead of "can<number>".
+func (b *Builder) Interface(name string) *Builder {
+	b.ifname = name
+	return b
+}
+
+//Filters makes the device deliver only messages accepted by filters, see MatchFilters.
+func (b *Builder) Filters(filters ...Filter) *Builder {
+	b.filters = append([]Filter(nil), filters...)
+	return b
+}
+
+//Timestamps sets Message.Time of received messages, true by default.
+//Without them receiving saves a clock read per message.
+func (b *Builder) Timestamps(on bool) *Builder {
+	b.noTimestamps = !on
+	return b
+}
+
+//Clock makes the device take timestamps of received messages from now instead of time.Now,
+//e.g. from the controllable clock of a test backend.
+func (b *Builder) Clock(now func() time.Time) *Builder {
+	b.clock = now
+	return b
+}
+
+//TxEcho makes the device receive its own messages once they are sent, see ixxatvci3.SetTxEcho.
+func (b *Builder) TxEcho(on bool) *Builder {
+	b.txEcho = on
+	return b
+}
+
+//FD requests a CAN FD channel. No backend supports CAN FD yet, so Get fails with it.
+func (b *Builder) FD(on bool) *Builder {
+	b.fd = on
+	return b
+}
+
 //Number set device number (for multi-device configuration).
 func (b *Builder) Number(number uint8) *Builder {
 	b.number = number
@@ -118,11 +303,22 @@ Possible values:
 "29bit" or "extended",
 "err" or "errframe",
 "listen" or "listenonly" or "listonly",
-"low" or "lowspeed".
-Default is "11bit".
+"low" or "lowspeed",
+"autobaud", "fd".
+Default is "11bit". Get fails for unknown names, see ixxatvci3.ParseOpMode,
+and for modes the device does not support.
 */
 func (b *Builder) Mode(mode string) *Builder {
-	b.mode = mode
+	b.mode, b.modeErr = 0, nil
+	if mode != "" {
+		b.mode, b.modeErr = ixxatvci3.ParseOpMode(mode)
+	}
+	return b
+}
+
+//OpMode set device mode flags, ixxatvci3.OpModeStandard by default.
+func (b *Builder) OpMode(mode ixxatvci3.OpMode) *Builder {
+	b.mode, b.modeErr = mode, nil
 	return b
 }
 
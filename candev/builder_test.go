@@ -0,0 +1,58 @@
+package candev_test
+
+import (
+	"testing"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+// singleOpen is a backend refusing to open a device twice, like the VCI.
+type singleOpen struct {
+	*cantest.Backend
+	open bool
+}
+
+func (s *singleOpen) SelectDevice(userselect bool, devnum uint8) (vcierr uint32) {
+	if s.open {
+		return ixxatvci3.VCI_E_ALREADY_INITIALIZED
+	}
+	if vcierr = s.Backend.SelectDevice(userselect, devnum); ixxatvci3.VCI_OK == vcierr {
+		s.open = true
+	}
+	return
+}
+
+func (s *singleOpen) CloseDevice(devnum uint8) uint32 {
+	s.open = false
+	return s.Backend.CloseDevice(devnum)
+}
+
+// TestGetClosesOnFailure checks that a failing Get closes the device, so Get can be retried.
+func TestGetClosesOnFailure(t *testing.T) {
+	failing := map[string]func(b *candev.Builder){
+		"mode":   func(b *candev.Builder) { b.OpMode(ixxatvci3.OpModeFD) },
+		"access": func(b *candev.Builder) { b.Access(ixxatvci3.AccessExclusive) },
+		"fifo":   func(b *candev.Builder) { b.Fifo(ixxatvci3.FifoConfig{RxFifoSize: 64}) },
+		"detect": func(b *candev.Builder) { b.Detect([]ixxatvci3.BitrateRegisterPair{ixxatvci3.Bitrate1000kbps}) },
+	}
+	for name, fail := range failing {
+		be := &singleOpen{Backend: cantest.New(nil)}
+		be.SetBusBitrate(ixxatvci3.Bitrate500kbps)
+		for i := 0; i < 2; i++ {
+			b := new(candev.Builder).Backend(be).Number(203).Speed(ixxatvci3.Bitrate500kbps)
+			fail(b)
+			if _, err := b.Get(); nil == err {
+				t.Fatalf("%s: Get succeeded", name)
+			} else if be.open {
+				t.Errorf("%s: device left open after %v", name, err)
+			}
+		}
+		dev, err := new(candev.Builder).Backend(be).Number(203).Speed(ixxatvci3.Bitrate500kbps).Get()
+		if err != nil {
+			t.Fatalf("%s: Get after failures: %v", name, err)
+		}
+		dev.Stop()
+	}
+}
@@ -4,15 +4,34 @@ import (
 	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/amdf/ixxatvci3"
 )
 
+//Counters are frame counters of a Device, safe for concurrent use unlike the Rcv* fields
+type Counters struct {
+	RxFrames uint64 // received frames
+	RxErrors uint64 // failed receive calls
+	TxFrames uint64 // sent frames
+	TxErrors uint64 // failed send calls
+}
+
 //Device is a USB-to-CAN device type
 type Device struct {
+	counters               Counters //first for 64-bit alignment of atomic counters
+	lastRxError            uint32
+	txTimeout              time.Duration
+	bitrate                ixxatvci3.BitrateRegisterPair
+	filters                []Filter
+	clock                  func() time.Time
+	rtr                    *rtrResponders
 	number                 uint8
-	stop                   bool
+	done                   chan struct{} //closed by Stop to end the reader threads
+	muStop                 sync.Mutex
+	readers                sync.WaitGroup
+	noTimestamps           bool
 	canMessagesChannel     chan Message
 	canAdditionalChannels  map[uint]chan Message
 	iChIndex               uint
@@ -33,29 +52,64 @@ type Message struct {
 	Ext  bool //true if 29-bit mode
 	Len  uint8
 	Data [8]byte
+	Time time.Time //reception time, zero for messages to send
+}
+
+// stopping reports whether Stop was called.
+func (dev *Device) stopping() bool {
+	select {
+	case <-dev.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (dev *Device) canReaderThread() {
-	for !dev.stop {
+	defer dev.readers.Done()
+	for !dev.stopping() {
 
-		var vcierr uint32
+		var vcierr, id uint32
 		var rxMsg Message
 
-		vcierr, rxMsg.ID, rxMsg.Rtr, rxMsg.Data, rxMsg.Len =
+		vcierr, id, rxMsg.Rtr, rxMsg.Data, rxMsg.Len =
 			ixxatvci3.Receive(dev.number)
+		rxMsg.ID, rxMsg.Ext = id&^(1<<31), id&(1<<31) != 0 //hi bit is 29-bit mode flag for ixxatvci3 package
 
-		if 0 == vcierr {
+		if dev.stopping() {
+			return
+		}
+		if 0 == vcierr && MatchFilters(dev.filters, rxMsg.ID) {
+			atomic.AddUint64(&dev.counters.RxFrames, 1)
 			dev.RcvOkCount++
+			if dev.clock != nil {
+				rxMsg.Time = dev.clock()
+			} else if !dev.noTimestamps {
+				rxMsg.Time = time.Now()
+			}
+			if rxMsg.Rtr {
+				dev.rtr.dispatch(rxMsg)
+			}
 
-			dev.canMessagesChannel <- rxMsg //blocking call
+			//blocking sends, ended by Stop
+			select {
+			case dev.canMessagesChannel <- rxMsg:
+			case <-dev.done:
+				return
+			}
 
 			//sending to additional channels
 			dev.muAddCh.Lock()
 			for _, addch := range dev.canAdditionalChannels {
-				addch <- rxMsg
+				select {
+				case addch <- rxMsg:
+				case <-dev.done:
+				}
 			}
 			dev.muAddCh.Unlock()
-		} else {
+		} else if 0 != vcierr {
+			atomic.AddUint64(&dev.counters.RxErrors, 1)
+			atomic.StoreUint32(&dev.lastRxError, vcierr)
 			dev.RcvErrCount++
 		}
 		runtime.Gosched()
@@ -64,7 +118,8 @@ func (dev *Device) canReaderThread() {
 
 //nonblocking background reading
 func (dev *Device) canBackgroundReaderThread() {
-	for !dev.stop {
+	defer dev.readers.Done()
+	for !dev.stopping() {
 		if dev.bReadCANBackground {
 			select {
 			case <-dev.canMessagesChannel:
@@ -156,7 +211,8 @@ func (dev *Device) GetMsgByIDAndSize(id uint32, size uint8, timeout time.Duratio
 	return
 }
 
-//GetMsgRTR waits for msg from CAN with id and RTR flag set
+//GetMsgRTR waits for msg from CAN with id and RTR flag set.
+//To answer remote requests use RespondRTR.
 func (dev *Device) GetMsgRTR(id uint32, timeout time.Duration) (ok bool, err error) {
 	if nil == dev {
 		err = fmt.Errorf("%s", "Device == nil")
@@ -189,6 +245,7 @@ func (dev *Device) GetMsgRTR(id uint32, timeout time.Duration) (ok bool, err err
 
 //Run starts receiving
 func (dev *Device) Run() {
+	dev.readers.Add(2)
 	go dev.canReaderThread()
 	go dev.canBackgroundReaderThread()
 }
@@ -197,8 +254,9 @@ func (dev *Device) deviceInit(devNum uint8) {
 	dev.number = devNum
 	dev.bReadCANBackground = true
 	dev.canMessagesChannel = make(chan Message)
-	dev.stop = false
+	dev.done = make(chan struct{})
 	dev.canAdditionalChannels = make(map[uint]chan Message)
+	dev.rtr = newRTRResponders()
 }
 
 //Init first USB-to-CAN device found
@@ -251,16 +309,36 @@ func (dev *Device) InitSelectDetectBitrate(devNum uint8, timeout time.Duration,
 	return
 }
 
-//Stop stops receiving
+//Stop stops receiving.
+//The reader threads are signalled first, so none of them holds muAddCh blocked on a full channel,
+//then Stop waits for them up to 2 seconds: a reader blocked in Receive ends when the device is closed.
 func (dev *Device) Stop() {
 	if nil == dev {
 		return
 	}
-	dev.stop = true
-	time.Sleep(2 * time.Second)
-	for _, addch := range dev.canAdditionalChannels {
+	dev.muStop.Lock()
+	if dev.done != nil && !dev.stopping() {
+		close(dev.done)
+	}
+	dev.muStop.Unlock()
+	if dev.rtr != nil {
+		dev.rtr.stop()
+	}
+	finished := make(chan struct{})
+	go func() {
+		dev.readers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+	}
+	dev.muAddCh.Lock()
+	for idx, addch := range dev.canAdditionalChannels {
 		close(addch)
+		delete(dev.canAdditionalChannels, idx)
 	}
+	dev.muAddCh.Unlock()
 	ixxatvci3.CloseDevice(dev.number)
 }
 
@@ -278,31 +356,76 @@ If msg.ID <= 0x7FF, msg is 11-bit.
 If msg.ID > 0x7FF, msg is 29-bit.
 
 For sending small IDs in 29 bit mode use SendExt.
+Send waits for space in the transmit queue for the Builder.TxTimeout time,
+forever by default, and returns ErrTxTimeout after that.
+msg.Len above 8 returns ErrInvalidFrame.
 */
 func (dev *Device) Send(msg Message) (err error) {
 	if nil == dev {
 		err = fmt.Errorf("%s", "null ptr")
 		return
 	}
-	var vcierr uint32
-	if !msg.Ext {
-		vcierr = ixxatvci3.Send(dev.number, msg.ID, msg.Rtr, msg.Data[0:msg.Len])
-	} else {
-		//hi bit is 29-bit mode flag for ixxatvci3 package
-		vcierr = ixxatvci3.Send(dev.number, msg.ID|(1<<31), msg.Rtr, msg.Data[0:msg.Len])
+	timeout := dev.txTimeout
+	if 0 == timeout {
+		timeout = -1
 	}
+	return dev.send(msg, timeout)
+}
+
+func (dev *Device) send(msg Message, timeout time.Duration) error {
+	return dev.count(dev.transmit(msg, timeout))
+}
+
+//Counters returns frame counters of the device.
+func (dev *Device) Counters() (c Counters) {
+	if nil == dev {
+		return
+	}
+	c.RxFrames = atomic.LoadUint64(&dev.counters.RxFrames)
+	c.RxErrors = atomic.LoadUint64(&dev.counters.RxErrors)
+	c.TxFrames = atomic.LoadUint64(&dev.counters.TxFrames)
+	c.TxErrors = atomic.LoadUint64(&dev.counters.TxErrors)
+	return
+}
+
+//LastRxError returns the last error code of ixxatvci3.Receive, VCI_OK if there were no errors.
+//Note that an empty receive queue is an error on Windows.
+func (dev *Device) LastRxError() uint32 {
+	if nil == dev {
+		return ixxatvci3.VCI_OK
+	}
+	return atomic.LoadUint32(&dev.lastRxError)
+}
+
+//Ownership reports the access mode and whether this process owns the controller.
+func (dev *Device) Ownership() (ownership ixxatvci3.Ownership, err error) {
+	if nil == dev {
+		err = fmt.Errorf("%s", "null ptr")
+		return
+	}
+	ownership, vcierr := ixxatvci3.GetOwnership(dev.number)
 	if ixxatvci3.VCI_OK != vcierr {
 		err = fmt.Errorf("%s", ixxatvci3.GetErrorText(vcierr))
 	}
 	return
 }
 
+//Number returns the device number for ixxatvci3 package functions.
+func (dev *Device) Number() uint8 {
+	if nil == dev {
+		return 0
+	}
+	return dev.number
+}
+
 //GetMsgChannelCopy returns channel with all messages received from CAN.
 //idx - channel index for use with CloseMsgChannelCopy().
 func (dev *Device) GetMsgChannelCopy() (ch <-chan Message, idx uint) {
 	if nil == dev {
 		return
 	}
+	dev.muAddCh.Lock()
+	defer dev.muAddCh.Unlock()
 	dev.canAdditionalChannels[dev.iChIndex] = make(chan Message)
 	ch = dev.canAdditionalChannels[dev.iChIndex]
 	idx = dev.iChIndex
@@ -318,11 +441,20 @@ func (dev *Device) CloseMsgChannelCopy(idx uint) {
 		return
 	}
 
-	_, ok := dev.canAdditionalChannels[idx]
+	dev.muAddCh.Lock()
+	addch, ok := dev.canAdditionalChannels[idx]
+	dev.muAddCh.Unlock()
 	if ok {
+		//the reader thread may be blocked sending to the channel while holding muAddCh
+		go func() {
+			for range addch {
+			}
+		}()
 		dev.muAddCh.Lock()
-		close(dev.canAdditionalChannels[idx])
-		delete(dev.canAdditionalChannels, idx)
+		if _, ok = dev.canAdditionalChannels[idx]; ok {
+			close(addch)
+			delete(dev.canAdditionalChannels, idx)
+		}
 		dev.muAddCh.Unlock()
 	}
 }
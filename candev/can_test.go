@@ -0,0 +1,40 @@
+package candev_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+// TestStopWithUnreadCopy stops a device while its reader is blocked on a channel copy nobody reads.
+func TestStopWithUnreadCopy(t *testing.T) {
+	b := cantest.New(nil)
+	dev, err := b.Builder().Number(202).Speed(ixxatvci3.Bitrate500kbps).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Run()
+	dev.GetMsgChannelCopy()
+
+	b.Inject(0, candev.Message{ID: 0x100}, candev.Message{ID: 0x101})
+	for deadline := time.Now().Add(time.Second); dev.Counters().RxFrames < 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no frame received")
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		dev.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked")
+	}
+	dev.Stop() // stopping again is harmless
+}
@@ -0,0 +1,239 @@
+package candev
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+// Config is the configuration of a device for a Builder, e.g. a section of a YAML, JSON or TOML file
+// decoded with the field names of the tags, or environment variables loaded by LoadEnv.
+// Empty fields keep the Builder defaults.
+type Config struct {
+	// Backend is a name registered with ixxatvci3.RegisterBackend: "native" (default),
+	// "slcan" or "remote" if their packages are imported.
+	Backend string `json:"backend" yaml:"backend" toml:"backend" env:"IXXAT_BACKEND"`
+	// Address is the serial port of an SLCAN adapter or host:port of a remote server.
+	Address string `json:"address" yaml:"address" toml:"address" env:"IXXAT_ADDRESS"`
+	// Token authenticates at a remote server.
+	Token string `json:"token" yaml:"token" toml:"token" env:"IXXAT_TOKEN"`
+	// Interface is the channel name, e.g. SocketCAN interface "vcan0", "can<device>" by default.
+	Interface string `json:"interface" yaml:"interface" toml:"interface" env:"IXXAT_INTERFACE"`
+	// Device is the device number.
+	Device uint8 `json:"device" yaml:"device" toml:"device" env:"IXXAT_DEVICE"`
+	// Select shows the device selection dialog.
+	Select bool `json:"select" yaml:"select" toml:"select" env:"IXXAT_SELECT"`
+	// Bitrate is "500k", "1M", BTR0:BTR1 in hex ("00:1C"), a comma separated list to detect from
+	// or "auto" to detect a common bitrate. Required unless Access is "monitor".
+	Bitrate string `json:"bitrate" yaml:"bitrate" toml:"bitrate" env:"IXXAT_BITRATE"`
+	// DetectTimeout limits bitrate detection, "5s" by default.
+	DetectTimeout string `json:"detect_timeout" yaml:"detect_timeout" toml:"detect_timeout" env:"IXXAT_DETECT_TIMEOUT"`
+	// Mode is the operating mode, see Builder.Mode.
+	Mode string `json:"mode" yaml:"mode" toml:"mode" env:"IXXAT_MODE"`
+	// Access is "shared" (default), "exclusive" or "monitor".
+	Access string `json:"access" yaml:"access" toml:"access" env:"IXXAT_ACCESS"`
+	// Filters are ParseFilters filters, "123:7FF,~200:700"; the environment variable is comma separated.
+	Filters []string `json:"filters" yaml:"filters" toml:"filters" env:"IXXAT_FILTERS"`
+	// Timestamps is "local" (default, reception time) or "none".
+	Timestamps string `json:"timestamps" yaml:"timestamps" toml:"timestamps" env:"IXXAT_TIMESTAMPS"`
+	// TxTimeout limits the wait of Send for transmit queue space, e.g. "100ms"; forever by default.
+	TxTimeout string `json:"tx_timeout" yaml:"tx_timeout" toml:"tx_timeout" env:"IXXAT_TX_TIMEOUT"`
+	// TxEcho receives sent messages once they are on the bus.
+	TxEcho bool `json:"tx_echo" yaml:"tx_echo" toml:"tx_echo" env:"IXXAT_TX_ECHO"`
+	// FD requests CAN FD, which no backend supports yet.
+	FD bool `json:"fd" yaml:"fd" toml:"fd" env:"IXXAT_FD"`
+
+	// Buffers, see ixxatvci3.FifoConfig; all zero keeps the backend defaults.
+	RxFifoSize   uint16 `json:"rx_fifo_size" yaml:"rx_fifo_size" toml:"rx_fifo_size" env:"IXXAT_RX_FIFO_SIZE"`
+	RxThreshold  uint16 `json:"rx_threshold" yaml:"rx_threshold" toml:"rx_threshold" env:"IXXAT_RX_THRESHOLD"`
+	TxFifoSize   uint16 `json:"tx_fifo_size" yaml:"tx_fifo_size" toml:"tx_fifo_size" env:"IXXAT_TX_FIFO_SIZE"`
+	TxThreshold  uint16 `json:"tx_threshold" yaml:"tx_threshold" toml:"tx_threshold" env:"IXXAT_TX_THRESHOLD"`
+	SocketRcvBuf int    `json:"socket_rcvbuf" yaml:"socket_rcvbuf" toml:"socket_rcvbuf" env:"IXXAT_SOCKET_RCVBUF"`
+	SocketSndBuf int    `json:"socket_sndbuf" yaml:"socket_sndbuf" toml:"socket_sndbuf" env:"IXXAT_SOCKET_SNDBUF"`
+	TxQueueLen   int    `json:"txqueuelen" yaml:"txqueuelen" toml:"txqueuelen" env:"IXXAT_TXQUEUELEN"`
+}
+
+// ConfigError is an invalid Config field or environment variable.
+type ConfigError struct {
+	Field string // tag name of the field ("bitrate") or the environment variable ("IXXAT_BITRATE")
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("candev.Config: %s: %v", e.Field, e.Err)
+}
+
+// ConfigFromEnv returns the configuration from IXXAT_* environment variables.
+func ConfigFromEnv() (cfg Config, err error) {
+	err = cfg.LoadEnv()
+	return
+}
+
+// LoadEnv overrides fields with the IXXAT_* environment variables that are set,
+// e.g. IXXAT_BACKEND and IXXAT_BITRATE, see the env tags of Config.
+func (cfg *Config) LoadEnv() error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		s, ok := os.LookupEnv(name)
+		if "" == name || !ok {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(s)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return &ConfigError{name, fmt.Errorf("invalid boolean %q", s)}
+			}
+			f.SetBool(b)
+		case reflect.Uint8, reflect.Uint16:
+			n, err := strconv.ParseUint(s, 0, f.Type().Bits())
+			if err != nil {
+				return &ConfigError{name, fmt.Errorf("invalid number %q", s)}
+			}
+			f.SetUint(n)
+		case reflect.Int:
+			n, err := strconv.ParseInt(s, 0, 0)
+			if err != nil {
+				return &ConfigError{name, fmt.Errorf("invalid number %q", s)}
+			}
+			f.SetInt(n)
+		case reflect.Slice:
+			f.Set(reflect.ValueOf(strings.Split(s, ",")))
+		}
+	}
+	return nil
+}
+
+// Builder validates the configuration and returns a builder for it.
+// A non-native backend is created by Get of the builder, so nothing is opened here.
+func (cfg Config) Builder() (b *Builder, err error) {
+	b = new(Builder).Number(cfg.Device).SelectDevice(cfg.Select).TxEcho(cfg.TxEcho).FD(cfg.FD)
+
+	access := ixxatvci3.AccessShared
+	if cfg.Access != "" {
+		if access, err = ixxatvci3.ParseAccessMode(cfg.Access); err != nil {
+			return nil, &ConfigError{"access", err}
+		}
+	}
+	b.Access(access)
+
+	switch {
+	case "" == cfg.Bitrate && access != ixxatvci3.AccessMonitor:
+		return nil, &ConfigError{"bitrate", fmt.Errorf("required")}
+	case "" == cfg.Bitrate:
+	case "auto" == cfg.Bitrate:
+		b.AutoDetect()
+	case strings.Contains(cfg.Bitrate, ","):
+		var list []ixxatvci3.BitrateRegisterPair
+		for _, s := range strings.Split(cfg.Bitrate, ",") {
+			bitrate, err := ixxatvci3.ParseBitrate(strings.TrimSpace(s))
+			if err != nil {
+				return nil, &ConfigError{"bitrate", err}
+			}
+			list = append(list, bitrate)
+		}
+		b.Detect(list)
+	default:
+		bitrate, err := ixxatvci3.ParseBitrate(cfg.Bitrate)
+		if err != nil {
+			return nil, &ConfigError{"bitrate", err}
+		}
+		b.Speed(bitrate)
+	}
+
+	if b.detectTimeout, err = parseConfigDuration("detect_timeout", cfg.DetectTimeout); err != nil {
+		return nil, err
+	}
+	if b.txTimeout, err = parseConfigDuration("tx_timeout", cfg.TxTimeout); err != nil {
+		return nil, err
+	}
+
+	if cfg.Mode != "" {
+		mode, err := ixxatvci3.ParseOpMode(cfg.Mode)
+		if err != nil {
+			return nil, &ConfigError{"mode", err}
+		}
+		b.OpMode(mode)
+	}
+
+	for _, s := range cfg.Filters {
+		filters, err := ParseFilters(s)
+		if err != nil {
+			return nil, &ConfigError{"filters", err}
+		}
+		b.filters = append(b.filters, filters...)
+	}
+
+	switch cfg.Timestamps {
+	case "", "local":
+	case "none":
+		b.Timestamps(false)
+	default:
+		return nil, &ConfigError{"timestamps", fmt.Errorf("%q is not local or none", cfg.Timestamps)}
+	}
+
+	fifo := ixxatvci3.FifoConfig{RxFifoSize: cfg.RxFifoSize, RxThreshold: cfg.RxThreshold,
+		TxFifoSize: cfg.TxFifoSize, TxThreshold: cfg.TxThreshold,
+		SocketRcvBuf: cfg.SocketRcvBuf, SocketSndBuf: cfg.SocketSndBuf, TxQueueLen: cfg.TxQueueLen}
+	switch {
+	case fifo.RxFifoSize != 0 && fifo.RxThreshold > fifo.RxFifoSize:
+		return nil, &ConfigError{"rx_threshold", fmt.Errorf("%d exceeds rx_fifo_size %d", fifo.RxThreshold, fifo.RxFifoSize)}
+	case fifo.TxFifoSize != 0 && fifo.TxThreshold > fifo.TxFifoSize:
+		return nil, &ConfigError{"tx_threshold", fmt.Errorf("%d exceeds tx_fifo_size %d", fifo.TxThreshold, fifo.TxFifoSize)}
+	case fifo.SocketRcvBuf < 0:
+		return nil, &ConfigError{"socket_rcvbuf", fmt.Errorf("negative")}
+	case fifo.SocketSndBuf < 0:
+		return nil, &ConfigError{"socket_sndbuf", fmt.Errorf("negative")}
+	case fifo.TxQueueLen < 0:
+		return nil, &ConfigError{"txqueuelen", fmt.Errorf("negative")}
+	}
+	if fifo != (ixxatvci3.FifoConfig{}) {
+		b.Fifo(fifo)
+	}
+
+	b.Interface(cfg.Interface)
+	if cfg.Backend != "" && cfg.Backend != "native" {
+		if "" == cfg.Address {
+			return nil, &ConfigError{"address", fmt.Errorf("required by backend %q", cfg.Backend)}
+		}
+		if !backendRegistered(cfg.Backend) {
+			return nil, &ConfigError{"backend", fmt.Errorf("unknown backend %q (registered: %v), import its package",
+				cfg.Backend, ixxatvci3.BackendNames())}
+		}
+		b.NamedBackend(cfg.Backend, ixxatvci3.BackendConfig{Address: cfg.Address, Token: cfg.Token})
+	}
+	return
+}
+
+func backendRegistered(name string) bool {
+	for _, s := range ixxatvci3.BackendNames() {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfigDuration parses duration field s, empty is zero.
+func parseConfigDuration(field, s string) (d time.Duration, err error) {
+	if "" == s {
+		return
+	}
+	if d, err = time.ParseDuration(s); err != nil {
+		return 0, &ConfigError{field, err}
+	}
+	if d < 0 {
+		return 0, &ConfigError{field, fmt.Errorf("negative")}
+	}
+	return
+}
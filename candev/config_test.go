@@ -0,0 +1,173 @@
+package candev
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+func init() {
+	ixxatvci3.RegisterBackend("cfgtest", func(ixxatvci3.BackendConfig) (ixxatvci3.Backend, error) {
+		return nil, nil
+	})
+}
+
+// setEnv sets environment variables and returns a function restoring them.
+func setEnv(vars map[string]string) (restore func()) {
+	old := make(map[string]*string)
+	for name, value := range vars {
+		if s, ok := os.LookupEnv(name); ok {
+			old[name] = &s
+		} else {
+			old[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, s := range old {
+			if nil == s {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *s)
+			}
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	restore := setEnv(map[string]string{
+		"IXXAT_BACKEND":      "slcan",
+		"IXXAT_ADDRESS":      "/dev/ttyACM0",
+		"IXXAT_DEVICE":       "0x03",
+		"IXXAT_SELECT":       "true",
+		"IXXAT_BITRATE":      "250k",
+		"IXXAT_FILTERS":      "123:7FF,~200:700",
+		"IXXAT_RX_FIFO_SIZE": "1024",
+		"IXXAT_TXQUEUELEN":   "-1",
+	})
+	defer restore()
+	cfg := Config{Bitrate: "500k", Mode: "11bit"}
+	if err := cfg.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{Backend: "slcan", Address: "/dev/ttyACM0", Device: 3, Select: true, Bitrate: "250k",
+		Mode: "11bit", Filters: []string{"123:7FF", "~200:700"}, RxFifoSize: 1024, TxQueueLen: -1}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadEnv = %+v, want %+v", cfg, want)
+	}
+
+	invalid := []struct {
+		name, value string
+	}{
+		{"IXXAT_SELECT", "maybe"},
+		{"IXXAT_DEVICE", "256"},
+		{"IXXAT_RX_FIFO_SIZE", "-1"},
+		{"IXXAT_SOCKET_RCVBUF", "1k"},
+	}
+	for _, tt := range invalid {
+		restore := setEnv(map[string]string{tt.name: tt.value})
+		_, err := ConfigFromEnv()
+		restore()
+		if e, ok := err.(*ConfigError); !ok || e.Field != tt.name {
+			t.Errorf("%s=%s: %v", tt.name, tt.value, err)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	const file = `{
+	"backend": "cfgtest",
+	"address": "host:1234",
+	"token": "secret",
+	"device": 2,
+	"bitrate": "125k,250k, 500k",
+	"detect_timeout": "2s",
+	"tx_timeout": "100ms",
+	"mode": "11bit,29bit",
+	"access": "exclusive",
+	"filters": ["100:700", "~7DF"],
+	"timestamps": "none",
+	"rx_fifo_size": 256,
+	"rx_threshold": 1
+}`
+	var cfg Config
+	if err := json.Unmarshal([]byte(file), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	b, err := cfg.Builder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.backendName != "cfgtest" || b.backendConfig != (ixxatvci3.BackendConfig{Address: "host:1234", Token: "secret"}) {
+		t.Errorf("backend %q %+v", b.backendName, b.backendConfig)
+	}
+	bitrates := []ixxatvci3.BitrateRegisterPair{ixxatvci3.Bitrate125kbps, ixxatvci3.Bitrate250kbps, ixxatvci3.Bitrate500kbps}
+	if b.number != 2 || !b.detectBitrate || !reflect.DeepEqual(b.wantBitrateList, bitrates) {
+		t.Errorf("device %d, detect %v %v", b.number, b.detectBitrate, b.wantBitrateList)
+	}
+	if b.detectTimeout != 2*time.Second || b.txTimeout != 100*time.Millisecond {
+		t.Errorf("timeouts %v %v", b.detectTimeout, b.txTimeout)
+	}
+	if b.mode != ixxatvci3.OpModeStandard|ixxatvci3.OpModeExtended || b.access != ixxatvci3.AccessExclusive || !b.noTimestamps {
+		t.Errorf("mode %v, access %v, timestamps %v", b.mode, b.access, !b.noTimestamps)
+	}
+	if len(b.filters) != 2 {
+		t.Errorf("filters %+v", b.filters)
+	}
+	if nil == b.fifo || *b.fifo != (ixxatvci3.FifoConfig{RxFifoSize: 256, RxThreshold: 1}) {
+		t.Errorf("fifo %+v", b.fifo)
+	}
+
+	// defaults: shared access, native backend, no buffer settings
+	if b, err = (Config{Bitrate: "00:1C"}).Builder(); err != nil {
+		t.Fatal(err)
+	}
+	if b.speed != ixxatvci3.Bitrate500kbps || b.access != ixxatvci3.AccessShared || b.backendName != "" || b.fifo != nil {
+		t.Errorf("defaults: speed %v, access %v, backend %q, fifo %+v", b.speed, b.access, b.backendName, b.fifo)
+	}
+	if b, err = (Config{Access: "monitor"}).Builder(); err != nil || b.access != ixxatvci3.AccessMonitor {
+		t.Errorf("monitor without bitrate: %v", err)
+	}
+	if b, err = (Config{Bitrate: "auto"}).Builder(); err != nil || !b.detectBitrate || 0 == len(b.wantBitrateList) {
+		t.Errorf("auto bitrate: %v", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		cfg   Config
+		field string
+	}{
+		{Config{}, "bitrate"},
+		{Config{Bitrate: "300k"}, "bitrate"},
+		{Config{Bitrate: "125k,fast"}, "bitrate"},
+		{Config{Bitrate: "0:XY"}, "bitrate"},
+		{Config{Bitrate: "500k", Access: "private"}, "access"},
+		{Config{Bitrate: "500k", Mode: "11bit,lowlisten"}, "mode"},
+		{Config{Bitrate: "500k", DetectTimeout: "5"}, "detect_timeout"},
+		{Config{Bitrate: "500k", TxTimeout: "-1s"}, "tx_timeout"},
+		{Config{Bitrate: "500k", Filters: []string{"XYZ"}}, "filters"},
+		{Config{Bitrate: "500k", Timestamps: "hardware"}, "timestamps"},
+		{Config{Bitrate: "500k", RxFifoSize: 16, RxThreshold: 17}, "rx_threshold"},
+		{Config{Bitrate: "500k", TxFifoSize: 16, TxThreshold: 17}, "tx_threshold"},
+		{Config{Bitrate: "500k", SocketRcvBuf: -1}, "socket_rcvbuf"},
+		{Config{Bitrate: "500k", SocketSndBuf: -1}, "socket_sndbuf"},
+		{Config{Bitrate: "500k", TxQueueLen: -1}, "txqueuelen"},
+		{Config{Bitrate: "500k", Backend: "cfgtest"}, "address"},
+		{Config{Bitrate: "500k", Backend: "nosuch", Address: "x"}, "backend"},
+	}
+	for _, tt := range tests {
+		_, err := tt.cfg.Builder()
+		if e, ok := err.(*ConfigError); !ok || e.Field != tt.field {
+			t.Errorf("%+v: %v, want a %s error", tt.cfg, err, tt.field)
+		}
+	}
+	// a named native backend needs no address
+	if _, err := (Config{Bitrate: "500k", Backend: "native"}).Builder(); err != nil {
+		t.Errorf("native backend: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package candev
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter accepts IDs with id&Mask == ID&Mask, or rejects them if Invert is set.
+// It is used by Builder.Filters, the remote server and the ixxatcan filters.
+type Filter struct {
+	ID     uint32
+	Mask   uint32
+	Invert bool
+}
+
+// Match reports whether the filter matches id, regardless of Invert.
+func (f Filter) Match(id uint32) bool {
+	return id&f.Mask == f.ID&f.Mask
+}
+
+// ParseFilters parses candump style filters "123:7FF,~200:700":
+// ID[:MASK] in hex, the mask is 1FFFFFFF if omitted, ~ rejects.
+func ParseFilters(s string) (filters []Filter, err error) {
+	if "" == s {
+		return
+	}
+	for _, item := range strings.Split(s, ",") {
+		var f Filter
+		item = strings.TrimSpace(item)
+		if strings.HasPrefix(item, "~") {
+			f.Invert = true
+			item = item[1:]
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid filter %q", item)
+		}
+		id, e := strconv.ParseUint(parts[0], 16, 32)
+		if e != nil {
+			return nil, fmt.Errorf("invalid filter %q", item)
+		}
+		f.ID, f.Mask = uint32(id), 0x1FFFFFFF
+		if 2 == len(parts) {
+			mask, e := strconv.ParseUint(parts[1], 16, 32)
+			if e != nil {
+				return nil, fmt.Errorf("invalid filter %q", item)
+			}
+			f.Mask = uint32(mask)
+		}
+		filters = append(filters, f)
+	}
+	return
+}
+
+// MatchFilters returns true if any accepting filter matches and no rejecting filter matches.
+// Without accepting filters all IDs are accepted.
+func MatchFilters(filters []Filter, id uint32) bool {
+	accepted, hasAccepting := false, false
+	for _, f := range filters {
+		match := f.Match(id)
+		if f.Invert {
+			if match {
+				return false
+			}
+			continue
+		}
+		hasAccepting = true
+		accepted = accepted || match
+	}
+	return accepted || !hasAccepting
+}
@@ -0,0 +1,68 @@
+package candev
+
+import (
+	"sync"
+)
+
+// Loopback is an in-memory CAN bus without hardware.
+// Every sent message is delivered to all channels from GetMsgChannelCopy,
+// including the sender's own. It has the same message API as Device
+// and is used to connect protocol simulators with clients in tests.
+type Loopback struct {
+	mu     sync.Mutex
+	subs   map[uint]*loopbackSub
+	nextCh uint
+}
+
+type loopbackSub struct {
+	ch   chan Message
+	done chan struct{}
+}
+
+// loopbackBufferSize is a number of messages buffered for each channel copy.
+const loopbackBufferSize = 256
+
+// Send delivers msg to all channels. It blocks while a channel buffer is full.
+func (lb *Loopback) Send(msg Message) (err error) {
+	lb.mu.Lock()
+	subs := make([]*loopbackSub, 0, len(lb.subs))
+	for _, sub := range lb.subs {
+		subs = append(subs, sub)
+	}
+	lb.mu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.ch <- msg:
+		case <-sub.done:
+		}
+	}
+	return
+}
+
+// GetMsgChannelCopy returns channel with all messages sent to the bus.
+// idx - channel index for use with CloseMsgChannelCopy().
+func (lb *Loopback) GetMsgChannelCopy() (ch <-chan Message, idx uint) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if nil == lb.subs {
+		lb.subs = make(map[uint]*loopbackSub)
+	}
+	sub := &loopbackSub{ch: make(chan Message, loopbackBufferSize), done: make(chan struct{})}
+	idx = lb.nextCh
+	lb.nextCh++
+	lb.subs[idx] = sub
+	ch = sub.ch
+	return
+}
+
+// CloseMsgChannelCopy stops delivery to the channel. The channel itself is not closed,
+// so a pending receive from it must be cancelled by the caller.
+func (lb *Loopback) CloseMsgChannelCopy(idx uint) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if sub, ok := lb.subs[idx]; ok {
+		close(sub.done)
+		delete(lb.subs, idx)
+	}
+}
@@ -0,0 +1,189 @@
+package candev
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDeviceStopped is returned by calls waiting for messages when the device stops receiving.
+var ErrDeviceStopped = errors.New("candev: device stopped")
+
+// RTRProvider returns the data answering remote request req, ok false sends no answer.
+// req.Len is the requested data length.
+type RTRProvider func(req Message) (data []byte, ok bool)
+
+// RTRStats counts remote requests handled by RespondRTR responders.
+type RTRStats struct {
+	Requests uint64 // remote requests for registered IDs
+	Replies  uint64 // answers sent within the budget
+	Late     uint64 // answers not sent because the budget was exceeded
+	Dropped  uint64 // requests dropped because the responder was busy
+	Errors   uint64 // failed sends and invalid provider data
+}
+
+// defaultRTRBudget is the default time between receiving a remote request and sending the answer.
+const defaultRTRBudget = 10 * time.Millisecond
+
+// rtrQueueSize is the number of remote requests waiting for the responder.
+const rtrQueueSize = 64
+
+type rtrRequest struct {
+	msg Message
+	at  time.Time
+}
+
+// rtrResponders answers remote requests of a device in a goroutine of its own,
+// so the reader thread does not wait for providers or the transmit queue.
+type rtrResponders struct {
+	stats     RTRStats //first for 64-bit alignment of atomic counters
+	budget    int64    //time.Duration
+	mu        sync.RWMutex
+	providers map[uint32]RTRProvider
+	requests  chan rtrRequest
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func newRTRResponders() *rtrResponders {
+	return &rtrResponders{
+		budget:    int64(defaultRTRBudget),
+		providers: make(map[uint32]RTRProvider),
+		requests:  make(chan rtrRequest, rtrQueueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// dispatch passes a received remote request to the responder if its ID is registered.
+func (r *rtrResponders) dispatch(msg Message) {
+	r.mu.RLock()
+	_, ok := r.providers[msg.ID]
+	r.mu.RUnlock()
+	if !ok {
+		return
+	}
+	atomic.AddUint64(&r.stats.Requests, 1)
+	select {
+	case r.requests <- rtrRequest{msg: msg, at: time.Now()}:
+	default:
+		atomic.AddUint64(&r.stats.Dropped, 1)
+	}
+}
+
+func (r *rtrResponders) run(dev *Device) {
+	for {
+		select {
+		case req := <-r.requests:
+			r.answer(dev, req)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// answer sends the provider data if it is still within the budget.
+func (r *rtrResponders) answer(dev *Device, req rtrRequest) {
+	r.mu.RLock()
+	provider, ok := r.providers[req.msg.ID]
+	r.mu.RUnlock()
+	if !ok {
+		return
+	}
+	data, ok := provider(req.msg)
+	if !ok {
+		return
+	}
+	if len(data) > 8 {
+		atomic.AddUint64(&r.stats.Errors, 1)
+		return
+	}
+	left := time.Duration(atomic.LoadInt64(&r.budget)) - time.Since(req.at)
+	if left <= 0 {
+		atomic.AddUint64(&r.stats.Late, 1)
+		return
+	}
+	reply := Message{ID: req.msg.ID, Ext: req.msg.Ext, Len: uint8(len(data))}
+	copy(reply.Data[:], data)
+	switch err := dev.SendTimeout(reply, left); err {
+	case nil:
+		atomic.AddUint64(&r.stats.Replies, 1)
+	case ErrTxTimeout:
+		atomic.AddUint64(&r.stats.Late, 1)
+	default:
+		atomic.AddUint64(&r.stats.Errors, 1)
+	}
+}
+
+func (r *rtrResponders) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+// RespondRTR answers remote requests for id with the data of provider until remove is called.
+// Answers are sent from a goroutine of the device within the budget of SetRTRBudget after
+// the request is received, later ones are dropped. A provider registered for the same id is replaced.
+// Received remote requests are still delivered to the message channels.
+func (dev *Device) RespondRTR(id uint32, provider RTRProvider) (remove func()) {
+	r := dev.rtr
+	r.mu.Lock()
+	r.providers[id] = provider
+	r.mu.Unlock()
+	r.startOnce.Do(func() { go r.run(dev) })
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.providers, id)
+			r.mu.Unlock()
+		})
+	}
+}
+
+// SetRTRBudget sets the longest time between receiving a remote request and sending the answer, 10 ms by default.
+// The budget includes the provider call and the wait for space in the transmit queue.
+func (dev *Device) SetRTRBudget(budget time.Duration) {
+	atomic.StoreInt64(&dev.rtr.budget, int64(budget))
+}
+
+// RTRStats returns the counters of remote requests for RespondRTR responders.
+func (dev *Device) RTRStats() (s RTRStats) {
+	r := dev.rtr
+	s.Requests = atomic.LoadUint64(&r.stats.Requests)
+	s.Replies = atomic.LoadUint64(&r.stats.Replies)
+	s.Late = atomic.LoadUint64(&r.stats.Late)
+	s.Dropped = atomic.LoadUint64(&r.stats.Dropped)
+	s.Errors = atomic.LoadUint64(&r.stats.Errors)
+	return
+}
+
+// RequestRTR sends a remote request for id with data length dlc and returns the data frame with the same ID,
+// waiting until ctx is done. Data frames with id received after the request are taken as the answer.
+// dlc above 8 returns ErrInvalidFrame.
+func (dev *Device) RequestRTR(ctx context.Context, id uint32, dlc uint8) (msg Message, err error) {
+	if dlc > 8 {
+		return msg, ErrInvalidFrame
+	}
+	ch, idx := dev.GetMsgChannelCopy()
+	defer dev.CloseMsgChannelCopy(idx)
+
+	req := Message{ID: id, Ext: id > 0x7FF, Rtr: true, Len: dlc}
+	if err = dev.SendContext(ctx, req); err != nil {
+		return
+	}
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return msg, ErrDeviceStopped
+			}
+			if m.ID == id && !m.Rtr {
+				return m, nil
+			}
+		case <-ctx.Done():
+			return msg, ctx.Err()
+		}
+	}
+}
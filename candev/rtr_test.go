@@ -0,0 +1,121 @@
+package candev_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+// waitFor polls cond for a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for", what)
+		}
+	}
+}
+
+// TestRTR runs the responders and requests of one device, a device stop takes a while.
+func TestRTR(t *testing.T) {
+	b := cantest.New(nil)
+	dev, err := b.Builder().Number(207).Speed(ixxatvci3.Bitrate500kbps).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Run()
+	defer dev.Stop()
+
+	t.Run("reply", func(t *testing.T) {
+		var req candev.Message
+		remove := dev.RespondRTR(0x321, func(m candev.Message) ([]byte, bool) {
+			req = m
+			return []byte{0xCA, 0xFE}, true
+		})
+		b.Inject(0, candev.Message{ID: 0x321, Rtr: true, Len: 2})
+		waitFor(t, "reply", func() bool { return dev.RTRStats().Replies == 1 })
+		if req.ID != 0x321 || !req.Rtr || req.Len != 2 {
+			t.Errorf("provider request %s", cantest.Format(req))
+		}
+		b.ExpectSent(t, candev.Message{ID: 0x321, Len: 2, Data: [8]byte{0xCA, 0xFE}})
+		b.TakeSent()
+
+		// requests for other IDs and after remove are not answered
+		remove()
+		b.Inject(0, candev.Message{ID: 0x321, Rtr: true, Len: 2}, candev.Message{ID: 0x322, Rtr: true})
+		time.Sleep(20 * time.Millisecond)
+		if s := dev.RTRStats(); s.Requests != 1 || s.Replies != 1 {
+			t.Errorf("stats after remove %+v", s)
+		}
+		b.ExpectSent(t)
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		dev.SetRTRBudget(5 * time.Millisecond)
+		defer dev.SetRTRBudget(10 * time.Millisecond)
+		before := dev.RTRStats()
+		remove := dev.RespondRTR(1<<20|0x55, func(m candev.Message) ([]byte, bool) {
+			time.Sleep(20 * time.Millisecond)
+			return []byte{1}, true
+		})
+		defer remove()
+		b.Inject(0, candev.Message{ID: 1<<20 | 0x55, Ext: true, Rtr: true, Len: 1})
+		waitFor(t, "late answer", func() bool { return dev.RTRStats().Late == before.Late+1 })
+		if s := dev.RTRStats(); s.Replies != before.Replies || s.Requests != before.Requests+1 {
+			t.Errorf("stats %+v, before %+v", s, before)
+		}
+		b.ExpectSent(t)
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		before := dev.RTRStats()
+		remove := dev.RespondRTR(0x400, func(m candev.Message) ([]byte, bool) {
+			return make([]byte, 9), true
+		})
+		defer remove()
+		b.Inject(0, candev.Message{ID: 0x400, Rtr: true})
+		waitFor(t, "error", func() bool { return dev.RTRStats().Errors == before.Errors+1 })
+		b.ExpectSent(t)
+	})
+
+	t.Run("request", func(t *testing.T) {
+		b.OnSend(func(m candev.Message) {
+			if m.Rtr {
+				b.Inject(0, candev.Message{ID: m.ID, Len: m.Len, Data: [8]byte{7, 8, 9}})
+			}
+		})
+		defer b.OnSend(nil)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		msg, err := dev.RequestRTR(ctx, 0x600, 3)
+		if err != nil || msg.ID != 0x600 || msg.Rtr || msg.Len != 3 || msg.Data[2] != 9 {
+			t.Errorf("RequestRTR = %s, %v", cantest.Format(msg), err)
+		}
+		b.ExpectSent(t, candev.Message{ID: 0x600, Rtr: true, Len: 3})
+		b.TakeSent()
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := dev.RequestRTR(ctx, 0x601, 1); err != context.DeadlineExceeded {
+			t.Errorf("RequestRTR without answer: %v", err)
+		}
+		b.ExpectSent(t, candev.Message{ID: 0x601, Rtr: true, Len: 1})
+		b.TakeSent()
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		if _, err := dev.RequestRTR(context.Background(), 0x602, 9); err != candev.ErrInvalidFrame {
+			t.Errorf("RequestRTR with dlc 9: %v", err)
+		}
+		if err := dev.Send(candev.Message{ID: 0x603, Len: 9}); err != candev.ErrInvalidFrame {
+			t.Errorf("Send of 9 bytes: %v", err)
+		}
+		b.ExpectSent(t)
+	})
+}
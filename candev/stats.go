@@ -0,0 +1,368 @@
+package candev
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FrameBits returns the length of msg on the bus in bits: the frame with its stuff bits,
+// counted on the bit stream with the real CRC like a controller sends it, and the 3 bit interframe space.
+// IDs above 0x7FF are extended like in Send.
+func FrameBits(msg Message) int {
+	var bits frameBits
+	bits.add(0, 1) // SOF
+	if msg.Ext || msg.ID > 0x7FF {
+		bits.add(msg.ID>>18, 11)
+		bits.add(1, 1) // SRR
+		bits.add(1, 1) // IDE
+		bits.add(msg.ID, 18)
+		bits.add(rtrBit(msg), 1)
+		bits.add(0, 2) // r1, r0
+	} else {
+		bits.add(msg.ID, 11)
+		bits.add(rtrBit(msg), 1)
+		bits.add(0, 2) // IDE, r0
+	}
+	bits.add(uint32(msg.Len), 4)
+	if !msg.Rtr {
+		n := int(msg.Len)
+		if n > 8 {
+			n = 8
+		}
+		for _, b := range msg.Data[:n] {
+			bits.add(uint32(b), 8)
+		}
+	}
+	bits.add(uint32(bits.crc), 15)
+	// CRC delimiter, ACK slot and delimiter, EOF, interframe space are not stuffed
+	return bits.n + bits.stuffed + 1 + 2 + 7 + 3
+}
+
+func rtrBit(msg Message) uint32 {
+	if msg.Rtr {
+		return 1
+	}
+	return 0
+}
+
+// frameBits counts bits of the stuffed part of a frame and computes its CRC-15.
+type frameBits struct {
+	n       int    // bits without stuff bits
+	stuffed int    // stuff bits
+	last    uint32 // last bit on the bus, stuff bits included
+	run     int    // number of equal bits ending with last
+	crc     uint16
+}
+
+// add appends the count low bits of v, most significant first.
+// The CRC covers the bits from SOF to the data field, later updates are not used.
+func (f *frameBits) add(v uint32, count int) {
+	for i := count - 1; i >= 0; i-- {
+		bit := v >> uint(i) & 1
+		f.updateCRC(bit)
+		f.n++
+		if f.run > 0 && bit == f.last {
+			f.run++
+		} else {
+			f.last, f.run = bit, 1
+		}
+		if 5 == f.run {
+			// the complement stuff bit starts a new run
+			f.stuffed++
+			f.last, f.run = bit^1, 1
+		}
+	}
+}
+
+func (f *frameBits) updateCRC(bit uint32) {
+	next := uint16(bit) ^ (f.crc >> 14 & 1)
+	f.crc = f.crc << 1 & 0x7FFF
+	if next != 0 {
+		f.crc ^= 0x4599
+	}
+}
+
+// PeriodBuckets are the upper bounds of IDStats.PeriodHistogram buckets, the last bucket counts longer intervals.
+var PeriodBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second,
+}
+
+// JitterBuckets are the upper bounds of IDStats.JitterHistogram buckets, the last bucket counts larger deviations.
+var JitterBuckets = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+}
+
+// StatsOptions configure Stats.
+type StatsOptions struct {
+	Bitrate       uint32          // bit/s for bus load, the device bitrate with Device.Stats; 0 reports no load
+	Windows       []time.Duration // bus load windows, 1 and 10 seconds by default
+	Resolution    time.Duration   // time step of the load windows, 10 ms by default
+	LearnTime     time.Duration   // IDs first seen later after the first frame are new, 10 seconds by default
+	MissingFactor float64         // an ID is missing after this many mean periods without frames, 3 by default
+}
+
+// Stats computes bus load and traffic statistics from received frames in software,
+// so they are available without hardware bus load support. It is safe for concurrent use.
+type Stats struct {
+	mu      sync.Mutex
+	opts    StatsOptions
+	buckets []uint64 // bits per Resolution slot, a ring over the longest window
+	head    int64    // absolute slot of the newest bucket
+	ids     map[idKey]*idStat
+	first   time.Time
+	last    time.Time
+	frames  uint64
+	bits    uint64
+}
+
+type idKey struct {
+	id  uint32
+	ext bool
+}
+
+type idStat struct {
+	IDStats
+	mean float64 // mean interval in ns (Welford)
+	m2   float64 // sum of squared deviations
+}
+
+// IDStats are the statistics of one identifier.
+type IDStats struct {
+	ID              uint32
+	Ext             bool
+	Count           uint64
+	Bits            uint64
+	First           time.Time
+	Last            time.Time
+	Period          time.Duration // mean interval between frames
+	MinPeriod       time.Duration
+	MaxPeriod       time.Duration
+	Jitter          time.Duration // standard deviation of the interval
+	Frequency       float64       // frames per second, 1/Period
+	PeriodHistogram []uint64      // intervals per PeriodBuckets bound and above the last one
+	JitterHistogram []uint64      // deviations of intervals from the mean period per JitterBuckets
+	New             bool          // first seen after LearnTime
+	Missing         bool          // no frames for MissingFactor periods at the snapshot time
+}
+
+// WindowLoad is the bus load over a sliding window.
+type WindowLoad struct {
+	Window time.Duration
+	Bits   uint64
+	Load   float64 // 0..1, bits / (bitrate * window)
+}
+
+// StatsSnapshot is the state of Stats at a time.
+type StatsSnapshot struct {
+	Time    time.Time
+	Bitrate uint32
+	Frames  uint64
+	Bits    uint64
+	Loads   []WindowLoad // per StatsOptions.Windows, empty without bitrate
+	IDs     []IDStats    // sorted by ID
+}
+
+// NewStats creates a statistics engine, feed it with Observe.
+func NewStats(opts StatsOptions) *Stats {
+	if 0 == len(opts.Windows) {
+		opts.Windows = []time.Duration{time.Second, 10 * time.Second}
+	}
+	if opts.Resolution <= 0 {
+		opts.Resolution = 10 * time.Millisecond
+	}
+	if opts.LearnTime <= 0 {
+		opts.LearnTime = 10 * time.Second
+	}
+	if opts.MissingFactor <= 0 {
+		opts.MissingFactor = 3
+	}
+	longest := opts.Resolution
+	for _, w := range opts.Windows {
+		if w > longest {
+			longest = w
+		}
+	}
+	n := int((longest + opts.Resolution - 1) / opts.Resolution)
+	return &Stats{opts: opts, buckets: make([]uint64, n), ids: make(map[idKey]*idStat)}
+}
+
+// advance moves the newest bucket to slot, clearing the skipped ones.
+func (s *Stats) advance(slot int64) {
+	n := int64(len(s.buckets))
+	if 0 == s.frames && s.first.IsZero() {
+		s.head = slot
+		return
+	}
+	if slot <= s.head {
+		return
+	}
+	if slot-s.head >= n {
+		for i := range s.buckets {
+			s.buckets[i] = 0
+		}
+	} else {
+		for i := s.head + 1; i <= slot; i++ {
+			s.buckets[i%n] = 0
+		}
+	}
+	s.head = slot
+}
+
+// Observe accounts a received frame at msg.Time, the current time if it is zero.
+func (s *Stats) Observe(msg Message) {
+	t := msg.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	bits := uint64(FrameBits(msg))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := t.UnixNano() / int64(s.opts.Resolution)
+	s.advance(slot)
+	if n := int64(len(s.buckets)); slot > s.head-n {
+		s.buckets[slot%n] += bits
+	}
+	if s.first.IsZero() {
+		s.first = t
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+	s.frames++
+	s.bits += bits
+
+	key := idKey{msg.ID, msg.Ext || msg.ID > 0x7FF}
+	st, ok := s.ids[key]
+	if !ok {
+		st = &idStat{IDStats: IDStats{ID: msg.ID, Ext: key.ext, First: t,
+			New:             t.Sub(s.first) > s.opts.LearnTime,
+			PeriodHistogram: make([]uint64, len(PeriodBuckets)+1),
+			JitterHistogram: make([]uint64, len(JitterBuckets)+1)}}
+		s.ids[key] = st
+	} else {
+		st.interval(t.Sub(st.Last))
+	}
+	st.Count++
+	st.Bits += bits
+	st.Last = t
+}
+
+// interval accounts the time between two frames of the ID.
+func (st *idStat) interval(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	n := float64(st.Count) // intervals so far + 1
+	if 1 == st.Count || d < st.MinPeriod {
+		st.MinPeriod = d
+	}
+	if d > st.MaxPeriod {
+		st.MaxPeriod = d
+	}
+	if st.Count > 1 {
+		dev := time.Duration(math.Abs(float64(d) - st.mean))
+		st.JitterHistogram[bucketOf(JitterBuckets, dev)]++
+	}
+	st.PeriodHistogram[bucketOf(PeriodBuckets, d)]++
+	delta := float64(d) - st.mean
+	st.mean += delta / n
+	st.m2 += delta * (float64(d) - st.mean)
+	st.Period = time.Duration(st.mean)
+	st.Jitter = time.Duration(math.Sqrt(st.m2 / n))
+	if st.mean > 0 {
+		st.Frequency = float64(time.Second) / st.mean
+	}
+}
+
+func bucketOf(bounds []time.Duration, d time.Duration) int {
+	return sort.Search(len(bounds), func(i int) bool { return d <= bounds[i] })
+}
+
+// Snapshot returns the statistics at time now; loads are those of the windows ending at now.
+func (s *Stats) Snapshot(now time.Time) (snap StatsSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := now.UnixNano() / int64(s.opts.Resolution)
+	s.advance(slot)
+
+	snap = StatsSnapshot{Time: now, Bitrate: s.opts.Bitrate, Frames: s.frames, Bits: s.bits}
+	if s.opts.Bitrate != 0 {
+		n := int64(len(s.buckets))
+		for _, w := range s.opts.Windows {
+			l := WindowLoad{Window: w}
+			slots := int64((w + s.opts.Resolution - 1) / s.opts.Resolution)
+			for i := int64(0); i < slots && i < n && slot-i <= s.head; i++ {
+				l.Bits += s.buckets[(slot-i)%n]
+			}
+			l.Load = float64(l.Bits) / (float64(s.opts.Bitrate) * w.Seconds())
+			snap.Loads = append(snap.Loads, l)
+		}
+	}
+	for _, st := range s.ids {
+		ids := st.IDStats
+		ids.PeriodHistogram = append([]uint64(nil), st.PeriodHistogram...)
+		ids.JitterHistogram = append([]uint64(nil), st.JitterHistogram...)
+		ids.Missing = st.Count > 1 && float64(now.Sub(st.Last)) > s.opts.MissingFactor*st.mean
+		snap.IDs = append(snap.IDs, ids)
+	}
+	sort.Slice(snap.IDs, func(i, j int) bool {
+		if snap.IDs[i].ID != snap.IDs[j].ID {
+			return snap.IDs[i].ID < snap.IDs[j].ID
+		}
+		return !snap.IDs[i].Ext
+	})
+	return
+}
+
+// NewIDs returns the IDs first seen after the learning time.
+func (snap StatsSnapshot) NewIDs() (ids []IDStats) {
+	for _, st := range snap.IDs {
+		if st.New {
+			ids = append(ids, st)
+		}
+	}
+	return
+}
+
+// MissingIDs returns the periodic IDs not received for MissingFactor periods.
+func (snap StatsSnapshot) MissingIDs() (ids []IDStats) {
+	for _, st := range snap.IDs {
+		if st.Missing {
+			ids = append(ids, st)
+		}
+	}
+	return
+}
+
+// Stats creates a statistics engine fed with the messages received by the device until stop is called.
+// Without opts.Bitrate the bitrate the device was opened with is used.
+func (dev *Device) Stats(opts StatsOptions) (s *Stats, stop func()) {
+	if 0 == opts.Bitrate {
+		opts.Bitrate = dev.bitrate.BitsPerSecond()
+	}
+	s = NewStats(opts)
+	ch, idx := dev.GetMsgChannelCopy()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range ch {
+			s.Observe(msg)
+		}
+	}()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			dev.CloseMsgChannelCopy(idx)
+			<-done
+		})
+	}
+	return
+}
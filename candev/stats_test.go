@@ -0,0 +1,134 @@
+package candev_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+func TestFrameBits(t *testing.T) {
+	eight := func(b byte) (d [8]byte) {
+		for i := range d {
+			d[i] = b
+		}
+		return
+	}
+	tests := []struct {
+		msg  candev.Message
+		bits int
+	}{
+		{candev.Message{ID: 0x000}, 53},
+		{candev.Message{ID: 0x7FF, Len: 8, Data: eight(0xFF)}, 126},
+		{candev.Message{ID: 0x123, Len: 8, Data: [8]byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}}, 112},
+		{candev.Message{ID: 0x000, Len: 8}, 127},
+		{candev.Message{ID: 0x123, Rtr: true, Len: 4}, 47},
+		{candev.Message{ID: 0x000, Ext: true}, 74},
+		{candev.Message{ID: 0x18DAF110, Ext: true, Len: 8, Data: [8]byte{0x02, 0x01, 0x0D}}, 143},
+		{candev.Message{ID: 0x18DAF110, Len: 8, Data: [8]byte{0x02, 0x01, 0x0D}}, 143}, // extended by its ID
+		{candev.Message{ID: 0x1FFFFFFF, Ext: true, Len: 8, Data: eight(0xAA)}, 137},
+		{candev.Message{ID: 0x18DAF110, Ext: true, Rtr: true, Len: 8, Data: eight(0xFF)}, 67}, // no data field
+	}
+	for _, tt := range tests {
+		if bits := candev.FrameBits(tt.msg); bits != tt.bits {
+			t.Errorf("FrameBits(%s) = %d, want %d", cantest.Format(tt.msg), bits, tt.bits)
+		}
+	}
+}
+
+// TestStatsLoad checks the load windows, frame times and snapshots follow a test clock.
+func TestStatsLoad(t *testing.T) {
+	clock := cantest.NewClock(time.Unix(1000, 0))
+	s := candev.NewStats(candev.StatsOptions{Bitrate: 125000, Windows: []time.Duration{100 * time.Millisecond, time.Second}})
+	for i := 0; i < 10; i++ {
+		if i > 0 {
+			clock.Advance(10 * time.Millisecond)
+		}
+		s.Observe(candev.Message{ID: 0x000, Time: clock.Now()}) // 53 bits
+	}
+
+	tests := []struct {
+		advance time.Duration
+		bits    [2]uint64
+	}{
+		{0, [2]uint64{530, 530}},
+		{60 * time.Millisecond, [2]uint64{4 * 53, 530}},
+		{900 * time.Millisecond, [2]uint64{0, 4 * 53}},
+		{2 * time.Second, [2]uint64{0, 0}},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		snap := s.Snapshot(clock.Now())
+		if snap.Frames != 10 || snap.Bits != 530 || len(snap.Loads) != 2 {
+			t.Fatalf("at %v: %d frames, %d bits, loads %+v", clock.Now(), snap.Frames, snap.Bits, snap.Loads)
+		}
+		for i, l := range snap.Loads {
+			load := float64(tt.bits[i]) / (125000 * l.Window.Seconds())
+			if l.Bits != tt.bits[i] || math.Abs(l.Load-load) > 1e-9 {
+				t.Errorf("at %v: window %v has %d bits, load %g, want %d bits", clock.Now(), l.Window, l.Bits, l.Load, tt.bits[i])
+			}
+		}
+	}
+	if snap := candev.NewStats(candev.StatsOptions{}).Snapshot(clock.Now()); len(snap.Loads) != 0 {
+		t.Errorf("loads without bitrate %+v", snap.Loads)
+	}
+}
+
+func TestStatsIDs(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	ms := time.Millisecond
+	s := candev.NewStats(candev.StatsOptions{LearnTime: time.Second})
+	for _, at := range []time.Duration{0, 10 * ms, 30 * ms, 60 * ms} {
+		s.Observe(candev.Message{ID: 0x100, Len: 1, Time: t0.Add(at)})
+	}
+	s.Observe(candev.Message{ID: 0x100, Ext: true, Time: t0.Add(5 * ms)})
+	s.Observe(candev.Message{ID: 0x200, Time: t0.Add(2 * time.Second)})
+
+	snap := s.Snapshot(t0.Add(2 * time.Second))
+	if len(snap.IDs) != 3 {
+		t.Fatalf("IDs %+v", snap.IDs)
+	}
+	std, ext, late := snap.IDs[0], snap.IDs[1], snap.IDs[2]
+	if std.ID != 0x100 || std.Ext || ext.ID != 0x100 || !ext.Ext || late.ID != 0x200 {
+		t.Fatalf("IDs %+v", snap.IDs)
+	}
+
+	// intervals 10, 20 and 30 ms
+	if std.Count != 4 || std.Period != 20*ms || std.MinPeriod != 10*ms || std.MaxPeriod != 30*ms {
+		t.Errorf("periods %+v", std)
+	}
+	if jitter := time.Duration(math.Sqrt(200.0/3) * float64(ms)); std.Jitter < jitter-time.Microsecond || std.Jitter > jitter+time.Microsecond {
+		t.Errorf("jitter %v, want %v", std.Jitter, jitter)
+	}
+	if math.Abs(std.Frequency-50) > 1e-9 {
+		t.Errorf("frequency %g", std.Frequency)
+	}
+	period := make([]uint64, len(candev.PeriodBuckets)+1)
+	period[3], period[4], period[5] = 1, 1, 1 // up to 10, 20 and 50 ms
+	jitter := make([]uint64, len(candev.JitterBuckets)+1)
+	jitter[6], jitter[7] = 1, 1 // 10 ms from the mean of 10 ms, 15 ms from 15 ms
+	for i := range period {
+		if std.PeriodHistogram[i] != period[i] {
+			t.Errorf("period histogram %v, want %v", std.PeriodHistogram, period)
+			break
+		}
+	}
+	for i := range jitter {
+		if std.JitterHistogram[i] != jitter[i] {
+			t.Errorf("jitter histogram %v, want %v", std.JitterHistogram, jitter)
+			break
+		}
+	}
+
+	if ext.Count != 1 || ext.Period != 0 || ext.Missing {
+		t.Errorf("extended ID %+v", ext)
+	}
+	if missing := snap.MissingIDs(); len(missing) != 1 || missing[0].ID != 0x100 || missing[0].Ext {
+		t.Errorf("missing IDs %+v", missing)
+	}
+	if ids := snap.NewIDs(); len(ids) != 1 || ids[0].ID != 0x200 {
+		t.Errorf("new IDs %+v", ids)
+	}
+}
@@ -0,0 +1,379 @@
+package candev
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+// SupervisorState is a state of Supervisor
+type SupervisorState int
+
+// Supervisor states
+const (
+	StateStopped      SupervisorState = iota // not started or stopped
+	StateConnecting                          // opening the device
+	StateRunning                             // the device is open and the bus is fine
+	StateBusOff                              // the controller is bus-off, the device is reopened
+	StateDisconnected                        // the device is unplugged or failed to open, retrying
+)
+
+func (s SupervisorState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateRunning:
+		return "running"
+	case StateBusOff:
+		return "bus-off"
+	case StateDisconnected:
+		return "disconnected"
+	}
+	return "stopped"
+}
+
+// Errors reported by Supervisor
+var (
+	ErrBusOff       = errors.New("candev: bus-off")
+	ErrDisconnected = errors.New("candev: device disconnected")
+	ErrNotConnected = errors.New("candev: device is not connected")
+)
+
+// SupervisorOptions configure Supervisor. Zero values select defaults.
+type SupervisorOptions struct {
+	PollInterval   time.Duration // status poll interval, 1 second by default
+	MinBackoff     time.Duration // first reopen delay, 100 ms by default
+	MaxBackoff     time.Duration // maximum reopen delay, 30 seconds by default
+	StatusFailures int           // failed status polls in a row treated as disconnection, 3 by default
+
+	//OnState is called on every state change; err is the reason of StateBusOff and StateDisconnected.
+	OnState func(state SupervisorState, err error)
+	//OnOpen is called after every successful open before the device runs,
+	//e.g. to replay filters or attach metrics. An error closes the device and retries.
+	OnOpen func(dev *Device) error
+	//Logger receives state changes, ixxatvci3.DefaultLogger if nil;
+	//Builder.Supervisor selects ixxatvci3.DeviceLogger of its device.
+	Logger ixxatvci3.Logger
+}
+
+// supervisorBufferSize is a number of messages buffered for each channel copy.
+const supervisorBufferSize = 256
+
+type supervisorSub struct {
+	ch   chan Message
+	done chan struct{}
+}
+
+// Supervisor keeps a device open: it detects bus-off (CAN_STATUS_BUSOFF),
+// disconnection (VCI_E_DISCONNECTED, removed network interface, failing status requests)
+// and reopens the device with exponential backoff, replaying mode and bitrate of the Builder.
+// It has the message API of Device with channel copies surviving reopening.
+type Supervisor struct {
+	reopens uint64 //first for 64-bit alignment of the atomic counter
+
+	open func() (*Device, error)
+	opts SupervisorOptions
+
+	mu      sync.Mutex
+	dev     *Device
+	state   SupervisorState
+	lastErr error
+	subs    map[uint]*supervisorSub
+	nextCh  uint
+	done    chan struct{}
+	wg      sync.WaitGroup
+	running bool
+}
+
+// NewSupervisor creates a supervisor of devices opened by open, e.g. Builder.Get of a new Builder.
+func NewSupervisor(open func() (*Device, error), opts SupervisorOptions) *Supervisor {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 30 * time.Second
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	if opts.StatusFailures <= 0 {
+		opts.StatusFailures = 3
+	}
+	if nil == opts.Logger {
+		opts.Logger = ixxatvci3.DefaultLogger()
+	}
+	return &Supervisor{open: open, opts: opts, subs: make(map[uint]*supervisorSub)}
+}
+
+// Supervisor creates a supervisor opening the device with the configuration of the builder.
+func (b *Builder) Supervisor(opts SupervisorOptions) *Supervisor {
+	proto := b.clone()
+	number, detectBitrate := b.number, b.detectBitrate
+	if nil == opts.Logger {
+		opts.Logger = ixxatvci3.DeviceLogger(number)
+	}
+	detected := ixxatvci3.BitrateRegisterPair{}
+	return NewSupervisor(func() (*Device, error) {
+		nb := proto.clone()
+		nb.detectBitrate = false
+		if detectBitrate && detected == (ixxatvci3.BitrateRegisterPair{}) {
+			nb.Detect(proto.wantBitrateList)
+		} else if detectBitrate {
+			nb.speed = detected //reopen with the bitrate detected first
+		}
+		dev, err := nb.Get()
+		if nil == err && detectBitrate {
+			detected = nb.foundBitrate
+		}
+		return dev, err
+	}, opts)
+}
+
+// Start opens the device and keeps it open until Stop.
+func (s *Supervisor) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+	s.done = make(chan struct{})
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop closes the device and all channel copies.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	close(s.done)
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	for idx, sub := range s.subs {
+		close(sub.ch)
+		delete(s.subs, idx)
+	}
+	s.mu.Unlock()
+	s.setState(StateStopped, nil)
+}
+
+// State returns the current state and the reason of the last failure.
+func (s *Supervisor) State() (state SupervisorState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.lastErr
+}
+
+// Device returns the open device, nil if it is not open.
+func (s *Supervisor) Device() *Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dev
+}
+
+// Reopens returns the number of device reopenings after failures.
+func (s *Supervisor) Reopens() uint64 {
+	return atomic.LoadUint64(&s.reopens)
+}
+
+func (s *Supervisor) setState(state SupervisorState, err error) {
+	s.mu.Lock()
+	changed := s.state != state
+	s.state = state
+	if err != nil {
+		s.lastErr = err
+	}
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	if s.opts.OnState != nil {
+		s.opts.OnState(state, err)
+	}
+	switch state {
+	case StateBusOff, StateDisconnected:
+		s.opts.Logger.Warn("supervisor state changed", "op", "supervise", "state", state.String(), "error", err)
+	default:
+		s.opts.Logger.Info("supervisor state changed", "op", "supervise", "state", state.String())
+	}
+}
+
+// sleep waits for d, false if stopped.
+func (s *Supervisor) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-s.done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (s *Supervisor) run() {
+	defer s.wg.Done()
+	backoff := s.opts.MinBackoff
+	for first := true; ; first = false {
+		if !first {
+			atomic.AddUint64(&s.reopens, 1)
+		}
+		s.setState(StateConnecting, nil)
+		dev, err := s.open()
+		if nil == err && s.opts.OnOpen != nil {
+			if err = s.opts.OnOpen(dev); err != nil {
+				dev.Stop()
+			}
+		}
+		if err != nil {
+			s.setState(StateDisconnected, fmt.Errorf("%v: %v", ErrDisconnected, err))
+			if !s.sleep(backoff) {
+				return
+			}
+			backoff = s.nextBackoff(backoff)
+			continue
+		}
+
+		ch, _ := dev.GetMsgChannelCopy()
+		dev.Run()
+		s.mu.Lock()
+		s.dev = dev
+		s.mu.Unlock()
+		s.setState(StateRunning, nil)
+
+		started := time.Now()
+		reason := s.watch(dev, ch)
+
+		s.mu.Lock()
+		s.dev = nil
+		s.mu.Unlock()
+		go func() {
+			for range ch {
+			}
+		}()
+		dev.Stop()
+		if nil == reason {
+			return
+		}
+		if ErrBusOff == reason {
+			s.setState(StateBusOff, reason)
+		} else {
+			s.setState(StateDisconnected, reason)
+		}
+		if time.Since(started) > s.opts.MaxBackoff {
+			backoff = s.opts.MinBackoff //the device worked for a while
+		}
+		if !s.sleep(backoff) {
+			return
+		}
+		backoff = s.nextBackoff(backoff)
+	}
+}
+
+func (s *Supervisor) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > s.opts.MaxBackoff {
+		backoff = s.opts.MaxBackoff
+	}
+	return backoff
+}
+
+// watch forwards messages and polls the device status, returns the failure or nil on Stop.
+func (s *Supervisor) watch(dev *Device, ch <-chan Message) error {
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return ErrDisconnected
+			}
+			s.deliver(msg)
+		case <-ticker.C:
+			if ixxatvci3.VCI_E_DISCONNECTED == dev.LastRxError() {
+				return ErrDisconnected
+			}
+			st, vcierr := ixxatvci3.GetStatus(dev.Number())
+			switch vcierr {
+			case ixxatvci3.VCI_OK:
+				failures = 0
+				if st.LineStatus.Status&ixxatvci3.CAN_STATUS_BUSOFF != 0 {
+					return ErrBusOff
+				}
+			case ixxatvci3.VCI_E_NOT_IMPLEMENTED:
+			case ixxatvci3.VCI_E_DISCONNECTED:
+				return ErrDisconnected
+			default:
+				if failures++; failures >= s.opts.StatusFailures {
+					return fmt.Errorf("%v: %s", ErrDisconnected, ixxatvci3.GetErrorText(vcierr))
+				}
+			}
+		}
+	}
+}
+
+// deliver passes msg to all channel copies.
+func (s *Supervisor) deliver(msg Message) {
+	s.mu.Lock()
+	subs := make([]*supervisorSub, 0, len(s.subs))
+	for _, sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+	for _, sub := range subs {
+		select {
+		case sub.ch <- msg:
+		case <-sub.done:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Send sends msg to the open device, ErrNotConnected while it is being reopened.
+func (s *Supervisor) Send(msg Message) error {
+	dev := s.Device()
+	if nil == dev {
+		return ErrNotConnected
+	}
+	return dev.Send(msg)
+}
+
+// GetMsgChannelCopy returns channel with all messages received from CAN, also after reopening.
+// The channel is closed by Stop.
+// idx - channel index for use with CloseMsgChannelCopy().
+func (s *Supervisor) GetMsgChannelCopy() (ch <-chan Message, idx uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := &supervisorSub{ch: make(chan Message, supervisorBufferSize), done: make(chan struct{})}
+	idx = s.nextCh
+	s.nextCh++
+	s.subs[idx] = sub
+	ch = sub.ch
+	return
+}
+
+// CloseMsgChannelCopy stops delivery to the channel. The channel itself is not closed,
+// so a pending receive from it must be cancelled by the caller.
+func (s *Supervisor) CloseMsgChannelCopy(idx uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subs[idx]; ok {
+		close(sub.done)
+		delete(s.subs, idx)
+	}
+}
@@ -0,0 +1,150 @@
+package candev_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+type stateChange struct {
+	state candev.SupervisorState
+	err   error
+	at    time.Time
+}
+
+// nextState returns the next change to want, the changes before it are returned too.
+func nextState(t *testing.T, changes <-chan stateChange, want candev.SupervisorState) (c stateChange, before []stateChange) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case c = <-changes:
+			if c.state == want {
+				return
+			}
+			before = append(before, c)
+		case <-timeout:
+			t.Fatalf("no %s state after %v", want, before)
+		}
+	}
+}
+
+func receiveFrom(t *testing.T, ch <-chan candev.Message, want candev.Message) {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		if !cantest.Equal(msg, want) {
+			t.Errorf("received %s, want %s", cantest.Format(msg), cantest.Format(want))
+		}
+	case <-time.After(time.Second):
+		t.Errorf("%s not received", cantest.Format(want))
+	}
+}
+
+// TestSupervisor recovers one device from bus-off and disconnection, every device stop takes a while.
+func TestSupervisor(t *testing.T) {
+	b := cantest.New(nil)
+	changes := make(chan stateChange, 256)
+	opts := candev.SupervisorOptions{
+		PollInterval: 5 * time.Millisecond,
+		MinBackoff:   20 * time.Millisecond,
+		MaxBackoff:   80 * time.Millisecond,
+		OnState: func(state candev.SupervisorState, err error) {
+			if candev.StateBusOff == state {
+				b.SetStatus(0) // the bus recovers while the device is closed
+			}
+			changes <- stateChange{state, err, time.Now()}
+		},
+	}
+	s := b.Builder().Number(209).Speed(ixxatvci3.Bitrate500kbps).Supervisor(opts)
+	if err := s.Send(candev.Message{ID: 0x100}); err != candev.ErrNotConnected {
+		t.Errorf("Send before Start: %v", err)
+	}
+	ch, _ := s.GetMsgChannelCopy()
+	s.Start()
+	nextState(t, changes, candev.StateRunning)
+
+	msg := candev.Message{ID: 0x100, Len: 1, Data: [8]byte{1}}
+	b.Inject(0, msg)
+	receiveFrom(t, ch, msg)
+	if err := s.Send(candev.Message{ID: 0x200, Len: 1, Data: [8]byte{2}}); err != nil {
+		t.Errorf("Send: %v", err)
+	}
+	b.ExpectSent(t, candev.Message{ID: 0x200, Len: 1, Data: [8]byte{2}})
+	b.TakeSent()
+	first := s.Device()
+
+	t.Run("bus-off", func(t *testing.T) {
+		b.BusOff()
+		c, _ := nextState(t, changes, candev.StateBusOff)
+		if c.err != candev.ErrBusOff {
+			t.Errorf("bus-off reason %v", c.err)
+		}
+		if state, err := s.State(); state != candev.StateBusOff && state != candev.StateConnecting || err != candev.ErrBusOff {
+			t.Errorf("State = %s, %v", state, err)
+		}
+		nextState(t, changes, candev.StateRunning)
+		if s.Reopens() != 1 {
+			t.Errorf("%d reopens", s.Reopens())
+		}
+		if dev := s.Device(); nil == dev || first == dev {
+			t.Errorf("device %p after reopening, %p before", dev, first)
+		}
+		msg := candev.Message{ID: 0x101, Len: 1, Data: [8]byte{3}}
+		b.Inject(0, msg)
+		receiveFrom(t, ch, msg)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		b.Disconnect()
+		c, _ := nextState(t, changes, candev.StateDisconnected)
+		if c.err != candev.ErrDisconnected {
+			t.Errorf("disconnection reason %v", c.err)
+		}
+		// failed opens are retried with a doubling delay up to MaxBackoff
+		var attempts []time.Time
+		for len(attempts) < 4 {
+			nextState(t, changes, candev.StateConnecting)
+			c, _ := nextState(t, changes, candev.StateDisconnected)
+			attempts = append(attempts, c.at)
+			if !strings.HasPrefix(c.err.Error(), candev.ErrDisconnected.Error()) {
+				t.Errorf("open failure %v", c.err)
+			}
+		}
+		if err := s.Send(candev.Message{ID: 0x100}); err != candev.ErrNotConnected {
+			t.Errorf("Send while disconnected: %v", err)
+		}
+		min := []time.Duration{40 * time.Millisecond, 80 * time.Millisecond, 80 * time.Millisecond}
+		for i := range min {
+			if gap := attempts[i+1].Sub(attempts[i]); gap < min[i] || gap > min[i]+time.Second {
+				t.Errorf("retry %d after %v, want %v", i+2, gap, min[i])
+			}
+		}
+
+		reopens := s.Reopens()
+		b.Reconnect()
+		nextState(t, changes, candev.StateRunning)
+		if s.Reopens() != reopens+1 {
+			t.Errorf("%d reopens after %d", s.Reopens(), reopens)
+		}
+		msg := candev.Message{ID: 0x18DAF110, Ext: true, Len: 1, Data: [8]byte{4}}
+		b.Inject(0, msg)
+		receiveFrom(t, ch, msg)
+		if err := s.Send(msg); err != nil {
+			t.Errorf("Send after reconnecting: %v", err)
+		}
+		b.ExpectSent(t, msg)
+	})
+
+	s.Stop()
+	if state, _ := s.State(); state != candev.StateStopped {
+		t.Errorf("state %s after Stop", state)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("channel copy is open after Stop")
+	}
+}
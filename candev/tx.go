@@ -0,0 +1,451 @@
+package candev
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+// Transmit errors
+var (
+	ErrTxQueueFull   = errors.New("candev: transmit queue full")
+	ErrTxTimeout     = errors.New("candev: transmit timeout")
+	ErrTxQueueClosed = errors.New("candev: transmit queue closed")
+	ErrEchoTimeout   = errors.New("candev: no echo of the sent frame")
+	ErrInvalidFrame  = errors.New("candev: invalid frame") // e.g. data length above 8
+)
+
+// sendPollInterval is the longest single wait of SendContext, so cancellation is noticed.
+const sendPollInterval = 20 * time.Millisecond
+
+// sendError converts a send error code to an error.
+func sendError(vcierr uint32) error {
+	switch vcierr {
+	case ixxatvci3.VCI_E_TXQUEUE_FULL:
+		return ErrTxQueueFull
+	case ixxatvci3.VCI_E_TIMEOUT:
+		return ErrTxTimeout
+	case ixxatvci3.VCI_E_INVALIDARG:
+		return ErrInvalidFrame
+	}
+	return fmt.Errorf("%s", ixxatvci3.GetErrorText(vcierr))
+}
+
+// transmit sends msg waiting at most timeout for space in the transmit queue, without counting.
+func (dev *Device) transmit(msg Message, timeout time.Duration) uint32 {
+	if msg.Len > 8 {
+		return ixxatvci3.VCI_E_INVALIDARG
+	}
+	id := msg.ID
+	if msg.Ext {
+		id |= 1 << 31 //hi bit is 29-bit mode flag for ixxatvci3 package
+	}
+	return ixxatvci3.SendTimeout(dev.number, id, msg.Rtr, msg.Data[0:msg.Len], timeout)
+}
+
+func (dev *Device) count(vcierr uint32) error {
+	if ixxatvci3.VCI_OK != vcierr {
+		atomic.AddUint64(&dev.counters.TxErrors, 1)
+		return sendError(vcierr)
+	}
+	atomic.AddUint64(&dev.counters.TxFrames, 1)
+	return nil
+}
+
+// SendTimeout sends msg waiting at most timeout for space in the transmit queue.
+// It returns ErrTxTimeout or ErrTxQueueFull if the frame is not queued.
+// A negative timeout waits forever.
+func (dev *Device) SendTimeout(msg Message, timeout time.Duration) error {
+	if nil == dev {
+		return fmt.Errorf("%s", "null ptr")
+	}
+	return dev.count(dev.transmit(msg, timeout))
+}
+
+// TrySend sends msg if there is space in the transmit queue, ErrTxQueueFull otherwise.
+func (dev *Device) TrySend(msg Message) error {
+	err := dev.SendTimeout(msg, 0)
+	if ErrTxTimeout == err {
+		err = ErrTxQueueFull
+	}
+	return err
+}
+
+// SendContext sends msg waiting for space in the transmit queue until ctx is done.
+// It returns ctx.Err() if the frame is not queued.
+func (dev *Device) SendContext(ctx context.Context, msg Message) error {
+	if nil == dev {
+		return fmt.Errorf("%s", "null ptr")
+	}
+	return dev.sendContext(ctx, nil, msg)
+}
+
+// sendContext is SendContext also giving up with ErrTxQueueClosed when stop is closed.
+func (dev *Device) sendContext(ctx context.Context, stop <-chan struct{}, msg Message) error {
+	for {
+		err := ctx.Err()
+		select {
+		case <-stop:
+			err = ErrTxQueueClosed
+		default:
+		}
+		if err != nil {
+			atomic.AddUint64(&dev.counters.TxErrors, 1)
+			return err
+		}
+		wait := sendPollInterval
+		if deadline, ok := ctx.Deadline(); ok {
+			if left := time.Until(deadline); left < wait {
+				wait = left
+			}
+			if wait < 0 {
+				wait = 0
+			}
+		}
+		vcierr := dev.transmit(msg, wait)
+		if vcierr != ixxatvci3.VCI_E_TXQUEUE_FULL && vcierr != ixxatvci3.VCI_E_TIMEOUT {
+			return dev.count(vcierr)
+		}
+		if 0 == wait {
+			time.Sleep(time.Millisecond) //Post does not wait
+		}
+	}
+}
+
+// TxQueueOptions configure TxQueue. Zero values select defaults.
+type TxQueueOptions struct {
+	Size int // pending frames, 64 by default
+
+	//Echo completes requests when the sent frame is received back (ixxatvci3.SetTxEcho),
+	//i.e. after it actually left, instead of when it is put into the transmit FIFO.
+	//Receivers of the device then also get the sent frames.
+	Echo        bool
+	EchoTimeout time.Duration // wait for the echo, 1 second by default
+}
+
+// TxRequest is a frame in TxQueue.
+type TxRequest struct {
+	Msg Message
+
+	ctx  context.Context
+	key  uint64
+	done chan struct{}
+	err  error
+	sent time.Time
+}
+
+// Done is closed when the frame is sent or failed.
+func (r *TxRequest) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the result after Done: nil if the frame is sent.
+func (r *TxRequest) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Sent returns the time the frame was sent or echoed, zero before Done.
+func (r *TxRequest) Sent() time.Time {
+	select {
+	case <-r.done:
+		return r.sent
+	default:
+		return time.Time{}
+	}
+}
+
+// Wait waits for the result until ctx is done.
+func (r *TxRequest) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *TxRequest) finish(err error) {
+	r.err = err
+	r.sent = time.Now()
+	close(r.done)
+}
+
+// arbitrationKey orders frames like bus arbitration: the lower key wins.
+// The bits are the base ID, RTR (standard) or SRR (extended), IDE, the extended ID and RTR.
+func arbitrationKey(msg Message) uint64 {
+	var key uint32
+	if msg.Ext || msg.ID > 0x7FF {
+		id := msg.ID & 0x1FFFFFFF
+		key = (id>>18)<<21 | 1<<20 | 1<<19 | (id&0x3FFFF)<<1
+		if msg.Rtr {
+			key |= 1
+		}
+	} else {
+		key = (msg.ID & 0x7FF) << 21
+		if msg.Rtr {
+			key |= 1 << 20
+		}
+	}
+	return uint64(key)
+}
+
+type txHeap []*TxRequest
+
+func (h txHeap) Len() int            { return len(h) }
+func (h txHeap) Less(i, j int) bool  { return h[i].key < h[j].key }
+func (h txHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *txHeap) Push(x interface{}) { *h = append(*h, x.(*TxRequest)) }
+func (h *txHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return r
+}
+
+// TxQueue sends frames of a device in the order of their CAN IDs, like arbitration would:
+// a pending frame with a lower ID goes first, frames with the same ID keep their order.
+// Callers get back-pressure from the queue size and learn when each frame is sent.
+type TxQueue struct {
+	dev  *Device
+	opts TxQueueOptions
+
+	mu      sync.Mutex
+	pending txHeap
+	seq     uint64
+	space   chan struct{} // signalled when a frame leaves the queue
+	ready   chan struct{} // signalled when a frame is added
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	echoCh  <-chan Message
+	echoIdx uint
+	echoes  chan Message
+}
+
+// NewTxQueue starts a transmit queue of an opened device.
+// With opts.Echo it enables the echo of sent frames and fails if the backend cannot echo them.
+func NewTxQueue(dev *Device, opts TxQueueOptions) (q *TxQueue, err error) {
+	if opts.Size <= 0 {
+		opts.Size = 64
+	}
+	if opts.EchoTimeout <= 0 {
+		opts.EchoTimeout = time.Second
+	}
+	q = &TxQueue{
+		dev:   dev,
+		opts:  opts,
+		space: make(chan struct{}, 1),
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	if opts.Echo {
+		if vcierr := ixxatvci3.SetTxEcho(dev.Number(), true); vcierr != ixxatvci3.VCI_OK {
+			return nil, fmt.Errorf("candev: transmit echo: %s", ixxatvci3.GetErrorText(vcierr))
+		}
+		q.echoes = make(chan Message, 16)
+		q.echoCh, q.echoIdx = dev.GetMsgChannelCopy()
+		q.wg.Add(1)
+		go q.receiveEchoes()
+	}
+	q.wg.Add(1)
+	go q.run()
+	return
+}
+
+// Close fails pending frames with ErrTxQueueClosed and stops the queue.
+func (q *TxQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.done)
+	pending := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+	for _, r := range pending {
+		r.finish(ErrTxQueueClosed)
+	}
+	if q.opts.Echo {
+		ixxatvci3.SetTxEcho(q.dev.Number(), false)
+		q.dev.CloseMsgChannelCopy(q.echoIdx)
+	}
+	q.wg.Wait()
+}
+
+// Len returns the number of pending frames.
+func (q *TxQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *TxQueue) add(ctx context.Context, msg Message) (r *TxRequest, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrTxQueueClosed
+	}
+	if len(q.pending) >= q.opts.Size {
+		return nil, ErrTxQueueFull
+	}
+	q.seq++
+	r = &TxRequest{Msg: msg, ctx: ctx, key: arbitrationKey(msg)<<32 | q.seq&0xFFFFFFFF, done: make(chan struct{})}
+	heap.Push(&q.pending, r)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// TryEnqueue adds msg to the queue, ErrTxQueueFull if the queue is full.
+func (q *TxQueue) TryEnqueue(msg Message) (*TxRequest, error) {
+	return q.add(context.Background(), msg)
+}
+
+// Enqueue adds msg to the queue waiting for space until ctx is done.
+// A frame still pending when ctx is done is dropped with ctx.Err().
+func (q *TxQueue) Enqueue(ctx context.Context, msg Message) (*TxRequest, error) {
+	for {
+		r, err := q.add(ctx, msg)
+		if err != ErrTxQueueFull {
+			return r, err
+		}
+		select {
+		case <-q.space:
+		case <-q.done:
+			return nil, ErrTxQueueClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Send enqueues msg and waits until it is sent or ctx is done.
+func (q *TxQueue) Send(ctx context.Context, msg Message) error {
+	r, err := q.Enqueue(ctx, msg)
+	if err != nil {
+		return err
+	}
+	return r.Wait(ctx)
+}
+
+// next waits for the frame with the lowest key, nil when closed.
+func (q *TxQueue) next() *TxRequest {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			r := heap.Pop(&q.pending).(*TxRequest)
+			q.mu.Unlock()
+			select {
+			case q.space <- struct{}{}:
+			default:
+			}
+			return r
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.ready:
+		case <-q.done:
+			return nil
+		}
+	}
+}
+
+func (q *TxQueue) run() {
+	defer q.wg.Done()
+	for {
+		r := q.next()
+		if nil == r {
+			return
+		}
+		if err := r.ctx.Err(); err != nil {
+			r.finish(err)
+			continue
+		}
+		if q.opts.Echo {
+			q.drainEchoes()
+		}
+		err := q.dev.sendContext(r.ctx, q.done, r.Msg)
+		if nil == err && q.opts.Echo {
+			err = q.waitEcho(r)
+		}
+		r.finish(err)
+	}
+}
+
+// receiveEchoes passes received frames to waitEcho, dropping them if nobody waits.
+func (q *TxQueue) receiveEchoes() {
+	defer q.wg.Done()
+	for {
+		select {
+		case msg, ok := <-q.echoCh:
+			if !ok {
+				return
+			}
+			select {
+			case q.echoes <- msg:
+			default:
+			}
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *TxQueue) drainEchoes() {
+	for {
+		select {
+		case <-q.echoes:
+		default:
+			return
+		}
+	}
+}
+
+// waitEcho waits for a received frame equal to the sent one.
+func (q *TxQueue) waitEcho(r *TxRequest) error {
+	timer := time.NewTimer(q.opts.EchoTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-q.echoes:
+			if sameFrame(msg, r.Msg) {
+				return nil
+			}
+		case <-timer.C:
+			return ErrEchoTimeout
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-q.done:
+			return ErrTxQueueClosed
+		}
+	}
+}
+
+// sameFrame compares ID, format and data of frames.
+func sameFrame(a, b Message) bool {
+	if a.ID != b.ID || a.Rtr != b.Rtr || a.Len != b.Len {
+		return false
+	}
+	if (a.Ext || a.ID > 0x7FF) != (b.Ext || b.ID > 0x7FF) {
+		return false
+	}
+	return a.Rtr || bytes.Equal(a.Data[:a.Len], b.Data[:b.Len])
+}
@@ -0,0 +1,170 @@
+package candev_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+// blockSends makes sends of b wait until release is called, started receives the frames being sent.
+func blockSends(b *cantest.Backend) (started <-chan candev.Message, release func()) {
+	ch, gate := make(chan candev.Message, 64), make(chan struct{})
+	b.OnSend(func(msg candev.Message) {
+		ch <- msg
+		<-gate
+	})
+	return ch, func() { close(gate) }
+}
+
+func enqueue(t *testing.T, q *candev.TxQueue, msgs ...candev.Message) (list []*candev.TxRequest) {
+	t.Helper()
+	for _, msg := range msgs {
+		r, err := q.TryEnqueue(msg)
+		if err != nil {
+			t.Fatalf("TryEnqueue %s: %v", cantest.Format(msg), err)
+		}
+		list = append(list, r)
+	}
+	return
+}
+
+func wait(t *testing.T, list ...*candev.TxRequest) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, r := range list {
+		if err := r.Wait(ctx); err != nil {
+			t.Errorf("%s: %v", cantest.Format(r.Msg), err)
+		}
+	}
+}
+
+// TestTxQueue runs the queues on one device, a device stop takes a while.
+func TestTxQueue(t *testing.T) {
+	b := cantest.New(nil)
+	dev, err := b.Builder().Number(208).Speed(ixxatvci3.Bitrate500kbps).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Run()
+	defer dev.Stop()
+
+	t.Run("order", func(t *testing.T) {
+		defer b.OnSend(nil)
+		defer b.TakeSent()
+		q, err := candev.NewTxQueue(dev, candev.TxQueueOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Close()
+
+		started, release := blockSends(b)
+		blocker := enqueue(t, q, candev.Message{ID: 0x7FF})
+		<-started
+		// frames with the same ID keep their order, the others go by arbitration:
+		// a standard frame wins over an extended one with the same base ID, data over RTR
+		list := enqueue(t, q,
+			candev.Message{ID: 0x123<<18 | 5, Ext: true, Rtr: true},
+			candev.Message{ID: 0x123, Len: 1, Data: [8]byte{1}},
+			candev.Message{ID: 0x123<<18 | 5, Ext: true},
+			candev.Message{ID: 0x123, Rtr: true},
+			candev.Message{ID: 0x123, Len: 1, Data: [8]byte{2}},
+			candev.Message{ID: 0x122<<18 | 0x3FFFF, Ext: true},
+			candev.Message{ID: 0x050},
+			candev.Message{ID: 0x123, Ext: true},
+			candev.Message{ID: 0x123, Len: 1, Data: [8]byte{3}},
+		)
+		if n := q.Len(); n != len(list) {
+			t.Errorf("Len = %d, want %d", n, len(list))
+		}
+		release()
+		wait(t, append(blocker, list...)...)
+		b.ExpectSent(t,
+			candev.Message{ID: 0x7FF},
+			candev.Message{ID: 0x123, Ext: true},
+			candev.Message{ID: 0x050},
+			candev.Message{ID: 0x122<<18 | 0x3FFFF, Ext: true},
+			candev.Message{ID: 0x123, Len: 1, Data: [8]byte{1}},
+			candev.Message{ID: 0x123, Len: 1, Data: [8]byte{2}},
+			candev.Message{ID: 0x123, Len: 1, Data: [8]byte{3}},
+			candev.Message{ID: 0x123, Rtr: true},
+			candev.Message{ID: 0x123<<18 | 5, Ext: true},
+			candev.Message{ID: 0x123<<18 | 5, Ext: true, Rtr: true},
+		)
+		for _, r := range list {
+			if r.Sent().IsZero() {
+				t.Errorf("%s: no send time", cantest.Format(r.Msg))
+			}
+		}
+	})
+
+	t.Run("full", func(t *testing.T) {
+		defer b.OnSend(nil)
+		defer b.TakeSent()
+		q, err := candev.NewTxQueue(dev, candev.TxQueueOptions{Size: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Close()
+
+		started, release := blockSends(b)
+		blocker := enqueue(t, q, candev.Message{ID: 0x100})
+		<-started
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		expiring, err := q.Enqueue(ctx, candev.Message{ID: 0x101})
+		if err != nil {
+			t.Fatal(err)
+		}
+		list := enqueue(t, q, candev.Message{ID: 0x102})
+		if _, err := q.TryEnqueue(candev.Message{ID: 0x103}); err != candev.ErrTxQueueFull {
+			t.Errorf("TryEnqueue on a full queue: %v", err)
+		}
+		if _, err := q.Enqueue(ctx, candev.Message{ID: 0x104}); err != context.DeadlineExceeded {
+			t.Errorf("Enqueue on a full queue: %v", err)
+		}
+		// the frame expired while pending is dropped
+		release()
+		wait(t, append(blocker, list...)...)
+		if err := expiring.Wait(context.Background()); err != context.DeadlineExceeded {
+			t.Errorf("expired frame: %v", err)
+		}
+		b.ExpectSent(t, candev.Message{ID: 0x100}, candev.Message{ID: 0x102})
+	})
+
+	t.Run("echo", func(t *testing.T) {
+		defer b.TakeSent()
+		q, err := candev.NewTxQueue(dev, candev.TxQueueOptions{Echo: true, EchoTimeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		msg := candev.Message{ID: 0x123, Len: 2, Data: [8]byte{1, 2}}
+		if err := q.Send(ctx, msg); err != nil {
+			t.Errorf("Send with echo: %v", err)
+		}
+		// a different frame on the bus is no echo, neither in format nor in data
+		b.Corrupt(cantest.Tx, 1, func(m *candev.Message) { m.Ext = true })
+		if err := q.Send(ctx, msg); err != candev.ErrEchoTimeout {
+			t.Errorf("Send with an extended echo: %v", err)
+		}
+		b.Corrupt(cantest.Tx, 1, func(m *candev.Message) { m.Data[1] = 0 })
+		if err := q.Send(ctx, msg); err != candev.ErrEchoTimeout {
+			t.Errorf("Send with a corrupted echo: %v", err)
+		}
+		b.Drop(cantest.Tx, 1)
+		if err := q.Send(ctx, msg); err != candev.ErrEchoTimeout {
+			t.Errorf("Send without echo: %v", err)
+		}
+		if err := q.Send(ctx, msg); err != nil {
+			t.Errorf("Send after the faults: %v", err)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+// Package canopen implements basic CANopen master services (CiA 301)
+// on top of candev.Device: NMT, SDO client, PDO mapping, SYNC producer,
+// heartbeat/node guarding consumer and EMCY decoding.
+package canopen
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Function codes of the predefined connection set (11-bit COB-ID = function code + node ID).
+const (
+	cobNMT       = 0x000
+	cobSYNC      = 0x080
+	cobEMCY      = 0x080
+	cobTPDO1     = 0x180
+	cobRPDO1     = 0x200
+	cobSDOTx     = 0x580 // server to client
+	cobSDORx     = 0x600 // client to server
+	cobHeartbeat = 0x700
+
+	cobFunctionMask = 0x780
+	cobNodeMask     = 0x07F
+)
+
+// MaxNodeID is the highest valid CANopen node ID.
+const MaxNodeID = 127
+
+// Bus is the part of candev.Device used by Master.
+type Bus interface {
+	Send(msg candev.Message) (err error)
+	GetMsgChannelCopy() (ch <-chan candev.Message, idx uint)
+	CloseMsgChannelCopy(idx uint)
+}
+
+// Master is a CANopen master working on a single CAN device.
+// Callbacks are called from the receiving goroutine and must not block,
+// so SDO transfers cannot be made from a callback directly.
+type Master struct {
+	bus   Bus
+	rxIdx uint
+	rx    <-chan candev.Message
+
+	mu        sync.Mutex
+	sdo       map[uint8]chan candev.Message
+	sdoBusy   [MaxNodeID + 1]sync.Mutex // held during an SDO transfer with the node
+	nodes     map[uint8]*nodeMonitor
+	pdo       map[uint32]func(msg candev.Message)
+	emcy      func(em Emergency)
+	onState   func(node uint8, state NMTState)
+	syncStop  chan struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	isRunning bool
+}
+
+// NewMaster creates a CANopen master on bus (usually *candev.Device).
+// Call Run to start processing received messages.
+func NewMaster(bus Bus) *Master {
+	return &Master{
+		bus:   bus,
+		sdo:   make(map[uint8]chan candev.Message),
+		nodes: make(map[uint8]*nodeMonitor),
+		pdo:   make(map[uint32]func(msg candev.Message)),
+	}
+}
+
+// Run starts receiving CANopen messages.
+func (m *Master) Run() {
+	if nil == m {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isRunning {
+		return
+	}
+	m.isRunning = true
+	m.stop = make(chan struct{})
+	m.rx, m.rxIdx = m.bus.GetMsgChannelCopy()
+
+	m.wg.Add(2)
+	go m.receiverThread()
+	go m.monitorThread()
+}
+
+// Stop stops receiving, SYNC production and node monitoring.
+func (m *Master) Stop() {
+	if nil == m {
+		return
+	}
+	m.StopSync()
+
+	m.mu.Lock()
+	if !m.isRunning {
+		m.mu.Unlock()
+		return
+	}
+	m.isRunning = false
+	close(m.stop)
+	m.mu.Unlock()
+
+	m.bus.CloseMsgChannelCopy(m.rxIdx)
+	m.wg.Wait()
+}
+
+func (m *Master) receiverThread() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case msg, ok := <-m.rx:
+			if !ok {
+				return
+			}
+			m.dispatch(msg)
+		}
+	}
+}
+
+func (m *Master) dispatch(msg candev.Message) {
+	if msg.Ext {
+		return
+	}
+	function := msg.ID & cobFunctionMask
+	node := uint8(msg.ID & cobNodeMask)
+
+	m.mu.Lock()
+	pdoHandler, isPDO := m.pdo[msg.ID]
+	sdo, isSDO := m.sdo[node]
+	emcy := m.emcy
+	onState := m.onState
+	m.mu.Unlock()
+
+	if isPDO && !msg.Rtr {
+		pdoHandler(msg)
+		return
+	}
+
+	switch function {
+	case cobSDOTx:
+		if isSDO {
+			select {
+			case sdo <- msg:
+			default: // nobody waits for this response
+			}
+		}
+	case cobHeartbeat:
+		if !msg.Rtr && msg.Len >= 1 {
+			state, changed := m.heartbeatReceived(node, msg.Data[0])
+			if changed && nil != onState {
+				onState(node, state)
+			}
+		}
+	case cobEMCY:
+		if 0 != node && nil != emcy && !msg.Rtr {
+			emcy(decodeEmergency(node, msg))
+		}
+	}
+}
+
+func (m *Master) send(id uint32, rtr bool, data []byte) (err error) {
+	msg := candev.Message{ID: id, Rtr: rtr, Len: uint8(len(data))}
+	copy(msg.Data[:], data)
+	err = m.bus.Send(msg)
+	return
+}
+
+func checkNodeID(node uint8) (err error) {
+	if 0 == node || node > MaxNodeID {
+		err = errors.New("canopen: node ID must be 1..127")
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package canopen
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Emergency is a decoded EMCY message.
+type Emergency struct {
+	Node          uint8
+	Code          uint16  // emergency error code
+	Register      uint8   // error register (object 1001h)
+	Manufacturer  [5]byte // manufacturer-specific error code
+	ManufacturerN uint8   // number of valid manufacturer bytes
+}
+
+// Error register bits (object 1001h).
+const (
+	ErrRegGeneric       = 0x01
+	ErrRegCurrent       = 0x02
+	ErrRegVoltage       = 0x04
+	ErrRegTemperature   = 0x08
+	ErrRegCommunication = 0x10
+	ErrRegProfile       = 0x20
+	ErrRegManufacturer  = 0x80
+)
+
+func decodeEmergency(node uint8, msg candev.Message) (em Emergency) {
+	em.Node = node
+	if msg.Len >= 2 {
+		em.Code = binary.LittleEndian.Uint16(msg.Data[0:2])
+	}
+	if msg.Len >= 3 {
+		em.Register = msg.Data[2]
+	}
+	if msg.Len > 3 {
+		em.ManufacturerN = uint8(copy(em.Manufacturer[:], msg.Data[3:msg.Len]))
+	}
+	return
+}
+
+// IsReset returns true if EMCY signals that errors were reset or there is no error.
+func (em Emergency) IsReset() bool {
+	return 0 == em.Code
+}
+
+// Class returns a description of the error code class.
+func (em Emergency) Class() string {
+	switch {
+	case 0x0000 == em.Code:
+		return "error reset or no error"
+	case 0x1000 == em.Code&0xFF00:
+		return "generic error"
+	case 0x2000 == em.Code&0xF000:
+		return "current"
+	case 0x3000 == em.Code&0xF000:
+		return "voltage"
+	case 0x4000 == em.Code&0xF000:
+		return "temperature"
+	case 0x5000 == em.Code&0xFF00:
+		return "device hardware"
+	case 0x6000 == em.Code&0xF000:
+		return "device software"
+	case 0x7000 == em.Code&0xFF00:
+		return "additional modules"
+	case 0x8000 == em.Code&0xF000:
+		return "monitoring"
+	case 0x9000 == em.Code&0xFF00:
+		return "external error"
+	case 0xF000 == em.Code&0xFF00:
+		return "additional functions"
+	case 0xFF00 == em.Code&0xFF00:
+		return "device specific"
+	}
+	return "unknown"
+}
+
+func (em Emergency) String() string {
+	return fmt.Sprintf("node %d EMCY %04Xh (%s) register %02Xh manufacturer % X",
+		em.Node, em.Code, em.Class(), em.Register, em.Manufacturer[:em.ManufacturerN])
+}
+
+// OnEmergency sets callback for EMCY messages.
+func (m *Master) OnEmergency(callback func(em Emergency)) {
+	m.mu.Lock()
+	m.emcy = callback
+	m.mu.Unlock()
+}
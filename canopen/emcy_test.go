@@ -0,0 +1,68 @@
+package canopen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+func TestEmergency(t *testing.T) {
+	bus := new(candev.Loopback)
+	m := NewMaster(bus)
+	received := make(chan Emergency, 4)
+	m.OnEmergency(func(em Emergency) { received <- em })
+	m.Run()
+	defer m.Stop()
+
+	m.Sync()                                                     // SYNC has the EMCY function code and node 0
+	bus.Send(candev.Message{ID: cobEMCY + 5, Rtr: true})         // a remote request is no EMCY
+	bus.Send(candev.Message{ID: cobEMCY + 5, Ext: true, Len: 8}) // not CANopen
+	bus.Send(candev.Message{ID: cobEMCY + 5, Len: 5, Data: [8]byte{0x10, 0x23, ErrRegCurrent | ErrRegGeneric, 0xAA, 0xBB}})
+	bus.Send(candev.Message{ID: cobEMCY + 0x7F, Len: 8})
+
+	want := []Emergency{
+		{Node: 5, Code: 0x2310, Register: 0x03, Manufacturer: [5]byte{0xAA, 0xBB}, ManufacturerN: 2},
+		{Node: 0x7F, ManufacturerN: 5},
+	}
+	for _, w := range want {
+		select {
+		case em := <-received:
+			if em != w {
+				t.Errorf("%v, want %v", em, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v not received", w)
+		}
+	}
+	em := want[0]
+	if s := em.String(); s != "node 5 EMCY 2310h (current) register 03h manufacturer AA BB" {
+		t.Errorf("String() = %q", s)
+	}
+	if em.IsReset() || !want[1].IsReset() {
+		t.Error("IsReset")
+	}
+}
+
+func TestEmergencyClass(t *testing.T) {
+	classes := map[uint16]string{
+		0x0000: "error reset or no error",
+		0x1000: "generic error",
+		0x2310: "current",
+		0x3210: "voltage",
+		0x4210: "temperature",
+		0x5000: "device hardware",
+		0x6100: "device software",
+		0x7000: "additional modules",
+		0x8110: "monitoring",
+		0x9000: "external error",
+		0xF000: "additional functions",
+		0xFF01: "device specific",
+		0x1100: "unknown",
+	}
+	for code, class := range classes {
+		if c := (Emergency{Code: code}).Class(); c != class {
+			t.Errorf("%04Xh: %s, want %s", code, c, class)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package canopen
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	monitorPeriod    = 10 * time.Millisecond
+	guardingToggle   = 0x80
+	guardingStateBit = 0x7F
+)
+
+type nodeMonitor struct {
+	state    NMTState
+	lastSeen time.Time
+	timeout  time.Duration // heartbeat consumer time or node life time
+
+	guarding   bool
+	guardTime  time.Duration
+	lastGuard  time.Time
+	toggle     byte
+	toggleInit bool
+}
+
+// OnStateChange sets callback for node state changes reported by heartbeat or node guarding.
+// StateUnknown is reported when a monitored node times out.
+func (m *Master) OnStateChange(callback func(node uint8, state NMTState)) {
+	m.mu.Lock()
+	m.onState = callback
+	m.mu.Unlock()
+}
+
+// State returns the last known state of node.
+func (m *Master) State(node uint8) NMTState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mon, ok := m.nodes[node]; ok {
+		return mon.state
+	}
+	return StateUnknown
+}
+
+// MonitorHeartbeat starts heartbeat consumer for node.
+// The node is lost if no heartbeat is received within timeout.
+func (m *Master) MonitorHeartbeat(node uint8, timeout time.Duration) (err error) {
+	if err = checkNodeID(node); err != nil {
+		return
+	}
+	if timeout <= 0 {
+		err = errors.New("canopen: heartbeat consumer time must be positive")
+		return
+	}
+	m.mu.Lock()
+	m.nodes[node] = &nodeMonitor{state: StateUnknown, timeout: timeout, lastSeen: time.Now()}
+	m.mu.Unlock()
+	return
+}
+
+// GuardNode starts node guarding: remote request is sent to node every guardTime,
+// the node is lost if it does not answer within guardTime*lifeTimeFactor.
+func (m *Master) GuardNode(node uint8, guardTime time.Duration, lifeTimeFactor uint8) (err error) {
+	if err = checkNodeID(node); err != nil {
+		return
+	}
+	if guardTime <= 0 || 0 == lifeTimeFactor {
+		err = errors.New("canopen: guard time and life time factor must be positive")
+		return
+	}
+	m.mu.Lock()
+	m.nodes[node] = &nodeMonitor{
+		state:     StateUnknown,
+		timeout:   guardTime * time.Duration(lifeTimeFactor),
+		lastSeen:  time.Now(),
+		guarding:  true,
+		guardTime: guardTime,
+	}
+	m.mu.Unlock()
+	return
+}
+
+// Forget stops heartbeat consumer or node guarding for node.
+func (m *Master) Forget(node uint8) {
+	m.mu.Lock()
+	delete(m.nodes, node)
+	m.mu.Unlock()
+}
+
+func (m *Master) heartbeatReceived(node uint8, data byte) (state NMTState, changed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mon, ok := m.nodes[node]
+	if !ok {
+		return
+	}
+	state = NMTState(data)
+	if mon.guarding {
+		state = NMTState(data & guardingStateBit)
+		toggle := data & guardingToggle
+		if mon.toggleInit && toggle == mon.toggle {
+			return // repeated answer, toggle bit must alternate
+		}
+		mon.toggleInit = true
+		mon.toggle = toggle
+	}
+	mon.lastSeen = time.Now()
+	changed = mon.setState(state)
+	return
+}
+
+func (mon *nodeMonitor) setState(state NMTState) (changed bool) {
+	if mon.state == state {
+		return
+	}
+	mon.state = state
+	if StateBootUp == state {
+		mon.toggleInit = false
+	}
+	changed = true
+	return
+}
+
+func (m *Master) monitorThread() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(monitorPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			var guard, lost []uint8
+
+			m.mu.Lock()
+			onState := m.onState
+			for node, mon := range m.nodes {
+				if mon.guarding && now.Sub(mon.lastGuard) >= mon.guardTime {
+					mon.lastGuard = now
+					guard = append(guard, node)
+				}
+				if now.Sub(mon.lastSeen) > mon.timeout && mon.setState(StateUnknown) {
+					lost = append(lost, node)
+				}
+			}
+			m.mu.Unlock()
+
+			for _, node := range guard {
+				m.send(cobHeartbeat+uint32(node), true, nil)
+			}
+			if nil != onState {
+				for _, node := range lost {
+					onState(node, StateUnknown)
+				}
+			}
+		}
+	}
+}
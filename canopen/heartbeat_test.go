@@ -0,0 +1,104 @@
+package canopen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+type stateChange struct {
+	node  uint8
+	state NMTState
+}
+
+func nextChange(t *testing.T, changes <-chan stateChange, want stateChange) {
+	t.Helper()
+	select {
+	case c := <-changes:
+		if c != want {
+			t.Errorf("node %d %s, want node %d %s", c.node, c.state, want.node, want.state)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no change to node %d %s", want.node, want.state)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	bus := new(candev.Loopback)
+	ch, idx := bus.GetMsgChannelCopy()
+	defer bus.CloseMsgChannelCopy(idx)
+	m := NewMaster(bus)
+	changes := make(chan stateChange, 16)
+	m.OnStateChange(func(node uint8, state NMTState) { changes <- stateChange{node, state} })
+	if err := m.MonitorHeartbeat(5, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.GuardNode(6, 50*time.Millisecond, 4); err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	defer m.Stop()
+	heartbeat := func(node uint8, data byte) {
+		bus.Send(candev.Message{ID: cobHeartbeat + uint32(node), Len: 1, Data: [8]byte{data}})
+	}
+
+	heartbeat(5, byte(StateBootUp))
+	nextChange(t, changes, stateChange{5, StateBootUp})
+	heartbeat(5, byte(StatePreOperational))
+	heartbeat(5, byte(StatePreOperational)) // no change
+	heartbeat(5, byte(StateOperational))
+	nextChange(t, changes, stateChange{5, StatePreOperational})
+	nextChange(t, changes, stateChange{5, StateOperational})
+	heartbeat(7, byte(StateOperational)) // not monitored
+	if s := m.State(5); s != StateOperational {
+		t.Errorf("node 5 %s", s)
+	}
+
+	// node guarding requests the state, answers toggle bit 7
+	timeout := time.After(time.Second)
+	for guarded := false; !guarded; {
+		select {
+		case msg := <-ch:
+			guarded = msg.ID == cobHeartbeat+6 && msg.Rtr
+		case <-timeout:
+			t.Fatal("node 6 is not guarded")
+		}
+	}
+	heartbeat(6, byte(StateOperational))
+	nextChange(t, changes, stateChange{6, StateOperational})
+	heartbeat(6, guardingToggle|byte(StateStopped))
+	nextChange(t, changes, stateChange{6, StateStopped})
+	heartbeat(6, guardingToggle|byte(StateOperational)) // the toggle bit did not alternate
+	heartbeat(5, byte(StateStopped))
+	nextChange(t, changes, stateChange{5, StateStopped})
+	if s := m.State(6); s != StateStopped {
+		t.Errorf("node 6 %s after an answer with the same toggle bit", s)
+	}
+
+	// nodes without heartbeats or answers are lost
+	lost := map[stateChange]bool{}
+	for len(lost) < 2 {
+		select {
+		case c := <-changes:
+			lost[c] = true
+		case <-time.After(time.Second):
+			t.Fatalf("lost nodes %v", lost)
+		}
+	}
+	if !lost[stateChange{5, StateUnknown}] || !lost[stateChange{6, StateUnknown}] {
+		t.Errorf("changes %v", lost)
+	}
+
+	m.Forget(5)
+	heartbeat(5, byte(StateOperational))
+	if s := m.State(5); s != StateUnknown {
+		t.Errorf("forgotten node %s", s)
+	}
+	if err := m.MonitorHeartbeat(0, time.Second); nil == err {
+		t.Error("heartbeat of node 0 monitored")
+	}
+	if err := m.GuardNode(6, time.Second, 0); nil == err {
+		t.Error("node guarded without life time factor")
+	}
+}
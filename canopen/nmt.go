@@ -0,0 +1,54 @@
+package canopen
+
+import "fmt"
+
+// NMTCommand is a network management command specifier.
+type NMTCommand uint8
+
+// NMT commands.
+const (
+	NMTStart               NMTCommand = 0x01
+	NMTStop                NMTCommand = 0x02
+	NMTEnterPreOperational NMTCommand = 0x80
+	NMTResetNode           NMTCommand = 0x81
+	NMTResetCommunication  NMTCommand = 0x82
+)
+
+// NMTState is a node state as reported by heartbeat or node guarding.
+type NMTState uint8
+
+// NMT states. StateUnknown is never sent by a node, it is reported when
+// heartbeat or guarding messages stop arriving.
+const (
+	StateBootUp         NMTState = 0x00
+	StateStopped        NMTState = 0x04
+	StateOperational    NMTState = 0x05
+	StatePreOperational NMTState = 0x7F
+	StateUnknown        NMTState = 0xFF
+)
+
+func (s NMTState) String() string {
+	switch s {
+	case StateBootUp:
+		return "boot-up"
+	case StateStopped:
+		return "stopped"
+	case StateOperational:
+		return "operational"
+	case StatePreOperational:
+		return "pre-operational"
+	case StateUnknown:
+		return "unknown"
+	}
+	return fmt.Sprintf("state 0x%02X", uint8(s))
+}
+
+// NMT sends NMT command to node. Node 0 addresses all nodes.
+func (m *Master) NMT(node uint8, cmd NMTCommand) (err error) {
+	if node > MaxNodeID {
+		err = fmt.Errorf("canopen: wrong node ID %d", node)
+		return
+	}
+	err = m.send(cobNMT, false, []byte{byte(cmd), node})
+	return
+}
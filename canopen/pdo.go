@@ -0,0 +1,242 @@
+package canopen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Communication and mapping parameter objects.
+const (
+	objRPDOCommunication = 0x1400
+	objRPDOMapping       = 0x1600
+	objTPDOCommunication = 0x1800
+	objTPDOMapping       = 0x1A00
+
+	pdoInvalidBit = 0x80000000
+	maxPDOBits    = 64
+)
+
+// PDO transmission types.
+const (
+	PDOSyncAcyclic   = 0x00 // synchronous, after an event and the next SYNC
+	PDOEventSpecific = 0xFE // event-driven, manufacturer specific
+	PDOEventProfile  = 0xFF // event-driven, device/application profile specific
+)
+
+// PDOEntry is an object mapped into a PDO.
+type PDOEntry struct {
+	Index    uint16
+	SubIndex uint8
+	Bits     uint8 // object size in bits
+}
+
+// PDO is a process data object description.
+// Values of mapped objects are packed in order, least significant bit first.
+type PDO struct {
+	Number           uint16 // 1..512
+	COBID            uint32 // 0 means predefined connection set COB-ID (PDO 1..4 only)
+	TransmissionType uint8  // 1..240 every n-th SYNC, 0xFE/0xFF event-driven
+	InhibitTime      uint16 // TPDO only, 100 us units
+	EventTimer       uint16 // TPDO only, ms
+	Entries          []PDOEntry
+}
+
+// DefaultPDOCOBID returns predefined connection set COB-ID of PDO 1..4 for node.
+// tpdo is true for PDO transmitted by the node.
+func DefaultPDOCOBID(tpdo bool, number uint16, node uint8) (cobid uint32) {
+	if 0 == number || number > 4 {
+		return
+	}
+	cobid = cobRPDO1 + 0x100*uint32(number-1) + uint32(node)
+	if tpdo {
+		cobid = cobTPDO1 + 0x100*uint32(number-1) + uint32(node)
+	}
+	return
+}
+
+// Bits returns the total size of mapped objects in bits.
+func (p *PDO) Bits() (bits int) {
+	for _, e := range p.Entries {
+		bits += int(e.Bits)
+	}
+	return
+}
+
+func (p *PDO) check() (err error) {
+	if 0 == p.Number || p.Number > 512 {
+		err = fmt.Errorf("canopen: wrong PDO number %d", p.Number)
+		return
+	}
+	if p.Bits() > maxPDOBits {
+		err = fmt.Errorf("canopen: PDO %d mapping is %d bits long", p.Number, p.Bits())
+		return
+	}
+	for _, e := range p.Entries {
+		if 0 == e.Bits {
+			err = fmt.Errorf("canopen: PDO %d entry %04Xh:%02Xh has zero size", p.Number, e.Index, e.SubIndex)
+			return
+		}
+	}
+	return
+}
+
+func (p *PDO) cobID(tpdo bool, node uint8) uint32 {
+	if 0 != p.COBID {
+		return p.COBID
+	}
+	return DefaultPDOCOBID(tpdo, p.Number, node)
+}
+
+// Encode packs values of mapped objects into a CAN message.
+func (p *PDO) Encode(values []uint64) (msg candev.Message, err error) {
+	if err = p.check(); err != nil {
+		return
+	}
+	if len(values) != len(p.Entries) {
+		err = fmt.Errorf("canopen: PDO %d has %d entries, %d values given", p.Number, len(p.Entries), len(values))
+		return
+	}
+
+	var packed uint64
+	var offset uint
+	for i, e := range p.Entries {
+		packed |= (values[i] & bitMask(e.Bits)) << offset
+		offset += uint(e.Bits)
+	}
+	binary.LittleEndian.PutUint64(msg.Data[:], packed)
+	msg.ID = p.COBID
+	msg.Len = uint8((offset + 7) / 8)
+	return
+}
+
+// Decode unpacks values of mapped objects from a CAN message.
+func (p *PDO) Decode(msg candev.Message) (values []uint64, err error) {
+	if err = p.check(); err != nil {
+		return
+	}
+	if int(msg.Len)*8 < p.Bits() {
+		err = fmt.Errorf("canopen: PDO %d is too short: %d bytes", p.Number, msg.Len)
+		return
+	}
+
+	packed := binary.LittleEndian.Uint64(msg.Data[:])
+	var offset uint
+	for _, e := range p.Entries {
+		values = append(values, (packed>>offset)&bitMask(e.Bits))
+		offset += uint(e.Bits)
+	}
+	return
+}
+
+func bitMask(bits uint8) uint64 {
+	if bits >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << uint(bits)) - 1
+}
+
+// ConfigureRPDO writes communication and mapping parameters of a PDO received by node.
+func (m *Master) ConfigureRPDO(node uint8, p PDO) error {
+	return m.configurePDO(node, false, p)
+}
+
+// ConfigureTPDO writes communication and mapping parameters of a PDO transmitted by node.
+func (m *Master) ConfigureTPDO(node uint8, p PDO) error {
+	return m.configurePDO(node, true, p)
+}
+
+func (m *Master) configurePDO(node uint8, tpdo bool, p PDO) (err error) {
+	if err = checkNodeID(node); err != nil {
+		return
+	}
+	if err = p.check(); err != nil {
+		return
+	}
+	cobid := p.cobID(tpdo, node)
+	if 0 == cobid {
+		err = errors.New("canopen: COB-ID must be set for PDO above 4")
+		return
+	}
+
+	comm := uint16(objRPDOCommunication) + p.Number - 1
+	mapping := uint16(objRPDOMapping) + p.Number - 1
+	if tpdo {
+		comm = uint16(objTPDOCommunication) + p.Number - 1
+		mapping = uint16(objTPDOMapping) + p.Number - 1
+	}
+
+	sdo := m.SDO(node)
+
+	// PDO must be invalid while its mapping is changed
+	if err = sdo.DownloadUint32(comm, 1, cobid|pdoInvalidBit); err != nil {
+		return
+	}
+	if err = sdo.DownloadUint8(comm, 2, p.TransmissionType); err != nil {
+		return
+	}
+	if tpdo {
+		if err = sdo.DownloadUint16(comm, 3, p.InhibitTime); err != nil {
+			return
+		}
+		if err = sdo.DownloadUint16(comm, 5, p.EventTimer); err != nil {
+			return
+		}
+	}
+
+	if err = sdo.DownloadUint8(mapping, 0, 0); err != nil {
+		return
+	}
+	for i, e := range p.Entries {
+		value := uint32(e.Index)<<16 | uint32(e.SubIndex)<<8 | uint32(e.Bits)
+		if err = sdo.DownloadUint32(mapping, uint8(i+1), value); err != nil {
+			return
+		}
+	}
+	if err = sdo.DownloadUint8(mapping, 0, uint8(len(p.Entries))); err != nil {
+		return
+	}
+
+	err = sdo.DownloadUint32(comm, 1, cobid)
+	return
+}
+
+// SendPDO transmits PDO p with values to node. Used for RPDOs of the node.
+func (m *Master) SendPDO(node uint8, p PDO, values []uint64) (err error) {
+	msg, err := p.Encode(values)
+	if err != nil {
+		return
+	}
+	msg.ID = p.cobID(false, node)
+	err = m.bus.Send(msg)
+	return
+}
+
+// OnPDO calls handler with values of every PDO p transmitted by node.
+// Handler nil removes the subscription.
+func (m *Master) OnPDO(node uint8, p PDO, handler func(values []uint64)) (err error) {
+	if err = p.check(); err != nil {
+		return
+	}
+	cobid := p.cobID(true, node)
+	if 0 == cobid {
+		err = errors.New("canopen: COB-ID must be set for PDO above 4")
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if nil == handler {
+		delete(m.pdo, cobid)
+		return
+	}
+	m.pdo[cobid] = func(msg candev.Message) {
+		values, err := p.Decode(msg)
+		if nil == err {
+			handler(values)
+		}
+	}
+	return
+}
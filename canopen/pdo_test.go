@@ -0,0 +1,140 @@
+package canopen
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+var testPDO = PDO{Number: 2, Entries: []PDOEntry{
+	{Index: 0x6000, SubIndex: 1, Bits: 8},
+	{Index: 0x6001, Bits: 16},
+	{Index: 0x6002, Bits: 1},
+	{Index: 0x6003, Bits: 3},
+}}
+
+func TestPDOEncode(t *testing.T) {
+	// values are packed least significant bit first, cut to their size
+	msg, err := testPDO.Encode([]uint64{0x1AB, 0x1234, 1, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Len != 4 || msg.Data != [8]byte{0xAB, 0x34, 0x12, 0x0B} {
+		t.Errorf("Encode = % X", msg.Data[:msg.Len])
+	}
+	values, err := testPDO.Decode(msg)
+	if err != nil || !reflect.DeepEqual(values, []uint64{0xAB, 0x1234, 1, 5}) {
+		t.Errorf("Decode = %X, %v", values, err)
+	}
+	msg.Len = 3
+	if _, err := testPDO.Decode(msg); nil == err {
+		t.Error("short PDO decoded")
+	}
+
+	full := PDO{Number: 1, Entries: []PDOEntry{{Index: 0x6000, Bits: 64}}}
+	if msg, err := full.Encode([]uint64{0x0102030405060708}); err != nil || msg.Len != 8 || msg.Data[0] != 0x08 {
+		t.Errorf("64 bits: % X, %v", msg.Data, err)
+	}
+	invalid := []PDO{
+		{Number: 0},
+		{Number: 513},
+		{Number: 1, Entries: []PDOEntry{{Index: 0x6000, Bits: 64}, {Index: 0x6001, Bits: 1}}},
+		{Number: 1, Entries: []PDOEntry{{Index: 0x6000}}},
+	}
+	for _, p := range invalid {
+		if _, err := p.Encode(make([]uint64, len(p.Entries))); nil == err {
+			t.Errorf("PDO %+v encoded", p)
+		}
+	}
+	if _, err := testPDO.Encode([]uint64{1}); nil == err {
+		t.Error("PDO encoded with missing values")
+	}
+	if id := DefaultPDOCOBID(true, 4, 5); id != 0x485 {
+		t.Errorf("TPDO4 COB-ID %X", id)
+	}
+	if id := DefaultPDOCOBID(false, 5, 5); id != 0 {
+		t.Errorf("RPDO5 COB-ID %X", id)
+	}
+}
+
+func TestConfigurePDO(t *testing.T) {
+	m, srv, stop := startSDO(t)
+	defer stop()
+
+	p := testPDO
+	p.TransmissionType, p.InhibitTime, p.EventTimer = PDOEventProfile, 100, 500
+	if err := m.ConfigureTPDO(5, p); err != nil {
+		t.Fatal(err)
+	}
+	// the PDO is invalid while its mapping changes
+	want := []string{
+		"1801:01=85 02 00 80", "1801:02=FF", "1801:03=64 00", "1801:05=F4 01",
+		"1A01:00=00", "1A01:01=08 01 00 60", "1A01:02=10 00 01 60", "1A01:03=01 00 02 60", "1A01:04=03 00 03 60",
+		"1A01:00=04", "1801:01=85 02 00 00",
+	}
+	if !reflect.DeepEqual(srv.writes, want) {
+		t.Errorf("TPDO writes\n%q\nwant\n%q", srv.writes, want)
+	}
+
+	srv.writes = nil
+	p = PDO{Number: 5, COBID: 0x301, TransmissionType: 1, Entries: []PDOEntry{{Index: 0x6200, SubIndex: 1, Bits: 8}}}
+	if err := m.ConfigureRPDO(5, p); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"1404:01=01 03 00 80", "1404:02=01", "1604:00=00", "1604:01=08 01 00 62", "1604:00=01", "1404:01=01 03 00 00"}
+	if !reflect.DeepEqual(srv.writes, want) {
+		t.Errorf("RPDO writes\n%q\nwant\n%q", srv.writes, want)
+	}
+
+	p.COBID = 0
+	if err := m.ConfigureRPDO(5, p); nil == err {
+		t.Error("PDO 5 configured without COB-ID")
+	}
+	if err := m.ConfigureTPDO(0, testPDO); nil == err {
+		t.Error("PDO of node 0 configured")
+	}
+}
+
+func TestPDOExchange(t *testing.T) {
+	bus := new(candev.Loopback)
+	ch, idx := bus.GetMsgChannelCopy()
+	defer bus.CloseMsgChannelCopy(idx)
+	m := NewMaster(bus)
+	m.Run()
+	defer m.Stop()
+
+	if err := m.SendPDO(5, testPDO, []uint64{1, 2, 0, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-ch; msg.ID != 0x305 || msg.Len != 4 || msg.Data != [8]byte{0x01, 0x02, 0x00, 0x06} {
+		t.Errorf("RPDO2 sent as %03X % X", msg.ID, msg.Data[:msg.Len])
+	}
+
+	received := make(chan []uint64, 4)
+	if err := m.OnPDO(5, testPDO, func(values []uint64) { received <- values }); err != nil {
+		t.Fatal(err)
+	}
+	bus.Send(candev.Message{ID: 0x285, Rtr: true, Len: 4})                    // a remote request is no PDO
+	bus.Send(candev.Message{ID: 0x285, Len: 2})                               // too short
+	bus.Send(candev.Message{ID: 0x285, Len: 4, Data: [8]byte{7, 8, 0, 0x0F}}) // the PDO
+	select {
+	case values := <-received:
+		if !reflect.DeepEqual(values, []uint64{7, 8, 1, 7}) {
+			t.Errorf("TPDO2 values %v", values)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TPDO2 not received")
+	}
+
+	if err := m.OnPDO(5, testPDO, nil); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	n := len(m.pdo)
+	m.mu.Unlock()
+	if n != 0 || len(received) != 0 {
+		t.Errorf("%d handlers, %d values after removing the handler", n, len(received))
+	}
+}
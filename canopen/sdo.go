@@ -0,0 +1,289 @@
+package canopen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// SDO command specifiers (upper 3 bits of the first byte).
+const (
+	sdoCCSDownloadSegment  = 0x00
+	sdoCCSInitiateDownload = 0x20
+	sdoCCSInitiateUpload   = 0x40
+	sdoCCSUploadSegment    = 0x60
+	sdoCSAbort             = 0x80
+
+	sdoSCSUploadSegment    = 0x00
+	sdoSCSDownloadSegment  = 0x20
+	sdoSCSInitiateUpload   = 0x40
+	sdoSCSInitiateDownload = 0x60
+
+	sdoCSMask     = 0xE0
+	sdoToggleBit  = 0x10
+	sdoExpedited  = 0x02
+	sdoSizeIsSet  = 0x01
+	sdoLastOfData = 0x01
+)
+
+// DefaultSDOTimeout is a default SDO response timeout.
+const DefaultSDOTimeout = 500 * time.Millisecond
+
+// SDOClient performs SDO transfers with a single node.
+// Expedited and segmented transfers are supported, block transfers are not.
+// Transfers with a node are made one at a time, also from different clients of the node.
+type SDOClient struct {
+	m       *Master
+	node    uint8
+	Timeout time.Duration // response timeout, DefaultSDOTimeout if 0
+}
+
+// SDO returns SDO client for node.
+func (m *Master) SDO(node uint8) *SDOClient {
+	return &SDOClient{m: m, node: node, Timeout: DefaultSDOTimeout}
+}
+
+// Upload reads object index:subindex from the node.
+func (c *SDOClient) Upload(index uint16, subindex uint8) (data []byte, err error) {
+	if err = checkNodeID(c.node); err != nil {
+		return
+	}
+	rx, done := c.m.openSDO(c.node)
+	defer done()
+
+	var req [8]byte
+	req[0] = sdoCCSInitiateUpload
+	putMux(req[:], index, subindex)
+
+	resp, err := c.request(rx, req, index, subindex)
+	if err != nil {
+		return
+	}
+	if sdoSCSInitiateUpload != resp.Data[0]&sdoCSMask || !sameMux(resp.Data[:], index, subindex) {
+		err = c.abort(index, subindex, SDOAbortCommandSpecifier) // also a response to another request
+		return
+	}
+
+	if 0 != resp.Data[0]&sdoExpedited {
+		size := 4
+		if 0 != resp.Data[0]&sdoSizeIsSet {
+			size = 4 - int((resp.Data[0]>>2)&0x03)
+		}
+		data = append(data, resp.Data[4:4+size]...)
+		return
+	}
+
+	size := -1
+	if 0 != resp.Data[0]&sdoSizeIsSet {
+		size = int(binary.LittleEndian.Uint32(resp.Data[4:8]))
+	}
+
+	var toggle byte
+	for {
+		req = [8]byte{sdoCCSUploadSegment | toggle}
+		resp, err = c.request(rx, req, index, subindex)
+		if err != nil {
+			return
+		}
+		if sdoSCSUploadSegment != resp.Data[0]&sdoCSMask {
+			err = c.abort(index, subindex, SDOAbortCommandSpecifier)
+			return
+		}
+		if toggle != resp.Data[0]&sdoToggleBit {
+			err = c.abort(index, subindex, SDOAbortToggleBit)
+			return
+		}
+		n := 7 - int((resp.Data[0]>>1)&0x07)
+		data = append(data, resp.Data[1:1+n]...)
+		if 0 != resp.Data[0]&sdoLastOfData {
+			break
+		}
+		toggle ^= sdoToggleBit
+	}
+
+	if size >= 0 && size != len(data) {
+		err = fmt.Errorf("canopen: SDO upload %04Xh:%02Xh size mismatch: %d indicated, %d received",
+			index, subindex, size, len(data))
+	}
+	return
+}
+
+// Download writes data to object index:subindex of the node.
+func (c *SDOClient) Download(index uint16, subindex uint8, data []byte) (err error) {
+	if err = checkNodeID(c.node); err != nil {
+		return
+	}
+	if 0 == len(data) {
+		err = errors.New("canopen: nothing to download")
+		return
+	}
+	rx, done := c.m.openSDO(c.node)
+	defer done()
+
+	var req [8]byte
+	putMux(req[:], index, subindex)
+	if len(data) <= 4 {
+		req[0] = sdoCCSInitiateDownload | byte(4-len(data))<<2 | sdoExpedited | sdoSizeIsSet
+		copy(req[4:], data)
+	} else {
+		req[0] = sdoCCSInitiateDownload | sdoSizeIsSet
+		binary.LittleEndian.PutUint32(req[4:], uint32(len(data)))
+	}
+
+	resp, err := c.request(rx, req, index, subindex)
+	if err != nil {
+		return
+	}
+	if sdoSCSInitiateDownload != resp.Data[0]&sdoCSMask || !sameMux(resp.Data[:], index, subindex) {
+		err = c.abort(index, subindex, SDOAbortCommandSpecifier) // also a response to another request
+		return
+	}
+	if len(data) <= 4 {
+		return
+	}
+
+	var toggle byte
+	for len(data) > 0 {
+		n := len(data)
+		if n > 7 {
+			n = 7
+		}
+		req = [8]byte{sdoCCSDownloadSegment | toggle | byte(7-n)<<1}
+		if n == len(data) {
+			req[0] |= sdoLastOfData
+		}
+		copy(req[1:], data[:n])
+		data = data[n:]
+
+		resp, err = c.request(rx, req, index, subindex)
+		if err != nil {
+			return
+		}
+		if sdoSCSDownloadSegment != resp.Data[0]&sdoCSMask {
+			err = c.abort(index, subindex, SDOAbortCommandSpecifier)
+			return
+		}
+		if toggle != resp.Data[0]&sdoToggleBit {
+			err = c.abort(index, subindex, SDOAbortToggleBit)
+			return
+		}
+		toggle ^= sdoToggleBit
+	}
+	return
+}
+
+// UploadUint32 reads an unsigned value of up to 4 bytes.
+func (c *SDOClient) UploadUint32(index uint16, subindex uint8) (value uint32, err error) {
+	data, err := c.Upload(index, subindex)
+	if err != nil {
+		return
+	}
+	if len(data) > 4 {
+		err = fmt.Errorf("canopen: %04Xh:%02Xh is %d bytes long", index, subindex, len(data))
+		return
+	}
+	var buf [4]byte
+	copy(buf[:], data)
+	value = binary.LittleEndian.Uint32(buf[:])
+	return
+}
+
+// DownloadUint8 writes an UNSIGNED8 value.
+func (c *SDOClient) DownloadUint8(index uint16, subindex uint8, value uint8) error {
+	return c.Download(index, subindex, []byte{value})
+}
+
+// DownloadUint16 writes an UNSIGNED16 value.
+func (c *SDOClient) DownloadUint16(index uint16, subindex uint8, value uint16) error {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], value)
+	return c.Download(index, subindex, buf[:])
+}
+
+// DownloadUint32 writes an UNSIGNED32 value.
+func (c *SDOClient) DownloadUint32(index uint16, subindex uint8, value uint32) error {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], value)
+	return c.Download(index, subindex, buf[:])
+}
+
+// request sends req and waits for the server response. Abort from the server is returned as *SDOAbortError.
+func (c *SDOClient) request(rx <-chan candev.Message, req [8]byte, index uint16, subindex uint8) (resp candev.Message, err error) {
+	err = c.m.send(cobSDORx+uint32(c.node), false, req[:])
+	if err != nil {
+		return
+	}
+
+	timeout := c.Timeout
+	if 0 == timeout {
+		timeout = DefaultSDOTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case resp = <-rx:
+			if resp.Len < 8 {
+				continue // SDO frames always have 8 bytes
+			}
+			if sdoCSAbort == resp.Data[0] {
+				err = &SDOAbortError{
+					Node:     c.node,
+					Index:    binary.LittleEndian.Uint16(resp.Data[1:3]),
+					SubIndex: resp.Data[3],
+					Code:     SDOAbortCode(binary.LittleEndian.Uint32(resp.Data[4:8])),
+				}
+			}
+			return
+		case <-timer.C:
+			err = c.abort(index, subindex, SDOAbortTimeout)
+			return
+		}
+	}
+}
+
+// abort sends SDO abort to the server and returns it as error.
+func (c *SDOClient) abort(index uint16, subindex uint8, code SDOAbortCode) error {
+	var req [8]byte
+	req[0] = sdoCSAbort
+	putMux(req[:], index, subindex)
+	binary.LittleEndian.PutUint32(req[4:], uint32(code))
+	c.m.send(cobSDORx+uint32(c.node), false, req[:])
+
+	return &SDOAbortError{Node: c.node, Index: index, SubIndex: subindex, Code: code, Local: true}
+}
+
+func putMux(buf []byte, index uint16, subindex uint8) {
+	binary.LittleEndian.PutUint16(buf[1:3], index)
+	buf[3] = subindex
+}
+
+// sameMux reports whether an initiate response is about object index:subindex.
+func sameMux(buf []byte, index uint16, subindex uint8) bool {
+	return binary.LittleEndian.Uint16(buf[1:3]) == index && buf[3] == subindex
+}
+
+// openSDO waits for the end of another transfer with node and registers a channel for SDO responses of node.
+// Responses carry only the node ID, so a single transfer with the node may be in progress.
+func (m *Master) openSDO(node uint8) (rx <-chan candev.Message, done func()) {
+	m.sdoBusy[node].Lock()
+	ch := make(chan candev.Message, 1)
+	m.mu.Lock()
+	m.sdo[node] = ch
+	m.mu.Unlock()
+
+	rx = ch
+	done = func() {
+		m.mu.Lock()
+		if m.sdo[node] == ch {
+			delete(m.sdo, node)
+		}
+		m.mu.Unlock()
+		m.sdoBusy[node].Unlock()
+	}
+	return
+}
@@ -0,0 +1,322 @@
+package canopen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// serveSDO answers expedited uploads of node with the object index as value after delay.
+func serveSDO(bus *candev.Loopback, node uint8, delay time.Duration) (stop func()) {
+	ch, idx := bus.GetMsgChannelCopy()
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var msg candev.Message
+			select {
+			case msg = <-ch:
+			case <-quit:
+				return
+			}
+			if msg.ID != cobSDORx+uint32(node) || sdoCCSInitiateUpload != msg.Data[0] {
+				continue
+			}
+			time.Sleep(delay)
+			resp := candev.Message{ID: cobSDOTx + uint32(node), Len: 8}
+			resp.Data[0] = sdoSCSInitiateUpload | sdoExpedited | sdoSizeIsSet
+			copy(resp.Data[1:4], msg.Data[1:4])
+			binary.LittleEndian.PutUint32(resp.Data[4:], uint32(binary.LittleEndian.Uint16(msg.Data[1:3])))
+			bus.Send(resp)
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+		bus.CloseMsgChannelCopy(idx)
+	}
+}
+
+func TestSDOConcurrentClients(t *testing.T) {
+	bus := new(candev.Loopback)
+	m := NewMaster(bus)
+	m.Run()
+	defer m.Stop()
+	defer serveSDO(bus, 5, 20*time.Millisecond)()
+
+	// separate clients of the same node share the response routing of the node
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		index := uint16(0x2000 + i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := m.SDO(5).UploadUint32(index, 0); err != nil || v != uint32(index) {
+				t.Errorf("upload %04Xh = %X, %v", index, v, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// sdoServer is an SDO server of a node with an object dictionary,
+// it makes expedited and segmented transfers.
+type sdoServer struct {
+	bus  *candev.Loopback
+	node uint8
+	// tamper changes a response before it is sent
+	tamper func(resp *candev.Message)
+
+	mu      sync.Mutex
+	objects map[uint32][]byte // index<<8 | subindex
+	writes  []string          // downloads in order, "index:subindex=data"
+	aborts  []candev.Message  // aborts from the client
+
+	mux     uint32
+	upload  []byte
+	segment []byte // segmented download
+	stop    func()
+}
+
+func newSDOServer(bus *candev.Loopback, node uint8) *sdoServer {
+	srv := &sdoServer{bus: bus, node: node, objects: make(map[uint32][]byte)}
+	ch, idx := bus.GetMsgChannelCopy()
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case msg := <-ch:
+				if msg.ID == cobSDORx+uint32(node) && 8 == msg.Len {
+					srv.handle(msg)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	srv.stop = func() {
+		close(quit)
+		<-done
+		bus.CloseMsgChannelCopy(idx)
+	}
+	return srv
+}
+
+func (srv *sdoServer) set(index uint16, subindex uint8, data []byte) {
+	srv.mu.Lock()
+	srv.objects[uint32(index)<<8|uint32(subindex)] = data
+	srv.mu.Unlock()
+}
+
+func (srv *sdoServer) get(index uint16, subindex uint8) []byte {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.objects[uint32(index)<<8|uint32(subindex)]
+}
+
+func (srv *sdoServer) store(data []byte) {
+	srv.objects[srv.mux] = data
+	srv.writes = append(srv.writes, fmt.Sprintf("%04X:%02X=% X", srv.mux>>8, uint8(srv.mux), data))
+}
+
+func (srv *sdoServer) handle(req candev.Message) {
+	srv.mu.Lock()
+	resp := candev.Message{ID: cobSDOTx + uint32(srv.node), Len: 8}
+	mux := uint32(binary.LittleEndian.Uint16(req.Data[1:3]))<<8 | uint32(req.Data[3])
+	toggle := req.Data[0] & sdoToggleBit
+	switch req.Data[0] & sdoCSMask {
+	case sdoCCSInitiateUpload:
+		srv.mux = mux
+		data, ok := srv.objects[mux]
+		copy(resp.Data[1:4], req.Data[1:4])
+		switch {
+		case !ok:
+			resp.Data[0] = sdoCSAbort
+			binary.LittleEndian.PutUint32(resp.Data[4:], uint32(SDOAbortNoObject))
+		case len(data) <= 4:
+			resp.Data[0] = sdoSCSInitiateUpload | byte(4-len(data))<<2 | sdoExpedited | sdoSizeIsSet
+			copy(resp.Data[4:], data)
+		default:
+			resp.Data[0] = sdoSCSInitiateUpload | sdoSizeIsSet
+			binary.LittleEndian.PutUint32(resp.Data[4:], uint32(len(data)))
+			srv.upload = data
+		}
+	case sdoCCSUploadSegment:
+		n := len(srv.upload)
+		if n > 7 {
+			n = 7
+		}
+		resp.Data[0] = sdoSCSUploadSegment | toggle | byte(7-n)<<1
+		if n == len(srv.upload) {
+			resp.Data[0] |= sdoLastOfData
+		}
+		copy(resp.Data[1:], srv.upload[:n])
+		srv.upload = srv.upload[n:]
+	case sdoCCSInitiateDownload:
+		srv.mux = mux
+		resp.Data[0] = sdoSCSInitiateDownload
+		copy(resp.Data[1:4], req.Data[1:4])
+		if 0 != req.Data[0]&sdoExpedited {
+			srv.store(append([]byte(nil), req.Data[4:8-(req.Data[0]>>2)&0x03]...))
+		} else {
+			srv.segment = nil
+		}
+	case sdoCCSDownloadSegment:
+		resp.Data[0] = sdoSCSDownloadSegment | toggle
+		srv.segment = append(srv.segment, req.Data[1:8-(req.Data[0]>>1)&0x07]...)
+		if 0 != req.Data[0]&sdoLastOfData {
+			srv.store(srv.segment)
+		}
+	case sdoCSAbort:
+		srv.aborts = append(srv.aborts, req)
+		srv.mu.Unlock()
+		return
+	}
+	tamper := srv.tamper
+	srv.mu.Unlock()
+	if tamper != nil {
+		tamper(&resp)
+	}
+	srv.bus.Send(resp)
+}
+
+// startSDO returns a running master and an SDO server of node 5 on a loopback bus.
+func startSDO(t *testing.T) (m *Master, srv *sdoServer, stop func()) {
+	bus := new(candev.Loopback)
+	m = NewMaster(bus)
+	m.Run()
+	srv = newSDOServer(bus, 5)
+	return m, srv, func() {
+		srv.stop()
+		m.Stop()
+	}
+}
+
+func TestSDOUpload(t *testing.T) {
+	m, srv, stop := startSDO(t)
+	defer stop()
+	c := m.SDO(5)
+	// expedited up to 4 bytes, segmented above, also ending at a segment boundary
+	for _, n := range []int{1, 2, 4, 5, 7, 14, 20} {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(n<<4 + i)
+		}
+		srv.set(0x2000, uint8(n), data)
+		if got, err := c.Upload(0x2000, uint8(n)); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("upload of %d bytes: % X, %v", n, got, err)
+		}
+	}
+	srv.set(0x1000, 0, []byte{0x91, 0x01, 0x0F, 0x00})
+	if v, err := c.UploadUint32(0x1000, 0); err != nil || v != 0x000F0191 {
+		t.Errorf("UploadUint32 = %X, %v", v, err)
+	}
+	if _, err := c.UploadUint32(0x2000, 5); nil == err {
+		t.Error("UploadUint32 of 5 bytes")
+	}
+}
+
+func TestSDODownload(t *testing.T) {
+	m, srv, stop := startSDO(t)
+	defer stop()
+	c := m.SDO(5)
+	for _, n := range []int{1, 3, 4, 5, 7, 8, 14, 20} {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(n<<4 + i)
+		}
+		if err := c.Download(0x2100, uint8(n), data); err != nil {
+			t.Errorf("download of %d bytes: %v", n, err)
+		}
+		if got := srv.get(0x2100, uint8(n)); !bytes.Equal(got, data) {
+			t.Errorf("download of %d bytes stored % X", n, got)
+		}
+	}
+	if err := c.DownloadUint16(0x2101, 2, 0xBEEF); err != nil || !bytes.Equal(srv.get(0x2101, 2), []byte{0xEF, 0xBE}) {
+		t.Errorf("DownloadUint16: % X, %v", srv.get(0x2101, 2), err)
+	}
+	if err := c.Download(0x2101, 3, nil); nil == err {
+		t.Error("empty download")
+	}
+	if err := m.SDO(0).Download(0x2101, 3, []byte{1}); nil == err {
+		t.Error("download to node 0")
+	}
+}
+
+func TestSDOAbort(t *testing.T) {
+	m, srv, stop := startSDO(t)
+	defer stop()
+	c := m.SDO(5)
+
+	// an abort from the server
+	_, err := c.Upload(0x2000, 1)
+	e, ok := err.(*SDOAbortError)
+	if !ok || *e != (SDOAbortError{Node: 5, Index: 0x2000, SubIndex: 1, Code: SDOAbortNoObject}) {
+		t.Fatalf("upload of a missing object: %v", err)
+	}
+	if want := "canopen: node 5 SDO 2000h:01h aborted by server: " +
+		"0x06020000 object does not exist in the object dictionary"; err.Error() != want {
+		t.Errorf("%q, want %q", err.Error(), want)
+	}
+
+	// aborts by the client are sent to the server too
+	tests := []struct {
+		name   string
+		tamper func(resp *candev.Message)
+		code   SDOAbortCode
+		do     func() error
+	}{
+		{"upload of another index", func(resp *candev.Message) {
+			if sdoSCSInitiateUpload == resp.Data[0]&sdoCSMask {
+				resp.Data[1]++
+			}
+		}, SDOAbortCommandSpecifier, func() error { _, err := c.Upload(0x2000, 1); return err }},
+		{"download of another subindex", func(resp *candev.Message) { resp.Data[3]++ },
+			SDOAbortCommandSpecifier, func() error { return c.DownloadUint8(0x2000, 1, 1) }},
+		{"command specifier", func(resp *candev.Message) { resp.Data[0] = sdoSCSUploadSegment },
+			SDOAbortCommandSpecifier, func() error { return c.DownloadUint32(0x2000, 1, 1) }},
+		{"toggle bit", func(resp *candev.Message) {
+			if sdoSCSUploadSegment == resp.Data[0]&sdoCSMask {
+				resp.Data[0] ^= sdoToggleBit
+			}
+		}, SDOAbortToggleBit, func() error { _, err := c.Upload(0x2000, 1); return err }},
+		{"timeout", func(resp *candev.Message) { resp.Len = 7 },
+			SDOAbortTimeout, func() error { c.Timeout = 20 * time.Millisecond; _, err := c.Upload(0x2000, 1); return err }},
+	}
+	for _, tt := range tests {
+		srv.set(0x2000, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) // segmented
+		srv.mu.Lock()
+		srv.tamper, srv.aborts = tt.tamper, nil
+		srv.mu.Unlock()
+		err := tt.do()
+		want := SDOAbortError{Node: 5, Index: 0x2000, SubIndex: 1, Code: tt.code, Local: true}
+		if e, ok := err.(*SDOAbortError); !ok || *e != want {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		// the abort is the last frame of the transfer, the next one waits for it
+		srv.mu.Lock()
+		srv.tamper = nil
+		srv.mu.Unlock()
+		c.Timeout = DefaultSDOTimeout
+		if _, err := c.Upload(0x1000, 0); nil == err {
+			t.Errorf("%s: upload of a missing object", tt.name)
+		}
+		srv.mu.Lock()
+		aborts := srv.aborts
+		srv.mu.Unlock()
+		if len(aborts) != 1 || aborts[0].Data != [8]byte{sdoCSAbort, 0x00, 0x20, 1,
+			byte(tt.code), byte(tt.code >> 8), byte(tt.code >> 16), byte(tt.code >> 24)} {
+			t.Errorf("%s: aborts sent %+v", tt.name, aborts)
+		}
+	}
+
+	if s := SDOAbortCode(0x12345678).String(); s != "0x12345678 unknown abort code" {
+		t.Errorf("unknown abort code: %s", s)
+	}
+}
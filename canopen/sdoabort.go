@@ -0,0 +1,102 @@
+package canopen
+
+import "fmt"
+
+// SDOAbortCode is an SDO abort code (CiA 301, 7.2.4.3.17).
+type SDOAbortCode uint32
+
+// SDO abort codes.
+const (
+	SDOAbortToggleBit          SDOAbortCode = 0x05030000
+	SDOAbortTimeout            SDOAbortCode = 0x05040000
+	SDOAbortCommandSpecifier   SDOAbortCode = 0x05040001
+	SDOAbortBlockSize          SDOAbortCode = 0x05040002
+	SDOAbortSequenceNumber     SDOAbortCode = 0x05040003
+	SDOAbortCRC                SDOAbortCode = 0x05040004
+	SDOAbortOutOfMemory        SDOAbortCode = 0x05040005
+	SDOAbortUnsupportedAccess  SDOAbortCode = 0x06010000
+	SDOAbortWriteOnly          SDOAbortCode = 0x06010001
+	SDOAbortReadOnly           SDOAbortCode = 0x06010002
+	SDOAbortNoObject           SDOAbortCode = 0x06020000
+	SDOAbortNotMappable        SDOAbortCode = 0x06040041
+	SDOAbortPDOLength          SDOAbortCode = 0x06040042
+	SDOAbortParamIncompatible  SDOAbortCode = 0x06040043
+	SDOAbortDeviceIncompatible SDOAbortCode = 0x06040047
+	SDOAbortHardware           SDOAbortCode = 0x06060000
+	SDOAbortTypeLength         SDOAbortCode = 0x06070010
+	SDOAbortTypeTooLong        SDOAbortCode = 0x06070012
+	SDOAbortTypeTooShort       SDOAbortCode = 0x06070013
+	SDOAbortNoSubIndex         SDOAbortCode = 0x06090011
+	SDOAbortInvalidValue       SDOAbortCode = 0x06090030
+	SDOAbortValueTooHigh       SDOAbortCode = 0x06090031
+	SDOAbortValueTooLow        SDOAbortCode = 0x06090032
+	SDOAbortMaxLessThanMin     SDOAbortCode = 0x06090036
+	SDOAbortResource           SDOAbortCode = 0x060A0023
+	SDOAbortGeneral            SDOAbortCode = 0x08000000
+	SDOAbortDataTransfer       SDOAbortCode = 0x08000020
+	SDOAbortLocalControl       SDOAbortCode = 0x08000021
+	SDOAbortDeviceState        SDOAbortCode = 0x08000022
+	SDOAbortNoDictionary       SDOAbortCode = 0x08000023
+	SDOAbortNoData             SDOAbortCode = 0x08000024
+)
+
+var sdoAbortText = map[SDOAbortCode]string{
+	SDOAbortToggleBit:          "toggle bit not alternated",
+	SDOAbortTimeout:            "SDO protocol timed out",
+	SDOAbortCommandSpecifier:   "client/server command specifier not valid or unknown",
+	SDOAbortBlockSize:          "invalid block size",
+	SDOAbortSequenceNumber:     "invalid sequence number",
+	SDOAbortCRC:                "CRC error",
+	SDOAbortOutOfMemory:        "out of memory",
+	SDOAbortUnsupportedAccess:  "unsupported access to an object",
+	SDOAbortWriteOnly:          "attempt to read a write only object",
+	SDOAbortReadOnly:           "attempt to write a read only object",
+	SDOAbortNoObject:           "object does not exist in the object dictionary",
+	SDOAbortNotMappable:        "object cannot be mapped to the PDO",
+	SDOAbortPDOLength:          "number and length of objects to be mapped would exceed PDO length",
+	SDOAbortParamIncompatible:  "general parameter incompatibility",
+	SDOAbortDeviceIncompatible: "general internal incompatibility in the device",
+	SDOAbortHardware:           "access failed due to a hardware error",
+	SDOAbortTypeLength:         "data type does not match, length of service parameter does not match",
+	SDOAbortTypeTooLong:        "data type does not match, length of service parameter too high",
+	SDOAbortTypeTooShort:       "data type does not match, length of service parameter too low",
+	SDOAbortNoSubIndex:         "sub-index does not exist",
+	SDOAbortInvalidValue:       "invalid value for parameter",
+	SDOAbortValueTooHigh:       "value of parameter written too high",
+	SDOAbortValueTooLow:        "value of parameter written too low",
+	SDOAbortMaxLessThanMin:     "maximum value is less than minimum value",
+	SDOAbortResource:           "resource not available: SDO connection",
+	SDOAbortGeneral:            "general error",
+	SDOAbortDataTransfer:       "data cannot be transferred or stored to the application",
+	SDOAbortLocalControl:       "data cannot be transferred or stored to the application because of local control",
+	SDOAbortDeviceState:        "data cannot be transferred or stored to the application because of the present device state",
+	SDOAbortNoDictionary:       "object dictionary dynamic generation fails or no object dictionary is present",
+	SDOAbortNoData:             "no data available",
+}
+
+func (code SDOAbortCode) String() string {
+	text, ok := sdoAbortText[code]
+	if !ok {
+		text = "unknown abort code"
+	}
+	return fmt.Sprintf("0x%08X %s", uint32(code), text)
+}
+
+// SDOAbortError is returned when an SDO transfer is aborted.
+// Local is true if the transfer was aborted by the client.
+type SDOAbortError struct {
+	Node     uint8
+	Index    uint16
+	SubIndex uint8
+	Code     SDOAbortCode
+	Local    bool
+}
+
+func (e *SDOAbortError) Error() string {
+	by := "server"
+	if e.Local {
+		by = "client"
+	}
+	return fmt.Sprintf("canopen: node %d SDO %04Xh:%02Xh aborted by %s: %s",
+		e.Node, e.Index, e.SubIndex, by, e.Code)
+}
@@ -0,0 +1,63 @@
+package canopen
+
+import (
+	"errors"
+	"time"
+)
+
+// StartSync starts SYNC producer with period.
+// counter enables the SYNC counter (1..240), 0 sends SYNC without data.
+func (m *Master) StartSync(period time.Duration, counter uint8) (err error) {
+	if period <= 0 {
+		err = errors.New("canopen: SYNC period must be positive")
+		return
+	}
+	if counter > 240 {
+		err = errors.New("canopen: SYNC counter overflow value must be 0..240")
+		return
+	}
+	m.StopSync()
+
+	stop := make(chan struct{})
+	m.mu.Lock()
+	m.syncStop = stop
+	m.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		var value uint8
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if 0 == counter {
+					m.send(cobSYNC, false, nil)
+					continue
+				}
+				value++
+				if value > counter {
+					value = 1
+				}
+				m.send(cobSYNC, false, []byte{value})
+			}
+		}
+	}()
+	return
+}
+
+// StopSync stops SYNC producer.
+func (m *Master) StopSync() {
+	m.mu.Lock()
+	if nil != m.syncStop {
+		close(m.syncStop)
+		m.syncStop = nil
+	}
+	m.mu.Unlock()
+}
+
+// Sync sends a single SYNC message without counter.
+func (m *Master) Sync() error {
+	return m.send(cobSYNC, false, nil)
+}
@@ -0,0 +1,612 @@
+// Package cantest is a deterministic CAN backend for unit tests of code built on candev.
+//
+// A Backend plays a scripted bus on a controllable Clock: frames injected for a time
+// are received when the clock reaches it, sent frames are recorded with the clock time
+// for assertions, and faults are switched on demand: failing sends (VCI_E_TIMEOUT,
+// VCI_E_TXQUEUE_FULL, ...), disconnection, bus-off and other controller states,
+// dropped, corrupted and delayed frames.
+//
+//	b := cantest.New(nil)
+//	dev, err := b.Builder().Number(9).Get()
+//	dev.Run()
+//	b.Inject(10*time.Millisecond, candev.Message{ID: 0x100, Len: 1, Data: [8]byte{1}})
+//	b.Clock().Advance(10 * time.Millisecond)
+//	b.FailSend(ixxatvci3.VCI_E_TXQUEUE_FULL, 1)
+//	err = dev.Send(candev.Message{ID: 0x200, Len: 1}) // candev.ErrTxQueueFull
+//	b.BusOff()
+//	b.ExpectSent(t, candev.Message{ID: 0x200, Len: 1})
+//
+// Only the backend and the device timestamps (Builder.Clock, set by Backend.Builder)
+// follow the Clock, and the watchdog when its Options.Now is Clock.Now. restbus,
+// candev.Stats, the RTR responders of candev.Device, TxQueue and the canopen heartbeat
+// use real time (time.Now, time.NewTicker), so tests of those on a Backend wait in real
+// time and need real time tolerances; advancing the Clock does not move them.
+package cantest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// RxQueueSize is the number of received frames buffered until the device reads them,
+// frames released beyond it are lost as a receive overrun.
+const RxQueueSize = 1024
+
+// errorPause is the real time an erroneous Receive waits, so a reader loop does not spin.
+const errorPause = time.Millisecond
+
+// Direction selects the frames a fault applies to.
+type Direction int
+
+// Directions of faults.
+const (
+	Rx Direction = iota // frames received by the device
+	Tx                  // frames sent by the device
+)
+
+type faultKind int
+
+const (
+	faultDrop faultKind = iota
+	faultCorrupt
+	faultDelay
+)
+
+// fault applies to the next n frames of a direction, to all if n is negative.
+type fault struct {
+	kind    faultKind
+	n       int
+	delay   time.Duration
+	corrupt func(msg *candev.Message)
+}
+
+type sendFault struct {
+	vcierr uint32
+	n      int
+}
+
+// T is the part of testing.TB used for assertions.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Backend is an ixxatvci3.Backend of one simulated channel. Use it for a single device number.
+type Backend struct {
+	clock *Clock
+
+	mu           sync.Mutex
+	open         bool
+	echo         bool
+	mode         ixxatvci3.OpMode
+	bitrate      ixxatvci3.BitrateRegisterPair
+	busBitrate   *ixxatvci3.BitrateRegisterPair
+	status       uint32
+	busLoad      uint8
+	disconnected bool
+	overrun      bool
+	rx           chan candev.Message
+	closed       chan struct{}
+	wake         chan struct{} // closed by Disconnect to end waiting Receive calls
+	pendingRx    []scheduled
+	pendingTx    []scheduled
+	sent         []candev.Message
+	sendFaults   []sendFault
+	faults       [2][]*fault
+	onSend       func(msg candev.Message)
+}
+
+// scheduled is a frame released at msg.Time, delayed ones are not faulted again.
+type scheduled struct {
+	msg     candev.Message
+	delayed bool
+}
+
+// New returns a closed channel on clock, a new clock at 2000-01-01 00:00 UTC if nil.
+func New(clock *Clock) *Backend {
+	if nil == clock {
+		clock = NewClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	b := &Backend{clock: clock, mode: ixxatvci3.OpModeStandard, rx: make(chan candev.Message, RxQueueSize),
+		wake: make(chan struct{})}
+	clock.listen(b.release)
+	return b
+}
+
+// Clock returns the clock of the backend.
+func (b *Backend) Clock() *Clock {
+	return b.clock
+}
+
+// Builder returns a device builder using the backend and its clock for timestamps.
+func (b *Backend) Builder() *candev.Builder {
+	return new(candev.Builder).Backend(b).Clock(b.clock.Now)
+}
+
+// Inject makes the device receive msgs when the clock has advanced by after, at once if after is 0.
+func (b *Backend) Inject(after time.Duration, msgs ...candev.Message) {
+	b.InjectAt(b.clock.Now().Add(after), msgs...)
+}
+
+// InjectAt makes the device receive msgs when the clock reaches t.
+func (b *Backend) InjectAt(t time.Time, msgs ...candev.Message) {
+	b.mu.Lock()
+	for _, msg := range msgs {
+		msg.Time = t
+		b.pendingRx = insert(b.pendingRx, scheduled{msg: msg})
+	}
+	b.mu.Unlock()
+	b.release(b.clock.Now())
+}
+
+// OnSend calls f with every frame put on the bus, e.g. to inject an answer.
+// It is called without locks held, after the frame is recorded.
+func (b *Backend) OnSend(f func(msg candev.Message)) {
+	b.mu.Lock()
+	b.onSend = f
+	b.mu.Unlock()
+}
+
+// Sent returns the frames put on the bus so far, with the clock time in Time.
+func (b *Backend) Sent() []candev.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]candev.Message(nil), b.sent...)
+}
+
+// TakeSent returns the frames put on the bus and forgets them.
+func (b *Backend) TakeSent() (sent []candev.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sent, b.sent = b.sent, nil
+	return
+}
+
+// ExpectSent takes the sent frames and reports an error to t unless they are want,
+// compared by identifier, format, remote flag, length and data.
+func (b *Backend) ExpectSent(t T, want ...candev.Message) bool {
+	t.Helper()
+	got := b.TakeSent()
+	ok := len(got) == len(want)
+	for i := 0; ok && i < len(got); i++ {
+		ok = Equal(got[i], want[i])
+	}
+	if !ok {
+		t.Errorf("cantest: sent frames\n%s\nwant\n%s", frames(got), frames(want))
+	}
+	return ok
+}
+
+// Equal reports whether two frames have the same identifier, format, remote flag, length and data.
+// Identifiers above 0x7FF are extended without the Ext flag, as candev sends them.
+func Equal(a, b candev.Message) bool {
+	return a.ID == b.ID && (a.Ext || a.ID > 0x7FF) == (b.Ext || b.ID > 0x7FF) && a.Rtr == b.Rtr &&
+		a.Len == b.Len && bytes.Equal(a.Data[:a.Len], b.Data[:b.Len])
+}
+
+// Format returns a frame in candump notation, e.g. "123#0102" or "12345678#R".
+func Format(msg candev.Message) string {
+	id := fmt.Sprintf("%03X", msg.ID)
+	if msg.Ext || msg.ID > 0x7FF {
+		id = fmt.Sprintf("%08X", msg.ID)
+	}
+	if msg.Rtr {
+		return fmt.Sprintf("%s#R%d", id, msg.Len)
+	}
+	return fmt.Sprintf("%s#%X", id, msg.Data[:msg.Len])
+}
+
+func frames(list []candev.Message) string {
+	if 0 == len(list) {
+		return "  (none)"
+	}
+	lines := make([]string, len(list))
+	for i, msg := range list {
+		lines[i] = "  " + Format(msg)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FailSend makes the next n sends fail with vcierr, e.g. ixxatvci3.VCI_E_TIMEOUT or
+// VCI_E_TXQUEUE_FULL, all sends until ClearFaults if n is negative.
+func (b *Backend) FailSend(vcierr uint32, n int) {
+	b.mu.Lock()
+	b.sendFaults = append(b.sendFaults, sendFault{vcierr: vcierr, n: n})
+	b.mu.Unlock()
+}
+
+// Drop loses the next n frames of dir, all until ClearFaults if n is negative.
+// Dropped sent frames are reported as sent to the device.
+func (b *Backend) Drop(dir Direction, n int) {
+	b.addFault(dir, &fault{kind: faultDrop, n: n})
+}
+
+// Corrupt changes the next n frames of dir with f, all until ClearFaults if n is negative.
+// A nil f inverts the last data byte, or the lowest identifier bit of a frame without data.
+func (b *Backend) Corrupt(dir Direction, n int, f func(msg *candev.Message)) {
+	if nil == f {
+		f = invertLast
+	}
+	b.addFault(dir, &fault{kind: faultCorrupt, n: n, corrupt: f})
+}
+
+// Delay postpones the next n frames of dir by d of the clock, all until ClearFaults if n is negative.
+func (b *Backend) Delay(dir Direction, n int, d time.Duration) {
+	b.addFault(dir, &fault{kind: faultDelay, n: n, delay: d})
+}
+
+func (b *Backend) addFault(dir Direction, f *fault) {
+	b.mu.Lock()
+	b.faults[dir] = append(b.faults[dir], f)
+	b.mu.Unlock()
+}
+
+func invertLast(msg *candev.Message) {
+	if msg.Len > 0 && !msg.Rtr {
+		msg.Data[msg.Len-1] ^= 0xFF
+	} else {
+		msg.ID ^= 1
+	}
+}
+
+// ClearFaults removes send failures and frame faults. Disconnection and the controller status stay.
+func (b *Backend) ClearFaults() {
+	b.mu.Lock()
+	b.sendFaults = nil
+	b.faults = [2][]*fault{}
+	b.mu.Unlock()
+}
+
+// Disconnect makes every call fail with VCI_E_DISCONNECTED as if the adapter was unplugged,
+// frames due meanwhile are lost.
+func (b *Backend) Disconnect() {
+	b.mu.Lock()
+	b.disconnected = true
+	close(b.wake)
+	b.wake = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// Reconnect ends a disconnection.
+func (b *Backend) Reconnect() {
+	b.mu.Lock()
+	b.disconnected = false
+	b.mu.Unlock()
+}
+
+// SetStatus sets the CAN_STATUS_* bits of the controller reported by GetStatus.
+// With CAN_STATUS_BUSOFF frames are neither sent nor received, sends still succeed
+// as the frames are queued by a real controller.
+func (b *Backend) SetStatus(status uint32) {
+	b.mu.Lock()
+	b.status = status
+	b.mu.Unlock()
+}
+
+// BusOff sets the bus-off status, see SetStatus.
+func (b *Backend) BusOff() {
+	b.SetStatus(ixxatvci3.CAN_STATUS_BUSOFF)
+}
+
+// SetBusLoad sets the bus load in percent reported by GetStatus.
+func (b *Backend) SetBusLoad(load uint8) {
+	b.mu.Lock()
+	b.busLoad = load
+	b.mu.Unlock()
+}
+
+// SetBusBitrate sets the bitrate found by OpenChannelDetectBitrate, which fails if it is
+// not in the list. By default the first bitrate of the list is found.
+func (b *Backend) SetBusBitrate(brp ixxatvci3.BitrateRegisterPair) {
+	b.mu.Lock()
+	b.busBitrate = &brp
+	b.mu.Unlock()
+}
+
+// insert adds s to list sorted by time, after frames of the same time.
+func insert(list []scheduled, s scheduled) []scheduled {
+	i := sort.Search(len(list), func(i int) bool { return list[i].msg.Time.After(s.msg.Time) })
+	list = append(list, scheduled{})
+	copy(list[i+1:], list[i:])
+	list[i] = s
+	return list
+}
+
+// applyFaults changes msg by the active faults of dir, false drops it.
+// Frames delayed before passed the faults already.
+func (b *Backend) applyFaults(dir Direction, msg *candev.Message, delayed bool) (keep bool, delay time.Duration) {
+	keep = true
+	if delayed {
+		return
+	}
+	active := b.faults[dir][:0]
+	for _, f := range b.faults[dir] {
+		switch f.kind {
+		case faultDrop:
+			keep = false
+		case faultCorrupt:
+			f.corrupt(msg)
+		case faultDelay:
+			delay += f.delay
+		}
+		if f.n > 0 {
+			f.n--
+		}
+		if f.n != 0 {
+			active = append(active, f)
+		}
+	}
+	b.faults[dir] = active
+	return
+}
+
+// release delivers the received and sent frames due at now.
+func (b *Backend) release(now time.Time) {
+	var sent []candev.Message
+	b.mu.Lock()
+	for len(b.pendingRx) > 0 && !b.pendingRx[0].msg.Time.After(now) {
+		s := b.pendingRx[0]
+		b.pendingRx = b.pendingRx[1:]
+		keep, delay := b.applyFaults(Rx, &s.msg, s.delayed)
+		if !keep {
+			continue
+		}
+		if delay > 0 {
+			s.msg.Time = s.msg.Time.Add(delay)
+			s.delayed = true
+			b.pendingRx = insert(b.pendingRx, s)
+			continue
+		}
+		if b.online() && b.open {
+			b.deliver(s.msg)
+		}
+	}
+	for len(b.pendingTx) > 0 && !b.pendingTx[0].msg.Time.After(now) {
+		msg := b.pendingTx[0].msg
+		b.pendingTx = b.pendingTx[1:]
+		if b.online() {
+			b.transmitted(msg)
+			sent = append(sent, msg)
+		}
+	}
+	onSend := b.onSend
+	b.mu.Unlock()
+
+	if onSend != nil {
+		for _, msg := range sent {
+			onSend(msg)
+		}
+	}
+}
+
+// online reports whether frames pass between the channel and the bus.
+func (b *Backend) online() bool {
+	return !b.disconnected && 0 == b.status&ixxatvci3.CAN_STATUS_BUSOFF
+}
+
+// deliver puts msg into the receive queue.
+func (b *Backend) deliver(msg candev.Message) {
+	select {
+	case b.rx <- msg:
+	default:
+		b.overrun = true
+	}
+}
+
+// transmitted records msg as put on the bus.
+func (b *Backend) transmitted(msg candev.Message) {
+	b.sent = append(b.sent, msg)
+	if b.echo {
+		b.deliver(msg)
+	}
+}
+
+// SelectDevice succeeds without the select dialog, which is not supported.
+func (b *Backend) SelectDevice(userselect bool, devnum uint8) (vcierr uint32) {
+	if userselect {
+		return ixxatvci3.VCI_E_NOT_IMPLEMENTED
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.disconnected {
+		return ixxatvci3.VCI_E_DISCONNECTED
+	}
+	return ixxatvci3.VCI_OK
+}
+
+// SetOpMode sets the mode, listen only mode makes sends fail with VCI_E_ACCESSDENIED.
+func (b *Backend) SetOpMode(devnum uint8, mode ixxatvci3.OpMode) (vcierr uint32) {
+	b.mu.Lock()
+	b.mode = mode
+	b.mu.Unlock()
+	return ixxatvci3.VCI_OK
+}
+
+// SupportedOpModes returns all modes except CAN FD and bitrate detection by the controller.
+func (b *Backend) SupportedOpModes(devnum uint8) (modes ixxatvci3.OpMode, vcierr uint32) {
+	return ixxatvci3.OpModeStandard | ixxatvci3.OpModeExtended | ixxatvci3.OpModeErrFrame |
+		ixxatvci3.OpModeListenOnly | ixxatvci3.OpModeLowSpeed, ixxatvci3.VCI_OK
+}
+
+// OpenChannel opens the channel, frames injected for earlier times are received at once.
+func (b *Backend) OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
+	b.mu.Lock()
+	if b.disconnected {
+		b.mu.Unlock()
+		return ixxatvci3.VCI_E_DISCONNECTED
+	}
+	if !b.open {
+		b.open = true
+		b.closed = make(chan struct{})
+	}
+	b.bitrate = ixxatvci3.BitrateRegisterPair{Btr0: btr0, Btr1: btr1}
+	b.mu.Unlock()
+	b.release(b.clock.Now())
+	return ixxatvci3.VCI_OK
+}
+
+// OpenChannelDetectBitrate opens the channel with the bitrate set by SetBusBitrate
+// or the first of bitrate, without waiting.
+func (b *Backend) OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []ixxatvci3.BitrateRegisterPair) (detected ixxatvci3.BitrateRegisterPair, err error) {
+	if 0 == len(bitrate) {
+		err = errors.New("bitrate array is empty")
+		return
+	}
+	b.mu.Lock()
+	bus := b.busBitrate
+	b.mu.Unlock()
+	detected = bitrate[0]
+	if bus != nil {
+		found := false
+		for _, brp := range bitrate {
+			found = found || brp == *bus
+		}
+		if !found {
+			err = errors.New("bitrate not detected")
+			return
+		}
+		detected = *bus
+	}
+	if vcierr := b.OpenChannel(devnum, detected.Btr0, detected.Btr1); vcierr != ixxatvci3.VCI_OK {
+		err = fmt.Errorf("%s", ixxatvci3.GetErrorText(vcierr))
+	}
+	return
+}
+
+// Send puts a frame on the bus, see SendTimeout.
+func (b *Backend) Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
+	return b.SendTimeout(devnum, msgid, rtr, msgdata, -1)
+}
+
+// SendTimeout puts a frame on the bus at the clock time unless a send failure is due.
+// The timeout is not waited for, the transmit queue never fills up by itself.
+func (b *Backend) SendTimeout(devnum uint8, msgid uint32, rtr bool, msgdata []byte, timeout time.Duration) (vcierr uint32) {
+	if len(msgdata) > 8 {
+		return ixxatvci3.VCI_E_INVALIDARG
+	}
+	now := b.clock.Now()
+	b.mu.Lock()
+	switch {
+	case b.disconnected:
+		vcierr = ixxatvci3.VCI_E_DISCONNECTED
+	case !b.open:
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+	case b.mode&ixxatvci3.OpModeListenOnly != 0:
+		vcierr = ixxatvci3.VCI_E_ACCESSDENIED
+	case len(b.sendFaults) > 0:
+		f := &b.sendFaults[0]
+		vcierr = f.vcierr
+		if f.n > 0 {
+			f.n--
+		}
+		if 0 == f.n {
+			b.sendFaults = b.sendFaults[1:]
+		}
+	}
+	if vcierr != ixxatvci3.VCI_OK {
+		b.mu.Unlock()
+		return
+	}
+
+	msg := candev.Message{ID: msgid &^ (1 << 31), Ext: msgid&(1<<31) != 0 || msgid&^(1<<31) > 0x7FF,
+		Rtr: rtr, Len: uint8(len(msgdata)), Time: now}
+	copy(msg.Data[:], msgdata)
+	keep, delay := b.applyFaults(Tx, &msg, false)
+	if keep && delay > 0 {
+		msg.Time = now.Add(delay)
+		b.pendingTx = insert(b.pendingTx, scheduled{msg: msg, delayed: true})
+		keep = false
+	}
+	if keep && 0 == b.status&ixxatvci3.CAN_STATUS_BUSOFF {
+		b.transmitted(msg)
+	} else {
+		keep = false
+	}
+	onSend := b.onSend
+	b.mu.Unlock()
+
+	if keep && onSend != nil {
+		onSend(msg)
+	}
+	return ixxatvci3.VCI_OK
+}
+
+// Receive waits for a received frame until the channel is closed.
+// The high bit of msgid is set for 29-bit frames, identifiers above 0x7FF are 29-bit without Ext.
+func (b *Backend) Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+	b.mu.Lock()
+	open, disconnected, closed, wake := b.open, b.disconnected, b.closed, b.wake
+	b.mu.Unlock()
+	if disconnected || !open {
+		time.Sleep(errorPause)
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+		if disconnected {
+			vcierr = ixxatvci3.VCI_E_DISCONNECTED
+		}
+		return
+	}
+	select {
+	case msg := <-b.rx:
+		msgid, rtr, msgdata, msgdatasize = msg.ID, msg.Rtr, msg.Data, msg.Len
+		if msg.Ext || msg.ID > 0x7FF {
+			msgid |= 1 << 31
+		}
+	case <-closed:
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+	case <-wake:
+		vcierr = ixxatvci3.VCI_E_DISCONNECTED
+	}
+	return
+}
+
+// GetStatus returns the status set by SetStatus and SetBusLoad and the receive queue state.
+func (b *Backend) GetStatus(devnum uint8) (status ixxatvci3.CANChanStatus, vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.disconnected {
+		vcierr = ixxatvci3.VCI_E_DISCONNECTED
+		return
+	}
+	status.LineStatus = ixxatvci3.CANLineStatus{OpMode: uint8(b.mode), BtReg0: b.bitrate.Btr0,
+		BtReg1: b.bitrate.Btr1, BusLoad: b.busLoad, Status: b.status}
+	if b.overrun {
+		status.LineStatus.Status |= ixxatvci3.CAN_STATUS_OVRRUN
+		status.RxOverrun = 1
+	}
+	if b.open {
+		status.Activated = 1
+	}
+	status.RxFifoLoad = uint8(100 * len(b.rx) / cap(b.rx))
+	return
+}
+
+// SetTxEcho makes sent frames received too once they are put on the bus.
+func (b *Backend) SetTxEcho(devnum uint8, echo bool) (vcierr uint32) {
+	b.mu.Lock()
+	b.echo = echo
+	b.mu.Unlock()
+	return ixxatvci3.VCI_OK
+}
+
+// CloseDevice closes the channel and drops received frames not read yet.
+func (b *Backend) CloseDevice(devnum uint8) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		b.open = false
+		close(b.closed)
+	}
+	for len(b.rx) > 0 {
+		<-b.rx
+	}
+	b.overrun = false
+	return ixxatvci3.VCI_OK
+}
@@ -0,0 +1,301 @@
+package cantest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+var start = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// openBackend returns an open backend used without a device.
+func openBackend(t *testing.T) *Backend {
+	t.Helper()
+	b := New(nil)
+	if vcierr := b.SelectDevice(false, 0); vcierr != ixxatvci3.VCI_OK {
+		t.Fatalf("SelectDevice: 0x%08X", vcierr)
+	}
+	if vcierr := b.OpenChannel(0, 0x00, 0x1C); vcierr != ixxatvci3.VCI_OK {
+		t.Fatalf("OpenChannel: 0x%08X", vcierr)
+	}
+	return b
+}
+
+// received returns the frames in the receive queue without waiting.
+func received(b *Backend) (list []string) {
+	for len(b.rx) > 0 {
+		vcierr, id, rtr, data, n := b.Receive(0)
+		if vcierr != ixxatvci3.VCI_OK {
+			list = append(list, fmt.Sprintf("error 0x%08X", vcierr))
+			continue
+		}
+		list = append(list, Format(candev.Message{ID: id, Rtr: rtr, Data: data, Len: n}))
+	}
+	return
+}
+
+func expectReceived(t *testing.T, b *Backend, want ...string) {
+	t.Helper()
+	got := received(b)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+}
+
+func msg(id uint32, data ...byte) candev.Message {
+	m := candev.Message{ID: id, Len: uint8(len(data))}
+	copy(m.Data[:], data)
+	return m
+}
+
+// TestDevice runs a candev.Device on the backend: scripted reception with clock timestamps,
+// sent frames and send failures as candev errors.
+func TestDevice(t *testing.T) {
+	b := New(nil)
+	dev, err := b.Builder().Number(201).Speed(ixxatvci3.Bitrate500kbps).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Run()
+	defer dev.Stop()
+	ch, idx := dev.GetMsgChannelCopy()
+	defer dev.CloseMsgChannelCopy(idx)
+
+	b.Inject(10*time.Millisecond, msg(0x100, 1))
+	b.Inject(25*time.Millisecond, msg(0x18DAF110, 2, 3))
+	expect := func(id uint32, at time.Duration) {
+		t.Helper()
+		select {
+		case m := <-ch:
+			if m.ID != id || !m.Time.Equal(start.Add(at)) {
+				t.Errorf("received %s at %v, want %X at %v", Format(m), m.Time.Sub(start), id, at)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%X not received", id)
+		}
+	}
+	nothing := func() {
+		t.Helper()
+		select {
+		case m := <-ch:
+			t.Errorf("received %s at %v before its time", Format(m), m.Time.Sub(start))
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	nothing()
+	b.Clock().Advance(10 * time.Millisecond)
+	expect(0x100, 10*time.Millisecond)
+	b.Clock().Advance(10 * time.Millisecond)
+	nothing()
+	b.Clock().Advance(5 * time.Millisecond)
+	expect(0x18DAF110, 25*time.Millisecond)
+
+	if err = dev.Send(msg(0x200, 0xAA)); err != nil {
+		t.Fatal(err)
+	}
+	dev.Send(candev.Message{ID: 0x10, Ext: true, Len: 1, Data: [8]byte{0xBB}})
+	sent := b.Sent()
+	if len(sent) != 2 || !sent[0].Time.Equal(start.Add(25*time.Millisecond)) {
+		t.Errorf("sent %v", sent)
+	}
+	b.ExpectSent(t, msg(0x200, 0xAA), candev.Message{ID: 0x10, Ext: true, Len: 1, Data: [8]byte{0xBB}})
+
+	b.FailSend(ixxatvci3.VCI_E_TIMEOUT, 1)
+	b.FailSend(ixxatvci3.VCI_E_TXQUEUE_FULL, 2)
+	for i, want := range []error{candev.ErrTxTimeout, candev.ErrTxQueueFull, candev.ErrTxQueueFull, nil} {
+		if err = dev.Send(msg(0x300, byte(i))); err != want {
+			t.Errorf("send %d: %v, want %v", i, err, want)
+		}
+	}
+	b.ExpectSent(t, msg(0x300, 3))
+	if c := dev.Counters(); c.TxErrors != 3 || c.TxFrames != 3 {
+		t.Errorf("counters %+v", c)
+	}
+
+	b.Disconnect()
+	if err = dev.Send(msg(0x300)); nil == err {
+		t.Error("send while disconnected succeeded")
+	}
+	for deadline := time.Now().Add(time.Second); dev.LastRxError() != ixxatvci3.VCI_E_DISCONNECTED; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("LastRxError 0x%08X, want VCI_E_DISCONNECTED", dev.LastRxError())
+		}
+	}
+	b.Reconnect()
+	if err = dev.Send(msg(0x300)); err != nil {
+		t.Errorf("send after reconnect: %v", err)
+	}
+}
+
+func TestSendFailures(t *testing.T) {
+	b := openBackend(t)
+	b.FailSend(ixxatvci3.VCI_E_TIMEOUT, 1)
+	b.FailSend(ixxatvci3.VCI_E_TXQUEUE_FULL, -1)
+	want := []uint32{ixxatvci3.VCI_E_TIMEOUT, ixxatvci3.VCI_E_TXQUEUE_FULL, ixxatvci3.VCI_E_TXQUEUE_FULL}
+	for i, w := range want {
+		if vcierr := b.Send(0, 0x100, false, []byte{byte(i)}); vcierr != w {
+			t.Errorf("send %d: 0x%08X, want 0x%08X", i, vcierr, w)
+		}
+	}
+	b.ClearFaults()
+	if vcierr := b.Send(0, 0x100, false, nil); vcierr != ixxatvci3.VCI_OK {
+		t.Errorf("send after ClearFaults: 0x%08X", vcierr)
+	}
+	b.ExpectSent(t, msg(0x100))
+
+	b.Disconnect()
+	b.Inject(0, msg(0x1))
+	if vcierr := b.Send(0, 0x100, false, nil); vcierr != ixxatvci3.VCI_E_DISCONNECTED {
+		t.Errorf("send while disconnected: 0x%08X", vcierr)
+	}
+	if _, vcierr := b.GetStatus(0); vcierr != ixxatvci3.VCI_E_DISCONNECTED {
+		t.Errorf("status while disconnected: 0x%08X", vcierr)
+	}
+	if vcierr, _, _, _, _ := b.Receive(0); vcierr != ixxatvci3.VCI_E_DISCONNECTED {
+		t.Errorf("receive while disconnected: 0x%08X", vcierr)
+	}
+	b.Reconnect()
+	expectReceived(t, b) // lost while disconnected
+	b.ExpectSent(t)
+}
+
+func TestBusOff(t *testing.T) {
+	b := openBackend(t)
+	b.Inject(10*time.Millisecond, msg(0x100, 1))
+	b.BusOff()
+	st, _ := b.GetStatus(0)
+	if st.LineStatus.Status&ixxatvci3.CAN_STATUS_BUSOFF == 0 {
+		t.Errorf("status 0x%X without bus-off", st.LineStatus.Status)
+	}
+	if vcierr := b.Send(0, 0x200, false, []byte{2}); vcierr != ixxatvci3.VCI_OK {
+		t.Errorf("send in bus-off: 0x%08X", vcierr)
+	}
+	b.Clock().Advance(10 * time.Millisecond)
+	expectReceived(t, b)
+	b.ExpectSent(t)
+
+	b.SetStatus(0)
+	b.Inject(0, msg(0x101, 1))
+	b.Send(0, 0x201, false, nil)
+	expectReceived(t, b, "101#01")
+	b.ExpectSent(t, msg(0x201))
+}
+
+func TestRxFaults(t *testing.T) {
+	b := openBackend(t)
+	b.Drop(Rx, 1)
+	b.Inject(0, msg(0x100, 1, 2), msg(0x101, 1, 2))
+	expectReceived(t, b, "101#0102")
+
+	b.Corrupt(Rx, 1, nil)
+	b.Inject(0, msg(0x101, 1, 2), msg(0x102, 1, 2))
+	expectReceived(t, b, "101#01FD", "102#0102")
+
+	b.Corrupt(Rx, -1, func(m *candev.Message) { m.ID |= 0x400 })
+	b.Inject(0, msg(0x001), candev.Message{ID: 0x002, Rtr: true, Len: 2})
+	expectReceived(t, b, "401#", "402#R2")
+	b.ClearFaults()
+
+	b.Corrupt(Rx, 1, nil)
+	b.Inject(0, candev.Message{ID: 0x010, Rtr: true})
+	expectReceived(t, b, "011#R0")
+}
+
+func TestDelay(t *testing.T) {
+	b := openBackend(t)
+	b.Delay(Rx, 1, 5*time.Millisecond)
+	b.Inject(10*time.Millisecond, msg(0x100), msg(0x101))
+	b.Clock().Advance(10 * time.Millisecond)
+	expectReceived(t, b, "101#")
+	b.Clock().Advance(4 * time.Millisecond)
+	expectReceived(t, b)
+	b.Clock().Advance(time.Millisecond)
+	expectReceived(t, b, "100#")
+
+	// delayed frames pass the faults once
+	b.Delay(Rx, 1, 5*time.Millisecond)
+	b.Corrupt(Rx, 1, nil)
+	b.Inject(0, msg(0x200, 0x0F))
+	b.Clock().Advance(5 * time.Millisecond)
+	expectReceived(t, b, "200#F0")
+
+	var onSend []string
+	b.OnSend(func(m candev.Message) { onSend = append(onSend, Format(m)+" "+m.Time.Sub(start).String()) })
+	b.Delay(Tx, 1, 3*time.Millisecond)
+	b.Send(0, 0x300, false, nil)
+	b.Send(0, 0x301, false, nil)
+	b.ExpectSent(t, msg(0x301))
+	b.Clock().Advance(3 * time.Millisecond)
+	b.ExpectSent(t, msg(0x300))
+	if want := "[301# 20ms 300# 23ms]"; fmt.Sprint(onSend) != want {
+		t.Errorf("OnSend %v, want %v", onSend, want)
+	}
+}
+
+func TestTxFaultsAndEcho(t *testing.T) {
+	b := openBackend(t)
+	b.SetTxEcho(0, true)
+	b.Drop(Tx, 1)
+	if vcierr := b.Send(0, 0x100, false, []byte{1}); vcierr != ixxatvci3.VCI_OK {
+		t.Errorf("dropped send: 0x%08X", vcierr)
+	}
+	b.ExpectSent(t)
+	expectReceived(t, b)
+
+	b.Corrupt(Tx, 1, nil)
+	b.Send(0, 0x80000101, false, []byte{1})
+	b.Send(0, 0x80000101, false, []byte{1})
+	b.ExpectSent(t, candev.Message{ID: 0x101, Ext: true, Len: 1, Data: [8]byte{0xFE}},
+		candev.Message{ID: 0x101, Ext: true, Len: 1, Data: [8]byte{0x01}})
+	expectReceived(t, b, "80000101#FE", "80000101#01") // the echo keeps the 29-bit format
+
+	b.SetOpMode(0, ixxatvci3.OpModeListenOnly)
+	if vcierr := b.Send(0, 0x100, false, nil); vcierr != ixxatvci3.VCI_E_ACCESSDENIED {
+		t.Errorf("send in listen only mode: 0x%08X", vcierr)
+	}
+}
+
+func TestOverrun(t *testing.T) {
+	b := openBackend(t)
+	for i := 0; i <= RxQueueSize; i++ {
+		b.Inject(0, msg(uint32(i&0x7FF)))
+	}
+	st, _ := b.GetStatus(0)
+	if st.RxOverrun != 1 || st.LineStatus.Status&ixxatvci3.CAN_STATUS_OVRRUN == 0 || st.RxFifoLoad != 100 {
+		t.Errorf("status %+v", st)
+	}
+	if n := len(received(b)); n != RxQueueSize {
+		t.Errorf("%d frames received", n)
+	}
+}
+
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestExpectSent(t *testing.T) {
+	b := openBackend(t)
+	b.Send(0, 0x123, false, []byte{1, 2})
+	b.Send(0, 0x7FF, true, nil)
+	var r recorder
+	if !b.ExpectSent(&r, msg(0x123, 1, 2), candev.Message{ID: 0x7FF, Rtr: true}) || len(r.errors) != 0 {
+		t.Errorf("expected frames reported: %v", r.errors)
+	}
+	b.Send(0, 0x123, false, []byte{1, 2})
+	if b.ExpectSent(&r, msg(0x123, 1, 3)) || len(r.errors) != 1 {
+		t.Errorf("different data not reported")
+	}
+	if b.ExpectSent(&r, msg(0x123)) || len(r.errors) != 2 {
+		t.Errorf("missing frame not reported")
+	}
+}
@@ -0,0 +1,65 @@
+package cantest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a clock moving only by Advance and Set. Frames of a Backend are
+// received and transmitted when its clock reaches their time.
+type Clock struct {
+	mu        sync.Mutex
+	now       time.Time
+	listeners []func(now time.Time)
+}
+
+// NewClock returns a clock showing start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current time of the clock, it may be passed to candev.Builder.Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d and releases the frames due until then.
+func (c *Clock) Advance(d time.Duration) {
+	if d < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+	c.notify()
+}
+
+// Set moves the clock forward to t, earlier times are ignored.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	c.mu.Unlock()
+	c.notify()
+}
+
+// notify calls the listeners with the current time, outside the lock so they may read the clock.
+func (c *Clock) notify() {
+	c.mu.Lock()
+	now := c.now
+	listeners := make([]func(time.Time), len(c.listeners))
+	copy(listeners, c.listeners)
+	c.mu.Unlock()
+	for _, f := range listeners {
+		f(now)
+	}
+}
+
+func (c *Clock) listen(f func(now time.Time)) {
+	c.mu.Lock()
+	c.listeners = append(c.listeners, f)
+	c.mu.Unlock()
+}
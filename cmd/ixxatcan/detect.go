@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+func runDetect(args []string) (err error) {
+	fs := flag.NewFlagSet("detect", flag.ExitOnError)
+	number := fs.Uint("dev", 0, "device number")
+	timeout := fs.Duration("timeout", 5*time.Second, "detection timeout")
+	mode := fs.String("mode", "11bit,29bit", "operating mode, see candev.Builder.Mode")
+	bf := addBackendFlags(fs)
+	fs.Parse(args)
+
+	opmode, err := ixxatvci3.ParseOpMode(*mode)
+	if err != nil {
+		return
+	}
+	devnum := uint8(*number)
+	if err = bf.use(devnum); err != nil {
+		return
+	}
+	if vcierr := ixxatvci3.OpenDevice(devnum); ixxatvci3.VCI_OK != vcierr {
+		return errors.New(ixxatvci3.GetErrorText(vcierr))
+	}
+	defer ixxatvci3.CloseDevice(devnum)
+	if vcierr := ixxatvci3.SetOpMode(devnum, opmode); ixxatvci3.VCI_OK != vcierr {
+		return errors.New(ixxatvci3.GetErrorText(vcierr))
+	}
+
+	bitrates := make([]ixxatvci3.BitrateRegisterPair, len(ixxatvci3.StandardBitrates))
+	for i, b := range ixxatvci3.StandardBitrates {
+		bitrates[i] = b.Bitrate
+	}
+	detected, err := ixxatvci3.OpenChannelDetectBitrate(devnum, *timeout, bitrates)
+	if err != nil {
+		return
+	}
+	fmt.Printf("can%d: %s (BTR0 0x%02X, BTR1 0x%02X)\n", devnum, detected, detected.Btr0, detected.Btr1)
+	return
+}
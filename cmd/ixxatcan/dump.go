@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/dbc"
+	"github.com/amdf/ixxatvci3/trace"
+)
+
+func runDump(args []string) (err error) {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	df := addDeviceFlags(fs)
+	filterStr := fs.String("f", "", "filters ID[:MASK] in hex, ~ rejects, e.g. 123:7FF,~200:700")
+	dbcPath := fs.String("dbc", "", "decode signals with DBC file")
+	timestamps := fs.String("t", "a", "timestamps: a - absolute, d - delta, z - from start, n - none")
+	logFormat := fs.Bool("l", false, "print in candump -l log format")
+	output := fs.String("o", "", "also write trace file, format by extension (.log .asc .trc .blf)")
+	count := fs.Uint("n", 0, "exit after n frames")
+	fs.Parse(args)
+
+	filters, err := candev.ParseFilters(*filterStr)
+	if err != nil {
+		return
+	}
+	var db *dbc.Database
+	if *dbcPath != "" {
+		if db, err = dbc.ParseFile(*dbcPath); err != nil {
+			return
+		}
+	}
+	var logger *trace.Logger
+	if *output != "" {
+		var format trace.Format
+		if format, err = trace.ParseFormat(extension(*output)); err != nil {
+			return
+		}
+		if logger, err = trace.Create(trace.Options{Format: format, Path: *output, Interface: df.iface()}); err != nil {
+			return
+		}
+		defer logger.Close()
+	}
+
+	dev, err := df.open()
+	if err != nil {
+		return
+	}
+	ch, _ := dev.GetMsgChannelCopy()
+	defer stopDevice(dev, ch)
+
+	stop := interrupted()
+	var start, last time.Time
+	var n uint
+	for {
+		var msg candev.Message
+		select {
+		case <-stop:
+			return
+		case msg = <-ch:
+		}
+		if !candev.MatchFilters(filters, msg.ID) {
+			continue
+		}
+		rec := trace.Record{Time: msg.Time, Channel: uint8(df.number), Msg: msg}
+		if logger != nil {
+			if err = logger.Log(rec); err != nil {
+				return
+			}
+		}
+
+		if *logFormat {
+			fmt.Printf("(%d.%06d) %s %s\n", msg.Time.Unix(), msg.Time.Nanosecond()/1000, df.iface(), trace.FormatFrame(rec))
+		} else {
+			if start.IsZero() {
+				start, last = msg.Time, msg.Time
+			}
+			fmt.Println(formatTime(*timestamps, msg.Time, start, last) + formatLine(df.iface(), msg))
+			last = msg.Time
+		}
+		if db != nil {
+			printSignals(db, msg)
+		}
+
+		n++
+		if *count > 0 && n >= *count {
+			return
+		}
+	}
+}
+
+func extension(path string) string {
+	path = strings.TrimSuffix(path, ".gz")
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		return path[i:]
+	}
+	return ""
+}
+
+func formatTime(mode string, t, start, last time.Time) string {
+	switch mode {
+	case "a":
+		return t.Format(" (2006-01-02 15:04:05.000000) ")
+	case "d":
+		return fmt.Sprintf(" (%.6f) ", t.Sub(last).Seconds())
+	case "z":
+		return fmt.Sprintf(" (%.6f) ", t.Sub(start).Seconds())
+	}
+	return ""
+}
+
+// formatLine formats a frame like candump: "  can0  123   [4]  DE AD BE EF".
+func formatLine(iface string, msg candev.Message) string {
+	var sb strings.Builder
+	id := fmt.Sprintf("%03X", msg.ID)
+	if msg.Ext || msg.ID > 0x7FF {
+		id = fmt.Sprintf("%08X", msg.ID)
+	}
+	fmt.Fprintf(&sb, " %s  %8s   [%d] ", iface, id, msg.Len)
+	if msg.Rtr {
+		sb.WriteString(" remote request")
+		return sb.String()
+	}
+	for i := 0; i < int(msg.Len) && i < len(msg.Data); i++ {
+		fmt.Fprintf(&sb, " %02X", msg.Data[i])
+	}
+	return sb.String()
+}
+
+func printSignals(db *dbc.Database, msg candev.Message) {
+	m, values, err := db.Decode(msg)
+	if nil == m {
+		return
+	}
+	if err != nil {
+		fmt.Printf("        %s: %v\n", m.Name, err)
+		return
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = v.String()
+	}
+	fmt.Printf("        %s: %s\n", m.Name, strings.Join(parts, ", "))
+}
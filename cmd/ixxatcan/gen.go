@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// generator creates frames like cangen: fixed, random or incrementing IDs, lengths and data.
+type generator struct {
+	idMode, lenMode, dataMode string
+	id                        uint32
+	dlc                       uint8
+	data                      []byte
+	ext                       bool
+	rtr                       bool
+	counter                   uint64
+}
+
+func newGenerator(idStr, lenStr, dataStr string, ext, rtr bool) (g *generator, err error) {
+	g = &generator{idMode: "fixed", lenMode: "fixed", dataMode: "fixed", ext: ext, rtr: rtr}
+
+	switch idStr {
+	case "r", "i":
+		g.idMode = idStr
+	default:
+		id, e := strconv.ParseUint(idStr, 16, 32)
+		if e != nil || id > 0x1FFFFFFF || (!ext && id > 0x7FF) {
+			return nil, fmt.Errorf("invalid identifier %q", idStr)
+		}
+		g.id = uint32(id)
+	}
+
+	switch lenStr {
+	case "r":
+		g.lenMode = lenStr
+	default:
+		dlc, e := strconv.ParseUint(lenStr, 10, 8)
+		if e != nil || dlc > 8 {
+			return nil, fmt.Errorf("invalid length %q", lenStr)
+		}
+		g.dlc = uint8(dlc)
+	}
+
+	switch dataStr {
+	case "r", "i":
+		g.dataMode = dataStr
+	default:
+		if g.data, err = hex.DecodeString(dataStr); err != nil || len(g.data) > 8 {
+			return nil, fmt.Errorf("invalid data %q", dataStr)
+		}
+		if "fixed" == g.lenMode {
+			g.dlc = uint8(len(g.data))
+		}
+	}
+	return
+}
+
+func (g *generator) next() (msg candev.Message) {
+	maxID := uint32(0x7FF)
+	if g.ext {
+		maxID = 0x1FFFFFFF
+	}
+	switch g.idMode {
+	case "r":
+		msg.ID = rand.Uint32() % (maxID + 1)
+	case "i":
+		msg.ID = uint32(g.counter % uint64(maxID+1))
+	default:
+		msg.ID = g.id
+	}
+	msg.Ext = g.ext
+
+	msg.Len = g.dlc
+	if "r" == g.lenMode {
+		msg.Len = uint8(rand.Intn(9))
+	}
+	msg.Rtr = g.rtr
+	if !msg.Rtr {
+		switch g.dataMode {
+		case "r":
+			rand.Read(msg.Data[:])
+		case "i":
+			for i := range msg.Data {
+				msg.Data[i] = byte(g.counter)
+			}
+		default:
+			copy(msg.Data[:], g.data)
+		}
+	}
+	g.counter++
+	return
+}
+
+func runGen(args []string) (err error) {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	df := addDeviceFlags(fs)
+	gap := fs.Duration("g", 200*time.Millisecond, "gap between frames")
+	rate := fs.Float64("rate", 0, "frames per second, overrides -g")
+	idStr := fs.String("I", "r", "ID: r - random, i - increment, or hex value")
+	lenStr := fs.String("L", "r", "data length: r - random or 0..8")
+	dataStr := fs.String("D", "r", "data: r - random, i - increment, or hex bytes")
+	ext := fs.Bool("e", false, "generate 29-bit frames")
+	rtr := fs.Bool("R", false, "generate remote requests")
+	count := fs.Uint("n", 0, "exit after n frames")
+	verbose := fs.Bool("v", false, "print sent frames")
+	fs.Parse(args)
+
+	g, err := newGenerator(*idStr, *lenStr, *dataStr, *ext, *rtr)
+	if err != nil {
+		return
+	}
+	if *rate > 0 {
+		*gap = time.Duration(float64(time.Second) / *rate)
+	}
+
+	dev, err := df.open()
+	if err != nil {
+		return
+	}
+	ch, _ := dev.GetMsgChannelCopy()
+	defer stopDevice(dev, ch)
+
+	rand.Seed(time.Now().UnixNano())
+	stop := interrupted()
+	next := time.Now()
+	for n := uint(0); 0 == *count || n < *count; n++ {
+		msg := g.next()
+		if err = dev.Send(msg); err != nil {
+			return
+		}
+		if *verbose {
+			fmt.Println(formatLine(df.iface(), msg))
+		}
+
+		next = next.Add(*gap)
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		} else {
+			next = time.Now() // too slow, do not try to catch up
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+	return
+}
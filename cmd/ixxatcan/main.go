@@ -0,0 +1,233 @@
+// Command ixxatcan dumps, monitors, sends and generates CAN traffic and shows device status.
+//
+// Usage:
+//
+//	ixxatcan list
+//	ixxatcan dump    [-dev N] [-bitrate 125k] [-f 123:7FF,~200:700] [-dbc file.dbc] [-o trace.asc]
+//	ixxatcan monitor [-dev N] [-bitrate 125k] [-f 123:7FF] [-dbc file.dbc] [-sort id|count|rate|time|name]
+//	ixxatcan send    [-dev N] [-bitrate 125k] 123#DEADBEEF 1ABCDEF0#R ...
+//	ixxatcan gen     [-dev N] [-bitrate 125k] [-g 10ms] [-I r|i|123] [-L r|8] [-D r|i|DEADBEEF]
+//	ixxatcan status  [-dev N] [-bitrate 125k] [-interval 1s]
+//	ixxatcan stats   [-dev N] [-bitrate 125k] [-interval 1s] [-learn 10s] [-missing 3]
+//	ixxatcan detect  [-dev N] [-timeout 5s]
+//	ixxatcan serve   [-dev N] [-bitrate 125k] [-listen :29536] [-auth T] [-socketcand :29537] [-metrics :9100]
+//
+// Commands opening a device accept -slcan PORT for SLCAN adapters
+// and -remote HOST[:PORT] -token T for devices shared by serve.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/remote"
+	"github.com/amdf/ixxatvci3/slcan"
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"list":    {runList, "list available CAN devices"},
+	"dump":    {runDump, "print received frames"},
+	"monitor": {runMonitor, "show one line per ID with rates and changed bytes"},
+	"send":    {runSend, "send frames given as ID#DATA"},
+	"gen":     {runGen, "generate traffic"},
+	"status":  {runStatus, "print channel status periodically"},
+	"stats":   {runStats, "print bus load and per-ID timing computed in software"},
+	"detect":  {runDetect, "detect the bus bitrate"},
+	"serve":   {runServe, "share the device over TCP"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun '%s <command> -h' for command flags\n", os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// backendFlags select a non-native backend of the device.
+type backendFlags struct {
+	slcan  string
+	remote string
+	token  string
+}
+
+func addBackendFlags(fs *flag.FlagSet) *backendFlags {
+	bf := &backendFlags{}
+	fs.StringVar(&bf.slcan, "slcan", "", "use SLCAN adapter at serial port (/dev/ttyACM0, COM3) as the device")
+	fs.StringVar(&bf.remote, "remote", "", "use device shared by 'ixxatcan serve' at host[:port]")
+	fs.StringVar(&bf.token, "token", os.Getenv("IXXATCAN_TOKEN"), "authentication token of -remote server")
+	return bf
+}
+
+// use assigns the selected backend to device number devnum.
+func (bf *backendFlags) use(devnum uint8) (err error) {
+	switch {
+	case bf.slcan != "" && bf.remote != "":
+		err = errors.New("-slcan and -remote are mutually exclusive")
+	case bf.slcan != "":
+		var b *slcan.Backend
+		if b, err = slcan.Open(bf.slcan); nil == err {
+			ixxatvci3.UseBackend(devnum, b)
+		}
+	case bf.remote != "":
+		ixxatvci3.UseBackend(devnum, remote.New(bf.remote, bf.token))
+	}
+	return
+}
+
+// deviceFlags are the flags of commands that open a device.
+type deviceFlags struct {
+	*backendFlags
+	number  uint
+	bitrate string
+	mode    string
+	access  string
+	ifname  string
+	log     string
+	trace   bool
+}
+
+func addDeviceFlags(fs *flag.FlagSet) *deviceFlags {
+	df := &deviceFlags{backendFlags: addBackendFlags(fs)}
+	fs.UintVar(&df.number, "dev", 0, "device number")
+	fs.StringVar(&df.bitrate, "bitrate", "125k", "bitrate in kbit/s (125, 1M) or BTR0:BTR1 in hex")
+	fs.StringVar(&df.ifname, "iface", "", "SocketCAN interface instead of can<dev>")
+	fs.StringVar(&df.mode, "mode", "11bit,29bit", "operating mode, see candev.Builder.Mode")
+	fs.StringVar(&df.log, "log", "error", "driver log level to stderr: off, error, warn, info or debug")
+	fs.BoolVar(&df.trace, "trace", false, "log every sent and received frame (with -log debug)")
+	fs.StringVar(&df.access, "access", "shared", "channel access: shared, exclusive or monitor (never touch the controller)")
+	return df
+}
+
+// open opens and starts the device.
+func (df *deviceFlags) open() (dev *candev.Device, err error) {
+	bitrate, err := ixxatvci3.ParseBitrate(df.bitrate)
+	if err != nil {
+		return
+	}
+	access, err := ixxatvci3.ParseAccessMode(df.access)
+	if err != nil {
+		return
+	}
+	if err = df.setLogger(); err != nil {
+		return
+	}
+	if err = df.use(uint8(df.number)); err != nil {
+		return
+	}
+	var b candev.Builder
+	if dev, err = b.Number(uint8(df.number)).Interface(df.ifname).Speed(bitrate).Mode(df.mode).Access(access).Get(); err != nil {
+		return
+	}
+	dev.Run()
+	return
+}
+
+// setLogger makes the driver log to stderr with the -log level.
+func (df *deviceFlags) setLogger() error {
+	levels := map[string]ixxatvci3.LogLevel{
+		"error": ixxatvci3.LogError,
+		"warn":  ixxatvci3.LogWarn,
+		"info":  ixxatvci3.LogInfo,
+		"debug": ixxatvci3.LogDebug,
+	}
+	if "off" == df.log {
+		ixxatvci3.SetLogger(nil)
+		return nil
+	}
+	level, ok := levels[df.log]
+	if !ok {
+		return fmt.Errorf("invalid log level %q", df.log)
+	}
+	ixxatvci3.SetLogger(ixxatvci3.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), level))
+	ixxatvci3.TraceFrames(uint8(df.number), df.trace)
+	return nil
+}
+
+func (df *deviceFlags) iface() string {
+	if df.ifname != "" {
+		return df.ifname
+	}
+	return fmt.Sprintf("can%d", df.number)
+}
+
+// stopDevice stops dev draining ch until Stop closes it.
+func stopDevice(dev *candev.Device, ch <-chan candev.Message) {
+	go func() {
+		for range ch {
+		}
+	}()
+	dev.Stop()
+}
+
+// interrupted returns a channel closed on SIGINT or SIGTERM.
+func interrupted() <-chan struct{} {
+	done := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		signal.Stop(sig)
+		close(done)
+	}()
+	return done
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Parse(args)
+	list, vcierr := ixxatvci3.ListDevices()
+	if ixxatvci3.VCI_OK != vcierr {
+		return fmt.Errorf("%s", ixxatvci3.GetErrorText(vcierr))
+	}
+	if 0 == len(list) {
+		fmt.Println("no devices found")
+		return nil
+	}
+	for i, info := range list {
+		line := []string{fmt.Sprintf("%d: %s", i, info.Description)}
+		if info.HardwareID != "" {
+			line = append(line, info.HardwareID)
+		}
+		if info.Manufacturer != "" {
+			line = append(line, "("+info.Manufacturer+")")
+		}
+		fmt.Println(strings.Join(line, " "))
+	}
+	return nil
+}
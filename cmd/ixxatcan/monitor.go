@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/dbc"
+)
+
+// ANSI escape sequences of the monitor screen.
+const (
+	ansiClear     = "\x1b[H\x1b[2J"
+	ansiHighlight = "\x1b[1;31m"
+	ansiDim       = "\x1b[2m"
+	ansiReset     = "\x1b[0m"
+)
+
+// monitorEntry is a line of the monitor, one per identifier.
+// Counts and timing of the identifier come from candev.Stats.
+type monitorEntry struct {
+	msg       candev.Message
+	changedAt [8]time.Time // last change time of every data byte
+	seen      bool
+}
+
+// update takes the data of a received frame.
+func (e *monitorEntry) update(msg candev.Message) {
+	if e.seen {
+		for i := 0; i < 8; i++ {
+			if msg.Data[i] != e.msg.Data[i] || (i >= int(e.msg.Len)) != (i >= int(msg.Len)) {
+				e.changedAt[i] = msg.Time
+			}
+		}
+	}
+	e.msg = msg
+	e.seen = true
+}
+
+type monitorKey struct {
+	id  uint32
+	ext bool
+}
+
+// monitorRow is an entry with the statistics of its identifier.
+type monitorRow struct {
+	*monitorEntry
+	candev.IDStats
+}
+
+// monitor holds the state shown by the monitor command.
+type monitor struct {
+	mu      sync.Mutex
+	entries map[monitorKey]*monitorEntry
+	db      *dbc.Database
+	filters []candev.Filter
+	sortBy  string
+	paused  bool
+	total   uint64
+	start   time.Time
+
+	highlight time.Duration
+	timeout   time.Duration
+
+	// stats computes counts, rates, periods, jitter and bus load in software,
+	// newStats replaces it on clear.
+	stats     *candev.Stats
+	stopStats func()
+	newStats  func() (*candev.Stats, func())
+	frozen    candev.StatsSnapshot // statistics shown while paused
+
+	busOffCount   uint
+	errLimitCount uint
+	lastStatus    uint32
+}
+
+func newMonitor(newStats func() (*candev.Stats, func())) *monitor {
+	m := &monitor{entries: make(map[monitorKey]*monitorEntry), sortBy: "id", start: time.Now(), newStats: newStats}
+	m.stats, m.stopStats = newStats()
+	return m
+}
+
+// close stops the statistics.
+func (m *monitor) close() {
+	m.mu.Lock()
+	stop := m.stopStats
+	m.mu.Unlock()
+	stop()
+}
+
+func (m *monitor) receive(msg candev.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total++
+	if m.paused {
+		return
+	}
+	key := monitorKey{msg.ID, msg.Ext || msg.ID > 0x7FF}
+	e, ok := m.entries[key]
+	if !ok {
+		e = &monitorEntry{}
+		m.entries[key] = e
+	}
+	e.update(msg)
+}
+
+// updateStatus counts transitions to error states.
+func (m *monitor) updateStatus(status uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rising := status &^ m.lastStatus
+	if rising&ixxatvci3.CAN_STATUS_BUSOFF != 0 {
+		m.busOffCount++
+	}
+	if rising&ixxatvci3.CAN_STATUS_ERRLIM != 0 {
+		m.errLimitCount++
+	}
+	m.lastStatus = status
+}
+
+// command executes a line typed by the user, returns false to quit.
+func (m *monitor) command(line string) bool {
+	var stopStats func()
+	defer func() {
+		// stopping waits for the device fan-out, which may wait for receive and m.mu
+		if stopStats != nil {
+			stopStats()
+		}
+	}()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fields := strings.Fields(line)
+	if 0 == len(fields) {
+		return true
+	}
+	switch fields[0] {
+	case "q", "quit":
+		return false
+	case "p", "pause":
+		m.paused = !m.paused
+		if m.paused {
+			m.frozen = m.stats.Snapshot(time.Now())
+		}
+	case "c", "clear":
+		m.entries = make(map[monitorKey]*monitorEntry)
+		stopStats = m.stopStats
+		m.stats, m.stopStats = m.newStats()
+		m.frozen = m.stats.Snapshot(time.Now())
+	case "s", "sort":
+		if len(fields) > 1 {
+			switch fields[1] {
+			case "id", "count", "rate", "name", "time":
+				m.sortBy = fields[1]
+			}
+		}
+	case "f", "filter":
+		if filters, err := candev.ParseFilters(strings.Join(fields[1:], "")); nil == err {
+			m.filters = filters
+		}
+	}
+	return true
+}
+
+// sorted returns the rows to show in display order.
+func (m *monitor) sorted(snap candev.StatsSnapshot) (list []monitorRow) {
+	ids := make(map[monitorKey]candev.IDStats, len(snap.IDs))
+	for _, st := range snap.IDs {
+		ids[monitorKey{st.ID, st.Ext}] = st
+	}
+	for key, e := range m.entries {
+		st := ids[key]
+		if m.timeout > 0 && !st.Last.IsZero() && snap.Time.Sub(st.Last) > m.timeout {
+			delete(m.entries, key)
+			continue
+		}
+		if candev.MatchFilters(m.filters, e.msg.ID) {
+			list = append(list, monitorRow{e, st})
+		}
+	}
+	less := func(i, j int) bool { return list[i].msg.ID < list[j].msg.ID }
+	switch m.sortBy {
+	case "count":
+		less = func(i, j int) bool { return list[i].Count > list[j].Count }
+	case "rate":
+		less = func(i, j int) bool { return list[i].Frequency > list[j].Frequency }
+	case "time":
+		less = func(i, j int) bool { return list[i].Last.After(list[j].Last) }
+	case "name":
+		less = func(i, j int) bool { return m.messageName(list[i].msg) < m.messageName(list[j].msg) }
+	}
+	sort.SliceStable(list, less)
+	return
+}
+
+func (m *monitor) messageName(msg candev.Message) string {
+	if nil == m.db {
+		return ""
+	}
+	if dm := m.db.Message(msg.ID, msg.Ext); dm != nil {
+		return dm.Name
+	}
+	return ""
+}
+
+// render draws the screen.
+func (m *monitor) render(w io.Writer, iface string, st ixxatvci3.CANChanStatus, stErr uint32, rxErrors uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	snap := m.frozen
+	if !m.paused {
+		snap = m.stats.Snapshot(now)
+	}
+	var sb strings.Builder
+	sb.WriteString(ansiClear)
+
+	load := "load -"
+	if len(snap.Loads) > 0 {
+		load = fmt.Sprintf("load %5.1f%%", 100*snap.Loads[0].Load)
+	}
+	if ixxatvci3.VCI_OK == stErr {
+		fmt.Fprintf(&sb, "%s  %s  status %s  rx fifo %3d%%  tx fifo %3d%%  overrun %t\n",
+			iface, load, statusText(st.LineStatus.Status), st.RxFifoLoad, st.TxFifoLoad, st.RxOverrun != 0)
+	} else {
+		fmt.Fprintf(&sb, "%s  %s  status unavailable: %s\n", iface, load, ixxatvci3.GetErrorText(stErr))
+	}
+	state := ""
+	if m.paused {
+		state = "  PAUSED"
+	}
+	fmt.Fprintf(&sb, "frames %d  ids %d  bus-off %d  error limit %d  rx errors %d  uptime %s%s\n\n",
+		m.total, len(m.entries), m.busOffCount, m.errLimitCount, rxErrors, now.Sub(m.start).Truncate(time.Second), state)
+	fmt.Fprintf(&sb, "%-8s %3s  %-23s %9s %9s %9s %9s\n", "ID", "DLC", "DATA", "COUNT", "RATE/s", "PERIOD", "JITTER")
+
+	for _, e := range m.sorted(snap) {
+		id := fmt.Sprintf("%03X", e.msg.ID)
+		if e.Ext {
+			id = fmt.Sprintf("%08X", e.msg.ID)
+		}
+		stale := e.Missing
+		if stale {
+			sb.WriteString(ansiDim)
+		}
+		fmt.Fprintf(&sb, "%-8s %3d  ", id, e.msg.Len)
+		for i := 0; i < 8; i++ {
+			switch {
+			case e.msg.Rtr && 0 == i:
+				sb.WriteString("RTR")
+			case e.msg.Rtr || i >= int(e.msg.Len):
+				sb.WriteString("   ")
+			case !e.changedAt[i].IsZero() && now.Sub(e.changedAt[i]) < m.highlight:
+				fmt.Fprintf(&sb, "%s%02X%s ", ansiHighlight, e.msg.Data[i], ansiReset)
+				if stale {
+					sb.WriteString(ansiDim)
+				}
+			default:
+				fmt.Fprintf(&sb, "%02X ", e.msg.Data[i])
+			}
+		}
+		fmt.Fprintf(&sb, "%8d %9.1f %9s %9s", e.Count, e.Frequency,
+			e.Period.Round(100*time.Microsecond), e.Jitter.Round(10*time.Microsecond))
+		if m.db != nil {
+			if dm, values, err := m.db.Decode(e.msg); dm != nil {
+				fmt.Fprintf(&sb, "  %s", dm.Name)
+				if nil == err {
+					for _, v := range values {
+						fmt.Fprintf(&sb, " %s", v)
+					}
+				}
+			}
+		}
+		if stale {
+			sb.WriteString(ansiReset)
+		}
+		sb.WriteByte('\n')
+	}
+	fmt.Fprintf(&sb, "\n[p]ause  [c]lear  [s]ort id|count|rate|time|name  [f]ilter 123:7FF,~200  [q]uit  (+Enter)  sort %s\n", m.sortBy)
+	io.WriteString(w, sb.String())
+}
+
+func runMonitor(args []string) (err error) {
+	fs := flag.NewFlagSet("monitor", flag.ExitOnError)
+	df := addDeviceFlags(fs)
+	filterStr := fs.String("f", "", "filters ID[:MASK] in hex, ~ rejects, e.g. 123:7FF,~200:700")
+	dbcPath := fs.String("dbc", "", "decode signals with DBC file")
+	refresh := fs.Duration("refresh", 250*time.Millisecond, "screen refresh interval")
+	highlight := fs.Duration("highlight", time.Second, "highlight changed bytes for this time")
+	timeout := fs.Duration("timeout", 0, "remove IDs not received for this time (0 - keep)")
+	sortBy := fs.String("sort", "id", "sort by id, count, rate, time or name")
+	fs.Parse(args)
+
+	filters, err := candev.ParseFilters(*filterStr)
+	if err != nil {
+		return
+	}
+	var db *dbc.Database
+	if *dbcPath != "" {
+		if db, err = dbc.ParseFile(*dbcPath); err != nil {
+			return
+		}
+	}
+
+	dev, err := df.open()
+	if err != nil {
+		return
+	}
+	ch, _ := dev.GetMsgChannelCopy()
+	defer stopDevice(dev, ch)
+
+	// the bus load of the first window is shown, it is known without hardware support
+	m := newMonitor(func() (*candev.Stats, func()) {
+		return dev.Stats(candev.StatsOptions{Windows: []time.Duration{time.Second}})
+	})
+	defer m.close()
+	m.highlight, m.timeout = *highlight, *timeout
+	m.filters, m.db = filters, db
+	m.command("sort " + *sortBy)
+	go func() {
+		for msg := range ch {
+			m.receive(msg)
+		}
+	}()
+
+	quit := make(chan struct{})
+	go func() {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			if !m.command(s.Text()) {
+				break
+			}
+		}
+		close(quit)
+	}()
+
+	stop := interrupted()
+	ticker := time.NewTicker(*refresh)
+	defer ticker.Stop()
+	for {
+		st, vcierr := ixxatvci3.GetStatus(uint8(df.number))
+		if ixxatvci3.VCI_OK == vcierr {
+			m.updateStatus(st.LineStatus.Status)
+		}
+		m.render(os.Stdout, df.iface(), st, vcierr, dev.RcvErrCount)
+		select {
+		case <-stop:
+			return
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/trace"
+)
+
+func runSend(args []string) (err error) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	df := addDeviceFlags(fs)
+	ext := fs.Bool("e", false, "send 3-digit IDs as 29-bit frames")
+	count := fs.Uint("n", 1, "number of times to send the frames")
+	interval := fs.Duration("i", 100*time.Millisecond, "interval between repetitions")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: send [flags] ID#DATA...\n"+
+			"  123#DEADBEEF, 123#DE.AD.BE.EF - 11-bit data frame\n"+
+			"  1ABCDEF0#01 - 29-bit frame (8 hex digits)\n"+
+			"  123#R, 123#R4 - remote request with DLC\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if 0 == fs.NArg() {
+		return errors.New("no frames given")
+	}
+
+	msgs := make([]candev.Message, fs.NArg())
+	for i, arg := range fs.Args() {
+		rec, e := trace.ParseFrame(arg)
+		if e != nil {
+			return e
+		}
+		if rec.Error {
+			return fmt.Errorf("cannot send error frame %q", arg)
+		}
+		msgs[i] = rec.Msg
+		if *ext {
+			msgs[i].Ext = true
+		}
+	}
+
+	dev, err := df.open()
+	if err != nil {
+		return
+	}
+	ch, _ := dev.GetMsgChannelCopy()
+	defer stopDevice(dev, ch)
+
+	for n := uint(0); n < *count; n++ {
+		if n > 0 {
+			time.Sleep(*interval)
+		}
+		for _, msg := range msgs {
+			if err = dev.Send(msg); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/metrics"
+	"github.com/amdf/ixxatvci3/remote"
+)
+
+func runServe(args []string) (err error) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	df := addDeviceFlags(fs)
+	listen := fs.String("listen", ":"+remote.DefaultPort, "TCP address of the binary protocol")
+	token := fs.String("auth", os.Getenv("IXXATCAN_TOKEN"), "token required from clients")
+	socketcand := fs.String("socketcand", "", "also serve socketcand raw mode at this TCP address")
+	readOnly := fs.Bool("ro", false, "reject frames sent by clients")
+	metricsAddr := fs.String("metrics", "", "serve Prometheus metrics at this HTTP address, e.g. :9100")
+	fs.Parse(args)
+
+	bitrate, err := ixxatvci3.ParseBitrate(df.bitrate)
+	if err != nil {
+		return
+	}
+	dev, err := df.open()
+	if err != nil {
+		return
+	}
+	ch, _ := dev.GetMsgChannelCopy()
+	defer stopDevice(dev, ch)
+	go func() {
+		for range ch {
+		}
+	}()
+
+	devnum := uint8(df.number)
+	var bus remote.Bus = dev
+	if *metricsAddr != "" {
+		c := metrics.NewCollector(df.iface())
+		defer c.Attach(dev)()
+		defer c.PollStatus(devnum, time.Second)()
+		bus = c.Wrap(dev)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(c))
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				fmt.Fprintf(os.Stderr, "metrics: %v\n", err)
+			}
+		}()
+		fmt.Printf("metrics at http://%s/metrics\n", *metricsAddr)
+	}
+	srv := &remote.Server{
+		Bus:      bus,
+		Token:    *token,
+		Bitrate:  bitrate,
+		ReadOnly: *readOnly,
+		Status:   func() (ixxatvci3.CANChanStatus, uint32) { return ixxatvci3.GetStatus(devnum) },
+		OnConnect: func(addr net.Addr, connected bool, err error) {
+			switch {
+			case connected:
+				fmt.Printf("%s connected\n", addr)
+			case err != nil:
+				fmt.Printf("%s disconnected: %v\n", addr, err)
+			default:
+				fmt.Printf("%s disconnected\n", addr)
+			}
+		},
+	}
+	errs := make(chan error, 2)
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return
+	}
+	fmt.Printf("serving %s at %s\n", df.iface(), l.Addr())
+	go func() { errs <- srv.Serve(l) }()
+	if *socketcand != "" {
+		var sl net.Listener
+		if sl, err = net.Listen("tcp", *socketcand); err != nil {
+			srv.Close()
+			return
+		}
+		fmt.Printf("serving %s with socketcand protocol at %s\n", df.iface(), sl.Addr())
+		go func() { errs <- srv.ServeSocketcand(sl, df.iface()) }()
+	}
+
+	select {
+	case <-interrupted():
+	case err = <-errs:
+	}
+	srv.Close()
+	return
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+func runStats(args []string) (err error) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	df := addDeviceFlags(fs)
+	interval := fs.Duration("interval", time.Second, "print interval")
+	learn := fs.Duration("learn", 10*time.Second, "IDs first seen later are reported as new")
+	missing := fs.Float64("missing", 3, "IDs are missing after this many periods without frames")
+	count := fs.Uint("n", 0, "exit after n prints")
+	ids := fs.Bool("ids", true, "print per-ID statistics")
+	fs.Parse(args)
+
+	dev, err := df.open()
+	if err != nil {
+		return
+	}
+	ch, _ := dev.GetMsgChannelCopy()
+	defer stopDevice(dev, ch)
+	go func() {
+		for range ch {
+		}
+	}()
+	stats, stopStats := dev.Stats(candev.StatsOptions{
+		Windows:       []time.Duration{100 * time.Millisecond, time.Second, 10 * time.Second},
+		LearnTime:     *learn,
+		MissingFactor: *missing,
+	})
+	defer stopStats()
+
+	stop := interrupted()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for n := uint(0); 0 == *count || n < *count; n++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		printStats(df.iface(), stats.Snapshot(time.Now()), *ids)
+	}
+	return
+}
+
+func printStats(iface string, snap candev.StatsSnapshot, ids bool) {
+	loads := make([]string, len(snap.Loads))
+	for i, l := range snap.Loads {
+		loads[i] = fmt.Sprintf("%v %5.1f%%", l.Window, 100*l.Load)
+	}
+	if 0 == len(loads) {
+		loads = []string{"load unknown (bitrate)"}
+	}
+	fmt.Printf("%s %s load %s, frames %d, bits %d\n", snap.Time.Format("15:04:05.000"), iface,
+		strings.Join(loads, ", "), snap.Frames, snap.Bits)
+	if !ids {
+		return
+	}
+	for _, st := range snap.IDs {
+		id := fmt.Sprintf("%03X", st.ID)
+		if st.Ext {
+			id = fmt.Sprintf("%08X", st.ID)
+		}
+		var flags []string
+		if st.New {
+			flags = append(flags, "NEW")
+		}
+		if st.Missing {
+			flags = append(flags, "MISSING")
+		}
+		fmt.Printf("  %8s %8d %8.1f/s period %-10v jitter %-10v min %-10v max %-10v %s\n", id, st.Count, st.Frequency,
+			st.Period.Round(time.Microsecond), st.Jitter.Round(time.Microsecond),
+			st.MinPeriod.Round(time.Microsecond), st.MaxPeriod.Round(time.Microsecond), strings.Join(flags, ","))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+var statusNames = []struct {
+	bit  uint32
+	name string
+}{
+	{ixxatvci3.CAN_STATUS_TXPEND, "TXPEND"},
+	{ixxatvci3.CAN_STATUS_OVRRUN, "OVERRUN"},
+	{ixxatvci3.CAN_STATUS_ERRLIM, "ERRLIMIT"},
+	{ixxatvci3.CAN_STATUS_BUSOFF, "BUSOFF"},
+	{ixxatvci3.CAN_STATUS_ININIT, "INIT"},
+	{ixxatvci3.CAN_STATUS_BUSCERR, "COUPLING"},
+}
+
+func statusText(status uint32) string {
+	var names []string
+	for _, s := range statusNames {
+		if status&s.bit != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if 0 == len(names) {
+		return "OK"
+	}
+	return strings.Join(names, ",")
+}
+
+func runStatus(args []string) (err error) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	df := addDeviceFlags(fs)
+	interval := fs.Duration("interval", time.Second, "status poll interval")
+	count := fs.Uint("n", 0, "exit after n polls")
+	fs.Parse(args)
+
+	dev, err := df.open()
+	if err != nil {
+		return
+	}
+	ch, _ := dev.GetMsgChannelCopy()
+	defer stopDevice(dev, ch)
+
+	// count received frames
+	var received uint64
+	go func() {
+		for range ch {
+			atomic.AddUint64(&received, 1)
+		}
+	}()
+
+	stop := interrupted()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for n := uint(0); 0 == *count || n < *count; n++ {
+		st, vcierr := ixxatvci3.GetStatus(uint8(df.number))
+		if ixxatvci3.VCI_OK != vcierr {
+			return errors.New(ixxatvci3.GetErrorText(vcierr))
+		}
+		ls := st.LineStatus
+		bitrate := ixxatvci3.BitrateRegisterPair{Btr0: ls.BtReg0, Btr1: ls.BtReg1}
+		fmt.Printf("%s %s bitrate %s, load %3d%%, status %s, rx fifo %3d%%, tx fifo %3d%%, overrun %t, frames %d\n",
+			time.Now().Format("15:04:05.000"), df.iface(), bitrate, ls.BusLoad, statusText(ls.Status),
+			st.RxFifoLoad, st.TxFifoLoad, st.RxOverrun != 0, atomic.LoadUint64(&received))
+		if 0 == n {
+			printFifoStatus(uint8(df.number))
+			if o, err := dev.Ownership(); nil == err {
+				fmt.Printf("access %s, owns controller %t\n", o.Mode, o.OwnsController)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+	return
+}
+
+// printFifoStatus prints buffer sizes in effect and receive overruns if the backend reports them.
+func printFifoStatus(devnum uint8) {
+	fs, vcierr := ixxatvci3.GetFifoStatus(devnum)
+	if ixxatvci3.VCI_OK != vcierr {
+		return
+	}
+	if fs.RxFifoSize != 0 {
+		fmt.Printf("rx fifo %d (threshold %d), tx fifo %d (threshold %d), overruns %d\n",
+			fs.RxFifoSize, fs.RxThreshold, fs.TxFifoSize, fs.TxThreshold, fs.RxOverruns)
+	} else {
+		fmt.Printf("socket rcvbuf %d, sndbuf %d, txqueuelen %d, overruns %d\n",
+			fs.SocketRcvBuf, fs.SocketSndBuf, fs.TxQueueLen, fs.RxOverruns)
+	}
+}
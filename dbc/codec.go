@@ -0,0 +1,129 @@
+package dbc
+
+import (
+	"fmt"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Value is a decoded signal value.
+type Value struct {
+	Signal      *Signal
+	Raw         uint64
+	Physical    float64
+	Description string // value description, if any
+}
+
+func (v Value) String() string {
+	if "" != v.Description {
+		return fmt.Sprintf("%s=%s", v.Signal.Name, v.Description)
+	}
+	if "" != v.Signal.Unit {
+		return fmt.Sprintf("%s=%g %s", v.Signal.Name, v.Physical, v.Signal.Unit)
+	}
+	return fmt.Sprintf("%s=%g", v.Signal.Name, v.Physical)
+}
+
+// Decode decodes a received CAN message.
+// Error is returned if the message is not in the database or is too short.
+func (db *Database) Decode(msg candev.Message) (m *Message, values []Value, err error) {
+	m = db.Message(msg.ID, msg.Ext)
+	if nil == m {
+		err = fmt.Errorf("dbc: unknown message ID %X", msg.ID)
+		return
+	}
+	values, err = m.Decode(msg)
+	return
+}
+
+// Decode decodes signals of msg. Multiplexed signals are decoded only
+// if the multiplexer value matches.
+func (m *Message) Decode(msg candev.Message) (values []Value, err error) {
+	if msg.Len < m.Length {
+		err = fmt.Errorf("dbc: message %s is %d bytes long, %d expected", m.Name, msg.Len, m.Length)
+		return
+	}
+
+	var muxValue uint64
+	if nil != m.mux {
+		muxValue = m.mux.Raw(msg.Data)
+	}
+	for _, s := range m.Signals {
+		if s.IsMultiplexed && s.MuxValue != muxValue {
+			continue
+		}
+		v := Value{Signal: s, Raw: s.Raw(msg.Data)}
+		v.Physical = s.ToPhysical(v.Raw)
+		v.Description, _ = s.Description(v.Raw)
+		values = append(values, v)
+	}
+	return
+}
+
+// DecodeMap decodes signals of msg into a map of physical values by signal name.
+func (m *Message) DecodeMap(msg candev.Message) (values map[string]float64, err error) {
+	list, err := m.Decode(msg)
+	if err != nil {
+		return
+	}
+	values = make(map[string]float64, len(list))
+	for _, v := range list {
+		values[v.Signal.Name] = v.Physical
+	}
+	return
+}
+
+// Encode builds a CAN message from physical signal values.
+// Signals missing in values get their start value (GenSigStartValue or 0).
+// For a multiplexed message only signals of the given multiplexer value are encoded.
+func (m *Message) Encode(values map[string]float64) (msg candev.Message, err error) {
+	for name := range values {
+		if nil == m.Signal(name) {
+			err = fmt.Errorf("dbc: message %s has no signal %s", m.Name, name)
+			return
+		}
+	}
+
+	msg.ID = m.ID
+	msg.Ext = m.Extended
+	msg.Len = m.Length
+
+	var muxValue uint64
+	if nil != m.mux {
+		muxValue = m.mux.StartValue()
+		if value, ok := values[m.mux.Name]; ok {
+			if muxValue, err = m.mux.FromPhysical(value); err != nil {
+				return
+			}
+		}
+	}
+
+	for _, s := range m.Signals {
+		if s.IsMultiplexed && s.MuxValue != muxValue {
+			if _, ok := values[s.Name]; ok {
+				err = fmt.Errorf("dbc: signal %s is not sent with multiplexer value %d", s.Name, muxValue)
+				return
+			}
+			continue
+		}
+		raw := s.StartValue()
+		if value, ok := values[s.Name]; ok {
+			if raw, err = s.FromPhysical(value); err != nil {
+				return
+			}
+		}
+		s.SetRaw(&msg.Data, raw)
+	}
+	return
+}
+
+// EncodeByName builds a CAN message by message name.
+func (db *Database) EncodeByName(name string, values map[string]float64) (msg candev.Message, err error) {
+	m := db.MessageByName(name)
+	if nil == m {
+		err = fmt.Errorf("dbc: unknown message %s", name)
+		return
+	}
+	msg, err = m.Encode(values)
+	return
+}
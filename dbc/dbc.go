@@ -0,0 +1,371 @@
+// Package dbc reads Vector DBC files and converts candev.Message frames
+// to physical signal values and back.
+package dbc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"go.einride.tech/can/pkg/dbc"
+)
+
+// Database is a parsed DBC file.
+type Database struct {
+	Version     string
+	Nodes       []string
+	Messages    []*Message
+	Comment     string
+	Attributes  map[string]interface{}      // network attributes: int64, float64 or string
+	ValueTables map[string]map[int64]string // global value tables (VAL_TABLE_)
+
+	standard map[uint32]*Message
+	extended map[uint32]*Message
+	byName   map[string]*Message
+}
+
+// Message is a CAN frame description.
+type Message struct {
+	ID         uint32 // CAN identifier without extended flag
+	Extended   bool
+	Name       string
+	Length     uint8
+	Sender     string
+	Signals    []*Signal
+	Comment    string
+	Attributes map[string]interface{}
+
+	mux *Signal // multiplexer switch signal
+}
+
+// ParseFile reads and parses DBC file.
+func ParseFile(filename string) (db *Database, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	db, err = Parse(filename, data)
+	return
+}
+
+// Parse parses DBC file contents. filename is used in error messages only.
+func Parse(filename string, data []byte) (db *Database, err error) {
+	p := dbc.NewParser(filename, data)
+	if perr := p.Parse(); perr != nil {
+		err = perr
+		return
+	}
+
+	db = &Database{
+		Attributes:  make(map[string]interface{}),
+		ValueTables: make(map[string]map[int64]string),
+		standard:    make(map[uint32]*Message),
+		extended:    make(map[uint32]*Message),
+		byName:      make(map[string]*Message),
+	}
+
+	defs := p.Defs()
+	var valueTypes []*dbc.SignalValueTypeDef
+	var valueDescriptions []*dbc.ValueDescriptionsDef
+	var comments []*dbc.CommentDef
+	var attributes []*dbc.AttributeDef
+	var defaults []*dbc.AttributeDefaultValueDef
+	var attributeValues []*dbc.AttributeValueForObjectDef
+
+	for _, def := range defs {
+		switch d := def.(type) {
+		case *dbc.VersionDef:
+			db.Version = d.Version
+		case *dbc.NodesDef:
+			for _, name := range d.NodeNames {
+				db.Nodes = append(db.Nodes, string(name))
+			}
+		case *dbc.MessageDef:
+			if d.MessageID == independentSignals {
+				continue
+			}
+			var m *Message
+			m, err = newMessage(d)
+			if err != nil {
+				return
+			}
+			if db.lookup(m.ID, m.Extended) != nil {
+				err = fmt.Errorf("dbc: %v: duplicate message ID %X", d.Pos, m.ID)
+				return
+			}
+			db.add(m)
+		case *dbc.ValueTableDef:
+			table := make(map[int64]string)
+			for _, vd := range d.ValueDescriptions {
+				table[int64(vd.Value)] = vd.Description
+			}
+			db.ValueTables[string(d.TableName)] = table
+		case *dbc.SignalValueTypeDef:
+			valueTypes = append(valueTypes, d)
+		case *dbc.ValueDescriptionsDef:
+			valueDescriptions = append(valueDescriptions, d)
+		case *dbc.CommentDef:
+			comments = append(comments, d)
+		case *dbc.AttributeDef:
+			attributes = append(attributes, d)
+		case *dbc.AttributeDefaultValueDef:
+			defaults = append(defaults, d)
+		case *dbc.AttributeValueForObjectDef:
+			attributeValues = append(attributeValues, d)
+		}
+	}
+
+	for _, d := range valueTypes {
+		var s *Signal
+		if s, err = db.signal(d.MessageID, d.SignalName, d.Pos); err != nil {
+			return
+		}
+		switch d.SignalValueType {
+		case dbc.SignalValueTypeFloat32:
+			s.Float = true
+			if 32 != s.Length {
+				err = fmt.Errorf("dbc: %v: float signal %s must be 32 bits long", d.Pos, s.Name)
+				return
+			}
+		case dbc.SignalValueTypeFloat64:
+			s.Float = true
+			if 64 != s.Length {
+				err = fmt.Errorf("dbc: %v: double signal %s must be 64 bits long", d.Pos, s.Name)
+				return
+			}
+		}
+	}
+
+	for _, d := range valueDescriptions {
+		if dbc.ObjectTypeSignal != d.ObjectType {
+			continue
+		}
+		var s *Signal
+		if s, err = db.signal(d.MessageID, d.SignalName, d.Pos); err != nil {
+			return
+		}
+		s.Values = make(map[int64]string)
+		for _, vd := range d.ValueDescriptions {
+			s.Values[int64(vd.Value)] = vd.Description
+		}
+	}
+
+	for _, d := range comments {
+		switch d.ObjectType {
+		case dbc.ObjectTypeUnspecified:
+			db.Comment = d.Comment
+		case dbc.ObjectTypeMessage:
+			if m := db.messageByDBCID(d.MessageID); m != nil {
+				m.Comment = d.Comment
+			}
+		case dbc.ObjectTypeSignal:
+			var s *Signal
+			if s, err = db.signal(d.MessageID, d.SignalName, d.Pos); err != nil {
+				return
+			}
+			s.Comment = d.Comment
+		}
+	}
+
+	db.applyAttributes(attributes, defaults, attributeValues)
+
+	return
+}
+
+const independentSignals = dbc.MessageID(0xC0000000)
+
+func newMessage(d *dbc.MessageDef) (m *Message, err error) {
+	if err = d.MessageID.Validate(); err != nil {
+		err = fmt.Errorf("dbc: %v: %s", d.Pos, err.Error())
+		return
+	}
+	if d.Size > 8 {
+		err = fmt.Errorf("dbc: %v: message %s is %d bytes long, CAN FD is not supported", d.Pos, d.Name, d.Size)
+		return
+	}
+
+	m = &Message{
+		ID:         d.MessageID.ToCAN(),
+		Extended:   d.MessageID.IsExtended(),
+		Name:       string(d.Name),
+		Length:     uint8(d.Size),
+		Sender:     string(d.Transmitter),
+		Attributes: make(map[string]interface{}),
+	}
+	for i := range d.Signals {
+		var s *Signal
+		s, err = newSignal(&d.Signals[i], m.Length)
+		if err != nil {
+			return
+		}
+		if s.IsMultiplexer {
+			if nil != m.mux {
+				err = fmt.Errorf("dbc: %v: message %s has more than one multiplexer", d.Signals[i].Pos, m.Name)
+				return
+			}
+			m.mux = s
+		}
+		m.Signals = append(m.Signals, s)
+	}
+	for _, s := range m.Signals {
+		if s.IsMultiplexed && nil == m.mux {
+			err = fmt.Errorf("dbc: message %s: multiplexed signal %s without multiplexer", m.Name, s.Name)
+			return
+		}
+	}
+	return
+}
+
+func (db *Database) add(m *Message) {
+	db.Messages = append(db.Messages, m)
+	if m.Extended {
+		db.extended[m.ID] = m
+	} else {
+		db.standard[m.ID] = m
+	}
+	db.byName[m.Name] = m
+}
+
+func (db *Database) lookup(id uint32, extended bool) *Message {
+	if extended {
+		return db.extended[id]
+	}
+	return db.standard[id]
+}
+
+func (db *Database) messageByDBCID(id dbc.MessageID) *Message {
+	return db.lookup(id.ToCAN(), id.IsExtended())
+}
+
+func (db *Database) signal(id dbc.MessageID, name dbc.Identifier, pos fmt.Stringer) (s *Signal, err error) {
+	m := db.messageByDBCID(id)
+	if nil == m {
+		err = fmt.Errorf("dbc: %v: unknown message ID %X", pos, id.ToCAN())
+		return
+	}
+	s = m.Signal(string(name))
+	if nil == s {
+		err = fmt.Errorf("dbc: %v: unknown signal %s in message %s", pos, name, m.Name)
+	}
+	return
+}
+
+func (db *Database) applyAttributes(attributes []*dbc.AttributeDef, defaults []*dbc.AttributeDefaultValueDef, values []*dbc.AttributeValueForObjectDef) {
+	defaultValues := make(map[string]interface{})
+	for _, d := range defaults {
+		for _, a := range attributes {
+			if a.Name == d.AttributeName {
+				defaultValues[string(a.Name)] = attributeValue(a.Type, d.DefaultIntValue, d.DefaultFloatValue, d.DefaultStringValue)
+			}
+		}
+	}
+
+	for _, a := range attributes {
+		def, ok := defaultValues[string(a.Name)]
+		if !ok {
+			continue
+		}
+		switch a.ObjectType {
+		case dbc.ObjectTypeUnspecified:
+			db.Attributes[string(a.Name)] = def
+		case dbc.ObjectTypeMessage:
+			for _, m := range db.Messages {
+				m.Attributes[string(a.Name)] = def
+			}
+		case dbc.ObjectTypeSignal:
+			for _, m := range db.Messages {
+				for _, s := range m.Signals {
+					s.Attributes[string(a.Name)] = def
+				}
+			}
+		}
+	}
+
+	for _, v := range values {
+		var typ dbc.AttributeValueType
+		for _, a := range attributes {
+			if a.Name == v.AttributeName {
+				typ = a.Type
+			}
+		}
+		value := attributeValue(typ, v.IntValue, v.FloatValue, v.StringValue)
+		switch v.ObjectType {
+		case dbc.ObjectTypeUnspecified:
+			db.Attributes[string(v.AttributeName)] = value
+		case dbc.ObjectTypeMessage:
+			if m := db.messageByDBCID(v.MessageID); m != nil {
+				m.Attributes[string(v.AttributeName)] = value
+			}
+		case dbc.ObjectTypeSignal:
+			if s, err := db.signal(v.MessageID, v.SignalName, v.Pos); nil == err {
+				s.Attributes[string(v.AttributeName)] = value
+			}
+		}
+	}
+}
+
+func attributeValue(typ dbc.AttributeValueType, i int64, f float64, s string) interface{} {
+	switch typ {
+	case dbc.AttributeValueTypeInt, dbc.AttributeValueTypeHex:
+		return i
+	case dbc.AttributeValueTypeFloat:
+		return f
+	}
+	return s
+}
+
+// Message returns a message by CAN identifier.
+// A standard identifier is looked up first unless extended is true.
+func (db *Database) Message(id uint32, extended bool) *Message {
+	if nil == db {
+		return nil
+	}
+	if m := db.lookup(id, extended); m != nil {
+		return m
+	}
+	if !extended {
+		// frames received without extended flag
+		return db.extended[id]
+	}
+	return nil
+}
+
+// MessageByName returns a message by name.
+func (db *Database) MessageByName(name string) *Message {
+	if nil == db {
+		return nil
+	}
+	return db.byName[name]
+}
+
+// MessagesOf returns messages sent by node, ordered by ID.
+func (db *Database) MessagesOf(node string) (list []*Message) {
+	for _, m := range db.Messages {
+		if m.Sender == node {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return
+}
+
+// Signal returns a signal by name.
+func (m *Message) Signal(name string) *Signal {
+	for _, s := range m.Signals {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Multiplexer returns the multiplexer switch signal or nil.
+func (m *Message) Multiplexer() *Signal {
+	return m.mux
+}
+
+// IntAttribute returns integer attribute value of the message.
+func (m *Message) IntAttribute(name string) (value int64, ok bool) {
+	value, ok = m.Attributes[name].(int64)
+	return
+}
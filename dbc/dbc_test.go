@@ -0,0 +1,155 @@
+package dbc_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/dbc"
+)
+
+const testDBC = `VERSION "1.0"
+
+NS_ :
+
+BS_:
+
+BU_: ECU TESTER
+
+BO_ 256 Engine: 8 ECU
+ SG_ Speed : 0|16@1+ (0.01,0) [0|655.35] "km/h" TESTER
+ SG_ Temp : 16|8@1- (1,0) [-40|125] "degC" TESTER
+ SG_ Gear : 24|4@1+ (1,0) [0|15] "" TESTER
+ SG_ Torque : 32|32@1- (1,0) [0|0] "Nm" TESTER
+
+BO_ 2364539904 Body: 8 ECU
+ SG_ Rpm : 7|16@0+ (0.125,0) [0|8031.875] "rpm" TESTER
+ SG_ Trim : 23|12@0- (0.5,-10) [-1034|1013.5] "" TESTER
+ SG_ Flags : 27|6@0+ (1,0) [0|63] "" TESTER
+
+BO_ 512 Diag: 8 TESTER
+ SG_ Page M : 0|8@1+ (1,0) [0|0] "" ECU
+ SG_ Volt m1 : 8|16@1+ (0.001,0) [0|65.535] "V" ECU
+ SG_ Count m2 : 8|32@1+ (1,0) [0|0] "" ECU
+
+BA_DEF_ SG_ "GenSigStartValue" INT 0 65535;
+BA_DEF_DEF_ "GenSigStartValue" 0;
+BA_ "GenSigStartValue" SG_ 256 Gear 3;
+VAL_ 512 Page 1 "voltage" 2 "counter" ;
+SIG_VALTYPE_ 256 Torque : 1;
+`
+
+func parse(t *testing.T) *dbc.Database {
+	t.Helper()
+	db, err := dbc.Parse("test.dbc", []byte(testDBC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// roundTrip encodes values into data, decodes the frame and compares the values.
+func roundTrip(t *testing.T, m *dbc.Message, values map[string]float64, data [8]byte) {
+	t.Helper()
+	msg, err := m.Encode(values)
+	if err != nil {
+		t.Fatalf("%s: Encode: %v", m.Name, err)
+	}
+	if msg.ID != m.ID || msg.Ext != m.Extended || msg.Len != 8 || msg.Data != data {
+		t.Errorf("%s: Encode = %X ext %v % X, want % X", m.Name, msg.ID, msg.Ext, msg.Data, data)
+	}
+	decoded, err := m.DecodeMap(msg)
+	if err != nil {
+		t.Fatalf("%s: Decode: %v", m.Name, err)
+	}
+	for name, want := range values {
+		if got, ok := decoded[name]; !ok || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: decoded %s = %g, want %g", m.Name, name, got, want)
+		}
+	}
+}
+
+func TestIntel(t *testing.T) {
+	m := parse(t).Message(0x100, false)
+	if nil == m {
+		t.Fatal("no message 100h")
+	}
+	// Speed 10050 = 2742h, Temp -40 = D8h, Gear 5, Torque float 1.5 = 3FC00000h
+	roundTrip(t, m, map[string]float64{"Speed": 100.5, "Temp": -40, "Gear": 5, "Torque": 1.5},
+		[8]byte{0x42, 0x27, 0xD8, 0x05, 0x00, 0x00, 0xC0, 0x3F})
+	// missing signals get their start value
+	roundTrip(t, m, map[string]float64{"Torque": -0.25},
+		[8]byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x80, 0xBE})
+	if s := m.Signal("Torque"); !s.Float || !s.Signed {
+		t.Errorf("Torque %+v", s)
+	}
+}
+
+func TestMotorola(t *testing.T) {
+	db := parse(t)
+	m := db.Message(0x0CF00400, true)
+	if nil == m {
+		t.Fatal("no extended message CF00400h")
+	}
+	// Rpm 8000 = 1F40h, Trim -20 = FECh in 12 bits, Flags 101101b across bytes 3 and 4
+	roundTrip(t, m, map[string]float64{"Rpm": 1000, "Trim": -20, "Flags": 0x2D},
+		[8]byte{0x1F, 0x40, 0xFE, 0xCB, 0x40})
+	roundTrip(t, m, map[string]float64{"Rpm": 8031.875, "Trim": 1013.5, "Flags": 63},
+		[8]byte{0xFA, 0xFF, 0x7F, 0xFF, 0xC0})
+}
+
+func TestMultiplexed(t *testing.T) {
+	db := parse(t)
+	m := db.MessageByName("Diag")
+	if nil == m || m.Multiplexer() != m.Signal("Page") {
+		t.Fatal("no multiplexed message Diag")
+	}
+	roundTrip(t, m, map[string]float64{"Page": 1, "Volt": 12.5}, [8]byte{0x01, 0xD4, 0x30})
+	roundTrip(t, m, map[string]float64{"Page": 2, "Count": 0x01020304}, [8]byte{0x02, 0x04, 0x03, 0x02, 0x01})
+
+	// only the signals of the multiplexer value are decoded
+	_, values, err := db.Decode(candev.Message{ID: 0x200, Len: 8, Data: [8]byte{0x02, 0x04, 0x03, 0x02, 0x01}})
+	if err != nil || len(values) != 2 || values[1].Signal.Name != "Count" || values[1].Raw != 0x01020304 {
+		t.Errorf("Decode = %v, %v", values, err)
+	}
+	if values[0].String() != "Page=counter" {
+		t.Errorf("multiplexer value %s", values[0])
+	}
+	if _, err := m.Encode(map[string]float64{"Page": 1, "Count": 1}); nil == err {
+		t.Error("signal of another multiplexer value encoded")
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	db := parse(t)
+	tests := []struct {
+		message string
+		values  map[string]float64
+	}{
+		{"Engine", map[string]float64{"Speed": 655.36}},      // above the range
+		{"Engine", map[string]float64{"Temp": -41}},          // below the range
+		{"Body", map[string]float64{"Trim": -1034.5}},        // below the range
+		{"Diag", map[string]float64{"Page": 256}},            // no range, does not fit into 8 bits
+		{"Diag", map[string]float64{"Page": 2, "Count": -1}}, // negative unsigned
+		{"Engine", map[string]float64{"Pressure": 1}},        // no such signal
+	}
+	for _, tt := range tests {
+		if msg, err := db.EncodeByName(tt.message, tt.values); nil == err {
+			t.Errorf("%s %v encoded to % X", tt.message, tt.values, msg.Data)
+		}
+	}
+
+	s := db.MessageByName("Body").Signal("Trim")
+	if _, err := s.FromPhysical(-1034); err != nil {
+		t.Errorf("Trim -1034: %v", err)
+	}
+	if _, err := db.EncodeByName("Gearbox", nil); nil == err {
+		t.Error("unknown message encoded")
+	}
+	if _, _, err := db.Decode(candev.Message{ID: 0x100, Len: 4}); nil == err {
+		t.Error("short message decoded")
+	}
+	if _, _, err := db.Decode(candev.Message{ID: 0x100, Ext: true, Len: 8}); nil == err {
+		t.Error("unknown extended message decoded")
+	}
+}
@@ -0,0 +1,240 @@
+package dbc
+
+import (
+	"fmt"
+	"math"
+
+	"go.einride.tech/can/pkg/dbc"
+)
+
+// Signal is a signal description.
+type Signal struct {
+	Name          string
+	StartBit      uint8 // LSB for Intel, MSB for Motorola byte order (saw-tooth numbering)
+	Length        uint8
+	BigEndian     bool // Motorola byte order
+	Signed        bool
+	Float         bool // IEEE float (32 bits) or double (64 bits), see SIG_VALTYPE_
+	Factor        float64
+	Offset        float64
+	Min           float64
+	Max           float64
+	Unit          string
+	Receivers     []string
+	Values        map[int64]string // value descriptions of raw values
+	IsMultiplexer bool
+	IsMultiplexed bool
+	MuxValue      uint64 // multiplexer value of a multiplexed signal
+	Comment       string
+	Attributes    map[string]interface{}
+}
+
+func newSignal(d *dbc.SignalDef, msgLength uint8) (s *Signal, err error) {
+	if 0 == d.Size || d.Size > 64 {
+		err = fmt.Errorf("dbc: %v: signal %s has wrong size %d", d.Pos, d.Name, d.Size)
+		return
+	}
+	if d.StartBit >= 64 {
+		err = fmt.Errorf("dbc: %v: signal %s has wrong start bit %d", d.Pos, d.Name, d.StartBit)
+		return
+	}
+
+	s = &Signal{
+		Name:          string(d.Name),
+		StartBit:      uint8(d.StartBit),
+		Length:        uint8(d.Size),
+		BigEndian:     d.IsBigEndian,
+		Signed:        d.IsSigned,
+		Factor:        d.Factor,
+		Offset:        d.Offset,
+		Min:           d.Minimum,
+		Max:           d.Maximum,
+		Unit:          d.Unit,
+		IsMultiplexer: d.IsMultiplexerSwitch,
+		IsMultiplexed: d.IsMultiplexed,
+		MuxValue:      d.MultiplexerSwitch,
+		Attributes:    make(map[string]interface{}),
+	}
+	for _, r := range d.Receivers {
+		s.Receivers = append(s.Receivers, string(r))
+	}
+	if 0 == s.Factor {
+		s.Factor = 1
+	}
+
+	lsb, msb := s.bitRange()
+	if lsb < 0 || msb >= 8*int(msgLength) {
+		err = fmt.Errorf("dbc: %v: signal %s does not fit into %d bytes", d.Pos, d.Name, msgLength)
+		s = nil
+	}
+	return
+}
+
+// bitRange returns signal position in the packed value of its byte order.
+func (s *Signal) bitRange() (lsb, msb int) {
+	if !s.BigEndian {
+		lsb = int(s.StartBit)
+		msb = lsb + int(s.Length) - 1
+		return
+	}
+	msb = int(invertEndian(s.StartBit))
+	lsb = msb - int(s.Length) + 1
+	// the range is checked against the message length in Intel byte numbering
+	msb, lsb = 63-lsb, 63-msb
+	return
+}
+
+// invertEndian converts between Motorola saw-tooth bit numbering and
+// bit index in the big-endian packed value.
+func invertEndian(i uint8) uint8 {
+	return (7-i/8)*8 + i%8
+}
+
+func packLittleEndian(data *[8]byte) (packed uint64) {
+	for i := uint(0); i < 8; i++ {
+		packed |= uint64(data[i]) << (8 * i)
+	}
+	return
+}
+
+func packBigEndian(data *[8]byte) (packed uint64) {
+	for i := uint(0); i < 8; i++ {
+		packed |= uint64(data[i]) << (8 * (7 - i))
+	}
+	return
+}
+
+func unpackLittleEndian(data *[8]byte, packed uint64) {
+	for i := uint(0); i < 8; i++ {
+		data[i] = byte(packed >> (8 * i))
+	}
+}
+
+func unpackBigEndian(data *[8]byte, packed uint64) {
+	for i := uint(0); i < 8; i++ {
+		data[i] = byte(packed >> (8 * (7 - i)))
+	}
+}
+
+func (s *Signal) mask() uint64 {
+	if s.Length >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << uint(s.Length)) - 1
+}
+
+func (s *Signal) shift() uint {
+	if !s.BigEndian {
+		return uint(s.StartBit)
+	}
+	return uint(int(invertEndian(s.StartBit)) - int(s.Length) + 1)
+}
+
+// Raw extracts raw (unscaled) signal bits from data.
+func (s *Signal) Raw(data [8]byte) uint64 {
+	var packed uint64
+	if s.BigEndian {
+		packed = packBigEndian(&data)
+	} else {
+		packed = packLittleEndian(&data)
+	}
+	return (packed >> s.shift()) & s.mask()
+}
+
+// SetRaw puts raw signal bits into data.
+func (s *Signal) SetRaw(data *[8]byte, raw uint64) {
+	var packed uint64
+	if s.BigEndian {
+		packed = packBigEndian(data)
+	} else {
+		packed = packLittleEndian(data)
+	}
+	packed &^= s.mask() << s.shift()
+	packed |= (raw & s.mask()) << s.shift()
+	if s.BigEndian {
+		unpackBigEndian(data, packed)
+	} else {
+		unpackLittleEndian(data, packed)
+	}
+}
+
+// ToPhysical converts raw bits to physical value.
+func (s *Signal) ToPhysical(raw uint64) float64 {
+	var value float64
+	switch {
+	case s.Float && 32 == s.Length:
+		value = float64(math.Float32frombits(uint32(raw)))
+	case s.Float:
+		value = math.Float64frombits(raw)
+	case s.Signed:
+		value = float64(s.signExtend(raw))
+	default:
+		value = float64(raw)
+	}
+	return value*s.Factor + s.Offset
+}
+
+// FromPhysical converts physical value to raw bits.
+// Error is returned if the value is out of the signal range.
+func (s *Signal) FromPhysical(value float64) (raw uint64, err error) {
+	if s.Min < s.Max && (value < s.Min || value > s.Max) {
+		err = fmt.Errorf("dbc: signal %s value %g is out of range [%g..%g]", s.Name, value, s.Min, s.Max)
+		return
+	}
+
+	scaled := (value - s.Offset) / s.Factor
+	switch {
+	case s.Float && 32 == s.Length:
+		raw = uint64(math.Float32bits(float32(scaled)))
+		return
+	case s.Float:
+		raw = math.Float64bits(scaled)
+		return
+	}
+
+	rounded := math.Round(scaled)
+	if s.Signed {
+		limit := math.Ldexp(1, int(s.Length)-1)
+		if rounded < -limit || rounded > limit-1 {
+			err = fmt.Errorf("dbc: signal %s value %g does not fit into %d bits", s.Name, value, s.Length)
+			return
+		}
+		raw = uint64(int64(rounded)) & s.mask()
+		return
+	}
+	if rounded < 0 || rounded > math.Ldexp(1, int(s.Length))-1 {
+		err = fmt.Errorf("dbc: signal %s value %g does not fit into %d bits", s.Name, value, s.Length)
+		return
+	}
+	raw = uint64(rounded)
+	return
+}
+
+func (s *Signal) signExtend(raw uint64) int64 {
+	if s.Length >= 64 {
+		return int64(raw)
+	}
+	shift := uint(64 - s.Length)
+	return int64(raw<<shift) >> shift
+}
+
+// Description returns the value description of a raw value.
+func (s *Signal) Description(raw uint64) (text string, ok bool) {
+	key := int64(raw)
+	if s.Signed && !s.Float {
+		key = s.signExtend(raw)
+	}
+	text, ok = s.Values[key]
+	return
+}
+
+// StartValue returns raw initial value of the signal (GenSigStartValue attribute) or 0.
+func (s *Signal) StartValue() uint64 {
+	switch v := s.Attributes["GenSigStartValue"].(type) {
+	case int64:
+		return uint64(v) & s.mask()
+	case float64:
+		return uint64(int64(v)) & s.mask()
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+// Package e2e reads and writes end-to-end protection fields of CAN frames:
+// alive counters and checksums at configurable positions of the data.
+//
+// The watchdog package checks them in received frames, the restbus package
+// maintains them in simulated ones:
+//
+//	c := e2e.Counter{Byte: 6, Bits: 4}
+//	s := e2e.Checksum{Byte: 7, Func: e2e.CRC8SAEJ1850}
+//	c.Set(data, c.Next(c.Get(data)))
+//	s.Set(id, data)
+package e2e
+
+// Counter is an alive counter of Bits bits (1..8, 4 if 0) starting at bit Shift of data byte Byte.
+type Counter struct {
+	Byte  int
+	Shift uint
+	Bits  uint
+}
+
+func (c Counter) mask() uint8 {
+	bits := c.Bits
+	if 0 == bits {
+		bits = 4
+	}
+	if bits >= 8 {
+		return 0xFF
+	}
+	return uint8(1)<<bits - 1
+}
+
+// Inside reports whether the counter byte is inside data.
+func (c Counter) Inside(data []byte) bool {
+	return c.Byte >= 0 && c.Byte < len(data)
+}
+
+// Get returns the counter value, 0 if it is outside data.
+func (c Counter) Get(data []byte) uint8 {
+	if !c.Inside(data) {
+		return 0
+	}
+	return data[c.Byte] >> c.Shift & c.mask()
+}
+
+// Set stores v in the counter bits, keeping the other bits of the byte.
+func (c Counter) Set(data []byte, v uint8) {
+	if !c.Inside(data) {
+		return
+	}
+	m := c.mask() << c.Shift
+	data[c.Byte] = data[c.Byte]&^m | v<<c.Shift&m
+}
+
+// Next returns the value following v, wrapping around at the counter width.
+func (c Counter) Next(v uint8) uint8 {
+	return (v + 1) & c.mask()
+}
+
+// ChecksumFunc computes a checksum over the data of frame id, without the checksum byte.
+type ChecksumFunc func(id uint32, data []byte) uint8
+
+// Checksum is a checksum byte at data byte Byte computed by Func over the other bytes.
+type Checksum struct {
+	Byte int
+	Func ChecksumFunc
+}
+
+// Compute returns the checksum of data, which must contain the checksum byte.
+func (c Checksum) Compute(id uint32, data []byte) uint8 {
+	if c.Byte < 0 || c.Byte >= len(data) || nil == c.Func {
+		return 0
+	}
+	rest := make([]byte, 0, len(data)-1)
+	rest = append(rest, data[:c.Byte]...)
+	rest = append(rest, data[c.Byte+1:]...)
+	return c.Func(id, rest)
+}
+
+// Set stores the checksum of data in its checksum byte.
+func (c Checksum) Set(id uint32, data []byte) {
+	if c.Byte >= 0 && c.Byte < len(data) {
+		data[c.Byte] = c.Compute(id, data)
+	}
+}
+
+// Verify reports whether the checksum byte of data is correct,
+// it is false if the byte is outside data.
+func (c Checksum) Verify(id uint32, data []byte) bool {
+	return c.Byte >= 0 && c.Byte < len(data) && data[c.Byte] == c.Compute(id, data)
+}
+
+// Sum8 is the sum of the bytes modulo 256.
+func Sum8(id uint32, data []byte) (sum uint8) {
+	for _, b := range data {
+		sum += b
+	}
+	return
+}
+
+// XOR8 is the exclusive or of the bytes.
+func XOR8(id uint32, data []byte) (x uint8) {
+	for _, b := range data {
+		x ^= b
+	}
+	return
+}
+
+// CRC8SAEJ1850 is the SAE J1850 CRC-8 (polynomial 0x1D, initial value and final XOR 0xFF)
+// used by AUTOSAR E2E profiles, without a data ID.
+func CRC8SAEJ1850(id uint32, data []byte) uint8 {
+	crc := uint8(0xFF)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x1D
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc ^ 0xFF
+}
@@ -0,0 +1,399 @@
+// Package gateway forwards frames between two CAN buses by rules.
+//
+// A typical use bridges the vehicle and the tool side through two channels:
+//
+//	gw := gateway.New(vehicle, tool,
+//		gateway.Rule{Name: "diag", ID: 0x7E0, Mask: 0x7F0, Direction: gateway.Both},
+//		gateway.Rule{Name: "speed", ID: 0x123, Mask: 0x7FF, Direction: gateway.AtoB, MaxRate: 10},
+//		gateway.Rule{Name: "no nm", ID: 0x500, Mask: 0x700, Block: true},
+//	)
+//	gw.Start()
+//	defer gw.Stop()
+package gateway
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Bus is a side of the gateway: *candev.Device or *candev.Loopback.
+type Bus interface {
+	Send(msg candev.Message) error
+	GetMsgChannelCopy() (<-chan candev.Message, uint)
+	CloseMsgChannelCopy(idx uint)
+}
+
+// Direction selects the flow a rule applies to.
+type Direction int
+
+// Directions of rules.
+const (
+	Both Direction = iota // frames received on A or B
+	AtoB                  // frames received on A
+	BtoA                  // frames received on B
+)
+
+// String returns "both", "a->b" or "b->a".
+func (d Direction) String() string {
+	switch d {
+	case AtoB:
+		return "a->b"
+	case BtoA:
+		return "b->a"
+	}
+	return "both"
+}
+
+// ByteRewrite replaces bits selected by Mask of data byte Index with bits of Value.
+type ByteRewrite struct {
+	Index int
+	Mask  byte
+	Value byte
+}
+
+// Rule selects frames with ID&Mask == Rule.ID&Mask and tells what to do with them.
+// Rules are checked in order, the first matching rule applies.
+type Rule struct {
+	Name      string
+	ID        uint32
+	Mask      uint32
+	Direction Direction
+
+	// Block drops matching frames.
+	Block bool
+
+	// MapID replaces bits of the identifier selected by MapMask:
+	// id = id&^MapMask | MapID&MapMask. Zero MapMask keeps the identifier.
+	MapID   uint32
+	MapMask uint32
+	// Ext forces 29-bit format of forwarded frames, e.g. after remapping to a large identifier.
+	Ext bool
+
+	// Rewrites change payload bytes, bytes beyond the frame length are not touched.
+	Rewrites []ByteRewrite
+
+	// Transform is called last for custom changes, false drops the frame.
+	Transform func(msg *candev.Message) bool
+
+	// MaxRate limits forwarded frames per second (0 - no limit),
+	// Burst frames may pass at once (1 if zero). Frames over the limit are dropped.
+	MaxRate float64
+	Burst   int
+}
+
+// Match reports whether the rule applies to msg received on the side from.
+func (r *Rule) Match(msg candev.Message, from Direction) bool {
+	if r.Direction != Both && r.Direction != from {
+		return false
+	}
+	return msg.ID&r.Mask == r.ID&r.Mask
+}
+
+// apply changes msg by the rule, false drops it.
+func (r *Rule) apply(msg *candev.Message) bool {
+	if r.MapMask != 0 {
+		msg.ID = msg.ID&^r.MapMask | r.MapID&r.MapMask
+	}
+	if r.Ext {
+		msg.Ext = true
+	}
+	for _, rw := range r.Rewrites {
+		if rw.Index >= 0 && rw.Index < int(msg.Len) && rw.Index < len(msg.Data) {
+			msg.Data[rw.Index] = msg.Data[rw.Index]&^rw.Mask | rw.Value&rw.Mask
+		}
+	}
+	if r.Transform != nil {
+		return r.Transform(msg)
+	}
+	return true
+}
+
+// Counters are frame counters of a rule.
+type Counters struct {
+	Matched     uint64 // frames matching the rule
+	Forwarded   uint64 // frames sent to the other side
+	Blocked     uint64 // frames dropped by Block or Transform
+	RateLimited uint64 // frames dropped by MaxRate
+	Errors      uint64 // send errors
+}
+
+// ruleState holds counters and the token bucket of a rule.
+type ruleState struct {
+	counters Counters // first for 64-bit alignment of atomic counters
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token of the rate limit bucket.
+func (s *ruleState) allow(r *Rule, now time.Time) bool {
+	if r.MaxRate <= 0 {
+		return true
+	}
+	burst := float64(r.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last.IsZero() {
+		s.tokens = burst
+	} else {
+		s.tokens += now.Sub(s.last).Seconds() * r.MaxRate
+		if s.tokens > burst {
+			s.tokens = burst
+		}
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (s *ruleState) snapshot() Counters {
+	return Counters{
+		Matched:     atomic.LoadUint64(&s.counters.Matched),
+		Forwarded:   atomic.LoadUint64(&s.counters.Forwarded),
+		Blocked:     atomic.LoadUint64(&s.counters.Blocked),
+		RateLimited: atomic.LoadUint64(&s.counters.RateLimited),
+		Errors:      atomic.LoadUint64(&s.counters.Errors),
+	}
+}
+
+// loopWindow is how long a forwarded frame is expected to come back as an echo.
+const loopWindow = 500 * time.Millisecond
+
+// frameKey identifies a frame for loop detection.
+type frameKey struct {
+	id   uint32
+	rtr  bool
+	len  uint8
+	data [8]byte
+}
+
+func keyOf(msg candev.Message) frameKey {
+	k := frameKey{id: msg.ID, rtr: msg.Rtr, len: msg.Len}
+	copy(k.data[:msg.Len], msg.Data[:msg.Len])
+	return k
+}
+
+// echoes remembers frames sent to a side to ignore them when they are received back
+// from the same side (bus echo, a second gateway or a loop through other equipment).
+type echoes struct {
+	mu   sync.Mutex
+	sent map[frameKey][]time.Time
+}
+
+func (e *echoes) add(k frameKey, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if nil == e.sent {
+		e.sent = make(map[frameKey][]time.Time)
+	}
+	e.sent[k] = append(e.sent[k], now)
+}
+
+// take reports whether k was sent recently and forgets one sending.
+func (e *echoes) take(k frameKey, now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	times := e.sent[k]
+	for len(times) > 0 && now.Sub(times[0]) > loopWindow {
+		times = times[1:]
+	}
+	if 0 == len(times) {
+		delete(e.sent, k)
+		return false
+	}
+	if 1 == len(times) {
+		delete(e.sent, k)
+	} else {
+		e.sent[k] = times[1:]
+	}
+	return true
+}
+
+// expire forgets old sendings, so the map does not grow with frames that never come back.
+func (e *echoes) expire(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for k, times := range e.sent {
+		for len(times) > 0 && now.Sub(times[0]) > loopWindow {
+			times = times[1:]
+		}
+		if 0 == len(times) {
+			delete(e.sent, k)
+		} else {
+			e.sent[k] = times
+		}
+	}
+}
+
+// Gateway forwards frames between buses A and B.
+type Gateway struct {
+	loops uint64 // first for 64-bit alignment of the atomic counter
+
+	A, B Bus
+
+	// DefaultForward forwards frames matching no rule unchanged, otherwise they are dropped.
+	DefaultForward bool
+	// NoLoopPrevention disables ignoring of frames received back on the side the gateway sent them to.
+	NoLoopPrevention bool
+	// OnError is called on send errors.
+	OnError func(to Direction, msg candev.Message, err error)
+	// Logger receives send errors, ixxatvci3.DefaultLogger if nil.
+	Logger ixxatvci3.Logger
+
+	rules        []Rule
+	states       []*ruleState
+	defaultState *ruleState
+
+	echoA, echoB echoes
+
+	mu      sync.Mutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+	running bool
+}
+
+// New creates a gateway between a and b with rules.
+func New(a, b Bus, rules ...Rule) *Gateway {
+	g := &Gateway{A: a, B: b, defaultState: &ruleState{}}
+	for _, r := range rules {
+		g.rules = append(g.rules, r)
+		g.states = append(g.states, &ruleState{})
+	}
+	return g
+}
+
+// Rules returns the rules of the gateway.
+func (g *Gateway) Rules() []Rule {
+	return append([]Rule(nil), g.rules...)
+}
+
+// Start starts forwarding in both directions.
+func (g *Gateway) Start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running {
+		return
+	}
+	g.running = true
+	g.done = make(chan struct{})
+	g.wg.Add(2)
+	chA, idxA := g.A.GetMsgChannelCopy()
+	chB, idxB := g.B.GetMsgChannelCopy()
+	go g.forward(chA, idxA, g.A, g.B, AtoB, &g.echoA, &g.echoB)
+	go g.forward(chB, idxB, g.B, g.A, BtoA, &g.echoB, &g.echoA)
+}
+
+// Stop stops forwarding.
+func (g *Gateway) Stop() {
+	g.mu.Lock()
+	if !g.running {
+		g.mu.Unlock()
+		return
+	}
+	g.running = false
+	close(g.done)
+	g.mu.Unlock()
+	g.wg.Wait()
+}
+
+// forward reads frames from ch of src, fromEcho holds frames sent to src, toEcho - to dst.
+func (g *Gateway) forward(ch <-chan candev.Message, idx uint, src, dst Bus, from Direction, fromEcho, toEcho *echoes) {
+	defer g.wg.Done()
+	defer src.CloseMsgChannelCopy(idx)
+	ticker := time.NewTicker(loopWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case now := <-ticker.C:
+			fromEcho.expire(now)
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			g.process(msg, from, dst, fromEcho, toEcho)
+		}
+	}
+}
+
+// process handles msg received from side from and sends it to dst if rules allow.
+func (g *Gateway) process(msg candev.Message, from Direction, dst Bus, fromEcho, toEcho *echoes) {
+	now := time.Now()
+	if !g.NoLoopPrevention && fromEcho.take(keyOf(msg), now) {
+		atomic.AddUint64(&g.loops, 1)
+		return
+	}
+
+	rule, state := (*Rule)(nil), g.defaultState
+	for i := range g.rules {
+		if g.rules[i].Match(msg, from) {
+			rule, state = &g.rules[i], g.states[i]
+			break
+		}
+	}
+	atomic.AddUint64(&state.counters.Matched, 1)
+	if nil == rule {
+		if !g.DefaultForward {
+			atomic.AddUint64(&state.counters.Blocked, 1)
+			return
+		}
+	} else {
+		if rule.Block || !rule.apply(&msg) {
+			atomic.AddUint64(&state.counters.Blocked, 1)
+			return
+		}
+		if !state.allow(rule, now) {
+			atomic.AddUint64(&state.counters.RateLimited, 1)
+			return
+		}
+	}
+
+	if !g.NoLoopPrevention {
+		toEcho.add(keyOf(msg), now)
+	}
+	msg.Time = time.Time{}
+	if err := dst.Send(msg); err != nil {
+		atomic.AddUint64(&state.counters.Errors, 1)
+		to := BtoA
+		if BtoA == from {
+			to = AtoB
+		}
+		if g.OnError != nil {
+			g.OnError(to, msg, err)
+		}
+		l := g.Logger
+		if nil == l {
+			l = ixxatvci3.DefaultLogger()
+		}
+		l.Warn("gateway send failed", "op", "forward", "direction", to.String(), "id", msg.ID, "error", err)
+		return
+	}
+	atomic.AddUint64(&state.counters.Forwarded, 1)
+}
+
+// Counters returns counters of rule i.
+func (g *Gateway) Counters(i int) Counters {
+	return g.states[i].snapshot()
+}
+
+// DefaultCounters returns counters of frames matching no rule.
+func (g *Gateway) DefaultCounters() Counters {
+	return g.defaultState.snapshot()
+}
+
+// LoopsPrevented returns the number of frames ignored as echoes of forwarded ones.
+func (g *Gateway) LoopsPrevented() uint64 {
+	return atomic.LoadUint64(&g.loops)
+}
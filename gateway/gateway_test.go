@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+// device opens a device on a new cantest backend.
+func device(t *testing.T, number uint8) (*cantest.Backend, *candev.Device) {
+	b := cantest.New(nil)
+	dev, err := b.Builder().Number(number).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Run()
+	return b, dev
+}
+
+// TestFrameFormat forwards a 29-bit frame with an identifier below 0x800 between two devices,
+// it must not become an 11-bit frame.
+func TestFrameFormat(t *testing.T) {
+	busA, devA := device(t, 205)
+	defer devA.Stop()
+	busB, devB := device(t, 206)
+	defer devB.Stop()
+
+	gw := New(devA, devB)
+	gw.DefaultForward = true
+	gw.Start()
+	defer gw.Stop()
+
+	want := []candev.Message{
+		{ID: 0x123, Ext: true, Len: 1, Data: [8]byte{1}},
+		{ID: 0x123, Len: 1, Data: [8]byte{2}},
+		{ID: 0x18DAF110, Ext: true, Len: 1, Data: [8]byte{3}},
+	}
+	for _, msg := range want {
+		busA.Inject(0, msg)
+	}
+	for deadline := time.Now().Add(time.Second); len(busB.Sent()) < len(want); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+	busB.ExpectSent(t, want...)
+}
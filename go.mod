@@ -4,5 +4,5 @@ go 1.12
 
 require (
 	go.einride.tech/can v0.2.2
-	golang.org/x/sys v0.0.0-20200728102440-3e129f6d46b1 // indirect
+	golang.org/x/sys v0.0.0-20200728102440-3e129f6d46b1
 )
@@ -0,0 +1,401 @@
+// Package isotp implements ISO 15765-2 (ISO-TP) transport protocol over
+// classic CAN frames of candev.Device: single, first, consecutive and
+// flow control frames with block size and separation time handling.
+package isotp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Protocol control information types.
+const (
+	pciSingle      = 0x00
+	pciFirst       = 0x10
+	pciConsecutive = 0x20
+	pciFlowControl = 0x30
+	pciTypeMask    = 0xF0
+
+	fcContinue = 0x00
+	fcWait     = 0x01
+	fcOverflow = 0x02
+
+	maxSingleLen = 7
+	firstDataLen = 6
+	consDataLen  = 7
+)
+
+// MaxPayload is the maximum payload length of classic CAN ISO-TP.
+const MaxPayload = 4095
+
+// DefaultTimeout is a default N_Bs/N_Cr timeout.
+const DefaultTimeout = time.Second
+
+// maxWait is a maximum number of consecutive FC.WAIT frames accepted.
+const maxWait = 10
+
+// Bus is the part of candev.Device used by Link.
+type Bus interface {
+	Send(msg candev.Message) (err error)
+	GetMsgChannelCopy() (ch <-chan candev.Message, idx uint)
+	CloseMsgChannelCopy(idx uint)
+}
+
+// Errors.
+var (
+	ErrTimeout  = errors.New("isotp: timeout")
+	ErrOverflow = errors.New("isotp: receiver buffer overflow")
+	ErrClosed   = errors.New("isotp: link is closed")
+)
+
+// Addr is a pair of CAN identifiers of a peer.
+type Addr struct {
+	TxID uint32 // identifier used to send to the peer (data and flow control)
+	RxID uint32 // identifier the peer sends with
+	Ext  bool   // 29-bit identifiers
+}
+
+// Resolver returns the peer address for a received identifier.
+// Frames with ok == false are ignored.
+type Resolver func(rxID uint32) (addr Addr, ok bool)
+
+// Fixed returns Resolver for a fixed list of peers.
+func Fixed(addrs ...Addr) Resolver {
+	return func(rxID uint32) (addr Addr, ok bool) {
+		for _, a := range addrs {
+			if a.RxID == rxID {
+				return a, true
+			}
+		}
+		return
+	}
+}
+
+// Link is an ISO-TP endpoint on a CAN bus. It may talk to several peers,
+// receiving segmented messages from them in parallel.
+// Only one Send or Receive call runs at a time.
+type Link struct {
+	Timeout   time.Duration // N_Bs/N_Cr timeout, DefaultTimeout if 0
+	BlockSize uint8         // block size requested in flow control, 0 - no limit
+	STmin     uint8         // separation time requested in flow control (ISO-TP encoding)
+	Padding   bool          // pad transmitted frames to 8 bytes
+	PadByte   byte
+
+	bus     Bus
+	resolve Resolver
+	rx      <-chan candev.Message
+	rxIdx   uint
+	frames  chan received
+	stop    chan struct{}
+	closing sync.Once
+
+	op      sync.Mutex
+	partial map[uint32]*reassembly
+	done    []Payload
+}
+
+// Payload is a complete received message.
+type Payload struct {
+	From Addr
+	Data []byte
+}
+
+type received struct {
+	addr Addr
+	msg  candev.Message
+}
+
+type reassembly struct {
+	data     []byte
+	size     int
+	sn       byte
+	blockCnt uint8
+	last     time.Time
+}
+
+// frameQueueSize is a number of received frames buffered by Link.
+const frameQueueSize = 1024
+
+// NewLink creates ISO-TP endpoint. Frames are received from the bus until Close.
+func NewLink(bus Bus, resolve Resolver) *Link {
+	l := &Link{
+		bus:     bus,
+		resolve: resolve,
+		frames:  make(chan received, frameQueueSize),
+		stop:    make(chan struct{}),
+		partial: make(map[uint32]*reassembly),
+		PadByte: 0xCC,
+	}
+	l.rx, l.rxIdx = bus.GetMsgChannelCopy()
+	go l.receiverThread()
+	return l
+}
+
+// Close stops receiving.
+func (l *Link) Close() {
+	if nil == l {
+		return
+	}
+	l.closing.Do(func() {
+		close(l.stop)
+		l.bus.CloseMsgChannelCopy(l.rxIdx)
+	})
+}
+
+func (l *Link) receiverThread() {
+	for {
+		select {
+		case <-l.stop:
+			return
+		case msg, ok := <-l.rx:
+			if !ok {
+				return
+			}
+			if msg.Rtr || 0 == msg.Len {
+				continue
+			}
+			addr, ok := l.resolve(msg.ID)
+			if !ok {
+				continue
+			}
+			select {
+			case l.frames <- received{addr: addr, msg: msg}:
+			default: // queue overflow, frame is lost
+			}
+		}
+	}
+}
+
+func (l *Link) timeout() time.Duration {
+	if 0 == l.Timeout {
+		return DefaultTimeout
+	}
+	return l.Timeout
+}
+
+func (l *Link) send(addr Addr, data []byte) error {
+	msg := candev.Message{ID: addr.TxID, Ext: addr.Ext, Len: uint8(len(data))}
+	copy(msg.Data[:], data)
+	if l.Padding {
+		for i := len(data); i < len(msg.Data); i++ {
+			msg.Data[i] = l.PadByte
+		}
+		msg.Len = uint8(len(msg.Data))
+	}
+	return l.bus.Send(msg)
+}
+
+// Flush drops received frames and incomplete messages.
+func (l *Link) Flush() {
+	l.op.Lock()
+	defer l.op.Unlock()
+	for {
+		select {
+		case <-l.frames:
+		default:
+			l.partial = make(map[uint32]*reassembly)
+			l.done = nil
+			return
+		}
+	}
+}
+
+// Send transmits payload to peer addr, waiting for flow control if it needs segmentation.
+func (l *Link) Send(addr Addr, payload []byte) (err error) {
+	if 0 == len(payload) || len(payload) > MaxPayload {
+		err = fmt.Errorf("isotp: wrong payload length %d", len(payload))
+		return
+	}
+	l.op.Lock()
+	defer l.op.Unlock()
+
+	if len(payload) <= maxSingleLen {
+		err = l.send(addr, append([]byte{pciSingle | byte(len(payload))}, payload...))
+		return
+	}
+
+	ff := []byte{pciFirst | byte(len(payload)>>8), byte(len(payload))}
+	ff = append(ff, payload[:firstDataLen]...)
+	if err = l.send(addr, ff); err != nil {
+		return
+	}
+	rest := payload[firstDataLen:]
+
+	var sn byte = 1
+	for len(rest) > 0 {
+		var bs uint8
+		var stmin time.Duration
+		if bs, stmin, err = l.waitFlowControl(addr); err != nil {
+			return
+		}
+		for sent := uint8(0); len(rest) > 0 && (0 == bs || sent < bs); sent++ {
+			n := len(rest)
+			if n > consDataLen {
+				n = consDataLen
+			}
+			cf := append([]byte{pciConsecutive | sn}, rest[:n]...)
+			if err = l.send(addr, cf); err != nil {
+				return
+			}
+			rest = rest[n:]
+			sn = (sn + 1) & 0x0F
+			if len(rest) > 0 && stmin > 0 {
+				time.Sleep(stmin)
+			}
+		}
+	}
+	return
+}
+
+func (l *Link) waitFlowControl(addr Addr) (bs uint8, stmin time.Duration, err error) {
+	timer := time.NewTimer(l.timeout())
+	defer timer.Stop()
+
+	waits := 0
+	for {
+		select {
+		case <-l.stop:
+			err = ErrClosed
+			return
+		case <-timer.C:
+			err = ErrTimeout
+			return
+		case r := <-l.frames:
+			if r.addr.RxID != addr.RxID || pciFlowControl != r.msg.Data[0]&pciTypeMask {
+				l.handle(r)
+				continue
+			}
+			if r.msg.Len < 3 {
+				continue
+			}
+			switch r.msg.Data[0] & 0x0F {
+			case fcContinue:
+				bs = r.msg.Data[1]
+				stmin = decodeSTmin(r.msg.Data[2])
+				return
+			case fcWait:
+				waits++
+				if waits > maxWait {
+					err = ErrTimeout
+					return
+				}
+				timer.Reset(l.timeout())
+			case fcOverflow:
+				err = ErrOverflow
+				return
+			}
+		}
+	}
+}
+
+// Receive waits for a complete message from any peer known to the resolver.
+func (l *Link) Receive(timeout time.Duration) (p Payload, err error) {
+	l.op.Lock()
+	defer l.op.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if len(l.done) > 0 {
+			p = l.done[0]
+			l.done = l.done[1:]
+			return
+		}
+		select {
+		case <-l.stop:
+			err = ErrClosed
+			return
+		case <-timer.C:
+			err = ErrTimeout
+			return
+		case r := <-l.frames:
+			l.handle(r)
+		}
+	}
+}
+
+// handle processes a received data frame, is called with l.op held.
+func (l *Link) handle(r received) {
+	msg := r.msg
+	id := r.addr.RxID
+	now := time.Now()
+
+	if st, ok := l.partial[id]; ok && now.Sub(st.last) > l.timeout() {
+		delete(l.partial, id) // N_Cr expired
+	}
+
+	switch msg.Data[0] & pciTypeMask {
+	case pciSingle:
+		n := int(msg.Data[0] & 0x0F)
+		if 0 == n || n > int(msg.Len)-1 {
+			return
+		}
+		delete(l.partial, id)
+		l.done = append(l.done, Payload{From: r.addr, Data: append([]byte(nil), msg.Data[1:1+n]...)})
+
+	case pciFirst:
+		if msg.Len < 8 {
+			return
+		}
+		size := int(msg.Data[0]&0x0F)<<8 | int(msg.Data[1])
+		if size <= maxSingleLen {
+			return
+		}
+		st := &reassembly{size: size, sn: 1, last: now}
+		st.data = append(st.data, msg.Data[2:8]...)
+		l.partial[id] = st
+		l.sendFlowControl(r.addr)
+
+	case pciConsecutive:
+		st, ok := l.partial[id]
+		if !ok {
+			return
+		}
+		if msg.Data[0]&0x0F != st.sn {
+			delete(l.partial, id) // wrong sequence number, message is lost
+			return
+		}
+		n := st.size - len(st.data)
+		if n > consDataLen {
+			n = consDataLen
+		}
+		if n > int(msg.Len)-1 {
+			delete(l.partial, id)
+			return
+		}
+		st.data = append(st.data, msg.Data[1:1+n]...)
+		st.sn = (st.sn + 1) & 0x0F
+		st.last = now
+		if len(st.data) == st.size {
+			delete(l.partial, id)
+			l.done = append(l.done, Payload{From: r.addr, Data: st.data})
+			return
+		}
+		if 0 != l.BlockSize {
+			st.blockCnt++
+			if st.blockCnt == l.BlockSize {
+				st.blockCnt = 0
+				l.sendFlowControl(r.addr)
+			}
+		}
+	}
+}
+
+func (l *Link) sendFlowControl(addr Addr) {
+	l.send(addr, []byte{pciFlowControl | fcContinue, l.BlockSize, l.STmin})
+}
+
+func decodeSTmin(v byte) time.Duration {
+	switch {
+	case v <= 0x7F:
+		return time.Duration(v) * time.Millisecond
+	case v >= 0xF1 && v <= 0xF9:
+		return time.Duration(v-0xF0) * 100 * time.Microsecond
+	}
+	return 0x7F * time.Millisecond // reserved values mean the maximum
+}
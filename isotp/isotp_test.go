@@ -0,0 +1,234 @@
+package isotp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+var (
+	tester = Addr{TxID: 0x7E0, RxID: 0x7E8}
+	ecu    = Addr{TxID: 0x7E8, RxID: 0x7E0}
+)
+
+// tap records the frames on the bus.
+type tap struct {
+	bus *candev.Loopback
+	ch  <-chan candev.Message
+	idx uint
+}
+
+func newTap(bus *candev.Loopback) *tap {
+	t := &tap{bus: bus}
+	t.ch, t.idx = bus.GetMsgChannelCopy()
+	return t
+}
+
+// frames returns the frames seen until the bus is quiet for 50 ms.
+func (t *tap) frames() (list []string) {
+	for {
+		select {
+		case m := <-t.ch:
+			list = append(list, fmt.Sprintf("%03X#% X", m.ID, m.Data[:m.Len]))
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func (t *tap) close() {
+	t.bus.CloseMsgChannelCopy(t.idx)
+}
+
+func payload(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return p
+}
+
+// links returns a tester and an ECU link on a loopback bus.
+func links() (bus *candev.Loopback, a, b *Link) {
+	bus = new(candev.Loopback)
+	a = NewLink(bus, Fixed(tester))
+	b = NewLink(bus, Fixed(ecu))
+	return
+}
+
+func TestSingleFrame(t *testing.T) {
+	bus, a, b := links()
+	defer a.Close()
+	defer b.Close()
+	tp := newTap(bus)
+	defer tp.close()
+
+	a.Padding = true
+	if err := a.Send(tester, []byte{0x01, 0x0C}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := b.Receive(time.Second)
+	if err != nil || !bytes.Equal(p.Data, []byte{0x01, 0x0C}) || p.From != ecu {
+		t.Errorf("Receive = %+v, %v", p, err)
+	}
+	if f := fmt.Sprint(tp.frames()); f != "[7E0#02 01 0C CC CC CC CC CC]" {
+		t.Errorf("frames %s", f)
+	}
+
+	if err = b.Send(ecu, payload(7)); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = a.Receive(time.Second); err != nil || !bytes.Equal(p.Data, payload(7)) {
+		t.Errorf("Receive = %+v, %v", p, err)
+	}
+	if f := fmt.Sprint(tp.frames()); f != "[7E8#07 00 01 02 03 04 05 06]" {
+		t.Errorf("frames %s", f)
+	}
+
+	for _, n := range []int{0, MaxPayload + 1} {
+		if err = a.Send(tester, payload(n)); nil == err {
+			t.Errorf("payload of %d bytes sent", n)
+		}
+	}
+}
+
+func TestMultiFrame(t *testing.T) {
+	bus, a, b := links()
+	defer a.Close()
+	defer b.Close()
+	tp := newTap(bus)
+	defer tp.close()
+
+	b.BlockSize = 2
+	b.STmin = 5 // ms
+	sent := make(chan error, 1)
+	var took time.Duration
+	go func() {
+		start := time.Now()
+		err := a.Send(tester, payload(30))
+		took = time.Since(start)
+		sent <- err
+	}()
+	p, err := b.Receive(time.Second)
+	if err != nil || !bytes.Equal(p.Data, payload(30)) {
+		t.Fatalf("Receive = %+v, %v", p, err)
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"7E0#10 1E 00 01 02 03 04 05",
+		"7E8#30 02 05",
+		"7E0#21 06 07 08 09 0A 0B 0C",
+		"7E0#22 0D 0E 0F 10 11 12 13",
+		"7E8#30 02 05",
+		"7E0#23 14 15 16 17 18 19 1A",
+		"7E0#24 1B 1C 1D",
+	}
+	if f := tp.frames(); fmt.Sprint(f) != fmt.Sprint(want) {
+		t.Errorf("frames\n%s\nwant\n%s", f, want)
+	}
+	if took < 15*time.Millisecond { // STmin after every but the last consecutive frame
+		t.Errorf("sent in %v, STmin is not kept", took)
+	}
+
+	// sequence numbers wrap after 0x2F, no block size limit
+	b.BlockSize, b.STmin = 0, 0
+	seen := make(chan []string)
+	go func() { seen <- tp.frames() }() // more frames than the tap buffers
+	go func() { sent <- a.Send(tester, payload(MaxPayload)) }()
+	if p, err = b.Receive(time.Second); err != nil || !bytes.Equal(p.Data, payload(MaxPayload)) {
+		t.Fatalf("Receive of %d bytes: %v", MaxPayload, err)
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if f := <-seen; len(f) != 1+1+(MaxPayload-6+6)/7 || f[17] != "7E0#20 6F 70 71 72 73 74 75" {
+		t.Errorf("%d frames, 17th %s", len(f), f[17])
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	bus, a, b := links()
+	defer a.Close()
+	defer b.Close()
+
+	// nobody answers with flow control
+	a.Timeout = 20 * time.Millisecond
+	if err := a.Send(Addr{TxID: 0x7E1, RxID: 0x7E9}, payload(20)); err != ErrTimeout {
+		t.Errorf("Send without flow control: %v", err)
+	}
+	if _, err := a.Receive(20 * time.Millisecond); err != ErrTimeout {
+		t.Errorf("Receive: %v", err)
+	}
+
+	// a consecutive frame after N_Cr is not appended to the first frame
+	b.Timeout = 20 * time.Millisecond
+	bus.Send(candev.Message{ID: 0x7E0, Len: 8, Data: [8]byte{0x10, 0x0A, 1, 2, 3, 4, 5, 6}})
+	if _, err := b.Receive(50 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("Receive of a first frame: %v", err)
+	}
+	bus.Send(candev.Message{ID: 0x7E0, Len: 5, Data: [8]byte{0x21, 7, 8, 9, 10}})
+	if p, err := b.Receive(50 * time.Millisecond); err != ErrTimeout {
+		t.Errorf("Receive after N_Cr = %+v, %v", p, err)
+	}
+
+	// flow control wait frames restart N_Bs
+	a.Flush() // flow control of b to the first frame above
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+			bus.Send(candev.Message{ID: 0x7E8, Len: 3, Data: [8]byte{0x31}})
+		}
+		bus.Send(candev.Message{ID: 0x7E8, Len: 3, Data: [8]byte{0x30}})
+	}()
+	if err := a.Send(tester, payload(20)); err != nil {
+		t.Errorf("Send with FC.WAIT: %v", err)
+	}
+	bus.Send(candev.Message{ID: 0x7E8, Len: 3, Data: [8]byte{0x32}})
+	if err := a.Send(tester, payload(20)); err != ErrOverflow {
+		t.Errorf("Send with FC.OVFLW: %v", err)
+	}
+
+	a.Close()
+	if _, err := a.Receive(time.Second); err != ErrClosed {
+		t.Errorf("Receive after Close: %v", err)
+	}
+}
+
+func TestWrongSequenceNumber(t *testing.T) {
+	bus, _, b := links()
+	defer b.Close()
+
+	bus.Send(candev.Message{ID: 0x7E0, Len: 8, Data: [8]byte{0x10, 0x0A, 1, 2, 3, 4, 5, 6}})
+	bus.Send(candev.Message{ID: 0x7E0, Len: 5, Data: [8]byte{0x22, 7, 8, 9, 10}}) // SN 1 expected
+	bus.Send(candev.Message{ID: 0x7E0, Len: 5, Data: [8]byte{0x21, 7, 8, 9, 10}})
+	bus.Send(candev.Message{ID: 0x7E0, Len: 3, Data: [8]byte{0x02, 0xAA, 0xBB}})
+	p, err := b.Receive(time.Second)
+	if err != nil || !bytes.Equal(p.Data, []byte{0xAA, 0xBB}) {
+		t.Errorf("Receive = %+v, %v, want the single frame only", p, err)
+	}
+	if p, err = b.Receive(50 * time.Millisecond); err != ErrTimeout {
+		t.Errorf("Receive = %+v, %v", p, err)
+	}
+}
+
+func TestDecodeSTmin(t *testing.T) {
+	values := map[byte]time.Duration{
+		0x00: 0,
+		0x05: 5 * time.Millisecond,
+		0x7F: 127 * time.Millisecond,
+		0xF1: 100 * time.Microsecond,
+		0xF9: 900 * time.Microsecond,
+		0x80: 127 * time.Millisecond,
+		0xFA: 127 * time.Millisecond,
+	}
+	for v, want := range values {
+		if d := decodeSTmin(v); d != want {
+			t.Errorf("decodeSTmin(0x%02X) = %v, want %v", v, d, want)
+		}
+	}
+}
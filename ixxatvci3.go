@@ -1,5 +1,5 @@
-//go:build windows
-// +build windows
+//go:build windows && cgo
+// +build windows,cgo
 
 package ixxatvci3
 
@@ -17,233 +17,186 @@ package ixxatvci3
 #cgo CFLAGS: -I"./inc"
 #cgo amd64 LDFLAGS: -L./amd64 -lvcinpl
 #cgo 386 LDFLAGS: -L./386 -lvcinpl
+#include <stdint.h>
 #include <vcinpl.h>
-#include "canvci3.h"
+
+// goHandle converts a handle kept by Go as an integer.
+static HANDLE goHandle(uintptr_t h) { return (HANDLE)h; }
 */
 import "C"
 import (
-	"bytes"
-	"fmt"
-	"strings"
-	"time"
 	"unsafe"
 )
 
-//SelectDevice USB-to-CAN device select dialog.
-//assignnumber - number to assign to the device.
-// vcierr is 0 if there are no errors.
-func SelectDevice(assignnumber uint8) (vcierr uint32) {
-	return selectDevice(true, assignnumber)
-}
+// cgoAPI calls vcinpl linked with the import libraries in amd64/ and 386/.
+// The structures are passed as the byte arrays converted by vcinpl.go.
+type cgoAPI struct{}
 
-//OpenDevice opens first USB-to-CAN device found.
-//assignnumber - number to assign to the device.
-// vcierr is 0 if there are no errors.
-func OpenDevice(assignnumber uint8) (vcierr uint32) {
-	return selectDevice(false, assignnumber)
+var vcinplAPI vciAPI = cgoAPI{}
+
+func handle(h vciHandle) C.HANDLE {
+	return C.goHandle(C.uintptr_t(h))
 }
 
-func selectDevice(userselect bool, assignnumber uint8) (vcierr uint32) {
-	var us uint8
+func fromHandle(h C.HANDLE) vciHandle {
+	return vciHandle(uintptr(unsafe.Pointer(h)))
+}
 
-	if userselect {
-		us = 1
+func cbool(b bool) C.BOOL {
+	if b {
+		return 1
 	}
-	// HRESULT CAN_VCI3_SelectDevice(UINT8 bUserSelect, UINT8 uAssignNumber);
-	ret := C.CAN_VCI3_SelectDevice(
-		C.uchar(us),
-		C.uchar(assignnumber))
-	vcierr = uint32(ret)
-	return
+	return 0
 }
 
-/*SetOperatingMode set operating mode at device with number "devnum".
-Call it after SelectDevice but before OpenChannel.
-11-bit mode is a default. Comma is a separator in opmode string.
-opmode values:
-	"11bit" or "standard" or "base",
-	"29bit" or "extended",
-	"err" or "errframe",
-	"listen" or "listenonly" or "listonly",
-	"low" or "lowspeed"
-// vcierr is 0 if there are no errors.
-*/
-func SetOperatingMode(devnum uint8, opmode string) (vcierr uint32) {
-	var setMode byte
+func (cgoAPI) EnumDeviceOpen() (hEnum vciHandle, hr uint32) {
+	var h C.HANDLE
+	hr = uint32(C.vciEnumDeviceOpen(&h))
+	return fromHandle(h), hr
+}
 
-	if strings.Contains(opmode, "11bit") || strings.Contains(opmode, "standard") || strings.Contains(opmode, "base") {
-		setMode |= opmodeSTANDARD
+func (cgoAPI) EnumDeviceNext(hEnum vciHandle) (info vciDeviceInfo, hr uint32) {
+	var b [vciDeviceInfoSize]byte
+	hr = uint32(C.vciEnumDeviceNext(handle(hEnum), (*C.VCIDEVICEINFO)(unsafe.Pointer(&b))))
+	if VCI_OK == hr {
+		info.unmarshal(&b)
 	}
-	if strings.Contains(opmode, "29bit") || strings.Contains(opmode, "extended") {
-		setMode |= opmodeEXTENDED // reception of 29-bit id messages
-	}
-	if strings.Contains(opmode, "err") {
-		setMode |= opmodeERRFRAME // enable reception of error frames
-	}
-	if strings.Contains(opmode, "list") {
-		setMode |= opmodeLISTONLY // listen only mode (TX passive)
-	}
-	if strings.Contains(opmode, "low") {
-		setMode |= opmodeLOWSPEED // use low speed bus interface
-	}
-
-	// HRESULT GOEXPORT CAN_VCI3_SetOperatingMode(UINT8 uDevNum, BYTE uCanOpMode)
-	ret := C.CAN_VCI3_SetOperatingMode(C.uchar(devnum), C.uchar(setMode))
-	vcierr = uint32(ret)
-
 	return
 }
 
-//OpenChannel opens a channel on a previously opened device with devnum number, and btr0 and btr1 speed parameters.
-//25 kbps is 0x1F 0x16.
-//125 кб/с is 0x03 0x1C.
-//vcierr is 0 if there are no errors.
-func OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
-	// HRESULT CAN_VCI3_OpenConnection(UINT8 uDevNum, UINT8 uBtr0, UINT8 uBtr1);
-	ret := C.CAN_VCI3_OpenConnection(
-		C.uchar(devnum),
-		C.uchar(btr0),
-		C.uchar(btr1))
-	vcierr = uint32(ret)
-	return
+func (cgoAPI) EnumDeviceClose(hEnum vciHandle) uint32 {
+	return uint32(C.vciEnumDeviceClose(handle(hEnum)))
 }
 
-// Send sends a data packet to device devnum.
-// msgid - Identifier.
-// rtr - Request flag, default value is false.
-// msgdata - An array of 1 to 8 bytes. If rtr = true this field is ignored.
-// vcierr is 0 if there are no errors.
-func Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
-	var pdata *C.uchar
-	var msgdatasize = len(msgdata)
-	if msgdatasize > 0 {
-		pdata = (*C.uchar)(unsafe.Pointer(&msgdata[0]))
-	}
-
-	var irtr uint8
-	if rtr {
-		irtr = 1
-	}
-	// HRESULT CAN_VCI3_TxData(UINT8 uDevNum, UINT32 uMsgId, UINT8 bRtr, BYTE * MsgData, UINT8 uMsgDataSize);
-	ret := C.CAN_VCI3_TxData(
-		C.uchar(devnum),
-		C.uint(msgid),
-		C.uchar(irtr),
-		pdata,
-		C.uchar(msgdatasize))
-	vcierr = uint32(ret)
+func (cgoAPI) SelectDeviceDlg() (id vciID, hr uint32) {
+	hr = uint32(C.vciSelectDeviceDlg(nil, (*C.VCIID)(unsafe.Pointer(&id))))
 	return
 }
 
-// Receive receives a message from a device with number "devnum".
-// You need to call this function regularly so that the hardware message buffer does not overflow.
-// Blocking call if no CAN messages are received.
-// May return: VCI_E_OK, VCI_E_TIMEOUT, VCI_E_NO_DATA, VCI_E_INVALIDARG
-func Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+func (cgoAPI) DeviceOpen(id vciID) (hDevice vciHandle, hr uint32) {
+	var h C.HANDLE
+	hr = uint32(C.vciDeviceOpen((*C.VCIID)(unsafe.Pointer(&id)), &h))
+	return fromHandle(h), hr
+}
 
-	var irtr uint8
+func (cgoAPI) DeviceClose(hDevice vciHandle) uint32 {
+	return uint32(C.vciDeviceClose(handle(hDevice)))
+}
 
-	// HRESULT CAN_VCI3_RxData(UINT8 uDevNum, UINT32 * uMsgId, UINT8 * bRtr, BYTE * MsgData, UINT8 * uMsgDataSize);
-	ret := C.CAN_VCI3_RxData(
-		C.uchar(devnum),
-		(*C.uint)(unsafe.Pointer(&msgid)),
-		(*C.uchar)(unsafe.Pointer(&irtr)),
-		(*C.uchar)(unsafe.Pointer(&msgdata[0])),
-		(*C.uchar)(unsafe.Pointer(&msgdatasize)))
-	vcierr = uint32(ret)
+func (cgoAPI) ControlOpen(hDevice vciHandle, canNo uint32) (hCtl vciHandle, hr uint32) {
+	var h C.HANDLE
+	hr = uint32(C.canControlOpen(handle(hDevice), C.uint(canNo), &h))
+	return fromHandle(h), hr
+}
 
-	rtr = bool(irtr != 0)
+func (cgoAPI) ControlClose(hCtl vciHandle) uint32 {
+	return uint32(C.canControlClose(handle(hCtl)))
+}
 
+func (cgoAPI) ControlGetStatus(hCtl vciHandle) (status CANLineStatus, hr uint32) {
+	var b [canLineStatusSize]byte
+	hr = uint32(C.canControlGetStatus(handle(hCtl), (*C.CANLINESTATUS)(unsafe.Pointer(&b))))
+	if VCI_OK == hr {
+		status = unmarshalLineStatus(b[:])
+	}
 	return
 }
 
-// GetStatus returns a structure containing various information about the connection status.
-func GetStatus(devnum uint8) (status CANChanStatus, vcierr uint32) {
-
-	// HRESULT CAN_VCI3_GetStatus(UINT8 uDevNum, PCANCHANSTATUS pCanStat)
-	ret := C.CAN_VCI3_GetStatus(C.uchar(devnum), (*C.CANCHANSTATUS)(unsafe.Pointer(&status)))
-	vcierr = uint32(ret)
-
+func (cgoAPI) ControlDetectBitrate(hCtl vciHandle, timeoutMs uint16, btr0, btr1 []byte) (index int32, hr uint32) {
+	if 0 == len(btr0) || len(btr0) != len(btr1) {
+		return -1, VCI_E_INVALIDARG
+	}
+	hr = uint32(C.canControlDetectBitrate(handle(hCtl), C.ushort(timeoutMs), C.uint(len(btr0)),
+		(*C.uchar)(unsafe.Pointer(&btr0[0])), (*C.uchar)(unsafe.Pointer(&btr1[0])),
+		(*C.int)(unsafe.Pointer(&index))))
 	return
 }
 
-//GetErrorText returns VCI error text by code
-func GetErrorText(vcierr uint32) string {
-	//void CAN_VCI3_FormatError(HRESULT hrError, PCHAR pszText, UINT32 dwSize)
-	buf := make([]C.char, vciMaxErrStrLen)
+func (cgoAPI) ControlInitialize(hCtl vciHandle, mode, btr0, btr1 uint8) uint32 {
+	return uint32(C.canControlInitialize(handle(hCtl), C.uchar(mode), C.uchar(btr0), C.uchar(btr1)))
+}
 
-	C.vciFormatError(C.long(vcierr), &buf[0], C.uint(vciMaxErrStrLen))
+func (cgoAPI) ControlReset(hCtl vciHandle) uint32 {
+	return uint32(C.canControlReset(handle(hCtl)))
+}
 
-	result := C.GoString(&buf[0])
+func (cgoAPI) ControlStart(hCtl vciHandle, start bool) uint32 {
+	return uint32(C.canControlStart(handle(hCtl), cbool(start)))
+}
 
-	return result
+func (cgoAPI) ControlSetAccFilter(hCtl vciHandle, extended bool, code, mask uint32) uint32 {
+	return uint32(C.canControlSetAccFilter(handle(hCtl), cbool(extended), C.uint(code), C.uint(mask)))
 }
 
-//CloseDevice close channel and free device with number "devnum".
-func CloseDevice(devnum uint8) (vcierr uint32) {
-	// HRESULT CAN_VCI3_CloseDevice(UINT8 uDevNum);
-	ret := C.CAN_VCI3_CloseDevice(C.uchar(devnum))
-	vcierr = uint32(ret)
-	return
+func (cgoAPI) ChannelOpen(hDevice vciHandle, canNo uint32, exclusive bool) (hChn vciHandle, hr uint32) {
+	var h C.HANDLE
+	hr = uint32(C.canChannelOpen(handle(hDevice), C.uint(canNo), cbool(exclusive), &h))
+	return fromHandle(h), hr
 }
 
-// OpenChannelDetectBitrate opens a channel on the previously opened device with devnum number, and tries to determine the bitrate in the CAN channel.
-// The bitrate is determined from the number of possible ones, specified through the bitrate array with several pairs of values for the btr0 and btr1 registers.
-// If the channel is open and the bitrate is defined, a pair of values btr0, btr1 is returned.
-func OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []BitrateRegisterPair) (detected BitrateRegisterPair, err error) {
+func (cgoAPI) ChannelClose(hChn vciHandle) uint32 {
+	return uint32(C.canChannelClose(handle(hChn)))
+}
 
-	if len(bitrate) <= 0 {
-		err = fmt.Errorf("%s", "bitrate array is empty")
-		return
+func (cgoAPI) ChannelGetCaps(hChn vciHandle) (caps canCapabilities, hr uint32) {
+	var b [canCapabilitiesSize]byte
+	hr = uint32(C.canChannelGetCaps(handle(hChn), (*C.CANCAPABILITIES)(unsafe.Pointer(&b))))
+	if VCI_OK == hr {
+		caps.unmarshal(&b)
 	}
+	return
+}
 
-	var buf0, buf1 bytes.Buffer
-
-	for _, b := range bitrate {
-		buf0.WriteByte(b.Btr0)
-		buf1.WriteByte(b.Btr1)
+func (cgoAPI) ChannelGetStatus(hChn vciHandle) (status CANChanStatus, hr uint32) {
+	var b [canChanStatusSize]byte
+	hr = uint32(C.canChannelGetStatus(handle(hChn), (*C.CANCHANSTATUS)(unsafe.Pointer(&b))))
+	if VCI_OK == hr {
+		status = unmarshalChanStatus(&b)
 	}
+	return
+}
 
-	timeoutMs := uint16(timeout / time.Millisecond)
+func (cgoAPI) ChannelInitialize(hChn vciHandle, rxSize, rxThreshold, txSize, txThreshold uint16) uint32 {
+	return uint32(C.canChannelInitialize(handle(hChn),
+		C.ushort(rxSize), C.ushort(rxThreshold), C.ushort(txSize), C.ushort(txThreshold)))
+}
 
-	vcierr, indexArray := openChannelDetectBitrate(devnum, timeoutMs, buf0.Bytes(), buf1.Bytes())
-	if C.VCI_OK != vcierr {
-		err = fmt.Errorf("%s", GetErrorText(vcierr))
-		return
-	}
+func (cgoAPI) ChannelActivate(hChn vciHandle, enable bool) uint32 {
+	return uint32(C.canChannelActivate(handle(hChn), cbool(enable)))
+}
 
-	if (indexArray < 0) || int(indexArray) >= len(bitrate) {
-		err = fmt.Errorf("%s", "wrong index of bitrate array")
-		return
+func (cgoAPI) ChannelPeekMessage(hChn vciHandle) (msg canMsg, hr uint32) {
+	var b [canMsgSize]byte
+	hr = uint32(C.canChannelPeekMessage(handle(hChn), (*C.CANMSG)(unsafe.Pointer(&b))))
+	if VCI_OK == hr {
+		msg.unmarshal(&b)
 	}
-
-	detected = bitrate[indexArray]
-
 	return
 }
 
-//see VCI canControlDetectBitrate
-func openChannelDetectBitrate(devnum uint8, timeoutMs uint16, arrayBtr0 []byte, arrayBtr1 []byte) (vcierr uint32, indexArray int32) {
-
-	len1 := len(arrayBtr0)
-	len2 := len(arrayBtr1)
-	if len1 != len2 {
-		vcierr = C.VCI_E_INVALIDARG
-		return
+func (cgoAPI) ChannelReadMessage(hChn vciHandle, timeoutMs uint32) (msg canMsg, hr uint32) {
+	var b [canMsgSize]byte
+	hr = uint32(C.canChannelReadMessage(handle(hChn), C.uint(timeoutMs), (*C.CANMSG)(unsafe.Pointer(&b))))
+	if VCI_OK == hr {
+		msg.unmarshal(&b)
 	}
+	return
+}
 
-	arrayElementCount := uint32(len1)
-
-	// HRESULT CAN_VCI3_OpenConnectionDetectBitrate(UINT8 uDevNum, UINT16 uTimeoutMs, UINT32 uArrayElementCount, BYTE * ArrayBtr0, BYTE * ArrayBtr1, INT32 * pIndexArray) {
-	ret := C.CAN_VCI3_OpenConnectionDetectBitrate(
-		C.uchar(devnum),
-		C.ushort(timeoutMs),
-		C.uint(arrayElementCount),
-		(*C.uchar)(unsafe.Pointer(&arrayBtr0[0])),
-		(*C.uchar)(unsafe.Pointer(&arrayBtr1[0])),
-		(*C.int)(unsafe.Pointer(&indexArray)))
+func (cgoAPI) ChannelPostMessage(hChn vciHandle, msg canMsg) uint32 {
+	var b [canMsgSize]byte
+	msg.marshal(&b)
+	return uint32(C.canChannelPostMessage(handle(hChn), (*C.CANMSG)(unsafe.Pointer(&b))))
+}
 
-	vcierr = uint32(ret)
+func (cgoAPI) ChannelSendMessage(hChn vciHandle, timeoutMs uint32, msg canMsg) uint32 {
+	var b [canMsgSize]byte
+	msg.marshal(&b)
+	return uint32(C.canChannelSendMessage(handle(hChn), C.uint(timeoutMs), (*C.CANMSG)(unsafe.Pointer(&b))))
+}
 
-	return
+func (cgoAPI) FormatError(hr uint32) string {
+	buf := make([]C.char, vciMaxErrStrLen)
+	C.vciFormatError(C.long(int32(hr)), &buf[0], C.uint(vciMaxErrStrLen))
+	return C.GoString(&buf[0])
 }
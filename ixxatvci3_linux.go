@@ -4,96 +4,164 @@
 package ixxatvci3
 
 import (
-	"context"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
-	"net"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"go.einride.tech/can"
 	"go.einride.tech/can/pkg/socketcan"
+	"golang.org/x/sys/unix"
 )
 
 type connectionCAN struct {
 	name string // "can0"
-	conn net.Conn
-	tr   *socketcan.Transmitter
+	conn *rawConn
 	recv *socketcan.Receiver
+	txmu sync.Mutex // write deadlines are per connection
+	fifo FifoConfig // requested buffer sizes
+	mode AccessMode
+	op   OpMode // SetOpMode modes, only listen only changes the interface
+	owns bool   // the interface is configured by OpenChannel and set down by CloseDevice
 }
 
-var devices map[uint8]*connectionCAN
+// devices are the open devices. Supervisors close and open them while other goroutines
+// send and receive, so the map is locked.
+var (
+	muDevices sync.RWMutex
+	devices   = make(map[uint8]*connectionCAN)
+)
 
-func init() {
-	devices = make(map[uint8]*connectionCAN)
-}
+var (
+	muIfnames sync.Mutex
+	ifnames   = make(map[uint8]string) // SetInterfaceName names
+)
 
-// SelectDevice USB-to-CAN device select dialog.
-// assignnumber - number to assign to the device.
-// vcierr is 0 if there are no errors.
-func SelectDevice(assignnumber uint8) (vcierr uint32) {
-	return selectDevice(true, assignnumber)
-}
+// arphrdCAN is the ARPHRD_CAN type of SocketCAN network interfaces.
+const arphrdCAN = "280"
 
-// OpenDevice opens first USB-to-CAN device found.
-// assignnumber - number to assign to the device.
-// vcierr is 0 if there are no errors.
-func OpenDevice(assignnumber uint8) (vcierr uint32) {
-	return selectDevice(false, assignnumber)
+// ListDevices returns CAN network interfaces.
+func ListDevices() (list []DeviceInfo, vcierr uint32) {
+	paths, err := filepath.Glob("/sys/class/net/*/type")
+	if err != nil {
+		return nil, VCI_E_FAIL
+	}
+	for _, p := range paths {
+		t, err := ioutil.ReadFile(p)
+		if err != nil || strings.TrimSpace(string(t)) != arphrdCAN {
+			continue
+		}
+		dir := filepath.Dir(p)
+		info := DeviceInfo{Description: filepath.Base(dir)}
+		if driver, err := os.Readlink(filepath.Join(dir, "device", "driver")); nil == err {
+			info.Manufacturer = filepath.Base(driver)
+		}
+		list = append(list, info)
+	}
+	return
 }
 
-func selectDevice(userselect bool, assignnumber uint8) (vcierr uint32) {
+// socketcanBackend is the native Linux backend, device N is the network interface canN
+// unless SetInterfaceName names another one.
+type socketcanBackend struct{}
+
+var native Backend = socketcanBackend{}
+
+// SelectDevice assigns interface "can<assignnumber>", there is no select dialog on Linux.
+func (socketcanBackend) SelectDevice(userselect bool, assignnumber uint8) (vcierr uint32) {
 	if userselect {
 		return VCI_E_NOT_IMPLEMENTED
 	}
 
-	_, ok := devices[assignnumber]
-	if ok {
+	name := socketcanBackend{}.channelName(assignnumber)
+
+	muDevices.Lock()
+	defer muDevices.Unlock()
+	if _, ok := devices[assignnumber]; ok {
 		return VCI_E_ALREADY_INITIALIZED
 	}
+	devices[assignnumber] = &connectionCAN{name: name}
 
-	var dev connectionCAN
-	dev.name = fmt.Sprintf("can%d", assignnumber)
+	return
+}
 
-	devices[assignnumber] = &dev
+// deviceOf returns the connection of device devnum, false if it is not open.
+func deviceOf(devnum uint8) (dev *connectionCAN, ok bool) {
+	muDevices.RLock()
+	defer muDevices.RUnlock()
+	dev, ok = devices[devnum]
+	return
+}
 
+// SetOpMode remembers the mode for OpenChannel, which sets listen only mode of the interface.
+// SocketCAN always receives both 11-bit and 29-bit frames.
+func (socketcanBackend) SetOpMode(devnum uint8, mode OpMode) (vcierr uint32) {
+	dev, ok := deviceOf(devnum)
+	if !ok {
+		return VCI_E_NOT_INITIALIZED
+	}
+	dev.op = mode
 	return
 }
 
-/*
-SetOperatingMode set operating mode at device with number "devnum".
-Call it after SelectDevice but before OpenChannel.
-11-bit mode is a default. Comma is a separator in opmode string.
-opmode values:
-
-	"11bit" or "standard" or "base",
-	"29bit" or "extended",
-	"err" or "errframe",
-	"listen" or "listenonly" or "listonly",
-	"low" or "lowspeed"
-
-// vcierr is 0 if there are no errors.
-*/
-func SetOperatingMode(devnum uint8, opmode string) (vcierr uint32) {
-	//NOTE: not implemented
+// SupportedOpModes returns standard, extended and listen only modes.
+func (socketcanBackend) SupportedOpModes(devnum uint8) (modes OpMode, vcierr uint32) {
+	return OpModeStandard | OpModeExtended | OpModeListenOnly, VCI_OK
+}
+
+// execCmd runs a command logging its output instead of writing it to stdout.
+func execCmd(devnum uint8, command string, args ...string) error {
+	out, err := exec.Command(command, args...).CombinedOutput()
+	cmdline := strings.Join(append([]string{command}, args...), " ")
+	fields := append(deviceFields(devnum), "op", "exec", "cmd", cmdline, "output", strings.TrimSpace(string(out)))
+	if err != nil {
+		loggerOf(devnum).Error("command failed", append(fields, "error", err)...)
+		return err
+	}
+	loggerOf(devnum).Debug("command", fields...)
+	return nil
+}
+
+// SetInterfaceName makes device devnum use network interface name instead of "can<devnum>",
+// an empty name restores it.
+func (socketcanBackend) SetInterfaceName(devnum uint8, name string) (vcierr uint32) {
+	if _, ok := deviceOf(devnum); ok {
+		return VCI_E_INVALID_STATE
+	}
+	if strings.ContainsAny(name, "/ ") || len(name) >= unix.IFNAMSIZ {
+		return VCI_E_INVALIDARG
+	}
+	muIfnames.Lock()
+	defer muIfnames.Unlock()
+	if "" == name {
+		delete(ifnames, devnum)
+	} else {
+		ifnames[devnum] = name
+	}
 	return
 }
 
-func execCmd(command string, args ...string) error {
-	cmdObj := exec.Command(command, args...)
-	cmdObj.Stdout = os.Stdout
-	cmdObj.Stderr = os.Stderr
-	return cmdObj.Run()
+// channelName returns the network interface of devnum.
+func (socketcanBackend) channelName(devnum uint8) string {
+	muIfnames.Lock()
+	defer muIfnames.Unlock()
+	if name, ok := ifnames[devnum]; ok {
+		return name
+	}
+	return fmt.Sprintf("can%d", devnum)
 }
 
-// OpenChannel opens a channel on a previously opened device with devnum number, and btr0 and btr1 speed parameters.
-// 25 kbps is 0x1F 0x16.
-// 125 кб/с is 0x03 0x1C.
-// vcierr is 0 if there are no errors.
-func OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
-	dev, ok := devices[devnum]
+// OpenChannel sets the interface bitrate with "ip link" and connects to it.
+func (socketcanBackend) OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
+	dev, ok := deviceOf(devnum)
 	if !ok {
 		return VCI_E_NOT_INITIALIZED
 	}
@@ -124,46 +192,78 @@ func OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
 		speed = "1000000"
 	}
 
-	err = execCmd("sudo", "ip", "link", "set", dev.name, "down")
-	if err != nil {
-		return VCI_E_FAIL
+	if AccessMonitor == dev.mode {
+		// the interface is configured by someone else
+		if readInterfaceValue(dev.name, "flags")&unix.IFF_UP == 0 {
+			return VCI_E_INVALID_STATE
+		}
+		if "0" != speed && interfaceBitrate(dev.name) != speed {
+			return VCI_E_BUSY
+		}
+	} else {
+		err = execCmd(devnum, "sudo", "ip", "link", "set", dev.name, "down")
+		if err != nil {
+			return VCI_E_FAIL
+		}
+		logInfo(devnum, "link restart", "op", "open channel")
+
+		args := []string{"ip", "link", "set", dev.name, "up"}
+		if dev.fifo.TxQueueLen > 0 {
+			args = append(args, "txqueuelen", strconv.Itoa(dev.fifo.TxQueueLen))
+		}
+		listenOnly := "off"
+		if dev.op&OpModeListenOnly != 0 {
+			listenOnly = "on"
+		}
+		err = execCmd(devnum, "sudo", append(args, "type", "can", "bitrate", speed, "listen-only", listenOnly)...)
+		if err != nil {
+			return VCI_E_FAIL
+		}
+		dev.owns = true
+		logInfo(devnum, "link up", "op", "open channel", "bitrate", speed)
 	}
-	log.Println("link", dev.name, "restart")
 
-	err = execCmd("sudo", "ip", "link", "set", dev.name, "up", "type", "can", "bitrate", speed)
+	dev.conn, err = dialRaw(dev.name)
 	if err != nil {
 		return VCI_E_FAIL
 	}
-	log.Println("link", dev.name, "up")
-
-	dev.conn, err = socketcan.DialContext(context.Background(), "can", dev.name)
+	if dev.fifo.SocketRcvBuf > 0 {
+		err = dev.conn.setsockoptInt(unix.SOL_SOCKET, unix.SO_RCVBUF, dev.fifo.SocketRcvBuf)
+	}
+	if nil == err && dev.fifo.SocketSndBuf > 0 {
+		err = dev.conn.setsockoptInt(unix.SOL_SOCKET, unix.SO_SNDBUF, dev.fifo.SocketSndBuf)
+	}
 	if err != nil {
+		dev.conn.Close()
+		dev.conn = nil
 		return VCI_E_FAIL
 	}
 
-	dev.tr = socketcan.NewTransmitter(dev.conn)
 	dev.recv = socketcan.NewReceiver(dev.conn)
 
-	log.Println("connection", dev.name, "established")
+	logInfo(devnum, "connection established", "op", "open channel")
 
 	return
 }
 
-// Send sends a data packet to device devnum.
-// msgid - Identifier.
-// rtr - Request flag, default value is false.
-// msgdata - An array of 1 to 8 bytes. If rtr = true this field is ignored.
-// vcierr is 0 if there are no errors.
-func Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
+// Send transmits a frame, IDs above 0x7FF are sent as 29-bit.
+func (b socketcanBackend) Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
+	return b.SendTimeout(devnum, msgid, rtr, msgdata, -1)
+}
 
-	dev, ok := devices[devnum]
+// SendTimeout transmits a frame waiting at most timeout for space in the interface queue.
+func (socketcanBackend) SendTimeout(devnum uint8, msgid uint32, rtr bool, msgdata []byte, timeout time.Duration) (vcierr uint32) {
+	dev, ok := deviceOf(devnum)
 	if !ok {
 		return VCI_E_NOT_INITIALIZED
 	}
-	if nil == dev.tr {
+	if nil == dev.conn {
 		vcierr = VCI_E_NOT_INITIALIZED
 		return
 	}
+	if AccessMonitor == dev.mode {
+		return VCI_E_ACCESSDENIED
+	}
 	if len(msgdata) > 8 {
 		return VCI_E_INVALIDARG
 	}
@@ -176,29 +276,133 @@ func Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32)
 		ext = true
 	}
 
-	fr := can.Frame{
-		ID:         msgid & maxmsgid29bit,
-		Length:     uint8(len(msgdata)),
-		IsRemote:   rtr,
-		IsExtended: ext,
+	// struct can_frame
+	var fr [16]byte
+	id := msgid & maxmsgid29bit
+	if ext {
+		id |= unix.CAN_EFF_FLAG
+	}
+	if rtr {
+		id |= unix.CAN_RTR_FLAG
 	}
+	binary.LittleEndian.PutUint32(fr[0:], id)
+	fr[4] = uint8(len(msgdata))
+	copy(fr[8:], msgdata)
+
+	dev.txmu.Lock()
+	defer dev.txmu.Unlock()
+	var err error
+	switch {
+	case 0 == timeout:
+		err = dev.conn.tryWrite(fr[:])
+	case timeout < 0:
+		dev.conn.SetWriteDeadline(time.Time{})
+		_, err = dev.conn.Write(fr[:])
+	default:
+		dev.conn.SetWriteDeadline(time.Now().Add(timeout))
+		_, err = dev.conn.Write(fr[:])
+	}
+	if err != nil {
+		return sendError(err)
+	}
+
+	return
+}
+
+// SetFifoConfig sets socket buffer sizes and the interface txqueuelen used by OpenChannel.
+func (socketcanBackend) SetFifoConfig(devnum uint8, cfg FifoConfig) (vcierr uint32) {
+	dev, ok := deviceOf(devnum)
+	if !ok {
+		return VCI_E_NOT_INITIALIZED
+	}
+	if cfg.SocketRcvBuf < 0 || cfg.SocketSndBuf < 0 || cfg.TxQueueLen < 0 {
+		return VCI_E_INVALIDARG
+	}
+	dev.fifo = cfg
+	return
+}
 
-	copy(fr.Data[:], msgdata)
+// FifoStatus returns the socket buffer sizes as reported by the kernel (twice the requested ones),
+// the interface txqueuelen and its receive overrun counters.
+func (socketcanBackend) FifoStatus(devnum uint8) (status FifoStatus, vcierr uint32) {
+	dev, ok := deviceOf(devnum)
+	if !ok || nil == dev.conn {
+		vcierr = VCI_E_NOT_INITIALIZED
+		return
+	}
+	var err error
+	if status.SocketRcvBuf, err = dev.conn.getsockoptInt(unix.SOL_SOCKET, unix.SO_RCVBUF); err == nil {
+		status.SocketSndBuf, err = dev.conn.getsockoptInt(unix.SOL_SOCKET, unix.SO_SNDBUF)
+	}
+	if err != nil {
+		vcierr = VCI_E_FAIL
+		return
+	}
+	status.TxQueueLen = int(readInterfaceValue(dev.name, "tx_queue_len"))
+	status.RxOverruns = uint32(readInterfaceValue(dev.name, "statistics/rx_over_errors") +
+		readInterfaceValue(dev.name, "statistics/rx_fifo_errors"))
+	return
+}
 
-	err := dev.tr.TransmitFrame(context.Background(), fr)
+// readInterfaceValue reads a decimal or "0x" hex number from /sys/class/net/<name>/<file>, 0 if it is missing.
+func readInterfaceValue(name, file string) uint64 {
+	b, err := ioutil.ReadFile(filepath.Join("/sys/class/net", name, file))
 	if err != nil {
-		return VCI_E_FAIL
+		return 0
 	}
+	v, _ := strconv.ParseUint(strings.TrimSpace(string(b)), 0, 64)
+	return v
+}
 
+// SetAccessMode sets the access mode used by OpenChannel.
+// Sockets are always shared, so AccessExclusive is not implemented.
+// In AccessMonitor mode the interface is not reconfigured and must be up.
+func (socketcanBackend) SetAccessMode(devnum uint8, mode AccessMode) (vcierr uint32) {
+	dev, ok := deviceOf(devnum)
+	if !ok {
+		return VCI_E_NOT_INITIALIZED
+	}
+	switch mode {
+	case AccessShared, AccessMonitor:
+		dev.mode = mode
+	case AccessExclusive:
+		return VCI_E_NOT_IMPLEMENTED
+	default:
+		return VCI_E_INVALIDARG
+	}
 	return
 }
 
-// Receive receives a message from a device with number "devnum".
-// You need to call this function regularly so that the hardware message buffer does not overflow.
-// Blocking call if no CAN messages are received.
-// May return: VCI_E_OK, VCI_E_TIMEOUT, VCI_E_NO_DATA, VCI_E_INVALIDARG
-func Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
-	dev, ok := devices[devnum]
+// Ownership reports the access mode and whether OpenChannel configured the interface.
+func (socketcanBackend) Ownership(devnum uint8) (ownership Ownership, vcierr uint32) {
+	dev, ok := deviceOf(devnum)
+	if !ok {
+		vcierr = VCI_E_NOT_INITIALIZED
+		return
+	}
+	ownership = Ownership{Mode: dev.mode, Opened: dev.conn != nil, OwnsController: dev.owns}
+	return
+}
+
+// SetTxEcho makes the socket receive its own frames after transmission (CAN_RAW_RECV_OWN_MSGS).
+func (socketcanBackend) SetTxEcho(devnum uint8, echo bool) (vcierr uint32) {
+	dev, ok := deviceOf(devnum)
+	if !ok || nil == dev.conn {
+		return VCI_E_NOT_INITIALIZED
+	}
+	on := 0
+	if echo {
+		on = 1
+	}
+	if err := dev.conn.setsockoptInt(solCANRaw, canRawRecvOwnMsgs, on); err != nil {
+		return VCI_E_FAIL
+	}
+	return
+}
+
+// Receive waits for a frame.
+func (socketcanBackend) Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+	dev, ok := deviceOf(devnum)
 	if !ok {
 		vcierr = VCI_E_NOT_INITIALIZED
 		return
@@ -211,11 +415,17 @@ func Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]by
 
 	if !dev.recv.Receive() {
 		vcierr = VCI_E_FAIL
+		if !interfaceExists(dev.name) {
+			vcierr = VCI_E_DISCONNECTED
+		}
 		return
 	}
 
 	fr := dev.recv.Frame()
 	msgid = fr.ID
+	if fr.IsExtended {
+		msgid |= 1 << 31
+	}
 	rtr = fr.IsRemote
 	msgdatasize = fr.Length
 	copy(msgdata[:], fr.Data[:])
@@ -223,8 +433,72 @@ func Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]by
 	return
 }
 
-// GetStatus returns a structure containing various information about the connection status.
-func GetStatus(devnum uint8) (status CANChanStatus, vcierr uint32) {
+// interfaceExists reports whether network interface name is present, e.g. the USB adapter is plugged in.
+func interfaceExists(name string) bool {
+	_, err := os.Stat(filepath.Join("/sys/class/net", name))
+	return nil == err
+}
+
+var (
+	reCANState   = regexp.MustCompile(`\bcan (?:<[^>]*> )?state ([A-Z-]+)`)
+	reCANBitrate = regexp.MustCompile(`\bbitrate (\d+)`)
+)
+
+// interfaceDetails returns the output of "ip -details link show".
+func interfaceDetails(name string) ([]byte, error) {
+	return exec.Command("ip", "-details", "link", "show", "dev", name).Output()
+}
+
+// interfaceBitrate returns the bitrate of interface name in bit/s, empty if unknown.
+func interfaceBitrate(name string) string {
+	out, err := interfaceDetails(name)
+	if err != nil {
+		return ""
+	}
+	if m := reCANBitrate.FindSubmatch(out); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// GetStatus returns the controller state and bitrate from "ip -details link show".
+// VCI_E_DISCONNECTED is returned if the interface is removed.
+func (socketcanBackend) GetStatus(devnum uint8) (status CANChanStatus, vcierr uint32) {
+	dev, ok := deviceOf(devnum)
+	if !ok {
+		vcierr = VCI_E_NOT_INITIALIZED
+		return
+	}
+	if !interfaceExists(dev.name) {
+		vcierr = VCI_E_DISCONNECTED
+		return
+	}
+	out, err := interfaceDetails(dev.name)
+	if err != nil {
+		vcierr = VCI_E_FAIL
+		return
+	}
+	if m := reCANState.FindSubmatch(out); m != nil {
+		switch string(m[1]) {
+		case "ERROR-WARNING", "ERROR-PASSIVE":
+			status.LineStatus.Status |= CAN_STATUS_ERRLIM
+		case "BUS-OFF":
+			status.LineStatus.Status |= CAN_STATUS_BUSOFF
+		case "STOPPED", "SLEEPING":
+			status.LineStatus.Status |= CAN_STATUS_ININIT
+		}
+	}
+	if m := reCANBitrate.FindSubmatch(out); m != nil {
+		bps, _ := strconv.ParseUint(string(m[1]), 10, 32)
+		for _, b := range StandardBitrates {
+			if uint64(b.BitsPerSecond) == bps {
+				status.LineStatus.BtReg0, status.LineStatus.BtReg1 = b.Bitrate.Btr0, b.Bitrate.Btr1
+			}
+		}
+	}
+	if nil != dev.recv && bytes.Contains(out, []byte(",UP")) {
+		status.Activated = 1
+	}
 	return
 }
 
@@ -235,34 +509,32 @@ func GetErrorText(vcierr uint32) string {
 	return result
 }
 
-// CloseDevice close channel and free device with number "devnum".
-func CloseDevice(devnum uint8) (vcierr uint32) {
+// CloseDevice closes the connection and sets the interface down.
+func (socketcanBackend) CloseDevice(devnum uint8) (vcierr uint32) {
+	muDevices.Lock()
 	dev, ok := devices[devnum]
+	delete(devices, devnum)
+	muDevices.Unlock()
 	if !ok {
 		vcierr = VCI_E_NOT_INITIALIZED
 		return
 	}
-	if nil != dev.recv && nil != dev.tr {
+	if nil != dev.recv {
 
 		dev.recv.Close()
-		dev.tr.Close()
 		dev.conn.Close()
-		log.Println("connection closed")
-		err := execCmd("ip", "link", "set", dev.name, "down")
-		if err != nil {
-			log.Println("close link error", err)
-		} else {
-			log.Println("link closed")
+		logInfo(devnum, "connection closed", "op", "close device")
+	}
+	if dev.owns {
+		if nil == execCmd(devnum, "ip", "link", "set", dev.name, "down") {
+			logInfo(devnum, "link down", "op", "close device")
 		}
 	}
-	delete(devices, devnum)
 	return
 }
 
-// OpenChannelDetectBitrate opens a channel on the previously opened device with devnum number, and tries to determine the bitrate in the CAN channel.
-// The bitrate is determined from the number of possible ones, specified through the bitrate array with several pairs of values for the btr0 and btr1 registers.
-// If the channel is open and the bitrate is defined, a pair of values btr0, btr1 is returned.
-func OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []BitrateRegisterPair) (detected BitrateRegisterPair, err error) {
+// OpenChannelDetectBitrate is not implemented.
+func (socketcanBackend) OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []BitrateRegisterPair) (detected BitrateRegisterPair, err error) {
 	err = errors.New("not implemented")
 	return
 }
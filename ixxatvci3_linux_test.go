@@ -0,0 +1,45 @@
+package ixxatvci3
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDevicesConcurrent opens and closes a device while others use it, as Supervisor does.
+// No interface is needed, the channel is never opened.
+func TestDevicesConcurrent(t *testing.T) {
+	const devnum = 240
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				native.Send(devnum, 0x100, false, []byte{1})
+				native.Receive(devnum)
+				native.GetStatus(devnum)
+			}
+		}()
+	}
+	for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+		if vcierr := native.SelectDevice(false, devnum); vcierr != VCI_OK {
+			t.Fatalf("SelectDevice: %s", GetErrorText(vcierr))
+		}
+		if vcierr := native.SelectDevice(false, devnum); vcierr != VCI_E_ALREADY_INITIALIZED {
+			t.Errorf("second SelectDevice: %s", GetErrorText(vcierr))
+		}
+		native.CloseDevice(devnum)
+	}
+	close(stop)
+	wg.Wait()
+	if vcierr := native.CloseDevice(devnum); vcierr != VCI_E_NOT_INITIALIZED {
+		t.Errorf("CloseDevice of a closed device: %s", GetErrorText(vcierr))
+	}
+}
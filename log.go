@@ -0,0 +1,194 @@
+package ixxatvci3
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Logger receives structured log records: args are alternating keys and values.
+// *slog.Logger satisfies it.
+// Records have the fields "device" (device number), "op" (operation), "vcierr" and "error" for failures,
+// and "channel" (e.g. "can0") if the backend names its channels.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+var (
+	muLoggers     sync.RWMutex
+	logger        Logger = nopLogger{}
+	deviceLoggers        = make(map[uint8]Logger)
+	frameTrace    [256]uint32
+)
+
+// SetLogger sets the logger of all devices without their own one. Nothing is logged by default, nil restores it.
+func SetLogger(l Logger) {
+	muLoggers.Lock()
+	defer muLoggers.Unlock()
+	if nil == l {
+		l = nopLogger{}
+	}
+	logger = l
+}
+
+// SetDeviceLogger sets the logger of device devnum, nil makes it use the SetLogger one.
+func SetDeviceLogger(devnum uint8, l Logger) {
+	muLoggers.Lock()
+	defer muLoggers.Unlock()
+	if nil == l {
+		delete(deviceLoggers, devnum)
+		return
+	}
+	deviceLoggers[devnum] = l
+}
+
+// TraceFrames logs every frame sent and received by device devnum at debug level
+// with the fields "dir" ("tx" or "rx"), "id", "rtr" and "data".
+func TraceFrames(devnum uint8, on bool) {
+	var v uint32
+	if on {
+		v = 1
+	}
+	atomic.StoreUint32(&frameTrace[devnum], v)
+}
+
+// DeviceLogger returns the logger of device devnum: its SetDeviceLogger one, otherwise the SetLogger one.
+// Packages built on ixxatvci3 (slcan, remote, candev.Supervisor, gateway) log their own events
+// with it unless they are given a Logger, so SetLogger covers them too.
+func DeviceLogger(devnum uint8) Logger {
+	return loggerOf(devnum)
+}
+
+// DefaultLogger returns the logger set with SetLogger, for events not bound to a device.
+func DefaultLogger() Logger {
+	muLoggers.RLock()
+	defer muLoggers.RUnlock()
+	return logger
+}
+
+func loggerOf(devnum uint8) Logger {
+	muLoggers.RLock()
+	defer muLoggers.RUnlock()
+	if l, ok := deviceLoggers[devnum]; ok {
+		return l
+	}
+	return logger
+}
+
+// channelNamer is implemented by backends naming their channels, e.g. SocketCAN interfaces.
+type channelNamer interface {
+	channelName(devnum uint8) string
+}
+
+// deviceFields returns the "device" and "channel" fields of devnum.
+func deviceFields(devnum uint8) []interface{} {
+	fields := []interface{}{"device", devnum}
+	if n, ok := backendOf(devnum).(channelNamer); ok {
+		fields = append(fields, "channel", n.channelName(devnum))
+	}
+	return fields
+}
+
+// logResult logs operation op of device devnum: failures as errors, successes at debug level.
+func logResult(devnum uint8, op string, vcierr uint32, args ...interface{}) {
+	l := loggerOf(devnum)
+	if _, nop := l.(nopLogger); nop {
+		return
+	}
+	fields := append(deviceFields(devnum), "op", op)
+	fields = append(fields, args...)
+	if VCI_OK == vcierr {
+		l.Debug(op, fields...)
+		return
+	}
+	fields = append(fields, "vcierr", vcierr, "error", GetErrorText(vcierr))
+	l.Error(op+" failed", fields...)
+}
+
+// logInfo logs an event of device devnum at info level.
+func logInfo(devnum uint8, msg string, args ...interface{}) {
+	loggerOf(devnum).Info(msg, append(deviceFields(devnum), args...)...)
+}
+
+// logSend traces a sent frame and logs a failure as a warning,
+// except a full transmit queue or a timeout the caller handles.
+func logSend(devnum uint8, vcierr uint32, msgid uint32, rtr bool, msgdata []byte) {
+	traceFrame(devnum, "tx", vcierr, msgid, rtr, msgdata)
+	switch vcierr {
+	case VCI_OK, VCI_E_TXQUEUE_FULL, VCI_E_TIMEOUT:
+		return
+	}
+	loggerOf(devnum).Warn("send failed", append(deviceFields(devnum), "op", "send",
+		"id", fmt.Sprintf("%X", msgid&0x1FFFFFFF), "vcierr", vcierr, "error", GetErrorText(vcierr))...)
+}
+
+// traceFrame logs a sent or received frame if TraceFrames is on for devnum.
+func traceFrame(devnum uint8, dir string, vcierr uint32, msgid uint32, rtr bool, msgdata []byte) {
+	if 0 == atomic.LoadUint32(&frameTrace[devnum]) {
+		return
+	}
+	fields := append(deviceFields(devnum), "op", "frame", "dir", dir,
+		"id", fmt.Sprintf("%X", msgid&0x1FFFFFFF), "rtr", rtr, "data", fmt.Sprintf("%X", msgdata))
+	if vcierr != VCI_OK {
+		fields = append(fields, "vcierr", vcierr)
+	}
+	loggerOf(devnum).Debug("frame", fields...)
+}
+
+// LogLevel is a record level of NewStdLogger, the values are those of log/slog.
+type LogLevel int
+
+// Log levels
+const (
+	LogDebug LogLevel = -4
+	LogInfo  LogLevel = 0
+	LogWarn  LogLevel = 4
+	LogError LogLevel = 8
+)
+
+// stdLogger writes records with a log.Logger.
+type stdLogger struct {
+	l   *log.Logger
+	min LogLevel
+}
+
+// NewStdLogger returns a Logger writing "LEVEL msg key=value ..." lines of level min and above to l.
+// It is for programs without log/slog.
+func NewStdLogger(l *log.Logger, min LogLevel) Logger {
+	return stdLogger{l: l, min: min}
+}
+
+func (s stdLogger) write(level LogLevel, name, msg string, args []interface{}) {
+	if level < s.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	s.l.Println(b.String())
+}
+
+func (s stdLogger) Debug(msg string, args ...interface{}) { s.write(LogDebug, "DEBUG", msg, args) }
+func (s stdLogger) Info(msg string, args ...interface{})  { s.write(LogInfo, "INFO", msg, args) }
+func (s stdLogger) Warn(msg string, args ...interface{})  { s.write(LogWarn, "WARN", msg, args) }
+func (s stdLogger) Error(msg string, args ...interface{}) { s.write(LogError, "ERROR", msg, args) }
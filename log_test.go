@@ -0,0 +1,43 @@
+package ixxatvci3
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recordLogger keeps records as text.
+type recordLogger struct {
+	records []string
+}
+
+func (r *recordLogger) log(level, msg string, args []interface{}) {
+	r.records = append(r.records, fmt.Sprint(level, " ", msg, args))
+}
+
+func (r *recordLogger) Debug(msg string, args ...interface{}) { r.log("debug", msg, args) }
+func (r *recordLogger) Info(msg string, args ...interface{})  { r.log("info", msg, args) }
+func (r *recordLogger) Warn(msg string, args ...interface{})  { r.log("warn", msg, args) }
+func (r *recordLogger) Error(msg string, args ...interface{}) { r.log("error", msg, args) }
+
+func TestDeviceLogger(t *testing.T) {
+	all, dev := new(recordLogger), new(recordLogger)
+	SetLogger(all)
+	defer SetLogger(nil)
+	SetDeviceLogger(3, dev)
+	defer SetDeviceLogger(3, nil)
+
+	DeviceLogger(3).Warn("lost", "device", 3)
+	DeviceLogger(4).Info("connected", "device", 4)
+	DefaultLogger().Error("failed")
+	if fmt.Sprint(dev.records) != "[warn lost[device 3]]" {
+		t.Errorf("device logger %q", dev.records)
+	}
+	if fmt.Sprint(all.records) != "[info connected[device 4] error failed[]]" {
+		t.Errorf("logger %q", all.records)
+	}
+
+	SetLogger(nil)
+	if _, ok := DefaultLogger().(nopLogger); !ok {
+		t.Errorf("DefaultLogger after SetLogger(nil) is %T", DefaultLogger())
+	}
+}
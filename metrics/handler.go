@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+// contentType is the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves metrics of collectors in the Prometheus text format.
+func Handler(collectors ...*Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		WriteText(w, collectors...)
+	})
+}
+
+// family is a metric family with samples of all collectors.
+type family struct {
+	name, typ, help string
+	samples         []string
+}
+
+func (f *family) add(labels string, value interface{}) {
+	f.samples = append(f.samples, fmt.Sprintf("%s{%s} %v", f.name, labels, value))
+}
+
+// WriteText writes metrics of collectors in the Prometheus text format.
+func WriteText(w io.Writer, collectors ...*Collector) error {
+	families := []*family{
+		{name: "can_rx_frames_total", typ: "counter", help: "Received frames."},
+		{name: "can_rx_bytes_total", typ: "counter", help: "Received data bytes."},
+		{name: "can_tx_frames_total", typ: "counter", help: "Sent frames."},
+		{name: "can_tx_bytes_total", typ: "counter", help: "Sent data bytes."},
+		{name: "can_tx_errors_total", typ: "counter", help: "Failed send calls."},
+		{name: "can_id_rx_frames_total", typ: "counter", help: "Received frames by identifier."},
+		{name: "can_id_rx_bytes_total", typ: "counter", help: "Received data bytes by identifier."},
+		{name: "can_id_tx_frames_total", typ: "counter", help: "Sent frames by identifier."},
+		{name: "can_id_tx_bytes_total", typ: "counter", help: "Sent data bytes by identifier."},
+		{name: "can_untracked_id_frames_total", typ: "counter", help: "Frames of identifiers over the per-identifier limit."},
+		{name: "can_error_frames_total", typ: "counter", help: "Received error frames by type."},
+		{name: "can_rx_overruns_total", typ: "counter", help: "Receive overrun occurrences."},
+		{name: "can_rx_overrun_frames_total", typ: "counter", help: "Receive overruns counted by the driver."},
+		{name: "can_bus_load_percent", typ: "gauge", help: "Bus load reported by the controller."},
+		{name: "can_rx_fifo_load_percent", typ: "gauge", help: "Receive FIFO load."},
+		{name: "can_tx_fifo_load_percent", typ: "gauge", help: "Transmit FIFO load."},
+		{name: "can_bus_state", typ: "gauge", help: "1 for the current bus state."},
+		{name: "can_bus_state_transitions_total", typ: "counter", help: "Bus state changes."},
+		{name: "can_status_errors_total", typ: "counter", help: "Failed status requests."},
+	}
+	const (
+		rxFrames = iota
+		rxBytes
+		txFrames
+		txBytes
+		txErrors
+		idRxFrames
+		idRxBytes
+		idTxFrames
+		idTxBytes
+		untracked
+		errorFrames
+		overruns
+		overrunFrames
+		busLoad
+		rxFifo
+		txFifo
+		busState
+		transitions
+		statusErrors
+	)
+
+	for _, c := range collectors {
+		s := c.Snapshot()
+		ch := "channel=" + quote(c.Channel)
+		families[rxFrames].add(ch, s.RxFrames)
+		families[rxBytes].add(ch, s.RxBytes)
+		families[txFrames].add(ch, s.TxFrames)
+		families[txBytes].add(ch, s.TxBytes)
+		families[txErrors].add(ch, s.TxErrors)
+		for _, id := range s.IDs {
+			labels := fmt.Sprintf("%s,id=%s", ch, quote(idLabel(id)))
+			families[idRxFrames].add(labels, id.RxFrames)
+			families[idRxBytes].add(labels, id.RxBytes)
+			families[idTxFrames].add(labels, id.TxFrames)
+			families[idTxBytes].add(labels, id.TxBytes)
+		}
+		families[untracked].add(ch, s.UntrackedIDFrames)
+		for _, kind := range []int{ixxatvci3.CAN_ERROR_STUFF, ixxatvci3.CAN_ERROR_FORM, ixxatvci3.CAN_ERROR_ACK,
+			ixxatvci3.CAN_ERROR_BIT, ixxatvci3.CAN_ERROR_CRC, ixxatvci3.CAN_ERROR_OTHER} {
+			n := s.ErrorFrames[kind]
+			if ixxatvci3.CAN_ERROR_OTHER == kind { // unknown types are "other"
+				n += s.ErrorFrames[0] + s.ErrorFrames[5]
+			}
+			families[errorFrames].add(fmt.Sprintf("%s,type=%s", ch, quote(ixxatvci3.ErrorFrameName(kind))), n)
+		}
+		families[overruns].add(ch, s.Overruns)
+		families[overrunFrames].add(ch, s.OverrunFrames)
+		if s.StatusKnown {
+			families[busLoad].add(ch, s.BusLoad)
+			families[rxFifo].add(ch, s.RxFifoLoad)
+			families[txFifo].add(ch, s.TxFifoLoad)
+			current := BusState(s.Status)
+			for _, state := range states {
+				v := 0
+				if state == current {
+					v = 1
+				}
+				families[busState].add(fmt.Sprintf("%s,state=%s", ch, quote(state)), v)
+			}
+		}
+		for _, t := range s.StateTransitions {
+			families[transitions].add(fmt.Sprintf("%s,from=%s,to=%s", ch, quote(t.From), quote(t.To)), t.Count)
+		}
+		families[statusErrors].add(ch, s.StatusErrors)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if 0 == len(f.samples) {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, sample := range f.samples {
+			bw.WriteString(sample)
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// idLabel is "123" for 11-bit and "1ABCDEF0" for 29-bit identifiers.
+func idLabel(id IDSnapshot) string {
+	if id.Ext {
+		return fmt.Sprintf("%08X", id.ID)
+	}
+	return fmt.Sprintf("%03X", id.ID)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns an escaped label value in quotes.
+func quote(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
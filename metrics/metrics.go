@@ -0,0 +1,405 @@
+// Package metrics collects CAN channel statistics and exposes them in the
+// Prometheus text format for long-running test stands.
+//
+//	c := metrics.NewCollector("can0")
+//	detach := c.Attach(dev)                          // received frames
+//	bus := c.Wrap(dev)                               // use bus.Send to count sent frames
+//	stop := c.PollStatus(dev.Number(), time.Second) // bus load, FIFO, overruns, bus state, error frames
+//	http.Handle("/metrics", metrics.Handler(c))
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Bus is a measured bus: *candev.Device or *candev.Loopback.
+type Bus interface {
+	Send(msg candev.Message) error
+	GetMsgChannelCopy() (<-chan candev.Message, uint)
+	CloseMsgChannelCopy(idx uint)
+}
+
+// Bus states of CAN_STATUS_* bits.
+const (
+	StateActive  = "error-active"
+	StateWarning = "error-warning" // CAN_STATUS_ERRLIM
+	StateBusOff  = "bus-off"       // CAN_STATUS_BUSOFF
+	StateInit    = "init"          // CAN_STATUS_ININIT
+)
+
+var states = []string{StateActive, StateWarning, StateBusOff, StateInit}
+
+// BusState returns the bus state of CAN_STATUS_* bits.
+func BusState(status uint32) string {
+	switch {
+	case status&ixxatvci3.CAN_STATUS_BUSOFF != 0:
+		return StateBusOff
+	case status&ixxatvci3.CAN_STATUS_ININIT != 0:
+		return StateInit
+	case status&ixxatvci3.CAN_STATUS_ERRLIM != 0:
+		return StateWarning
+	}
+	return StateActive
+}
+
+// DefaultMaxIDs is a limit of identifiers tracked separately if Collector.MaxIDs is zero.
+const DefaultMaxIDs = 2048
+
+// idKey is an identifier with its format.
+type idKey struct {
+	id  uint32
+	ext bool
+}
+
+// idStats are per-identifier counters.
+type idStats struct {
+	rxFrames, rxBytes, txFrames, txBytes uint64
+}
+
+// transition is a bus state change.
+type transition struct {
+	from, to string
+}
+
+// Collector collects statistics of a channel. All methods are safe for concurrent use.
+type Collector struct {
+	// counters are first for 64-bit alignment
+	rxFrames, rxBytes uint64
+	txFrames, txBytes uint64
+	txErrors          uint64
+	overruns          uint64
+	statusErrors      uint64
+	idOverflow        uint64
+	overrunFrames     uint64
+	errorFrames       [8]uint64
+	busLoad           uint32
+	rxFifoLoad        uint32
+	txFifoLoad        uint32
+	status            uint32
+	statusKnown       uint32
+
+	// Channel is the "channel" label value, e.g. "can0".
+	Channel string
+	// MaxIDs limits identifiers tracked separately, frames of other ones
+	// are counted in totals only. DefaultMaxIDs if zero, negative disables per-ID counters.
+	MaxIDs int
+
+	mu          sync.RWMutex
+	ids         map[idKey]*idStats
+	transitions map[transition]uint64
+	overrun     bool
+
+	errMu           sync.Mutex
+	lastErrorFrames ixxatvci3.ErrorFrameCounts
+	lastOverruns    uint32
+}
+
+// NewCollector creates a collector of channel.
+func NewCollector(channel string) *Collector {
+	return &Collector{Channel: channel}
+}
+
+// idStatsOf returns counters of an identifier, nil over MaxIDs.
+func (c *Collector) idStatsOf(msg candev.Message) *idStats {
+	max := c.MaxIDs
+	if 0 == max {
+		max = DefaultMaxIDs
+	}
+	if max < 0 {
+		return nil
+	}
+	key := idKey{msg.ID, msg.Ext || msg.ID > 0x7FF}
+	c.mu.RLock()
+	s := c.ids[key]
+	c.mu.RUnlock()
+	if s != nil {
+		return s
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s = c.ids[key]; s != nil {
+		return s
+	}
+	if len(c.ids) >= max {
+		atomic.AddUint64(&c.idOverflow, 1)
+		return nil
+	}
+	if nil == c.ids {
+		c.ids = make(map[idKey]*idStats)
+	}
+	s = &idStats{}
+	c.ids[key] = s
+	return s
+}
+
+// ObserveRx accounts a received frame.
+func (c *Collector) ObserveRx(msg candev.Message) {
+	n := uint64(msg.Len)
+	if msg.Rtr {
+		n = 0
+	}
+	atomic.AddUint64(&c.rxFrames, 1)
+	atomic.AddUint64(&c.rxBytes, n)
+	if s := c.idStatsOf(msg); s != nil {
+		atomic.AddUint64(&s.rxFrames, 1)
+		atomic.AddUint64(&s.rxBytes, n)
+	}
+}
+
+// ObserveTx accounts a sent frame, err is the result of sending.
+func (c *Collector) ObserveTx(msg candev.Message, err error) {
+	if err != nil {
+		atomic.AddUint64(&c.txErrors, 1)
+		return
+	}
+	n := uint64(msg.Len)
+	if msg.Rtr {
+		n = 0
+	}
+	atomic.AddUint64(&c.txFrames, 1)
+	atomic.AddUint64(&c.txBytes, n)
+	if s := c.idStatsOf(msg); s != nil {
+		atomic.AddUint64(&s.txFrames, 1)
+		atomic.AddUint64(&s.txBytes, n)
+	}
+}
+
+// ObserveErrorFrame accounts an error frame of CAN_ERROR_* kind.
+func (c *Collector) ObserveErrorFrame(kind int) {
+	atomic.AddUint64(&c.errorFrames[kind&7], 1)
+}
+
+// ObserveErrorFrameCounts accounts error frames of cumulative counts from ixxatvci3.GetErrorFrameCounts.
+func (c *Collector) ObserveErrorFrameCounts(counts ixxatvci3.ErrorFrameCounts) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	for i, n := range counts {
+		last := c.lastErrorFrames[i]
+		if n < last { // the device was reopened
+			last = 0
+		}
+		atomic.AddUint64(&c.errorFrames[i], uint64(n-last))
+	}
+	c.lastErrorFrames = counts
+}
+
+// ObserveFifoStatus accounts receive overruns of cumulative counts from ixxatvci3.GetFifoStatus.
+func (c *Collector) ObserveFifoStatus(st ixxatvci3.FifoStatus) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	last := c.lastOverruns
+	if st.RxOverruns < last { // the device was reopened
+		last = 0
+	}
+	atomic.AddUint64(&c.overrunFrames, uint64(st.RxOverruns-last))
+	c.lastOverruns = st.RxOverruns
+}
+
+// ObserveStatus accounts a channel status: load gauges, overruns and bus state transitions.
+func (c *Collector) ObserveStatus(st ixxatvci3.CANChanStatus) {
+	ls := st.LineStatus
+	atomic.StoreUint32(&c.busLoad, uint32(ls.BusLoad))
+	atomic.StoreUint32(&c.rxFifoLoad, uint32(st.RxFifoLoad))
+	atomic.StoreUint32(&c.txFifoLoad, uint32(st.TxFifoLoad))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	overrun := st.RxOverrun != 0 || ls.Status&ixxatvci3.CAN_STATUS_OVRRUN != 0
+	if overrun && !c.overrun {
+		atomic.AddUint64(&c.overruns, 1)
+	}
+	c.overrun = overrun
+
+	from, to := BusState(atomic.LoadUint32(&c.status)), BusState(ls.Status)
+	if atomic.LoadUint32(&c.statusKnown) != 0 && from != to {
+		if nil == c.transitions {
+			c.transitions = make(map[transition]uint64)
+		}
+		c.transitions[transition{from, to}]++
+	}
+	atomic.StoreUint32(&c.status, ls.Status)
+	atomic.StoreUint32(&c.statusKnown, 1)
+}
+
+// ObserveStatusError accounts a failed status request.
+func (c *Collector) ObserveStatusError() {
+	atomic.AddUint64(&c.statusErrors, 1)
+}
+
+// Attach counts frames received from bus until the returned detach function is called.
+func (c *Collector) Attach(bus Bus) (detach func()) {
+	ch, idx := bus.GetMsgChannelCopy()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				c.ObserveRx(msg)
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+			bus.CloseMsgChannelCopy(idx)
+		})
+	}
+}
+
+// countingBus counts frames sent through it.
+type countingBus struct {
+	Bus
+	c *Collector
+}
+
+func (b countingBus) Send(msg candev.Message) error {
+	err := b.Bus.Send(msg)
+	b.c.ObserveTx(msg, err)
+	return err
+}
+
+// Wrap returns bus counting frames sent through it. Use it instead of bus
+// everywhere frames are sent, e.g. in a gateway or a remote server.
+func (c *Collector) Wrap(bus Bus) Bus {
+	return countingBus{bus, c}
+}
+
+// PollStatus polls ixxatvci3.GetStatus, ixxatvci3.GetErrorFrameCounts and ixxatvci3.GetFifoStatus of device devnum
+// every interval until the returned stop function is called.
+func (c *Collector) PollStatus(devnum uint8, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	poll := func() {
+		if st, vcierr := ixxatvci3.GetStatus(devnum); ixxatvci3.VCI_OK == vcierr {
+			c.ObserveStatus(st)
+		} else {
+			c.ObserveStatusError()
+		}
+		if counts, vcierr := ixxatvci3.GetErrorFrameCounts(devnum); ixxatvci3.VCI_OK == vcierr {
+			c.ObserveErrorFrameCounts(counts)
+		}
+		if st, vcierr := ixxatvci3.GetFifoStatus(devnum); ixxatvci3.VCI_OK == vcierr {
+			c.ObserveFifoStatus(st)
+		}
+	}
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			poll()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+// Snapshot are collected values at a moment.
+type Snapshot struct {
+	RxFrames, RxBytes uint64
+	TxFrames, TxBytes uint64
+	TxErrors          uint64
+	Overruns          uint64
+	OverrunFrames     uint64 // overruns counted by the backend, see ixxatvci3.FifoStatus
+	StatusErrors      uint64
+	UntrackedIDFrames uint64 // frames of identifiers over MaxIDs
+	ErrorFrames       [8]uint64
+	BusLoad           uint8
+	RxFifoLoad        uint8
+	TxFifoLoad        uint8
+	Status            uint32
+	StatusKnown       bool
+	IDs               []IDSnapshot
+	StateTransitions  []StateTransition
+}
+
+// IDSnapshot are counters of an identifier.
+type IDSnapshot struct {
+	ID                uint32
+	Ext               bool
+	RxFrames, RxBytes uint64
+	TxFrames, TxBytes uint64
+}
+
+// StateTransition is a number of bus state changes.
+type StateTransition struct {
+	From, To string
+	Count    uint64
+}
+
+// Snapshot returns collected values, identifiers and transitions sorted.
+func (c *Collector) Snapshot() (s Snapshot) {
+	s.RxFrames = atomic.LoadUint64(&c.rxFrames)
+	s.RxBytes = atomic.LoadUint64(&c.rxBytes)
+	s.TxFrames = atomic.LoadUint64(&c.txFrames)
+	s.TxBytes = atomic.LoadUint64(&c.txBytes)
+	s.TxErrors = atomic.LoadUint64(&c.txErrors)
+	s.Overruns = atomic.LoadUint64(&c.overruns)
+	s.OverrunFrames = atomic.LoadUint64(&c.overrunFrames)
+	s.StatusErrors = atomic.LoadUint64(&c.statusErrors)
+	s.UntrackedIDFrames = atomic.LoadUint64(&c.idOverflow)
+	for i := range s.ErrorFrames {
+		s.ErrorFrames[i] = atomic.LoadUint64(&c.errorFrames[i])
+	}
+	s.BusLoad = uint8(atomic.LoadUint32(&c.busLoad))
+	s.RxFifoLoad = uint8(atomic.LoadUint32(&c.rxFifoLoad))
+	s.TxFifoLoad = uint8(atomic.LoadUint32(&c.txFifoLoad))
+	s.Status = atomic.LoadUint32(&c.status)
+	s.StatusKnown = atomic.LoadUint32(&c.statusKnown) != 0
+
+	c.mu.RLock()
+	for key, st := range c.ids {
+		s.IDs = append(s.IDs, IDSnapshot{
+			ID:       key.id,
+			Ext:      key.ext,
+			RxFrames: atomic.LoadUint64(&st.rxFrames),
+			RxBytes:  atomic.LoadUint64(&st.rxBytes),
+			TxFrames: atomic.LoadUint64(&st.txFrames),
+			TxBytes:  atomic.LoadUint64(&st.txBytes),
+		})
+	}
+	for t, n := range c.transitions {
+		s.StateTransitions = append(s.StateTransitions, StateTransition{t.from, t.to, n})
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(s.IDs, func(i, j int) bool {
+		if s.IDs[i].Ext != s.IDs[j].Ext {
+			return !s.IDs[i].Ext
+		}
+		return s.IDs[i].ID < s.IDs[j].ID
+	})
+	sort.Slice(s.StateTransitions, func(i, j int) bool {
+		a, b := s.StateTransitions[i], s.StateTransitions[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return
+}
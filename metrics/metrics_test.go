@@ -0,0 +1,178 @@
+package metrics_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/metrics"
+)
+
+const golden = `# HELP can_rx_frames_total Received frames.
+# TYPE can_rx_frames_total counter
+can_rx_frames_total{channel="can0"} 4
+can_rx_frames_total{channel="rig \"A\"\\1\n"} 2
+# HELP can_rx_bytes_total Received data bytes.
+# TYPE can_rx_bytes_total counter
+can_rx_bytes_total{channel="can0"} 13
+can_rx_bytes_total{channel="rig \"A\"\\1\n"} 3
+# HELP can_tx_frames_total Sent frames.
+# TYPE can_tx_frames_total counter
+can_tx_frames_total{channel="can0"} 1
+can_tx_frames_total{channel="rig \"A\"\\1\n"} 0
+# HELP can_tx_bytes_total Sent data bytes.
+# TYPE can_tx_bytes_total counter
+can_tx_bytes_total{channel="can0"} 1
+can_tx_bytes_total{channel="rig \"A\"\\1\n"} 0
+# HELP can_tx_errors_total Failed send calls.
+# TYPE can_tx_errors_total counter
+can_tx_errors_total{channel="can0"} 1
+can_tx_errors_total{channel="rig \"A\"\\1\n"} 0
+# HELP can_id_rx_frames_total Received frames by identifier.
+# TYPE can_id_rx_frames_total counter
+can_id_rx_frames_total{channel="can0",id="123"} 2
+can_id_rx_frames_total{channel="can0",id="7FF"} 1
+can_id_rx_frames_total{channel="can0",id="18DAF110"} 1
+can_id_rx_frames_total{channel="rig \"A\"\\1\n",id="100"} 1
+# HELP can_id_rx_bytes_total Received data bytes by identifier.
+# TYPE can_id_rx_bytes_total counter
+can_id_rx_bytes_total{channel="can0",id="123"} 5
+can_id_rx_bytes_total{channel="can0",id="7FF"} 0
+can_id_rx_bytes_total{channel="can0",id="18DAF110"} 8
+can_id_rx_bytes_total{channel="rig \"A\"\\1\n",id="100"} 1
+# HELP can_id_tx_frames_total Sent frames by identifier.
+# TYPE can_id_tx_frames_total counter
+can_id_tx_frames_total{channel="can0",id="123"} 1
+can_id_tx_frames_total{channel="can0",id="7FF"} 0
+can_id_tx_frames_total{channel="can0",id="18DAF110"} 0
+can_id_tx_frames_total{channel="rig \"A\"\\1\n",id="100"} 0
+# HELP can_id_tx_bytes_total Sent data bytes by identifier.
+# TYPE can_id_tx_bytes_total counter
+can_id_tx_bytes_total{channel="can0",id="123"} 1
+can_id_tx_bytes_total{channel="can0",id="7FF"} 0
+can_id_tx_bytes_total{channel="can0",id="18DAF110"} 0
+can_id_tx_bytes_total{channel="rig \"A\"\\1\n",id="100"} 0
+# HELP can_untracked_id_frames_total Frames of identifiers over the per-identifier limit.
+# TYPE can_untracked_id_frames_total counter
+can_untracked_id_frames_total{channel="can0"} 0
+can_untracked_id_frames_total{channel="rig \"A\"\\1\n"} 1
+# HELP can_error_frames_total Received error frames by type.
+# TYPE can_error_frames_total counter
+can_error_frames_total{channel="can0",type="stuff"} 2
+can_error_frames_total{channel="can0",type="form"} 0
+can_error_frames_total{channel="can0",type="ack"} 0
+can_error_frames_total{channel="can0",type="bit"} 0
+can_error_frames_total{channel="can0",type="crc"} 6
+can_error_frames_total{channel="can0",type="other"} 3
+can_error_frames_total{channel="rig \"A\"\\1\n",type="stuff"} 0
+can_error_frames_total{channel="rig \"A\"\\1\n",type="form"} 0
+can_error_frames_total{channel="rig \"A\"\\1\n",type="ack"} 0
+can_error_frames_total{channel="rig \"A\"\\1\n",type="bit"} 0
+can_error_frames_total{channel="rig \"A\"\\1\n",type="crc"} 0
+can_error_frames_total{channel="rig \"A\"\\1\n",type="other"} 0
+# HELP can_rx_overruns_total Receive overrun occurrences.
+# TYPE can_rx_overruns_total counter
+can_rx_overruns_total{channel="can0"} 1
+can_rx_overruns_total{channel="rig \"A\"\\1\n"} 0
+# HELP can_rx_overrun_frames_total Receive overruns counted by the driver.
+# TYPE can_rx_overrun_frames_total counter
+can_rx_overrun_frames_total{channel="can0"} 7
+can_rx_overrun_frames_total{channel="rig \"A\"\\1\n"} 0
+# HELP can_bus_load_percent Bus load reported by the controller.
+# TYPE can_bus_load_percent gauge
+can_bus_load_percent{channel="can0"} 40
+# HELP can_rx_fifo_load_percent Receive FIFO load.
+# TYPE can_rx_fifo_load_percent gauge
+can_rx_fifo_load_percent{channel="can0"} 10
+# HELP can_tx_fifo_load_percent Transmit FIFO load.
+# TYPE can_tx_fifo_load_percent gauge
+can_tx_fifo_load_percent{channel="can0"} 5
+# HELP can_bus_state 1 for the current bus state.
+# TYPE can_bus_state gauge
+can_bus_state{channel="can0",state="error-active"} 0
+can_bus_state{channel="can0",state="error-warning"} 1
+can_bus_state{channel="can0",state="bus-off"} 0
+can_bus_state{channel="can0",state="init"} 0
+# HELP can_bus_state_transitions_total Bus state changes.
+# TYPE can_bus_state_transitions_total counter
+can_bus_state_transitions_total{channel="can0",from="error-active",to="error-warning"} 1
+# HELP can_status_errors_total Failed status requests.
+# TYPE can_status_errors_total counter
+can_status_errors_total{channel="can0"} 1
+can_status_errors_total{channel="rig \"A\"\\1\n"} 0
+`
+
+// collectors returns a channel with every kind of value and one with an escaped name and no status.
+func collectors() []*metrics.Collector {
+	c := metrics.NewCollector("can0")
+	c.ObserveRx(candev.Message{ID: 0x123, Len: 2})
+	c.ObserveRx(candev.Message{ID: 0x123, Len: 3})
+	c.ObserveRx(candev.Message{ID: 0x18DAF110, Ext: true, Len: 8})
+	c.ObserveRx(candev.Message{ID: 0x7FF, Rtr: true, Len: 4})
+	c.ObserveTx(candev.Message{ID: 0x123, Len: 1}, nil)
+	c.ObserveTx(candev.Message{ID: 0x200, Len: 8}, errors.New("no ack"))
+
+	// error frames of unknown types 0 and 5 are "other"
+	for _, kind := range []int{ixxatvci3.CAN_ERROR_STUFF, ixxatvci3.CAN_ERROR_STUFF, 0, 5, ixxatvci3.CAN_ERROR_OTHER} {
+		c.ObserveErrorFrame(kind)
+	}
+	var counts ixxatvci3.ErrorFrameCounts
+	counts[ixxatvci3.CAN_ERROR_CRC] = 4
+	c.ObserveErrorFrameCounts(counts)
+	counts[ixxatvci3.CAN_ERROR_CRC] = 6
+	c.ObserveErrorFrameCounts(counts)
+
+	c.ObserveStatus(ixxatvci3.CANChanStatus{})
+	c.ObserveStatus(ixxatvci3.CANChanStatus{RxOverrun: 1, RxFifoLoad: 10, TxFifoLoad: 5,
+		LineStatus: ixxatvci3.CANLineStatus{BusLoad: 40, Status: ixxatvci3.CAN_STATUS_ERRLIM}})
+	c.ObserveFifoStatus(ixxatvci3.FifoStatus{RxOverruns: 7})
+	c.ObserveStatusError()
+
+	rig := metrics.NewCollector("rig \"A\"\\1\n")
+	rig.MaxIDs = 1
+	rig.ObserveRx(candev.Message{ID: 0x100, Len: 1})
+	rig.ObserveRx(candev.Message{ID: 0x101, Len: 2})
+	return []*metrics.Collector{c, rig}
+}
+
+func TestWriteText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := metrics.WriteText(&buf, collectors()...); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != golden {
+		got, want := strings.Split(buf.String(), "\n"), strings.Split(golden, "\n")
+		for i := 0; i < len(got) || i < len(want); i++ {
+			var g, w string
+			if i < len(got) {
+				g = got[i]
+			}
+			if i < len(want) {
+				w = want[i]
+			}
+			if g != w {
+				t.Fatalf("line %d:\n%s\nwant\n%s", i+1, g, w)
+			}
+		}
+	}
+
+	buf.Reset()
+	if err := metrics.WriteText(&buf); err != nil || buf.Len() != 0 {
+		t.Errorf("no collectors: %q, %v", buf.String(), err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	metrics.Handler(collectors()...).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if rec.Body.String() != golden {
+		t.Error("handler output differs from WriteText")
+	}
+}
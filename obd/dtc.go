@@ -0,0 +1,95 @@
+package obd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DTC is a diagnostic trouble code, e.g. P0301.
+type DTC uint16
+
+var dtcSystems = [4]byte{'P', 'C', 'B', 'U'}
+
+func (d DTC) String() string {
+	code := uint16(d)
+	return fmt.Sprintf("%c%d%03X", dtcSystems[code>>14], (code>>12)&0x03, code&0x0FFF)
+}
+
+// ParseDTC parses a trouble code like "P0301".
+func ParseDTC(s string) (d DTC, err error) {
+	if 5 != len(s) {
+		err = fmt.Errorf("obd: wrong DTC %q", s)
+		return
+	}
+	system := strings.IndexByte(string(dtcSystems[:]), s[0]&^0x20)
+	if system < 0 || s[1] < '0' || s[1] > '3' {
+		err = fmt.Errorf("obd: wrong DTC %q", s)
+		return
+	}
+	rest, e := strconv.ParseUint(s[2:], 16, 12)
+	if e != nil {
+		err = fmt.Errorf("obd: wrong DTC %q", s)
+		return
+	}
+	d = DTC(uint16(system)<<14 | uint16(s[1]-'0')<<12 | uint16(rest))
+	return
+}
+
+// ECUCodes are trouble codes reported by a single ECU.
+type ECUCodes struct {
+	ECU   uint32
+	Codes []DTC
+}
+
+// ReadDTC reads stored trouble codes (mode 03) of all ECUs.
+func (c *Client) ReadDTC() (codes []ECUCodes, err error) {
+	responses, err := c.Request(ModeReadDTC)
+	if err != nil {
+		return
+	}
+	for _, r := range responses {
+		if len(r.Data) < 1 {
+			continue
+		}
+		// ISO 15765-4: the first byte is the number of DTCs
+		n := int(r.Data[0])
+		list := r.Data[1:]
+		if len(list) < 2*n {
+			err = fmt.Errorf("obd: ECU %X reported %d DTCs in %d bytes", r.ECU, n, len(list))
+			return
+		}
+		e := ECUCodes{ECU: r.ECU}
+		for i := 0; i < n; i++ {
+			d := DTC(uint16(list[2*i])<<8 | uint16(list[2*i+1]))
+			if 0 != d {
+				e.Codes = append(e.Codes, d)
+			}
+		}
+		codes = append(codes, e)
+	}
+	return
+}
+
+// ClearDTC clears trouble codes and stored diagnostic information (mode 04).
+func (c *Client) ClearDTC() (err error) {
+	_, err = c.Request(ModeClearDTC)
+	return
+}
+
+// VIN requests vehicle identification number (mode 09 PID 02).
+func (c *Client) VIN() (vin string, err error) {
+	responses, err := c.Request(ModeVehicleInfo, 0x02)
+	if err != nil {
+		return
+	}
+	for _, r := range responses {
+		// PID, number of data items, 17 characters
+		if len(r.Data) >= 2+17 && 0x02 == r.Data[0] {
+			vin = strings.TrimRight(string(r.Data[2:2+17]), "\x00")
+			return
+		}
+	}
+	err = ErrNoResponse
+	return
+}
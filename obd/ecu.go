@@ -0,0 +1,166 @@
+package obd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3/isotp"
+)
+
+// ECU is a simulated OBD-II responder for tests. It answers modes 01-04 and 09
+// using the values set in its fields. Fields may be changed while running
+// if guarded by Lock/Unlock.
+type ECU struct {
+	sync.Mutex
+	PIDs   map[byte][]byte // mode 01 data by PID
+	Frozen map[byte][]byte // mode 02 data (freeze frame 0) by PID
+	DTCs   []DTC
+	VIN    string
+
+	addr isotp.Addr
+	link *isotp.Link
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewECU creates a simulated ECU number n (0..7) on bus.
+// Supported PID masks (PID 00, 20...) are generated from PIDs.
+func NewECU(bus isotp.Bus, extended bool, n uint8) *ECU {
+	e := &ECU{
+		PIDs:   make(map[byte][]byte),
+		Frozen: make(map[byte][]byte),
+	}
+	if extended {
+		ecu := uint32(0x10 + n)
+		e.addr = isotp.Addr{TxID: physicalResp29 | ecu, RxID: physicalReq29 | ecu<<addressByteShift, Ext: true}
+	} else {
+		e.addr = isotp.Addr{TxID: PhysicalResponseID + uint32(n), RxID: PhysicalRequestID + uint32(n)}
+	}
+
+	functional := isotp.Addr{TxID: e.addr.TxID, RxID: FunctionalID11}
+	if extended {
+		functional = isotp.Addr{TxID: e.addr.TxID, RxID: FunctionalID29, Ext: true}
+	}
+	e.link = isotp.NewLink(bus, isotp.Fixed(e.addr, functional))
+	e.link.Padding = true
+	e.link.PadByte = 0x00
+	return e
+}
+
+// ResponseID returns the CAN identifier the ECU answers with.
+func (e *ECU) ResponseID() uint32 {
+	return e.addr.TxID
+}
+
+// Run starts answering requests.
+func (e *ECU) Run() {
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		for {
+			select {
+			case <-e.stop:
+				return
+			default:
+			}
+			p, err := e.link.Receive(50 * time.Millisecond)
+			if err != nil || 0 == len(p.Data) {
+				continue
+			}
+			if resp := e.answer(p.Data); nil != resp {
+				e.link.Send(e.addr, resp)
+			}
+		}
+	}()
+}
+
+// Stop stops answering requests and releases the bus.
+func (e *ECU) Stop() {
+	if nil != e.stop {
+		close(e.stop)
+		<-e.done
+		e.stop = nil
+	}
+	e.link.Close()
+}
+
+func (e *ECU) answer(req []byte) (resp []byte) {
+	e.Lock()
+	defer e.Unlock()
+
+	mode := req[0]
+	resp = []byte{mode | positiveResponse}
+	switch mode {
+	case ModeCurrentData, ModeFreezeFrame:
+		if len(req) < 2 {
+			return e.negative(mode, 0x13)
+		}
+		pid := req[1]
+		table := e.PIDs
+		if ModeFreezeFrame == mode {
+			table = e.Frozen
+		}
+		var data []byte
+		if 0 == pid%0x20 {
+			data = supportedMask(table, pid)
+		} else if value, ok := table[pid]; ok {
+			data = value
+		}
+		if nil == data {
+			return nil // unsupported PIDs are not answered
+		}
+		resp = append(resp, pid)
+		if ModeFreezeFrame == mode {
+			frame := byte(0)
+			if len(req) > 2 {
+				frame = req[2]
+			}
+			resp = append(resp, frame)
+		}
+		resp = append(resp, data...)
+	case ModeReadDTC:
+		resp = append(resp, byte(len(e.DTCs)))
+		for _, d := range e.DTCs {
+			resp = append(resp, byte(d>>8), byte(d))
+		}
+	case ModeClearDTC:
+		e.DTCs = nil
+		e.Frozen = make(map[byte][]byte)
+	case ModeVehicleInfo:
+		if len(req) < 2 || 0x02 != req[1] || "" == e.VIN {
+			return e.negative(mode, 0x12)
+		}
+		vin := make([]byte, 17)
+		copy(vin, e.VIN)
+		resp = append(resp, 0x02, 0x01)
+		resp = append(resp, vin...)
+	default:
+		return e.negative(mode, 0x11)
+	}
+	return
+}
+
+func (e *ECU) negative(mode, code byte) []byte {
+	return []byte{negativeResponse, mode, code}
+}
+
+// supportedMask builds the answer to "supported PIDs" request base.
+func supportedMask(table map[byte][]byte, base byte) []byte {
+	var mask uint32
+	for pid := range table {
+		if pid > base && int(pid) <= int(base)+0x20 {
+			mask |= 0x80000000 >> uint(pid-base-1)
+		}
+	}
+	for pid := range table {
+		if int(pid) > int(base)+0x20 {
+			mask |= 1 // next range is supported
+			break
+		}
+	}
+	if 0 == mask && 0 != base {
+		return nil
+	}
+	return []byte{byte(mask >> 24), byte(mask >> 16), byte(mask >> 8), byte(mask)}
+}
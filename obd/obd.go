@@ -0,0 +1,173 @@
+// Package obd implements SAE J1979 / ISO 15765-4 OBD-II diagnostic requests
+// over candev.Device using 11-bit or 29-bit functional addressing.
+package obd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amdf/ixxatvci3/isotp"
+)
+
+// OBD-II services (modes).
+const (
+	ModeCurrentData  = 0x01
+	ModeFreezeFrame  = 0x02
+	ModeReadDTC      = 0x03
+	ModeClearDTC     = 0x04
+	ModeVehicleInfo  = 0x09
+	positiveResponse = 0x40
+	negativeResponse = 0x7F
+)
+
+// 11-bit addressing (ISO 15765-4).
+const (
+	FunctionalID11     = 0x7DF
+	PhysicalRequestID  = 0x7E0 // ECU 1, up to 0x7E7
+	PhysicalResponseID = 0x7E8 // ECU 1, up to 0x7EF
+	responseOffset     = 8
+	ecuCount           = 8
+)
+
+// 29-bit addressing (ISO 15765-4, normal fixed addressing).
+const (
+	FunctionalID29   = 0x18DB33F1
+	physicalReq29    = 0x18DA00F1 // | ecu << 8
+	physicalResp29   = 0x18DAF100 // | ecu
+	testerAddress    = 0xF1
+	address29Mask    = 0xFFFFFF00
+	addressByteShift = 8
+)
+
+// DefaultTimeout is a default time to wait for responses (P2 max of ISO 15765-4 is 50 ms).
+const DefaultTimeout = 100 * time.Millisecond
+
+// Response is a positive response of a single ECU.
+type Response struct {
+	ECU  uint32 // response CAN identifier of the ECU
+	Data []byte // response without the service byte
+}
+
+// NegativeResponseError is returned when an ECU rejects a request.
+type NegativeResponseError struct {
+	ECU  uint32
+	Mode byte
+	Code byte
+}
+
+func (e *NegativeResponseError) Error() string {
+	return fmt.Sprintf("obd: ECU %X rejected mode %02X: NRC %02X", e.ECU, e.Mode, e.Code)
+}
+
+// ErrNoResponse is returned when no ECU answers.
+var ErrNoResponse = errors.New("obd: no response")
+
+// Client sends OBD-II requests and collects responses of all ECUs.
+type Client struct {
+	Timeout  time.Duration // response collection time, DefaultTimeout if 0
+	extended bool
+	link     *isotp.Link
+}
+
+// NewClient creates OBD-II client on bus (usually *candev.Device).
+// extended selects 29-bit addressing (0x18DB33F1), otherwise 11-bit (0x7DF).
+func NewClient(bus isotp.Bus, extended bool) *Client {
+	c := &Client{extended: extended}
+	c.link = isotp.NewLink(bus, responseResolver(extended))
+	c.link.Padding = true
+	c.link.PadByte = 0x00
+	return c
+}
+
+// Close stops receiving responses.
+func (c *Client) Close() {
+	if nil == c {
+		return
+	}
+	c.link.Close()
+}
+
+func responseResolver(extended bool) isotp.Resolver {
+	if extended {
+		return func(rxID uint32) (addr isotp.Addr, ok bool) {
+			if physicalResp29 != rxID&address29Mask {
+				return
+			}
+			ecu := rxID & 0xFF
+			addr = isotp.Addr{TxID: physicalReq29 | ecu<<addressByteShift, RxID: rxID, Ext: true}
+			ok = true
+			return
+		}
+	}
+	return func(rxID uint32) (addr isotp.Addr, ok bool) {
+		if rxID < PhysicalResponseID || rxID >= PhysicalResponseID+ecuCount {
+			return
+		}
+		addr = isotp.Addr{TxID: rxID - responseOffset, RxID: rxID}
+		ok = true
+		return
+	}
+}
+
+func (c *Client) functional() isotp.Addr {
+	if c.extended {
+		return isotp.Addr{TxID: FunctionalID29, Ext: true}
+	}
+	return isotp.Addr{TxID: FunctionalID11}
+}
+
+// Request sends a functional request and returns positive responses of all ECUs
+// answering within Timeout. If no ECU gives a positive response, the first
+// negative response is returned as *NegativeResponseError.
+func (c *Client) Request(mode byte, params ...byte) (responses []Response, err error) {
+	c.link.Flush()
+	if err = c.link.Send(c.functional(), append([]byte{mode}, params...)); err != nil {
+		return
+	}
+
+	timeout := c.Timeout
+	if 0 == timeout {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	var negative error
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			break
+		}
+		var p isotp.Payload
+		p, err = c.link.Receive(left)
+		if isotp.ErrTimeout == err {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if len(p.Data) >= 3 && negativeResponse == p.Data[0] && mode == p.Data[1] {
+			if 0x78 == p.Data[2] { // response pending
+				deadline = time.Now().Add(5 * time.Second)
+				continue
+			}
+			if nil == negative {
+				negative = &NegativeResponseError{ECU: p.From.RxID, Mode: mode, Code: p.Data[2]}
+			}
+			continue
+		}
+		if len(p.Data) < 1 || mode|positiveResponse != p.Data[0] {
+			continue
+		}
+		responses = append(responses, Response{ECU: p.From.RxID, Data: p.Data[1:]})
+	}
+
+	if 0 == len(responses) {
+		err = negative
+		if nil == err {
+			err = ErrNoResponse
+		}
+	}
+	return
+}
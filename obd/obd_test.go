@@ -0,0 +1,163 @@
+package obd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// addressing runs test with a client and two simulated ECUs in 11-bit and 29-bit addressing.
+func addressing(t *testing.T, test func(t *testing.T, c *Client, ecus []*ECU)) {
+	for _, extended := range []bool{false, true} {
+		name := "11bit"
+		if extended {
+			name = "29bit"
+		}
+		t.Run(name, func(t *testing.T) {
+			bus := new(candev.Loopback)
+			ecus := []*ECU{NewECU(bus, extended, 0), NewECU(bus, extended, 1)}
+			for _, e := range ecus {
+				e.Run()
+				defer e.Stop()
+			}
+			c := NewClient(bus, extended)
+			defer c.Close()
+			test(t, c, ecus)
+		})
+	}
+}
+
+func TestResponseIDs(t *testing.T) {
+	bus := new(candev.Loopback)
+	ids := []uint32{
+		NewECU(bus, false, 0).ResponseID(), NewECU(bus, false, 7).ResponseID(),
+		NewECU(bus, true, 0).ResponseID(), NewECU(bus, true, 1).ResponseID(),
+	}
+	if s := fmt.Sprintf("%X", ids); s != "[7E8 7EF 18DAF110 18DAF111]" {
+		t.Errorf("response IDs %s", s)
+	}
+}
+
+func TestCurrentData(t *testing.T) {
+	addressing(t, func(t *testing.T, c *Client, ecus []*ECU) {
+		ecus[0].Lock()
+		ecus[0].PIDs[0x0C] = []byte{0x1A, 0xF8} // 1726 rpm
+		ecus[0].PIDs[0x05] = []byte{0x7B}       // 83 °C
+		ecus[0].PIDs[0x42] = []byte{0x30, 0x00}
+		ecus[0].Unlock()
+		ecus[1].Lock()
+		ecus[1].PIDs[0x05] = []byte{0x50} // 40 °C
+		ecus[1].Unlock()
+
+		values, err := c.CurrentData(0x0C)
+		if err != nil || 1 != len(values) || values[0].ECU != ecus[0].ResponseID() || values[0].Value != 1726 {
+			t.Errorf("engine speed %v, %v", values, err)
+		}
+		values, err = c.CurrentData(0x05)
+		if err != nil || 2 != len(values) {
+			t.Fatalf("coolant temperature %v, %v", values, err)
+		}
+		temps := map[uint32]float64{values[0].ECU: values[0].Value, values[1].ECU: values[1].Value}
+		if temps[ecus[0].ResponseID()] != 83 || temps[ecus[1].ResponseID()] != 40 {
+			t.Errorf("coolant temperatures %v", temps)
+		}
+		if values, err = c.CurrentData(0x0D); err != ErrNoResponse {
+			t.Errorf("unsupported PID %v, %v", values, err)
+		}
+
+		pids, err := c.SupportedPIDs()
+		if err != nil || fmt.Sprintf("% X", pids) != "05 0C 42" {
+			t.Errorf("supported PIDs %X, %v", pids, err)
+		}
+	})
+}
+
+func TestVIN(t *testing.T) {
+	addressing(t, func(t *testing.T, c *Client, ecus []*ECU) {
+		if vin, err := c.VIN(); nil == err {
+			t.Errorf("VIN %q without a VIN", vin)
+		} else if nrc, ok := err.(*NegativeResponseError); !ok || nrc.Mode != ModeVehicleInfo || nrc.Code != 0x12 {
+			t.Errorf("VIN error %v", err)
+		}
+
+		ecus[1].Lock()
+		ecus[1].VIN = "WDB2030461A123456"
+		ecus[1].Unlock()
+		// the VIN answer is segmented, the client sends flow control to the ECU
+		if vin, err := c.VIN(); err != nil || vin != "WDB2030461A123456" {
+			t.Errorf("VIN %q, %v", vin, err)
+		}
+	})
+}
+
+func TestDTC(t *testing.T) {
+	addressing(t, func(t *testing.T, c *Client, ecus []*ECU) {
+		p0301, _ := ParseDTC("P0301")
+		u0100, _ := ParseDTC("U0100")
+		ecus[0].Lock()
+		ecus[0].DTCs = []DTC{p0301, u0100}
+		ecus[0].Frozen[0x0C] = []byte{0x0F, 0xA0}
+		ecus[0].Unlock()
+
+		codes, err := c.ReadDTC()
+		if err != nil || 2 != len(codes) {
+			t.Fatalf("ReadDTC %v, %v", codes, err)
+		}
+		for _, e := range codes {
+			want := "[]"
+			if e.ECU == ecus[0].ResponseID() {
+				want = "[P0301 U0100]"
+			}
+			if fmt.Sprint(e.Codes) != want {
+				t.Errorf("ECU %X codes %v, want %s", e.ECU, e.Codes, want)
+			}
+		}
+
+		values, err := c.FreezeFrame(0x0C, 0)
+		if err != nil || 1 != len(values) || values[0].Value != 1000 {
+			t.Errorf("freeze frame %v, %v", values, err)
+		}
+
+		if err = c.ClearDTC(); err != nil {
+			t.Fatal(err)
+		}
+		if codes, err = c.ReadDTC(); err != nil || 2 != len(codes) || len(codes[0].Codes)+len(codes[1].Codes) != 0 {
+			t.Errorf("ReadDTC after ClearDTC %v, %v", codes, err)
+		}
+		if _, err = c.FreezeFrame(0x0C, 0); err != ErrNoResponse {
+			t.Errorf("freeze frame after ClearDTC: %v", err)
+		}
+	})
+}
+
+func TestNegativeResponse(t *testing.T) {
+	addressing(t, func(t *testing.T, c *Client, ecus []*ECU) {
+		_, err := c.Request(0x22, 0xF1, 0x90)
+		if nrc, ok := err.(*NegativeResponseError); !ok || nrc.Code != 0x11 || nrc.Mode != 0x22 {
+			t.Errorf("unsupported service: %v", err)
+		}
+	})
+
+	c := NewClient(new(candev.Loopback), false)
+	defer c.Close()
+	c.Timeout = 20 * time.Millisecond
+	if _, err := c.Request(ModeCurrentData, 0x00); err != ErrNoResponse {
+		t.Errorf("request without ECUs: %v", err)
+	}
+}
+
+func TestDTCString(t *testing.T) {
+	for _, s := range []string{"P0301", "C1234", "B0ABC", "U3FFF"} {
+		d, err := ParseDTC(s)
+		if err != nil || d.String() != s {
+			t.Errorf("ParseDTC(%q) = %v, %v", s, d, err)
+		}
+	}
+	for _, s := range []string{"", "P030", "X0301", "P4301", "P03G1", "P0+01"} {
+		if _, err := ParseDTC(s); nil == err {
+			t.Errorf("ParseDTC(%q) accepted", s)
+		}
+	}
+}
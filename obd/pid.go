@@ -0,0 +1,168 @@
+package obd
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PID is a standard mode 01/02 parameter description.
+type PID struct {
+	Code   byte
+	Name   string
+	Unit   string
+	Bytes  int
+	Decode func(d []byte) float64
+}
+
+func a(d []byte) float64  { return float64(d[0]) }
+func ab(d []byte) float64 { return float64(uint16(d[0])<<8 | uint16(d[1])) }
+
+func percent(d []byte) float64     { return a(d) * 100 / 255 }
+func temperature(d []byte) float64 { return a(d) - 40 }
+func fuelTrim(d []byte) float64    { return a(d)*100/128 - 100 }
+
+// PIDs is the table of standard PIDs decoded by Value.
+var PIDs = map[byte]PID{
+	0x04: {0x04, "Calculated engine load", "%", 1, percent},
+	0x05: {0x05, "Engine coolant temperature", "°C", 1, temperature},
+	0x06: {0x06, "Short term fuel trim, bank 1", "%", 1, fuelTrim},
+	0x07: {0x07, "Long term fuel trim, bank 1", "%", 1, fuelTrim},
+	0x08: {0x08, "Short term fuel trim, bank 2", "%", 1, fuelTrim},
+	0x09: {0x09, "Long term fuel trim, bank 2", "%", 1, fuelTrim},
+	0x0A: {0x0A, "Fuel pressure", "kPa", 1, func(d []byte) float64 { return a(d) * 3 }},
+	0x0B: {0x0B, "Intake manifold absolute pressure", "kPa", 1, a},
+	0x0C: {0x0C, "Engine speed", "rpm", 2, func(d []byte) float64 { return ab(d) / 4 }},
+	0x0D: {0x0D, "Vehicle speed", "km/h", 1, a},
+	0x0E: {0x0E, "Timing advance", "° before TDC", 1, func(d []byte) float64 { return a(d)/2 - 64 }},
+	0x0F: {0x0F, "Intake air temperature", "°C", 1, temperature},
+	0x10: {0x10, "Mass air flow rate", "g/s", 2, func(d []byte) float64 { return ab(d) / 100 }},
+	0x11: {0x11, "Throttle position", "%", 1, percent},
+	0x1F: {0x1F, "Run time since engine start", "s", 2, ab},
+	0x21: {0x21, "Distance traveled with MIL on", "km", 2, ab},
+	0x22: {0x22, "Fuel rail pressure (relative to manifold vacuum)", "kPa", 2, func(d []byte) float64 { return ab(d) * 0.079 }},
+	0x23: {0x23, "Fuel rail gauge pressure", "kPa", 2, func(d []byte) float64 { return ab(d) * 10 }},
+	0x2C: {0x2C, "Commanded EGR", "%", 1, percent},
+	0x2F: {0x2F, "Fuel tank level input", "%", 1, percent},
+	0x30: {0x30, "Warm-ups since codes cleared", "", 1, a},
+	0x31: {0x31, "Distance traveled since codes cleared", "km", 2, ab},
+	0x33: {0x33, "Absolute barometric pressure", "kPa", 1, a},
+	0x42: {0x42, "Control module voltage", "V", 2, func(d []byte) float64 { return ab(d) / 1000 }},
+	0x43: {0x43, "Absolute load value", "%", 2, func(d []byte) float64 { return ab(d) * 100 / 255 }},
+	0x44: {0x44, "Commanded air-fuel equivalence ratio", "", 2, func(d []byte) float64 { return ab(d) / 32768 }},
+	0x45: {0x45, "Relative throttle position", "%", 1, percent},
+	0x46: {0x46, "Ambient air temperature", "°C", 1, temperature},
+	0x49: {0x49, "Accelerator pedal position D", "%", 1, percent},
+	0x4A: {0x4A, "Accelerator pedal position E", "%", 1, percent},
+	0x4C: {0x4C, "Commanded throttle actuator", "%", 1, percent},
+	0x4D: {0x4D, "Time run with MIL on", "min", 2, ab},
+	0x4E: {0x4E, "Time since trouble codes cleared", "min", 2, ab},
+	0x51: {0x51, "Fuel type", "", 1, a},
+	0x52: {0x52, "Ethanol fuel", "%", 1, percent},
+	0x5A: {0x5A, "Relative accelerator pedal position", "%", 1, percent},
+	0x5B: {0x5B, "Hybrid battery pack remaining life", "%", 1, percent},
+	0x5C: {0x5C, "Engine oil temperature", "°C", 1, temperature},
+	0x5E: {0x5E, "Engine fuel rate", "L/h", 2, func(d []byte) float64 { return ab(d) / 20 }},
+	0x61: {0x61, "Driver's demand engine percent torque", "%", 1, func(d []byte) float64 { return a(d) - 125 }},
+	0x62: {0x62, "Actual engine percent torque", "%", 1, func(d []byte) float64 { return a(d) - 125 }},
+	0x63: {0x63, "Engine reference torque", "Nm", 2, ab},
+	0xA6: {0xA6, "Odometer", "km", 4, func(d []byte) float64 {
+		return float64(uint32(d[0])<<24|uint32(d[1])<<16|uint32(d[2])<<8|uint32(d[3])) / 10
+	}},
+}
+
+// Value is a decoded PID value of a single ECU.
+type Value struct {
+	ECU   uint32
+	PID   PID
+	Raw   []byte
+	Value float64
+}
+
+func (v Value) String() string {
+	return fmt.Sprintf("%s: %g %s", v.PID.Name, v.Value, v.PID.Unit)
+}
+
+// CurrentData requests mode 01 PID and decodes the answers of all ECUs.
+func (c *Client) CurrentData(pid byte) (values []Value, err error) {
+	responses, err := c.Request(ModeCurrentData, pid)
+	if err != nil {
+		return
+	}
+	values, err = decodePID(pid, responses, 1)
+	return
+}
+
+// FreezeFrame requests mode 02 PID of freeze frame number frame.
+func (c *Client) FreezeFrame(pid byte, frame byte) (values []Value, err error) {
+	responses, err := c.Request(ModeFreezeFrame, pid, frame)
+	if err != nil {
+		return
+	}
+	values, err = decodePID(pid, responses, 2)
+	return
+}
+
+// decodePID decodes responses "PID [frame] data...", skip is the number of bytes before data.
+func decodePID(pid byte, responses []Response, skip int) (values []Value, err error) {
+	desc, known := PIDs[pid]
+	for _, r := range responses {
+		if len(r.Data) < skip || r.Data[0] != pid {
+			continue
+		}
+		v := Value{ECU: r.ECU, PID: desc, Raw: r.Data[skip:]}
+		if !known {
+			v.PID = PID{Code: pid, Name: fmt.Sprintf("PID %02X", pid), Bytes: len(v.Raw)}
+		} else if len(v.Raw) < desc.Bytes {
+			err = fmt.Errorf("obd: ECU %X PID %02X: %d bytes, %d expected", r.ECU, pid, len(v.Raw), desc.Bytes)
+			return
+		} else {
+			v.Raw = v.Raw[:desc.Bytes]
+			v.Value = desc.Decode(v.Raw)
+		}
+		values = append(values, v)
+	}
+	if 0 == len(values) {
+		err = ErrNoResponse
+	}
+	return
+}
+
+// SupportedPIDs returns mode 01 PIDs supported by any ECU, asking PIDs 00, 20, 40...
+func (c *Client) SupportedPIDs() (pids []byte, err error) {
+	found := make(map[byte]bool)
+	for base := 0; base < 0x100; base += 0x20 {
+		var responses []Response
+		responses, err = c.Request(ModeCurrentData, byte(base))
+		if err != nil {
+			if 0 != base && ErrNoResponse == err {
+				err = nil
+			}
+			break
+		}
+		next := false
+		for _, r := range responses {
+			if len(r.Data) < 5 || r.Data[0] != byte(base) {
+				continue
+			}
+			mask := uint32(r.Data[1])<<24 | uint32(r.Data[2])<<16 | uint32(r.Data[3])<<8 | uint32(r.Data[4])
+			for i := uint(0); i < 32; i++ {
+				if 0 != mask&(0x80000000>>i) {
+					found[byte(base+int(i)+1)] = true
+				}
+			}
+			if 0 != mask&1 {
+				next = true
+			}
+		}
+		if !next {
+			break
+		}
+	}
+	for pid := range found {
+		if 0 != pid%0x20 { // "supported PIDs" PIDs themselves are not listed
+			pids = append(pids, pid)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return
+}
@@ -0,0 +1,86 @@
+package ixxatvci3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpMode is a set of operating mode flags, the VCI3 CAN_OPMODE_* values and later additions.
+type OpMode uint8
+
+// Operating modes
+const (
+	OpModeStandard   OpMode = opmodeSTANDARD // reception of 11-bit id messages
+	OpModeExtended   OpMode = opmodeEXTENDED // reception of 29-bit id messages
+	OpModeErrFrame   OpMode = opmodeERRFRAME // reception of error frames
+	OpModeListenOnly OpMode = opmodeLISTONLY // listen only mode (TX passive)
+	OpModeLowSpeed   OpMode = opmodeLOWSPEED // low speed bus interface
+	OpModeAutoBaud   OpMode = 0x20           // bitrate detection by the controller (VCI4 CAN_OPMODE_AUTOBAUD)
+	OpModeFD         OpMode = 0x80           // CAN FD frames
+)
+
+// opModeNames are the tokens of ParseOpMode, the first one of a mode is used by String.
+var opModeNames = []struct {
+	mode  OpMode
+	names []string
+}{
+	{OpModeStandard, []string{"11bit", "standard", "base"}},
+	{OpModeExtended, []string{"29bit", "extended"}},
+	{OpModeErrFrame, []string{"err", "errframe"}},
+	{OpModeListenOnly, []string{"listen", "listenonly", "listonly"}},
+	{OpModeLowSpeed, []string{"low", "lowspeed"}},
+	{OpModeAutoBaud, []string{"autobaud"}},
+	{OpModeFD, []string{"fd"}},
+}
+
+// ParseOpMode parses comma separated mode names:
+//
+//	"11bit" or "standard" or "base",
+//	"29bit" or "extended",
+//	"err" or "errframe",
+//	"listen" or "listenonly" or "listonly",
+//	"low" or "lowspeed",
+//	"autobaud",
+//	"fd".
+//
+// Empty names are skipped, so "" and "," are 11-bit mode, the default of SetOperatingMode.
+// Unknown names are errors, e.g. "11bit,29bit,lowlisten".
+func ParseOpMode(s string) (mode OpMode, err error) {
+	for _, token := range strings.Split(s, ",") {
+		name := strings.ToLower(strings.TrimSpace(token))
+		if "" == name {
+			continue
+		}
+		found := false
+		for _, m := range opModeNames {
+			for _, n := range m.names {
+				if n == name {
+					mode |= m.mode
+					found = true
+				}
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown operating mode %q", token)
+		}
+	}
+	if 0 == mode {
+		mode = OpModeStandard
+	}
+	return
+}
+
+// String returns the mode in the syntax of ParseOpMode, e.g. "11bit,29bit".
+func (m OpMode) String() string {
+	var names []string
+	for _, n := range opModeNames {
+		if m&n.mode != 0 {
+			names = append(names, n.names[0])
+			m &^= n.mode
+		}
+	}
+	if m != 0 {
+		names = append(names, fmt.Sprintf("0x%02X", uint8(m)))
+	}
+	return strings.Join(names, ",")
+}
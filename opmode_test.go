@@ -0,0 +1,38 @@
+package ixxatvci3
+
+import "testing"
+
+func TestParseOpMode(t *testing.T) {
+	modes := []struct {
+		s    string
+		want OpMode
+	}{
+		{"", OpModeStandard},
+		{",", OpModeStandard},
+		{"11bit", OpModeStandard},
+		{"29bit", OpModeExtended},
+		{"11bit,29bit,", OpModeStandard | OpModeExtended},
+		{" Standard , listenonly", OpModeStandard | OpModeListenOnly},
+		{"base,err,low,autobaud,fd", OpModeStandard | OpModeErrFrame | OpModeLowSpeed | OpModeAutoBaud | OpModeFD},
+	}
+	for _, m := range modes {
+		mode, err := ParseOpMode(m.s)
+		if err != nil || mode != m.want {
+			t.Errorf("ParseOpMode(%q) = %v, %v, want %v", m.s, mode, err, m.want)
+		}
+	}
+	for _, s := range []string{"11bit,29bit,lowlisten", "11bit;29bit"} {
+		if _, err := ParseOpMode(s); nil == err {
+			t.Errorf("ParseOpMode(%q) accepted", s)
+		}
+	}
+}
+
+func TestOpModeString(t *testing.T) {
+	if s := (OpModeStandard | OpModeExtended | OpModeListenOnly).String(); s != "11bit,29bit,listen" {
+		t.Errorf("String = %q", s)
+	}
+	if mode, err := ParseOpMode((OpModeErrFrame | OpModeFD).String()); err != nil || mode != OpModeErrFrame|OpModeFD {
+		t.Errorf("round trip = %v, %v", mode, err)
+	}
+}
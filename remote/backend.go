@@ -0,0 +1,470 @@
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// rxQueueSize is a number of frames buffered between the connection and Receive.
+const rxQueueSize = 1024
+
+// Backend is an ixxatvci3 backend using a Server over the binary protocol.
+// It reconnects after connection loss and restores filters, frames sent
+// by the bus in the meantime are lost.
+type Backend struct {
+	// Addr is the server address, host[:port].
+	Addr string
+	// Token is the authentication token of the server.
+	Token string
+	// Filters selects frames the server sends to the client, all frames if empty.
+	// Set them before OpenDevice or change with SetFilters.
+	Filters []candev.Filter
+	// DialTimeout limits connection time, 5 seconds by default.
+	DialTimeout time.Duration
+	// CommandTimeout limits the wait for an answer of the server, 5 seconds by default.
+	CommandTimeout time.Duration
+	// MaxReconnectDelay is the limit of the exponentially growing delay between reconnects, 5 seconds by default.
+	MaxReconnectDelay time.Duration
+	// OnConnect is called after every connection attempt, err is nil if it succeeded,
+	// and with connected false and the reason on connection loss.
+	OnConnect func(connected bool, err error)
+	// Logger receives connection changes, ixxatvci3.DeviceLogger of the device if nil.
+	Logger ixxatvci3.Logger
+
+	rx      chan candev.Message
+	results chan []byte
+	status  chan []byte
+	done    chan struct{}
+
+	muCmd sync.Mutex // one command at a time
+
+	mu         sync.Mutex
+	devnum     uint8
+	conn       net.Conn
+	bitrate    ixxatvci3.BitrateRegisterPair
+	listenOnly bool
+	started    bool
+	closed     bool
+}
+
+func init() {
+	ixxatvci3.RegisterBackend("remote", func(cfg ixxatvci3.BackendConfig) (ixxatvci3.Backend, error) {
+		return New(cfg.Address, cfg.Token), nil
+	})
+}
+
+// New creates a backend for the server at addr.
+func New(addr, token string) *Backend {
+	return &Backend{Addr: addr, Token: token}
+}
+
+// ErrDisconnected is returned while there is no connection to the server.
+var ErrDisconnected = errors.New("remote: disconnected")
+
+func (b *Backend) address() string {
+	if _, _, err := net.SplitHostPort(b.Addr); err != nil {
+		return net.JoinHostPort(strings.Trim(b.Addr, "[]"), DefaultPort)
+	}
+	return b.Addr
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// logger returns the logger and the fields of the backend connection.
+func (b *Backend) logger() (l ixxatvci3.Logger, fields []interface{}) {
+	b.mu.Lock()
+	devnum := b.devnum
+	b.mu.Unlock()
+	l = b.Logger
+	if nil == l {
+		l = ixxatvci3.DeviceLogger(devnum)
+	}
+	return l, []interface{}{"device", devnum, "op", "connect", "address", b.address()}
+}
+
+// connectEvent calls OnConnect after a connection attempt and logs it:
+// connections at info level, failures at debug level as reconnects repeat them.
+func (b *Backend) connectEvent(connected bool, err error) {
+	if b.OnConnect != nil {
+		b.OnConnect(connected, err)
+	}
+	l, fields := b.logger()
+	if connected {
+		l.Info("remote connected", fields...)
+	} else {
+		l.Debug("remote connection failed", append(fields, "error", err)...)
+	}
+}
+
+// lost calls OnConnect and logs a warning on connection loss.
+func (b *Backend) lost(err error) {
+	if b.OnConnect != nil {
+		b.OnConnect(false, err)
+	}
+	l, fields := b.logger()
+	l.Warn("remote connection lost", append(fields, "error", err)...)
+}
+
+// connect dials the server and passes the hello exchange.
+func (b *Backend) connect() (conn net.Conn, r *bufio.Reader, bitrate ixxatvci3.BitrateRegisterPair, err error) {
+	conn, err = net.DialTimeout("tcp", b.address(), durationOr(b.DialTimeout, 5*time.Second))
+	if err != nil {
+		return
+	}
+	fail := func(e error) {
+		conn.Close()
+		conn, err = nil, e
+	}
+	conn.SetDeadline(time.Now().Add(durationOr(b.CommandTimeout, 5*time.Second)))
+	if err = writePacket(conn, pktHello, append([]byte{protocolVersion}, b.Token...)); err != nil {
+		fail(err)
+		return
+	}
+	r = bufio.NewReader(conn)
+	typ, payload, err := readPacket(r)
+	switch {
+	case err != nil:
+		fail(err)
+		return
+	case pktError == typ:
+		fail(remoteError(payload))
+		return
+	case typ != pktWelcome || len(payload) < 2:
+		fail(ErrProtocol)
+		return
+	}
+	bitrate = ixxatvci3.BitrateRegisterPair{Btr0: payload[0], Btr1: payload[1]}
+	b.mu.Lock()
+	filters := b.Filters
+	b.mu.Unlock()
+	if len(filters) > 0 {
+		if err = writePacket(conn, pktFilters, encodeFilters(filters)); err != nil {
+			fail(err)
+			return
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return
+}
+
+// readerThread dispatches server packets and reconnects on errors until CloseDevice.
+func (b *Backend) readerThread(conn net.Conn, r *bufio.Reader) {
+	delay := 100 * time.Millisecond
+	for {
+		err := b.readPackets(r)
+		conn.Close()
+		b.mu.Lock()
+		b.conn = nil
+		closed := b.closed
+		b.mu.Unlock()
+		if closed {
+			return
+		}
+		b.lost(err)
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-time.After(delay):
+			}
+			var bitrate ixxatvci3.BitrateRegisterPair
+			conn, r, bitrate, err = b.connect()
+			b.connectEvent(nil == err, err)
+			if nil == err {
+				b.mu.Lock()
+				if b.closed {
+					b.mu.Unlock()
+					conn.Close()
+					return
+				}
+				b.conn, b.bitrate = conn, bitrate
+				b.mu.Unlock()
+				delay = 100 * time.Millisecond
+				break
+			}
+			if delay *= 2; delay > durationOr(b.MaxReconnectDelay, 5*time.Second) {
+				delay = durationOr(b.MaxReconnectDelay, 5*time.Second)
+			}
+		}
+	}
+}
+
+func (b *Backend) readPackets(r *bufio.Reader) error {
+	for {
+		typ, payload, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case pktFrame:
+			msg, err := decodeFrame(payload)
+			if err != nil {
+				return err
+			}
+			select {
+			case b.rx <- msg:
+			default: // overrun, Receive is not called
+			}
+		case pktResult:
+			answer(b.results, payload)
+		case pktStatus:
+			answer(b.status, payload)
+		case pktError:
+			return remoteError(payload)
+		default:
+			return ErrProtocol
+		}
+	}
+}
+
+// answer passes payload to a command, nobody waits for it if the command timed out.
+func answer(ch chan []byte, payload []byte) {
+	select {
+	case ch <- payload:
+	default:
+	}
+}
+
+// command sends a packet and waits for the answer on ch.
+func (b *Backend) command(typ byte, payload []byte, ch chan []byte) (answer []byte, vcierr uint32) {
+	b.muCmd.Lock()
+	defer b.muCmd.Unlock()
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+	if nil == conn {
+		return nil, ixxatvci3.VCI_E_DISCONNECTED
+	}
+	// drop answers to timed out commands
+	for len(ch) > 0 {
+		<-ch
+	}
+	conn.SetWriteDeadline(time.Now().Add(durationOr(b.CommandTimeout, 5*time.Second)))
+	if err := writePacket(conn, typ, payload); err != nil {
+		conn.Close()
+		return nil, ixxatvci3.VCI_E_DISCONNECTED
+	}
+	timer := time.NewTimer(durationOr(b.CommandTimeout, 5*time.Second))
+	defer timer.Stop()
+	select {
+	case answer = <-ch:
+	case <-timer.C:
+		vcierr = ixxatvci3.VCI_E_TIMEOUT
+	case <-b.done:
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+	}
+	return
+}
+
+// SetFilters replaces the frame filters, also on the server if connected.
+func (b *Backend) SetFilters(filters []candev.Filter) (err error) {
+	b.mu.Lock()
+	b.Filters = filters
+	conn := b.conn
+	b.mu.Unlock()
+	if conn != nil {
+		b.muCmd.Lock()
+		err = writePacket(conn, pktFilters, encodeFilters(filters))
+		b.muCmd.Unlock()
+	}
+	return
+}
+
+// SelectDevice connects to the server. The select dialog is not supported.
+func (b *Backend) SelectDevice(userselect bool, devnum uint8) (vcierr uint32) {
+	if userselect {
+		return ixxatvci3.VCI_E_NOT_IMPLEMENTED
+	}
+	b.mu.Lock()
+	started := b.started
+	b.mu.Unlock()
+	if started {
+		return ixxatvci3.VCI_E_ALREADY_INITIALIZED
+	}
+	b.mu.Lock()
+	b.devnum = devnum
+	b.mu.Unlock()
+	conn, r, bitrate, err := b.connect()
+	b.connectEvent(nil == err, err)
+	if err != nil {
+		if _, ok := err.(remoteError); ok {
+			return ixxatvci3.VCI_E_ACCESSDENIED
+		}
+		return ixxatvci3.VCI_E_FAIL
+	}
+	b.mu.Lock()
+	b.conn, b.bitrate, b.started, b.closed = conn, bitrate, true, false
+	b.rx = make(chan candev.Message, rxQueueSize)
+	b.results = make(chan []byte, 1)
+	b.status = make(chan []byte, 1)
+	b.done = make(chan struct{})
+	b.mu.Unlock()
+	go b.readerThread(conn, r)
+	return ixxatvci3.VCI_OK
+}
+
+// SetOpMode remembers listen only mode: Send is rejected in it.
+// The other modes are defined by the server device.
+func (b *Backend) SetOpMode(devnum uint8, mode ixxatvci3.OpMode) (vcierr uint32) {
+	b.mu.Lock()
+	b.listenOnly = mode&ixxatvci3.OpModeListenOnly != 0
+	b.mu.Unlock()
+	return ixxatvci3.VCI_OK
+}
+
+// OpenChannel checks that the server channel has the requested bitrate, if the server reports it.
+func (b *Backend) OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.started {
+		return ixxatvci3.VCI_E_NOT_INITIALIZED
+	}
+	known := b.bitrate != ixxatvci3.BitrateRegisterPair{}
+	if known && (b.bitrate.Btr0 != btr0 || b.bitrate.Btr1 != btr1) {
+		return ixxatvci3.VCI_E_INVALIDARG
+	}
+	return ixxatvci3.VCI_OK
+}
+
+// OpenChannelDetectBitrate returns the bitrate of the server channel if it is one of bitrate.
+func (b *Backend) OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []ixxatvci3.BitrateRegisterPair) (detected ixxatvci3.BitrateRegisterPair, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.started {
+		err = errors.New(ixxatvci3.GetErrorText(ixxatvci3.VCI_E_NOT_INITIALIZED))
+		return
+	}
+	for _, brp := range bitrate {
+		if brp == b.bitrate {
+			detected = brp
+			return
+		}
+	}
+	err = errors.New("bitrate not detected")
+	return
+}
+
+// Send transmits a frame through the server and returns the result of its transmission.
+func (b *Backend) Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
+	if len(msgdata) > 8 {
+		return ixxatvci3.VCI_E_INVALIDARG
+	}
+	b.mu.Lock()
+	listenOnly, started := b.listenOnly, b.started
+	b.mu.Unlock()
+	if !started {
+		return ixxatvci3.VCI_E_NOT_INITIALIZED
+	}
+	if listenOnly {
+		return ixxatvci3.VCI_E_ACCESSDENIED
+	}
+	msg := candev.Message{ID: msgid &^ (1 << 31), Ext: msgid&(1<<31) != 0, Rtr: rtr, Len: uint8(len(msgdata))}
+	copy(msg.Data[:], msgdata)
+	answer, vcierr := b.command(pktSend, encodeFrame(msg), b.results)
+	if ixxatvci3.VCI_OK == vcierr {
+		if len(answer) < 4 {
+			return ixxatvci3.VCI_E_UNEXPECTED
+		}
+		vcierr = binary.BigEndian.Uint32(answer)
+	}
+	return
+}
+
+// Receive waits for a frame, also while reconnecting. The high bit of msgid is set for 29-bit frames.
+func (b *Backend) Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+	b.mu.Lock()
+	rx, done := b.rx, b.done
+	b.mu.Unlock()
+	if nil == rx {
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+		return
+	}
+	select {
+	case msg := <-rx:
+		msgid = msg.ID
+		if msg.Ext || msg.ID > 0x7FF {
+			msgid |= 1 << 31
+		}
+		return ixxatvci3.VCI_OK, msgid, msg.Rtr, msg.Data, msg.Len
+	case <-done:
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+	}
+	return
+}
+
+// ReceiveMessage waits for a frame with its server timestamp and frame format.
+// Do not mix it with Receive on the same backend.
+func (b *Backend) ReceiveMessage() (msg candev.Message, err error) {
+	b.mu.Lock()
+	rx, done := b.rx, b.done
+	b.mu.Unlock()
+	if nil == rx {
+		err = ErrDisconnected
+		return
+	}
+	select {
+	case msg = <-rx:
+	case <-done:
+		err = ErrDisconnected
+	}
+	return
+}
+
+// GetStatus returns the status of the server channel.
+// The receive FIFO load is the load of the client queue.
+func (b *Backend) GetStatus(devnum uint8) (status ixxatvci3.CANChanStatus, vcierr uint32) {
+	b.mu.Lock()
+	started := b.started
+	b.mu.Unlock()
+	if !started {
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+		return
+	}
+	answer, vcierr := b.command(pktQuery, nil, b.status)
+	if vcierr != ixxatvci3.VCI_OK {
+		return
+	}
+	status, vcierr, err := decodeStatus(answer)
+	if err != nil {
+		vcierr = ixxatvci3.VCI_E_UNEXPECTED
+		return
+	}
+	status.RxFifoLoad = uint8(100 * len(b.rx) / cap(b.rx))
+	return
+}
+
+// Connected reports whether the connection to the server is up.
+func (b *Backend) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.conn != nil
+}
+
+// CloseDevice disconnects from the server and stops reconnecting.
+func (b *Backend) CloseDevice(devnum uint8) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.started {
+		return ixxatvci3.VCI_E_NOT_INITIALIZED
+	}
+	b.started, b.closed = false, true
+	close(b.done)
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+	return ixxatvci3.VCI_OK
+}
@@ -0,0 +1,195 @@
+// Package remote shares an opened CAN channel over TCP.
+//
+// Server exposes a bus (a candev.Device or candev.Loopback) to network clients
+// with a framed binary protocol or the socketcand ASCII protocol.
+// Backend is an ixxatvci3 backend using such a server, so programs on another
+// machine use a remote adapter with the usual OpenDevice/Send/Receive calls:
+//
+//	ixxatvci3.UseBackend(0, remote.New("bench:29536", "secret"))
+//	dev, err := new(candev.Builder).Speed(ixxatvci3.Bitrate500kbps).Get()
+//
+// Importing the package registers the "remote" backend of ixxatvci3.NewBackend.
+//
+// Binary protocol packets are a type byte, a big-endian uint16 payload length and the payload:
+//
+//	'H' hello          client: version byte, token
+//	'W' welcome        server: btr0, btr1 of the channel (0, 0 if unknown)
+//	'E' error          server: text, the connection is closed after it
+//	'L' filters        client: n * (id uint32, mask uint32, flags byte), empty - all frames
+//	'S' send           client: frame
+//	'R' send result    server: vcierr uint32
+//	'Q' status request client: empty
+//	'T' status         server: vcierr uint32, status
+//	'F' frame          server: frame
+//
+// A frame is flags byte (0x01 - 29-bit, 0x02 - RTR), id uint32, time int64 (Unix ns), length byte and data.
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// DefaultPort is a TCP port of the server used when an address has no port.
+const DefaultPort = "29536"
+
+// protocolVersion is sent in the hello packet.
+const protocolVersion = 1
+
+// Packet types.
+const (
+	pktHello   = 'H'
+	pktWelcome = 'W'
+	pktError   = 'E'
+	pktFilters = 'L'
+	pktSend    = 'S'
+	pktResult  = 'R'
+	pktQuery   = 'Q'
+	pktStatus  = 'T'
+	pktFrame   = 'F'
+)
+
+// Frame flags.
+const (
+	flagExt = 0x01
+	flagRtr = 0x02
+)
+
+const (
+	frameHeaderSize = 1 + 4 + 8 + 1
+	filterSize      = 4 + 4 + 1
+	statusSize      = 4 + 4 + 4
+)
+
+// ErrProtocol is returned for malformed packets.
+var ErrProtocol = errors.New("remote: protocol error")
+
+func writePacket(w io.Writer, typ byte, payload []byte) (err error) {
+	if len(payload) > 0xFFFF {
+		return ErrProtocol
+	}
+	var hdr [3]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint16(hdr[1:], uint16(len(payload)))
+	if _, err = w.Write(hdr[:]); err != nil {
+		return
+	}
+	_, err = w.Write(payload)
+	return
+}
+
+func readPacket(r *bufio.Reader) (typ byte, payload []byte, err error) {
+	var hdr [3]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	typ = hdr[0]
+	payload = make([]byte, binary.BigEndian.Uint16(hdr[1:]))
+	_, err = io.ReadFull(r, payload)
+	return
+}
+
+func encodeFrame(msg candev.Message) []byte {
+	b := make([]byte, frameHeaderSize+int(msg.Len))
+	if msg.Ext || msg.ID > 0x7FF {
+		b[0] |= flagExt
+	}
+	if msg.Rtr {
+		b[0] |= flagRtr
+	}
+	binary.BigEndian.PutUint32(b[1:], msg.ID)
+	if !msg.Time.IsZero() {
+		binary.BigEndian.PutUint64(b[5:], uint64(msg.Time.UnixNano()))
+	}
+	b[13] = msg.Len
+	copy(b[frameHeaderSize:], msg.Data[:msg.Len])
+	return b
+}
+
+func decodeFrame(b []byte) (msg candev.Message, err error) {
+	if len(b) < frameHeaderSize || b[13] > 8 || len(b) != frameHeaderSize+int(b[13]) {
+		err = ErrProtocol
+		return
+	}
+	msg.Ext = b[0]&flagExt != 0
+	msg.Rtr = b[0]&flagRtr != 0
+	msg.ID = binary.BigEndian.Uint32(b[1:])
+	if ns := int64(binary.BigEndian.Uint64(b[5:])); ns != 0 {
+		msg.Time = time.Unix(0, ns)
+	}
+	msg.Len = b[13]
+	copy(msg.Data[:], b[frameHeaderSize:])
+	return
+}
+
+func encodeFilters(filters []candev.Filter) []byte {
+	b := make([]byte, 0, filterSize*len(filters))
+	for _, f := range filters {
+		var rec [filterSize]byte
+		binary.BigEndian.PutUint32(rec[0:], f.ID)
+		binary.BigEndian.PutUint32(rec[4:], f.Mask)
+		if f.Invert {
+			rec[8] = 1
+		}
+		b = append(b, rec[:]...)
+	}
+	return b
+}
+
+func decodeFilters(b []byte) (filters []candev.Filter, err error) {
+	if len(b)%filterSize != 0 {
+		err = ErrProtocol
+		return
+	}
+	for ; len(b) > 0; b = b[filterSize:] {
+		filters = append(filters, candev.Filter{
+			ID:     binary.BigEndian.Uint32(b[0:]),
+			Mask:   binary.BigEndian.Uint32(b[4:]),
+			Invert: b[8] != 0,
+		})
+	}
+	return
+}
+
+func encodeStatus(st ixxatvci3.CANChanStatus, vcierr uint32) []byte {
+	b := make([]byte, 4+statusSize)
+	binary.BigEndian.PutUint32(b[0:], vcierr)
+	ls := st.LineStatus
+	b[4], b[5], b[6], b[7] = ls.OpMode, ls.BtReg0, ls.BtReg1, ls.BusLoad
+	binary.BigEndian.PutUint32(b[8:], ls.Status)
+	b[12], b[13], b[14], b[15] = byte(st.Activated), byte(st.RxOverrun), st.RxFifoLoad, st.TxFifoLoad
+	return b
+}
+
+func decodeStatus(b []byte) (st ixxatvci3.CANChanStatus, vcierr uint32, err error) {
+	if len(b) < 4+statusSize {
+		err = ErrProtocol
+		return
+	}
+	vcierr = binary.BigEndian.Uint32(b[0:])
+	ls := &st.LineStatus
+	ls.OpMode, ls.BtReg0, ls.BtReg1, ls.BusLoad = b[4], b[5], b[6], b[7]
+	ls.Status = binary.BigEndian.Uint32(b[8:])
+	st.Activated, st.RxOverrun, st.RxFifoLoad, st.TxFifoLoad = uint32(b[12]), uint32(b[13]), b[14], b[15]
+	return
+}
+
+func encodeUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// remoteError is an error text sent by the server.
+type remoteError string
+
+func (e remoteError) Error() string {
+	return fmt.Sprintf("remote: %s", string(e))
+}
@@ -0,0 +1,309 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// pipeClient is the client end of a connection served by serveBinary.
+type pipeClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	result chan error
+}
+
+func servePipe(t *testing.T, s *Server) *pipeClient {
+	server, client := net.Pipe()
+	c := &pipeClient{t: t, conn: client, r: bufio.NewReader(client), result: make(chan error, 1)}
+	go func() {
+		err := s.serveBinary(server)
+		server.Close()
+		c.result <- err
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+// write sends a packet in one write: a pipe write of an empty payload waits for a read.
+func (c *pipeClient) write(typ byte, payload []byte) {
+	c.t.Helper()
+	var buf bytes.Buffer
+	writePacket(&buf, typ, payload)
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		c.t.Fatalf("write %c: %v", typ, err)
+	}
+}
+
+// read returns the next packet other than a frame, frames read before it are returned too.
+func (c *pipeClient) read() (typ byte, payload []byte, frames []candev.Message) {
+	c.t.Helper()
+	for {
+		var err error
+		if typ, payload, err = readPacket(c.r); err != nil {
+			c.t.Fatalf("read: %v", err)
+		}
+		if typ != pktFrame {
+			return
+		}
+		msg, err := decodeFrame(payload)
+		if err != nil {
+			c.t.Fatalf("frame % X: %v", payload, err)
+		}
+		frames = append(frames, msg)
+	}
+}
+
+func (c *pipeClient) hello(token string) {
+	c.t.Helper()
+	c.write(pktHello, append([]byte{protocolVersion}, token...))
+	if typ, payload, _ := c.read(); typ != pktWelcome {
+		c.t.Fatalf("hello answered with %c %q", typ, payload)
+	}
+}
+
+// end closes the client and returns the result of serveBinary.
+func (c *pipeClient) end() error {
+	c.conn.Close()
+	select {
+	case err := <-c.result:
+		return err
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("server does not end the connection")
+		return nil
+	}
+}
+
+func TestHello(t *testing.T) {
+	s := &Server{Bus: new(candev.Loopback), Token: "secret", Bitrate: ixxatvci3.Bitrate500kbps}
+	tests := []struct {
+		name    string
+		typ     byte
+		payload []byte
+		err     error
+	}{
+		{"token", pktHello, append([]byte{protocolVersion}, "guess"...), errInvalidToken},
+		{"no token", pktHello, []byte{protocolVersion}, errInvalidToken},
+		{"version", pktHello, append([]byte{protocolVersion + 1}, "secret"...), ErrProtocol},
+		{"empty", pktHello, nil, ErrProtocol},
+		{"no hello", pktQuery, nil, ErrProtocol},
+	}
+	for _, tt := range tests {
+		c := servePipe(t, s)
+		c.write(tt.typ, tt.payload)
+		if typ, payload, _ := c.read(); typ != pktError {
+			t.Errorf("%s: answered with %c %q", tt.name, typ, payload)
+		}
+		if err := c.end(); err != tt.err {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	c := servePipe(t, s)
+	c.write(pktHello, append([]byte{protocolVersion}, "secret"...))
+	if typ, payload, _ := c.read(); typ != pktWelcome || !bytes.Equal(payload, []byte{0x00, 0x1C}) {
+		t.Errorf("welcome %c % X", typ, payload)
+	}
+	c.end()
+}
+
+func TestMalformed(t *testing.T) {
+	s := &Server{Bus: new(candev.Loopback)}
+	frame := encodeFrame(candev.Message{ID: 0x100, Len: 2})
+	tests := []struct {
+		name    string
+		typ     byte
+		payload []byte
+	}{
+		{"short frame", pktSend, frame[:frameHeaderSize-1]},
+		{"frame length", pktSend, frame[:len(frame)-1]},
+		{"frame DLC", pktSend, append(append([]byte(nil), frame[:13]...), append([]byte{9}, make([]byte, 9)...)...)},
+		{"filters", pktFilters, make([]byte, filterSize+1)},
+		{"type", 'X', nil},
+	}
+	for _, tt := range tests {
+		c := servePipe(t, s)
+		c.hello("")
+		c.write(tt.typ, tt.payload)
+		if 'X' == tt.typ {
+			if typ, _, _ := c.read(); typ != pktError {
+				t.Errorf("unknown packet answered with %c", typ)
+			}
+		}
+		if err := c.end(); err != ErrProtocol {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+
+	// the client side
+	var b Backend
+	for _, p := range [][]byte{{pktFrame, 0, 1, 0}, {'X', 0, 0}} {
+		if err := b.readPackets(bufio.NewReader(bytes.NewReader(p))); err != ErrProtocol {
+			t.Errorf("packet % X: %v", p, err)
+		}
+	}
+	p := append([]byte{pktError, 0, 4}, "busy"...)
+	if err := b.readPackets(bufio.NewReader(bytes.NewReader(p))); err != remoteError("busy") {
+		t.Errorf("error packet: %v", err)
+	}
+}
+
+func TestSendAndStatus(t *testing.T) {
+	bus := new(candev.Loopback)
+	ch, idx := bus.GetMsgChannelCopy()
+	defer bus.CloseMsgChannelCopy(idx)
+	s := &Server{Bus: bus}
+	c := servePipe(t, s)
+	c.hello("")
+
+	sent := candev.Message{ID: 0x010, Ext: true, Len: 2, Data: [8]byte{0xAB, 0xCD}}
+	c.write(pktSend, encodeFrame(sent))
+	typ, payload, frames := c.read()
+	if typ != pktResult || binary.BigEndian.Uint32(payload) != ixxatvci3.VCI_OK {
+		t.Errorf("send result %c % X", typ, payload)
+	}
+	if msg := <-ch; msg != sent {
+		t.Errorf("bus got %+v, want %+v", msg, sent)
+	}
+	// the frame sent to the bus comes back from it
+	if 0 == len(frames) {
+		typ, payload, _ = readPacket(c.r)
+		if msg, err := decodeFrame(payload); typ != pktFrame || err != nil {
+			t.Errorf("echo %c % X, %v", typ, payload, err)
+		} else {
+			frames = append(frames, msg)
+		}
+	}
+	if len(frames) != 1 || frames[0].ID != 0x010 || !frames[0].Ext || frames[0].Data != sent.Data {
+		t.Errorf("frames %+v", frames)
+	}
+
+	c.write(pktQuery, nil)
+	typ, payload, _ = c.read()
+	if _, vcierr, err := decodeStatus(payload); typ != pktStatus || err != nil || vcierr != ixxatvci3.VCI_E_NOT_IMPLEMENTED {
+		t.Errorf("status without Status: %c % X", typ, payload)
+	}
+	c.end()
+
+	want := ixxatvci3.CANChanStatus{Activated: 1, RxFifoLoad: 10, TxFifoLoad: 20,
+		LineStatus: ixxatvci3.CANLineStatus{OpMode: 3, BtReg0: 0x00, BtReg1: 0x1C, BusLoad: 40, Status: ixxatvci3.CAN_STATUS_ERRLIM}}
+	s = &Server{Bus: bus, ReadOnly: true, Status: func() (ixxatvci3.CANChanStatus, uint32) { return want, ixxatvci3.VCI_OK }}
+	c = servePipe(t, s)
+	c.hello("")
+	c.write(pktSend, encodeFrame(sent))
+	if typ, payload, _ := c.read(); typ != pktResult || binary.BigEndian.Uint32(payload) != ixxatvci3.VCI_E_ACCESSDENIED {
+		t.Errorf("read only send result %c % X", typ, payload)
+	}
+	c.write(pktQuery, nil)
+	typ, payload, _ = c.read()
+	if st, vcierr, err := decodeStatus(payload); typ != pktStatus || err != nil || vcierr != ixxatvci3.VCI_OK || st != want {
+		t.Errorf("status %+v, 0x%08X, %v", st, vcierr, err)
+	}
+	c.end()
+}
+
+// receive returns the next frame of b or fails after a second.
+func receive(t *testing.T, b *Backend) (msgid uint32, data [8]byte, n uint8) {
+	t.Helper()
+	type frame struct {
+		vcierr, msgid uint32
+		data          [8]byte
+		n             uint8
+	}
+	got := make(chan frame, 1)
+	go func() {
+		vcierr, msgid, _, data, n := b.Receive(0)
+		got <- frame{vcierr, msgid, data, n}
+	}()
+	select {
+	case f := <-got:
+		if f.vcierr != ixxatvci3.VCI_OK {
+			t.Fatalf("Receive: 0x%08X", f.vcierr)
+		}
+		return f.msgid, f.data, f.n
+	case <-time.After(time.Second):
+		t.Fatal("no frame received")
+	}
+	return
+}
+
+func TestBackend(t *testing.T) {
+	bus := new(candev.Loopback)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no TCP:", err)
+	}
+	s := &Server{Bus: bus, Token: "secret", Bitrate: ixxatvci3.Bitrate500kbps}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	defer func() {
+		s.Close()
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("Serve: %v", err)
+		}
+	}()
+	addr := l.Addr().String()
+
+	if vcierr := New(addr, "guess").SelectDevice(false, 0); vcierr != ixxatvci3.VCI_E_ACCESSDENIED {
+		t.Errorf("wrong token: 0x%08X", vcierr)
+	}
+
+	b := New(addr, "secret")
+	b.Filters = []candev.Filter{{ID: 0x100, Mask: 0x700}}
+	if vcierr := b.SelectDevice(false, 0); vcierr != ixxatvci3.VCI_OK {
+		t.Fatalf("SelectDevice: 0x%08X", vcierr)
+	}
+	defer b.CloseDevice(0)
+	if vcierr := b.OpenChannel(0, 0x01, 0x1C); vcierr != ixxatvci3.VCI_E_INVALIDARG {
+		t.Errorf("OpenChannel with another bitrate: 0x%08X", vcierr)
+	}
+	if vcierr := b.OpenChannel(0, 0x00, 0x1C); vcierr != ixxatvci3.VCI_OK {
+		t.Errorf("OpenChannel: 0x%08X", vcierr)
+	}
+	// the status answer follows the filters sent after the hello
+	if _, vcierr := b.GetStatus(0); vcierr != ixxatvci3.VCI_E_NOT_IMPLEMENTED {
+		t.Errorf("GetStatus: 0x%08X", vcierr)
+	}
+
+	for _, msg := range []candev.Message{
+		{ID: 0x200, Len: 1},
+		{ID: 0x123, Len: 2, Data: [8]byte{1, 2}},
+		{ID: 0x010, Ext: true},
+		{ID: 0x18DAF110, Ext: true, Len: 1, Data: [8]byte{3}},
+		{ID: 0x105, Ext: true, Len: 1, Data: [8]byte{4}},
+	} {
+		bus.Send(msg)
+	}
+	want := []struct {
+		msgid uint32
+		data  []byte
+	}{
+		{0x123, []byte{1, 2}},
+		{1<<31 | 0x18DAF110, []byte{3}},
+		{1<<31 | 0x105, []byte{4}},
+	}
+	for _, w := range want {
+		if msgid, data, n := receive(t, b); msgid != w.msgid || !bytes.Equal(data[:n], w.data) {
+			t.Errorf("received %X % X, want %X % X", msgid, data[:n], w.msgid, w.data)
+		}
+	}
+
+	ch, idx := bus.GetMsgChannelCopy()
+	defer bus.CloseMsgChannelCopy(idx)
+	if vcierr := b.Send(0, 1<<31|0x010, false, []byte{0xEE}); vcierr != ixxatvci3.VCI_OK {
+		t.Errorf("Send: 0x%08X", vcierr)
+	}
+	if msg := <-ch; msg.ID != 0x010 || !msg.Ext || msg.Len != 1 || msg.Data[0] != 0xEE {
+		t.Errorf("bus got %+v", msg)
+	}
+	if vcierr := b.Send(0, 0x100, false, make([]byte, 9)); vcierr != ixxatvci3.VCI_E_INVALIDARG {
+		t.Errorf("Send of 9 bytes: 0x%08X", vcierr)
+	}
+}
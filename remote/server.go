@@ -0,0 +1,315 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Bus is the CAN bus shared by the server: *candev.Device or *candev.Loopback.
+type Bus interface {
+	Send(msg candev.Message) error
+	GetMsgChannelCopy() (<-chan candev.Message, uint)
+	CloseMsgChannelCopy(idx uint)
+}
+
+// Server shares a bus with network clients.
+type Server struct {
+	// Bus is the shared bus.
+	Bus Bus
+	// Token is required from binary protocol clients if not empty.
+	// The socketcand protocol has no authentication, so do not expose it to untrusted networks.
+	Token string
+	// Bitrate is reported to clients, so they can check it in OpenChannel.
+	Bitrate ixxatvci3.BitrateRegisterPair
+	// Status returns the channel status for clients, e.g. ixxatvci3.GetStatus of the device.
+	// Clients get VCI_E_NOT_IMPLEMENTED if it is nil.
+	Status func() (ixxatvci3.CANChanStatus, uint32)
+	// ReadOnly rejects frames sent by clients.
+	ReadOnly bool
+	// WriteTimeout disconnects clients that do not read frames for this time, 5 seconds by default.
+	WriteTimeout time.Duration
+	// OnConnect is called when a client connects (err is nil) or disconnects.
+	OnConnect func(addr net.Addr, connected bool, err error)
+	// Logger receives client connections, ixxatvci3.DeviceLogger of the device if Bus is a *candev.Device,
+	// otherwise ixxatvci3.DefaultLogger, if nil.
+	Logger ixxatvci3.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("remote: server closed")
+
+// errInvalidToken ends connections of binary protocol clients with a wrong token.
+var errInvalidToken = errors.New("remote: invalid token")
+
+// ListenAndServe serves the binary protocol on TCP address addr, ":29536" if addr is empty.
+func (s *Server) ListenAndServe(addr string) (err error) {
+	if "" == addr {
+		addr = ":" + DefaultPort
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts binary protocol clients on l until Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(l, s.serveBinary)
+}
+
+func (s *Server) serve(l net.Listener, handler func(net.Conn) error) error {
+	if !s.track(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.track(l, false)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handle(conn, handler)
+	}
+}
+
+func (s *Server) handle(conn net.Conn, handler func(net.Conn) error) {
+	if !s.trackConn(conn, true) {
+		conn.Close()
+		return
+	}
+	if s.OnConnect != nil {
+		s.OnConnect(conn.RemoteAddr(), true, nil)
+	}
+	l := s.logger()
+	l.Info("remote client connected", "op", "serve", "client", conn.RemoteAddr().String())
+	err := handler(conn)
+	conn.Close()
+	s.trackConn(conn, false)
+	if s.OnConnect != nil {
+		s.OnConnect(conn.RemoteAddr(), false, err)
+	}
+	fields := []interface{}{"op", "serve", "client", conn.RemoteAddr().String()}
+	if ErrProtocol == err || errInvalidToken == err {
+		l.Warn("remote client rejected", append(fields, "error", err)...)
+	} else {
+		l.Info("remote client disconnected", append(fields, "error", err)...)
+	}
+}
+
+// logger returns the logger of the server.
+func (s *Server) logger() ixxatvci3.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	if d, ok := s.Bus.(interface{ Number() uint8 }); ok {
+		return ixxatvci3.DeviceLogger(d.Number())
+	}
+	return ixxatvci3.DefaultLogger()
+}
+
+// Close stops all listeners and disconnects all clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) track(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.listeners {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) trackConn(c net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.conns {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	if !add {
+		delete(s.conns, c)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) writeTimeout() time.Duration {
+	if s.WriteTimeout > 0 {
+		return s.WriteTimeout
+	}
+	return 5 * time.Second
+}
+
+// send transmits a client frame to the bus.
+func (s *Server) send(msg candev.Message) uint32 {
+	if s.ReadOnly {
+		return ixxatvci3.VCI_E_ACCESSDENIED
+	}
+	if err := s.Bus.Send(msg); err != nil {
+		return ixxatvci3.VCI_E_FAIL
+	}
+	return ixxatvci3.VCI_OK
+}
+
+// clientConn is a connection with its write lock, used by the reader and the frame forwarder.
+type clientConn struct {
+	conn    net.Conn
+	timeout time.Duration
+	mu      sync.Mutex
+	filters []candev.Filter
+}
+
+func (c *clientConn) write(typ byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return writePacket(c.conn, typ, payload)
+}
+
+func (c *clientConn) setFilters(filters []candev.Filter) {
+	c.mu.Lock()
+	c.filters = filters
+	c.mu.Unlock()
+}
+
+func (c *clientConn) pass(id uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return candev.MatchFilters(c.filters, id)
+}
+
+func (s *Server) serveBinary(conn net.Conn) (err error) {
+	c := &clientConn{conn: conn, timeout: s.writeTimeout()}
+	r := bufio.NewReader(conn)
+
+	// the first packet must be hello with the token
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	typ, payload, err := readPacket(r)
+	if err != nil {
+		return
+	}
+	if typ != pktHello || len(payload) < 1 {
+		c.write(pktError, []byte("hello expected"))
+		return ErrProtocol
+	}
+	if payload[0] != protocolVersion {
+		c.write(pktError, []byte("unsupported protocol version"))
+		return ErrProtocol
+	}
+	if subtle.ConstantTimeCompare(payload[1:], []byte(s.Token)) != 1 {
+		c.write(pktError, []byte("access denied"))
+		return errInvalidToken
+	}
+	if err = c.write(pktWelcome, []byte{s.Bitrate.Btr0, s.Bitrate.Btr1}); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	ch, idx := s.Bus.GetMsgChannelCopy()
+	defer s.Bus.CloseMsgChannelCopy(idx)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					conn.Close() // the bus is stopped
+					return
+				}
+				if !c.pass(msg.ID) {
+					continue
+				}
+				if c.write(pktFrame, encodeFrame(msg)) != nil {
+					conn.Close() // the client does not read, the reader gets an error
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		if typ, payload, err = readPacket(r); err != nil {
+			return
+		}
+		switch typ {
+		case pktFilters:
+			var filters []candev.Filter
+			if filters, err = decodeFilters(payload); err != nil {
+				return
+			}
+			c.setFilters(filters)
+		case pktSend:
+			var msg candev.Message
+			if msg, err = decodeFrame(payload); err != nil {
+				return
+			}
+			msg.Time = time.Time{}
+			if err = c.write(pktResult, encodeUint32(s.send(msg))); err != nil {
+				return
+			}
+		case pktQuery:
+			var st ixxatvci3.CANChanStatus
+			vcierr := uint32(ixxatvci3.VCI_E_NOT_IMPLEMENTED)
+			if s.Status != nil {
+				st, vcierr = s.Status()
+			}
+			if err = c.write(pktStatus, encodeStatus(st, vcierr)); err != nil {
+				return
+			}
+		default:
+			c.write(pktError, []byte("unknown packet"))
+			return ErrProtocol
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// ServeSocketcand accepts clients of the socketcand ASCII protocol (raw mode) on l until Close,
+// e.g. for Kayak or python-can "socketcand" interface. iface is the bus name clients open, e.g. "can0".
+// socketcand has no authentication and no filters in raw mode: Token is not checked.
+func (s *Server) ServeSocketcand(l net.Listener, iface string) error {
+	return s.serve(l, func(conn net.Conn) error {
+		return s.serveSocketcand(conn, iface)
+	})
+}
+
+// readElement reads a "< ... >" element and returns its words.
+func readElement(r *bufio.Reader) (words []string, err error) {
+	if _, err = r.ReadString('<'); err != nil {
+		return
+	}
+	element, err := r.ReadString('>')
+	if err != nil {
+		return
+	}
+	words = strings.Fields(strings.TrimSuffix(element, ">"))
+	return
+}
+
+func (s *Server) serveSocketcand(conn net.Conn, iface string) (err error) {
+	c := &clientConn{conn: conn, timeout: s.writeTimeout()}
+	write := func(element string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		_, err := fmt.Fprintf(conn, "< %s >", element)
+		return err
+	}
+	r := bufio.NewReader(conn)
+	if err = write("hi"); err != nil {
+		return
+	}
+
+	// "open" and "rawmode" come before any frames
+	for opened := false; ; {
+		var words []string
+		if words, err = readElement(r); err != nil {
+			return
+		}
+		if 0 == len(words) {
+			continue
+		}
+		switch {
+		case "open" == words[0] && len(words) > 1 && words[1] == iface:
+			opened = true
+			err = write("ok")
+		case "open" == words[0]:
+			err = write("error could not open bus")
+		case "rawmode" == words[0] && opened:
+			err = write("ok")
+		case "echo" == words[0]:
+			err = write("echo")
+		default:
+			err = write("error unsupported command")
+		}
+		if err != nil {
+			return
+		}
+		if "rawmode" == words[0] && opened {
+			break
+		}
+	}
+
+	ch, idx := s.Bus.GetMsgChannelCopy()
+	defer s.Bus.CloseMsgChannelCopy(idx)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					conn.Close() // the bus is stopped
+					return
+				}
+				if write(formatSocketcandFrame(msg)) != nil {
+					conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		var words []string
+		if words, err = readElement(r); err != nil {
+			return
+		}
+		if 0 == len(words) {
+			continue
+		}
+		switch words[0] {
+		case "send":
+			msg, e := parseSocketcandSend(words[1:])
+			if e != nil {
+				err = write("error " + e.Error())
+			} else if vcierr := s.send(msg); vcierr != 0 {
+				err = write("error send failed")
+			}
+		case "echo":
+			err = write("echo")
+		default:
+			err = write("error unsupported command")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// formatSocketcandFrame returns "frame <id> <seconds>.<usecs> <data>".
+func formatSocketcandFrame(msg candev.Message) string {
+	t := msg.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	id := fmt.Sprintf("%03X", msg.ID)
+	if msg.Ext || msg.ID > 0x7FF {
+		id = fmt.Sprintf("%08X", msg.ID)
+	}
+	data := ""
+	if !msg.Rtr {
+		data = fmt.Sprintf("%X", msg.Data[:msg.Len])
+	}
+	return fmt.Sprintf("frame %s %d.%06d %s", id, t.Unix(), t.Nanosecond()/1000, data)
+}
+
+// parseSocketcandSend parses arguments of "send <id> <dlc> <byte>...".
+// An ID of 8 hex digits is 29-bit.
+func parseSocketcandSend(args []string) (msg candev.Message, err error) {
+	if len(args) < 2 {
+		err = errors.New("invalid send")
+		return
+	}
+	id, err := strconv.ParseUint(args[0], 16, 32)
+	if err != nil || id > 0x1FFFFFFF {
+		err = errors.New("invalid id")
+		return
+	}
+	dlc, err := strconv.ParseUint(args[1], 10, 8)
+	if err != nil || dlc > 8 || len(args) != 2+int(dlc) {
+		err = errors.New("invalid dlc")
+		return
+	}
+	msg.ID, msg.Len, msg.Ext = uint32(id), uint8(dlc), len(args[0]) > 3 || id > 0x7FF
+	for i := 0; i < int(dlc); i++ {
+		var v uint64
+		if v, err = strconv.ParseUint(args[2+i], 16, 8); err != nil {
+			err = errors.New("invalid data")
+			return
+		}
+		msg.Data[i] = byte(v)
+	}
+	return
+}
@@ -0,0 +1,439 @@
+// Package restbus simulates the CAN nodes around a device under test: it sends
+// the cyclic messages of the simulated nodes, keeps their alive counters and
+// checksums up to date and answers remote and request frames.
+//
+// Messages come from a DBC file or are defined in Go:
+//
+//	db, _ := dbc.ParseFile("vehicle.dbc")
+//	msgs, _ := restbus.FromDBC(db, "ABS", "Engine")
+//	sim, _ := restbus.New(bus, msgs...)
+//	sim.Start()
+//	defer sim.Stop()
+//	sim.SetSignal("WheelSpeeds", "SpeedFL", 42.5)
+package restbus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/dbc"
+	"github.com/amdf/ixxatvci3/e2e"
+)
+
+// Bus is the simulated network: *candev.Device or *candev.Loopback.
+type Bus interface {
+	Send(msg candev.Message) error
+	GetMsgChannelCopy() (<-chan candev.Message, uint)
+	CloseMsgChannelCopy(idx uint)
+}
+
+// Message is a simulated frame.
+type Message struct {
+	Name string // unique name, the hex identifier if empty
+	ID   uint32
+	Ext  bool
+	Len  uint8
+	Data [8]byte // initial data
+
+	Period time.Duration // send interval, 0 - sent only on request or by Trigger
+	Offset time.Duration // delay of the first cyclic send after Start
+
+	Counter  *e2e.Counter  // alive counter advanced before every send, nil if none
+	Checksum *e2e.Checksum // checksum computed before every send, nil if none
+
+	RespondRTR bool     // answer remote requests for ID with the current data
+	Requests   []uint32 // IDs of data frames triggering a send of the message
+
+	DBC *dbc.Message // signal layout for SetSignal and Signal, nil for raw messages
+}
+
+// MessageOf returns a simulated message of a DBC message with the start values of its signals.
+// The period is taken from the GenMsgCycleTime attribute and the offset from GenMsgStartDelayTime.
+func MessageOf(dm *dbc.Message) (m Message, err error) {
+	frame, err := dm.Encode(nil)
+	if err != nil {
+		return
+	}
+	m = Message{Name: dm.Name, ID: dm.ID, Ext: dm.Extended, Len: dm.Length, Data: frame.Data, DBC: dm}
+	if ms, ok := dm.IntAttribute("GenMsgCycleTime"); ok && ms > 0 {
+		m.Period = time.Duration(ms) * time.Millisecond
+	}
+	if ms, ok := dm.IntAttribute("GenMsgStartDelayTime"); ok && ms > 0 {
+		m.Offset = time.Duration(ms) * time.Millisecond
+	}
+	return
+}
+
+// FromDBC returns the messages sent by nodes, see MessageOf.
+func FromDBC(db *dbc.Database, nodes ...string) (list []Message, err error) {
+	for _, node := range nodes {
+		known := false
+		for _, n := range db.Nodes {
+			known = known || n == node
+		}
+		if !known {
+			return nil, fmt.Errorf("restbus: unknown node %s", node)
+		}
+		for _, dm := range db.MessagesOf(node) {
+			var m Message
+			if m, err = MessageOf(dm); err != nil {
+				return nil, err
+			}
+			list = append(list, m)
+		}
+	}
+	return
+}
+
+// MessageStats are the counts of a simulated message.
+type MessageStats struct {
+	Name      string
+	ID        uint32
+	Sent      uint64 // frames sent, cyclic and answers
+	Answers   uint64 // frames sent for remote or request frames
+	Errors    uint64 // failed sends
+	LastError error
+}
+
+type message struct {
+	spec    Message
+	data    [8]byte
+	enabled bool
+	next    time.Time // next cyclic send
+	stats   MessageStats
+}
+
+// Simulation sends and answers the messages of the simulated nodes.
+type Simulation struct {
+	bus Bus
+
+	mu       sync.Mutex
+	list     []*message
+	byName   map[string]*message
+	byID     map[uint32]*message
+	requests map[uint32][]*message
+	running  bool
+	done     chan struct{}
+	wake     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates a simulation of msgs on bus, start it with Start.
+func New(bus Bus, msgs ...Message) (*Simulation, error) {
+	s := &Simulation{
+		bus:      bus,
+		byName:   make(map[string]*message),
+		byID:     make(map[uint32]*message),
+		requests: make(map[uint32][]*message),
+		wake:     make(chan struct{}, 1),
+	}
+	for _, spec := range msgs {
+		if spec.Len > 8 {
+			return nil, fmt.Errorf("restbus: message %03X: length %d", spec.ID, spec.Len)
+		}
+		if "" == spec.Name {
+			spec.Name = fmt.Sprintf("%03X", spec.ID)
+		}
+		if _, ok := s.byName[spec.Name]; ok {
+			return nil, fmt.Errorf("restbus: duplicate message %s", spec.Name)
+		}
+		if _, ok := s.byID[spec.ID]; ok {
+			return nil, fmt.Errorf("restbus: message %s: duplicate ID %03X", spec.Name, spec.ID)
+		}
+		data := spec.Data[:spec.Len]
+		if spec.Counter != nil && !spec.Counter.Inside(data) {
+			return nil, fmt.Errorf("restbus: message %s: counter outside data", spec.Name)
+		}
+		if spec.Checksum != nil && (spec.Checksum.Byte < 0 || spec.Checksum.Byte >= len(data) || nil == spec.Checksum.Func) {
+			return nil, fmt.Errorf("restbus: message %s: invalid checksum", spec.Name)
+		}
+		m := &message{spec: spec, data: spec.Data, enabled: true}
+		m.stats.Name, m.stats.ID = spec.Name, spec.ID
+		s.list = append(s.list, m)
+		s.byName[spec.Name] = m
+		s.byID[spec.ID] = m
+		for _, id := range spec.Requests {
+			s.requests[id] = append(s.requests[id], m)
+		}
+	}
+	return s, nil
+}
+
+// Start begins sending cyclic messages and answering requests.
+func (s *Simulation) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+	s.done = make(chan struct{})
+	now := time.Now()
+	for _, m := range s.list {
+		m.next = now.Add(m.spec.Offset)
+	}
+	ch, idx := s.bus.GetMsgChannelCopy()
+	s.wg.Add(2)
+	go s.schedule(s.done)
+	go s.receive(ch, idx, s.done)
+}
+
+// Stop ends sending. The current data and the counts are kept, Start continues the simulation.
+func (s *Simulation) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	close(s.done)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// schedule sends cyclic messages when they are due.
+func (s *Simulation) schedule(done chan struct{}) {
+	defer s.wg.Done()
+	for {
+		now := time.Now()
+		next := now.Add(time.Hour)
+		var due []*message
+		s.mu.Lock()
+		for _, m := range s.list {
+			if !m.enabled || m.spec.Period <= 0 {
+				continue
+			}
+			if !m.next.After(now) {
+				due = append(due, m)
+				m.next = m.next.Add(m.spec.Period)
+				if !m.next.After(now) {
+					// fell behind, e.g. a blocked transmit queue: do not send a burst
+					m.next = now.Add(m.spec.Period)
+				}
+			}
+			if m.next.Before(next) {
+				next = m.next
+			}
+		}
+		s.mu.Unlock()
+
+		for _, m := range due {
+			s.send(m, false)
+		}
+		if len(due) > 0 {
+			continue
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		case <-done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// receive answers remote and request frames. Data frames with IDs of simulated
+// messages are not taken as requests, so simulated messages cannot trigger each other.
+func (s *Simulation) receive(ch <-chan candev.Message, idx uint, done chan struct{}) {
+	defer s.wg.Done()
+	defer s.bus.CloseMsgChannelCopy(idx)
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var answers []*message
+			s.mu.Lock()
+			if m, own := s.byID[msg.ID]; msg.Rtr {
+				if own && m.spec.RespondRTR && m.enabled {
+					answers = append(answers, m)
+				}
+			} else if !own {
+				for _, m := range s.requests[msg.ID] {
+					if m.enabled {
+						answers = append(answers, m)
+					}
+				}
+			}
+			s.mu.Unlock()
+			for _, m := range answers {
+				s.send(m, true)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// send advances the counter, updates the checksum and sends the current data of m.
+// The frame is copied under the lock and sent without it, so a blocking bus
+// does not stall signal updates or other messages.
+func (s *Simulation) send(m *message, answer bool) {
+	s.mu.Lock()
+	data := m.data[:m.spec.Len]
+	if c := m.spec.Counter; c != nil {
+		c.Set(data, c.Next(c.Get(data)))
+	}
+	if c := m.spec.Checksum; c != nil {
+		c.Set(m.spec.ID, data)
+	}
+	frame := candev.Message{ID: m.spec.ID, Ext: m.spec.Ext, Len: m.spec.Len, Data: m.data}
+	s.mu.Unlock()
+
+	err := s.bus.Send(frame)
+
+	s.mu.Lock()
+	if err != nil {
+		m.stats.Errors++
+		m.stats.LastError = err
+	} else {
+		m.stats.Sent++
+		if answer {
+			m.stats.Answers++
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *Simulation) message(name string) (*message, error) {
+	if m, ok := s.byName[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("restbus: unknown message %s", name)
+}
+
+func (s *Simulation) signal(msgName, sigName string) (m *message, sig *dbc.Signal, err error) {
+	if m, err = s.message(msgName); err != nil {
+		return
+	}
+	if nil == m.spec.DBC {
+		return nil, nil, fmt.Errorf("restbus: message %s has no signals", msgName)
+	}
+	if sig = m.spec.DBC.Signal(sigName); nil == sig {
+		return nil, nil, fmt.Errorf("restbus: message %s has no signal %s", msgName, sigName)
+	}
+	return
+}
+
+// SetSignal sets the physical value of a signal sent from now on.
+// For a multiplexed signal the multiplexer signal has to be set too.
+func (s *Simulation) SetSignal(msgName, sigName string, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, sig, err := s.signal(msgName, sigName)
+	if err != nil {
+		return err
+	}
+	raw, err := sig.FromPhysical(value)
+	if err != nil {
+		return err
+	}
+	sig.SetRaw(&m.data, raw)
+	return nil
+}
+
+// Signal returns the physical value of a signal as it was last sent or set.
+func (s *Simulation) Signal(msgName, sigName string) (value float64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, sig, err := s.signal(msgName, sigName)
+	if err != nil {
+		return
+	}
+	return sig.ToPhysical(sig.Raw(m.data)), nil
+}
+
+// SetData replaces the data of a message, the counter and checksum are still maintained.
+func (s *Simulation) SetData(msgName string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.message(msgName)
+	if err != nil {
+		return err
+	}
+	if len(data) != int(m.spec.Len) {
+		return fmt.Errorf("restbus: message %s: %d data bytes instead of %d", msgName, len(data), m.spec.Len)
+	}
+	copy(m.data[:], data)
+	return nil
+}
+
+// Data returns the data of a message as it was last sent or set.
+func (s *Simulation) Data(msgName string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.message(msgName)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), m.data[:m.spec.Len]...), nil
+}
+
+// Enable starts or stops sending a message, e.g. to make a receiver detect its timeout.
+// A disabled message does not answer requests either. An enabled message is sent at once.
+func (s *Simulation) Enable(msgName string, on bool) error {
+	s.mu.Lock()
+	m, err := s.message(msgName)
+	if nil == err && on != m.enabled {
+		m.enabled = on
+		m.next = time.Now()
+	}
+	s.mu.Unlock()
+	s.wakeUp()
+	return err
+}
+
+// SetPeriod changes the send interval of a message, 0 stops cyclic sending.
+func (s *Simulation) SetPeriod(msgName string, period time.Duration) error {
+	if period < 0 {
+		return errors.New("restbus: negative period")
+	}
+	s.mu.Lock()
+	m, err := s.message(msgName)
+	if nil == err {
+		m.spec.Period = period
+		m.next = time.Now().Add(period)
+	}
+	s.mu.Unlock()
+	s.wakeUp()
+	return err
+}
+
+// Trigger sends a message at once, e.g. an event message without period.
+func (s *Simulation) Trigger(msgName string) error {
+	s.mu.Lock()
+	m, err := s.message(msgName)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.send(m, false)
+	return nil
+}
+
+// wakeUp makes the scheduler recompute the next send time.
+func (s *Simulation) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stats returns the counts of all messages sorted by ID.
+func (s *Simulation) Stats() (list []MessageStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.list {
+		list = append(list, m.stats)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return
+}
@@ -0,0 +1,265 @@
+package restbus_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/dbc"
+	"github.com/amdf/ixxatvci3/e2e"
+	"github.com/amdf/ixxatvci3/restbus"
+)
+
+type received struct {
+	msg candev.Message
+	at  time.Time
+}
+
+// collect returns the next n data frames with identifier id, the others are skipped.
+func collect(t *testing.T, ch <-chan candev.Message, id uint32, n int) (list []received) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for len(list) < n {
+		select {
+		case msg := <-ch:
+			if msg.ID == id && !msg.Rtr {
+				list = append(list, received{msg, time.Now()})
+			}
+		case <-timeout:
+			t.Fatalf("%d of %d frames %03X received", len(list), n, id)
+		}
+	}
+	return
+}
+
+func statsOf(s *restbus.Simulation, name string) (st restbus.MessageStats) {
+	for _, st = range s.Stats() {
+		if st.Name == name {
+			return
+		}
+	}
+	return restbus.MessageStats{}
+}
+
+func TestCyclic(t *testing.T) {
+	bus := new(candev.Loopback)
+	ch, idx := bus.GetMsgChannelCopy()
+	defer bus.CloseMsgChannelCopy(idx)
+	counter := e2e.Counter{Byte: 3, Bits: 4}
+	checksum := e2e.Checksum{Byte: 0, Func: e2e.Sum8}
+	s, err := restbus.New(bus,
+		restbus.Message{ID: 0x100, Len: 4, Data: [8]byte{0, 0x11, 0x22, 0xAE}, Period: 20 * time.Millisecond,
+			Counter: &counter, Checksum: &checksum},
+		restbus.Message{Name: "Slow", ID: 0x18FEF100, Ext: true, Len: 1, Period: 50 * time.Millisecond,
+			Offset: 30 * time.Millisecond},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	s.Start()
+
+	list := collect(t, ch, 0x100, 5)
+	for i, r := range list {
+		data := r.msg.Data[:r.msg.Len]
+		// the counter wraps from 15 to 0 and keeps the upper bits of its byte
+		if want := uint8(0xF+i) & 0xF; counter.Get(data) != want || data[3]&0xF0 != 0xA0 {
+			t.Errorf("frame %d: counter of % X, want %X", i, data, want)
+		}
+		if !checksum.Verify(0x100, data) || data[1] != 0x11 || data[2] != 0x22 {
+			t.Errorf("frame %d: % X", i, data)
+		}
+	}
+	if gap := list[4].at.Sub(list[0].at) / 4; gap < 15*time.Millisecond || gap > 60*time.Millisecond {
+		t.Errorf("period %v, want 20ms", gap)
+	}
+	slow := collect(t, ch, 0x18FEF100, 2)
+	if d := slow[0].at.Sub(start); d < 30*time.Millisecond {
+		t.Errorf("first Slow frame after %v, before its offset", d)
+	}
+	if !slow[0].msg.Ext {
+		t.Error("Slow frame is not extended")
+	}
+
+	// Stop keeps the data and counts, Start continues with them
+	s.Stop()
+	sent := statsOf(s, "100").Sent
+	last, err := s.Data("100")
+	if err != nil || sent < 5 {
+		t.Fatalf("%d sent, data % X, %v", sent, last, err)
+	}
+	for len(ch) > 0 {
+		<-ch // sent before Stop
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := len(ch); n > 0 {
+		t.Errorf("%d frames sent after Stop", n)
+	}
+	// new data keeps the counter, which continues after Start
+	if err := s.SetData("100", []byte{0, 0x33, 0x44, last[3]}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop()
+	next := collect(t, ch, 0x100, 1)[0].msg
+	if data := next.Data[:next.Len]; data[1] != 0x33 || counter.Get(data) != counter.Next(counter.Get(last)) || !checksum.Verify(0x100, data) {
+		t.Errorf("after Start % X", data)
+	}
+	if st := statsOf(s, "100"); st.Sent <= sent {
+		t.Errorf("%d sent after restarting, %d before", st.Sent, sent)
+	}
+
+	// a message without period is sent by Trigger only
+	if err := s.SetPeriod("Slow", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetData("Slow", []byte{7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Trigger("Slow"); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		r := collect(t, ch, 0x18FEF100, 1)[0]
+		if 7 == r.msg.Data[0] {
+			break
+		}
+	}
+}
+
+func TestRequests(t *testing.T) {
+	bus := new(candev.Loopback)
+	ch, idx := bus.GetMsgChannelCopy()
+	defer bus.CloseMsgChannelCopy(idx)
+	counter := e2e.Counter{Byte: 1, Bits: 8}
+	s, err := restbus.New(bus,
+		restbus.Message{ID: 0x300, Len: 2, Data: [8]byte{1, 2}, RespondRTR: true},
+		restbus.Message{ID: 0x301, Len: 2, Data: [8]byte{9}, Requests: []uint32{0x7DF, 0x7E0}, Counter: &counter},
+		restbus.Message{ID: 0x302, Len: 1, Requests: []uint32{0x7DF}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop()
+
+	// answers follow the requests in order, so a request without answer
+	// is detected by the answer of the next request
+	answers := func(requests ...candev.Message) (list []candev.Message) {
+		t.Helper()
+		sent := make(map[candev.Message]bool)
+		for _, r := range requests {
+			bus.Send(r)
+			sent[r] = true
+		}
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case msg := <-ch:
+				if sent[msg] || msg.ID > 0x302 || msg.ID < 0x300 {
+					continue
+				}
+				if list = append(list, msg); 0x302 == msg.ID {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("answers %v", list)
+			}
+		}
+	}
+
+	list := answers(candev.Message{ID: 0x300, Rtr: true, Len: 2}, candev.Message{ID: 0x7DF})
+	want := []candev.Message{{ID: 0x300, Len: 2, Data: [8]byte{1, 2}}, {ID: 0x301, Len: 2, Data: [8]byte{9, 1}},
+		{ID: 0x302, Len: 1}}
+	if len(list) != 3 || list[0] != want[0] || list[1] != want[1] || list[2] != want[2] {
+		t.Errorf("answers %+v, want %+v", list, want)
+	}
+	// own data frames and remote frames of messages without RespondRTR are not requests
+	list = answers(candev.Message{ID: 0x301, Rtr: true}, candev.Message{ID: 0x300, Len: 2},
+		candev.Message{ID: 0x7E0}, candev.Message{ID: 0x7DF})
+	if len(list) != 3 || list[0].ID != 0x301 || list[0].Data[1] != 2 || list[1].Data[1] != 3 {
+		t.Errorf("answers %+v", list)
+	}
+	// a disabled message does not answer
+	if err := s.Enable("300", false); err != nil {
+		t.Fatal(err)
+	}
+	list = answers(candev.Message{ID: 0x300, Rtr: true}, candev.Message{ID: 0x7DF})
+	if len(list) != 2 || list[0].ID != 0x301 {
+		t.Errorf("answers of a disabled message %+v", list)
+	}
+
+	if st := statsOf(s, "301"); st.Sent != 4 || st.Answers != 4 || st.Errors != 0 {
+		t.Errorf("stats %+v", st)
+	}
+	if st := statsOf(s, "300"); st.Answers != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs []restbus.Message
+	}{
+		{"length", []restbus.Message{{ID: 1, Len: 9}}},
+		{"name", []restbus.Message{{Name: "A", ID: 1}, {Name: "A", ID: 2}}},
+		{"ID", []restbus.Message{{Name: "A", ID: 1}, {Name: "B", ID: 1}}},
+		{"counter", []restbus.Message{{ID: 1, Len: 2, Counter: &e2e.Counter{Byte: 2}}}},
+		{"checksum", []restbus.Message{{ID: 1, Len: 2, Checksum: &e2e.Checksum{Byte: 0}}}},
+	}
+	for _, tt := range tests {
+		if _, err := restbus.New(new(candev.Loopback), tt.msgs...); nil == err {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestSignals(t *testing.T) {
+	db, err := dbc.Parse("test.dbc", []byte(`VERSION ""
+
+BU_: ECU TESTER
+
+BO_ 256 Wheels: 4 ECU
+ SG_ Speed : 0|16@1+ (0.01,0) [0|655.35] "km/h" TESTER
+ SG_ Alive : 16|4@1+ (1,0) [0|15] "" TESTER
+
+BA_DEF_ BO_ "GenMsgCycleTime" INT 0 10000;
+BA_ "GenMsgCycleTime" BO_ 256 10;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := restbus.FromDBC(db, "ECU")
+	if err != nil || len(msgs) != 1 || msgs[0].Period != 10*time.Millisecond {
+		t.Fatalf("FromDBC = %+v, %v", msgs, err)
+	}
+	if _, err := restbus.FromDBC(db, "ABS"); nil == err {
+		t.Error("unknown node accepted")
+	}
+
+	bus := new(candev.Loopback)
+	ch, idx := bus.GetMsgChannelCopy()
+	defer bus.CloseMsgChannelCopy(idx)
+	s, err := restbus.New(bus, msgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSignal("Wheels", "Speed", 42.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSignal("Wheels", "Speed", 700); nil == err {
+		t.Error("value above the range set")
+	}
+	if v, err := s.Signal("Wheels", "Speed"); err != nil || v != 42.5 {
+		t.Errorf("Speed = %g, %v", v, err)
+	}
+	if _, err := s.Signal("Wheels", "Rpm"); nil == err {
+		t.Error("unknown signal read")
+	}
+	s.Start()
+	defer s.Stop()
+	if msg := collect(t, ch, 0x100, 1)[0].msg; msg.Data[0] != 0x9A || msg.Data[1] != 0x10 {
+		t.Errorf("sent % X, want Speed 109Ah", msg.Data[:msg.Len])
+	}
+}
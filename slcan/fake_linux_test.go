@@ -0,0 +1,202 @@
+package slcan_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+	"github.com/amdf/ixxatvci3/slcan"
+	"github.com/amdf/ixxatvci3/slcan/slcantest"
+)
+
+// openFake returns a backend on an emulated adapter, its channel not opened yet,
+// and a function closing both.
+func openFake(t *testing.T) (*slcantest.FakeDevice, *slcan.Backend, func()) {
+	t.Helper()
+	d, err := slcantest.NewFakeDevice()
+	if err != nil {
+		t.Skip("no pseudo terminals:", err)
+	}
+	b, err := slcan.Open(d.Path)
+	if err != nil {
+		d.Close()
+		t.Fatal(err)
+	}
+	closeAll := func() {
+		b.CloseDevice(0)
+		d.Close()
+	}
+	if vcierr := b.SelectDevice(false, 0); vcierr != ixxatvci3.VCI_OK {
+		closeAll()
+		t.Fatalf("SelectDevice: 0x%08X", vcierr)
+	}
+	return d, b, closeAll
+}
+
+func sent(t *testing.T, d *slcantest.FakeDevice) (f slcan.Frame) {
+	t.Helper()
+	select {
+	case f = <-d.Sent:
+	case <-time.After(time.Second):
+		t.Fatal("no frame sent")
+	}
+	return
+}
+
+func TestSetup(t *testing.T) {
+	d, b, closeAll := openFake(t)
+	defer closeAll()
+	if vcierr := b.SelectDevice(true, 0); vcierr != ixxatvci3.VCI_E_NOT_IMPLEMENTED {
+		t.Errorf("select dialog: 0x%08X", vcierr)
+	}
+	if vcierr := b.OpenChannel(0, ixxatvci3.Bitrate500kbps.Btr0, ixxatvci3.Bitrate500kbps.Btr1); vcierr != ixxatvci3.VCI_OK {
+		t.Fatalf("OpenChannel: 0x%08X", vcierr)
+	}
+	if s := d.Setup(); s != "S6" {
+		t.Errorf("setup %q, want S6", s)
+	}
+	// the channel is open, the adapter rejects another setup
+	if vcierr := b.OpenChannel(0, 0x00, 0x14); vcierr != ixxatvci3.VCI_E_FAIL {
+		t.Errorf("setup of an open channel: 0x%08X", vcierr)
+	}
+	if v, err := b.Version(); err != nil || v != "V1013" {
+		t.Errorf("Version = %q, %v", v, err)
+	}
+
+	if _, err := b.Command("C"); err != nil {
+		t.Fatal(err)
+	}
+	if vcierr := b.OpenChannel(0, 0x01, 0x2F); vcierr != ixxatvci3.VCI_OK {
+		t.Fatalf("OpenChannel: 0x%08X", vcierr)
+	}
+	if s := d.Setup(); s != "s012F" {
+		t.Errorf("setup %q, want s012F", s)
+	}
+	st, vcierr := b.GetStatus(0)
+	if vcierr != ixxatvci3.VCI_OK || 0 == st.Activated || st.LineStatus.BtReg0 != 0x01 || st.LineStatus.BtReg1 != 0x2F {
+		t.Errorf("status %+v, 0x%08X", st, vcierr)
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	d, b, closeAll := openFake(t)
+	defer closeAll()
+	if vcierr := b.OpenChannel(0, 0x00, 0x1C); vcierr != ixxatvci3.VCI_OK {
+		t.Fatalf("OpenChannel: 0x%08X", vcierr)
+	}
+
+	tx := []struct {
+		id   uint32
+		rtr  bool
+		data []byte
+		want slcan.Frame
+	}{
+		{0x123, false, []byte{0xDE, 0xAD}, slcan.Frame{ID: 0x123, Len: 2, Data: [8]byte{0xDE, 0xAD}}},
+		{0x18DAF110, false, []byte{1, 2, 3, 4, 5, 6, 7, 8}, slcan.Frame{ID: 0x18DAF110, Ext: true, Len: 8, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+		{1<<31 | 0x10, false, []byte{0xBB}, slcan.Frame{ID: 0x10, Ext: true, Len: 1, Data: [8]byte{0xBB}}},
+		{0x7FF, true, make([]byte, 3), slcan.Frame{ID: 0x7FF, Rtr: true, Len: 3}},
+	}
+	for _, f := range tx {
+		if vcierr := b.Send(0, f.id, f.rtr, f.data); vcierr != ixxatvci3.VCI_OK {
+			t.Fatalf("send %X: 0x%08X", f.id, vcierr)
+		}
+		if got := sent(t, d); got != f.want {
+			t.Errorf("sent %+v, want %+v", got, f.want)
+		}
+	}
+	if vcierr := b.Send(0, 0x100, false, make([]byte, 9)); vcierr != ixxatvci3.VCI_E_INVALIDARG {
+		t.Errorf("send of 9 bytes: 0x%08X", vcierr)
+	}
+	if vcierr := b.Send(0, 0x3FFFFFFF, false, nil); vcierr != ixxatvci3.VCI_E_INVALIDARG {
+		t.Errorf("send of an invalid ID: 0x%08X", vcierr)
+	}
+
+	rx := []slcan.Frame{
+		{ID: 0x100, Len: 1, Data: [8]byte{0x42}},
+		{ID: 0x18DAF110, Ext: true, Len: 2, Data: [8]byte{1, 2}},
+		{ID: 0x010, Ext: true},
+		{ID: 0x1ABCDEF0, Ext: true, Rtr: true, Len: 8},
+	}
+	for _, f := range rx {
+		if err := d.Inject(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vcierr, id, rtr, data, n := b.Receive(0)
+	if vcierr != ixxatvci3.VCI_OK || id != 0x100 || rtr || n != 1 || data[0] != 0x42 {
+		t.Errorf("Receive = 0x%08X %X %v % X %d", vcierr, id, rtr, data, n)
+	}
+	for _, want := range rx[1:] {
+		f, err := b.ReceiveFrame()
+		if err != nil || f != want {
+			t.Errorf("ReceiveFrame = %+v, %v, want %+v", f, err, want)
+		}
+	}
+
+	// the high bit marks a 29-bit frame, as for Send
+	if err := d.Inject(slcan.Frame{ID: 0x020, Ext: true}); err != nil {
+		t.Fatal(err)
+	}
+	if vcierr, id, _, _, _ := b.Receive(0); vcierr != ixxatvci3.VCI_OK || id != 1<<31|0x020 {
+		t.Errorf("Receive of a 29-bit frame = 0x%08X %X", vcierr, id)
+	}
+}
+
+func TestListenOnly(t *testing.T) {
+	d, b, closeAll := openFake(t)
+	defer closeAll()
+	b.SetOpMode(0, ixxatvci3.OpModeStandard|ixxatvci3.OpModeListenOnly)
+	if vcierr := b.OpenChannel(0, 0x00, 0x1C); vcierr != ixxatvci3.VCI_OK {
+		t.Fatalf("OpenChannel: 0x%08X", vcierr)
+	}
+	if vcierr := b.Send(0, 0x100, false, nil); vcierr != ixxatvci3.VCI_E_FAIL {
+		t.Errorf("send in listen only mode: 0x%08X", vcierr)
+	}
+	st, _ := b.GetStatus(0)
+	if st.LineStatus.OpMode&uint8(ixxatvci3.OpModeListenOnly) == 0 {
+		t.Errorf("status op mode 0x%02X", st.LineStatus.OpMode)
+	}
+	d.Inject(slcan.Frame{ID: 0x100})
+	if f, err := b.ReceiveFrame(); err != nil || f.ID != 0x100 {
+		t.Errorf("ReceiveFrame = %+v, %v", f, err)
+	}
+}
+
+func TestStatusAndErrors(t *testing.T) {
+	d, b, closeAll := openFake(t)
+	defer closeAll()
+	st, vcierr := b.GetStatus(0)
+	if vcierr != ixxatvci3.VCI_OK || st.LineStatus.Status != ixxatvci3.CAN_STATUS_ININIT || st.Activated != 0 {
+		t.Errorf("status of a closed channel %+v, 0x%08X", st, vcierr)
+	}
+	if _, err := b.Flags(); nil == err {
+		t.Error("flags of a closed channel")
+	}
+	if vcierr := b.Send(0, 0x100, false, nil); vcierr != ixxatvci3.VCI_E_FAIL {
+		t.Errorf("send on a closed channel: 0x%08X", vcierr)
+	}
+	if _, err := b.Command("X"); nil == err {
+		t.Error("unknown command accepted")
+	}
+
+	b.OpenChannel(0, 0x00, 0x1C)
+	d.SetFlags(slcan.FlagErrorPassive | slcan.FlagDataOverrun | slcan.FlagBusError | slcan.FlagTxFull)
+	st, vcierr = b.GetStatus(0)
+	want := uint32(ixxatvci3.CAN_STATUS_ERRLIM | ixxatvci3.CAN_STATUS_OVRRUN | ixxatvci3.CAN_STATUS_BUSOFF | ixxatvci3.CAN_STATUS_TXPEND)
+	if vcierr != ixxatvci3.VCI_OK || st.LineStatus.Status != want || 0 == st.RxOverrun || st.TxFifoLoad != 100 {
+		t.Errorf("status %+v, 0x%08X, want status 0x%X", st, vcierr, want)
+	}
+	// overrun, arbitration lost and bus error flags are cleared by reading them
+	if flags, err := b.Flags(); err != nil || flags != slcan.FlagErrorPassive|slcan.FlagTxFull {
+		t.Errorf("flags after reading 0x%02X, %v", flags, err)
+	}
+
+	b.CommandTimeout = 50 * time.Millisecond
+	d.Close()
+	if _, err := b.Version(); nil == err {
+		t.Error("command without the adapter succeeded")
+	}
+	if vcierr, _, _, _, _ := b.Receive(0); vcierr != ixxatvci3.VCI_E_NOT_INITIALIZED {
+		t.Errorf("receive without the adapter: 0x%08X", vcierr)
+	}
+}
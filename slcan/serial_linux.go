@@ -0,0 +1,67 @@
+package slcan
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	500000:  unix.B500000,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	2000000: unix.B2000000,
+	3000000: unix.B3000000,
+}
+
+// OpenPort opens a serial port (e.g. "/dev/ttyACM0") in raw 8N1 mode.
+// USB CDC adapters ignore baud, but it is required by UART adapters.
+func OpenPort(name string, baud int) (port io.ReadWriteCloser, err error) {
+	speed, ok := baudRates[baud]
+	if !ok {
+		err = fmt.Errorf("slcan: unsupported baud rate %d", baud)
+		return
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return
+	}
+	// Fd would switch the file to blocking mode and Close would not interrupt Read
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return
+	}
+	rc.Control(func(fd uintptr) { err = makeRaw(int(fd), speed) })
+	if err != nil {
+		f.Close()
+		return
+	}
+	port = f
+	return
+}
+
+// makeRaw sets raw mode like cfmakeraw(3) with the speed.
+func makeRaw(fd int, speed uint32) (err error) {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CRTSCTS | unix.CBAUD
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | speed
+	t.Ispeed, t.Ospeed = speed, speed
+	t.Cc[unix.VMIN], t.Cc[unix.VTIME] = 1, 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
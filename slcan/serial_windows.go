@@ -0,0 +1,109 @@
+package slcan
+
+import (
+	"io"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32            = windows.NewLazySystemDLL("kernel32.dll")
+	procGetCommState    = kernel32.NewProc("GetCommState")
+	procSetCommState    = kernel32.NewProc("SetCommState")
+	procSetCommTimeouts = kernel32.NewProc("SetCommTimeouts")
+)
+
+// dcb is the DCB structure of winbase.h.
+type dcb struct {
+	DCBlength  uint32
+	BaudRate   uint32
+	Flags      uint32
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+// commTimeouts is the COMMTIMEOUTS structure of winbase.h.
+type commTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+const dcbBinary = 0x01 // fBinary, other flags (flow control) off
+
+// OpenPort opens a serial port (e.g. "COM3") in 8N1 mode without flow control.
+func OpenPort(name string, baud int) (port io.ReadWriteCloser, err error) {
+	if !strings.HasPrefix(name, `\\.\`) {
+		name = `\\.\` + name
+	}
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return
+	}
+	h, err := windows.CreateFile(path, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return
+	}
+	var d dcb
+	d.DCBlength = uint32(unsafe.Sizeof(d))
+	if r, _, e := procGetCommState.Call(uintptr(h), uintptr(unsafe.Pointer(&d))); 0 == r {
+		windows.CloseHandle(h)
+		err = e
+		return
+	}
+	d.BaudRate, d.Flags = uint32(baud), dcbBinary
+	d.ByteSize, d.Parity, d.StopBits = 8, 0, 0 // 8N1
+	if r, _, e := procSetCommState.Call(uintptr(h), uintptr(unsafe.Pointer(&d))); 0 == r {
+		windows.CloseHandle(h)
+		err = e
+		return
+	}
+	// ReadFile waits for at least one byte and returns what is available
+	const maxdword = 0xFFFFFFFF
+	t := commTimeouts{ReadIntervalTimeout: maxdword, ReadTotalTimeoutMultiplier: maxdword, ReadTotalTimeoutConstant: maxdword - 1}
+	if r, _, e := procSetCommTimeouts.Call(uintptr(h), uintptr(unsafe.Pointer(&t))); 0 == r {
+		windows.CloseHandle(h)
+		err = e
+		return
+	}
+	port = &comPort{h}
+	return
+}
+
+type comPort struct {
+	h windows.Handle
+}
+
+func (p *comPort) Read(b []byte) (n int, err error) {
+	for 0 == n && nil == err {
+		var done uint32
+		err = windows.ReadFile(p.h, b, &done, nil)
+		n = int(done)
+	}
+	return
+}
+
+func (p *comPort) Write(b []byte) (n int, err error) {
+	var done uint32
+	err = windows.WriteFile(p.h, b, &done, nil)
+	n = int(done)
+	return
+}
+
+func (p *comPort) Close() error {
+	return windows.CloseHandle(p.h)
+}
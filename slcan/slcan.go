@@ -0,0 +1,564 @@
+// Package slcan is an ixxatvci3 backend for serial-line CAN adapters
+// (CANable, USBtin, Lawicel CANUSB and other SLCAN firmware).
+//
+// Use it by assigning a device number before opening the device:
+//
+//	b, err := slcan.Open("/dev/ttyACM0")
+//	ixxatvci3.UseBackend(1, b)
+//	dev, err := new(candev.Builder).Number(1).Speed(ixxatvci3.Bitrate500kbps).Get()
+//
+// Importing the package registers the "slcan" backend of ixxatvci3.NewBackend,
+// the address is the serial port.
+package slcan
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3"
+)
+
+// Status flags of the "F" command.
+const (
+	FlagRxFull       = 0x01 // receive FIFO full
+	FlagTxFull       = 0x02 // transmit FIFO full
+	FlagErrorWarning = 0x04 // error warning (EI)
+	FlagDataOverrun  = 0x08 // data overrun (DOI)
+	FlagErrorPassive = 0x20 // error passive (EPI)
+	FlagArbLost      = 0x40 // arbitration lost (ALI)
+	FlagBusError     = 0x80 // bus error (BEI)
+)
+
+// setupCommands are "Sn" bitrate commands of standard bitrates.
+var setupCommands = map[ixxatvci3.BitrateRegisterPair]string{
+	ixxatvci3.Bitrate10kbps:   "S0",
+	ixxatvci3.Bitrate20kbps:   "S1",
+	ixxatvci3.Bitrate50kbps:   "S2",
+	ixxatvci3.Bitrate100kbps:  "S3",
+	ixxatvci3.Bitrate125kbps:  "S4",
+	ixxatvci3.Bitrate250kbps:  "S5",
+	ixxatvci3.Bitrate500kbps:  "S6",
+	ixxatvci3.Bitrate800kbps:  "S7",
+	ixxatvci3.Bitrate1000kbps: "S8",
+}
+
+// Frame is a frame received from the adapter.
+type Frame struct {
+	ID        uint32
+	Ext       bool
+	Rtr       bool
+	Len       uint8
+	Data      [8]byte
+	Timestamp uint16 // adapter time in ms (0..59999) if timestamps are enabled
+}
+
+// rxQueueSize is a number of frames buffered between the port reader and Receive.
+const rxQueueSize = 1024
+
+// Backend is an SLCAN adapter. It implements ixxatvci3.Backend for a single device.
+type Backend struct {
+	// CommandTimeout is a time to wait for an answer of the adapter, 1 second by default.
+	CommandTimeout time.Duration
+	// Timestamps enables adapter timestamps ("Z1") on OpenChannel.
+	Timestamps bool
+	// Logger receives failed commands, receive queue overflows and the loss of the port,
+	// ixxatvci3.DeviceLogger of the device if nil.
+	Logger ixxatvci3.Logger
+
+	port io.ReadWriteCloser
+
+	muCmd    sync.Mutex // one command at a time
+	answers  chan string
+	rx       chan Frame
+	done     chan struct{}
+	readErr  error
+	closeErr sync.Once
+
+	mu         sync.Mutex
+	devnum     uint8
+	listenOnly bool
+	open       bool
+	closing    bool
+	bitrate    ixxatvci3.BitrateRegisterPair
+	overruns   uint
+	overflow   bool // frames are lost until the queue accepts one again
+}
+
+// ErrClosed is returned when the port is closed.
+var ErrClosed = errors.New("slcan: port closed")
+
+// New creates a backend talking over port, e.g. a serial port from OpenPort.
+func New(port io.ReadWriteCloser) *Backend {
+	b := &Backend{
+		CommandTimeout: time.Second,
+		port:           port,
+		answers:        make(chan string, 16),
+		rx:             make(chan Frame, rxQueueSize),
+		done:           make(chan struct{}),
+	}
+	go b.readerThread()
+	return b
+}
+
+func init() {
+	ixxatvci3.RegisterBackend("slcan", func(cfg ixxatvci3.BackendConfig) (ixxatvci3.Backend, error) {
+		b, err := Open(cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	})
+}
+
+// Open opens serial port name at 115200 baud and creates a backend.
+func Open(name string) (b *Backend, err error) {
+	port, err := OpenPort(name, 115200)
+	if err != nil {
+		return
+	}
+	b = New(port)
+	return
+}
+
+// logger returns the logger and the "device" field of the backend.
+func (b *Backend) logger() (l ixxatvci3.Logger, fields []interface{}) {
+	b.mu.Lock()
+	devnum := b.devnum
+	b.mu.Unlock()
+	l = b.Logger
+	if nil == l {
+		l = ixxatvci3.DeviceLogger(devnum)
+	}
+	return l, []interface{}{"device", devnum}
+}
+
+// readerThread splits adapter output into frames and command answers.
+func (b *Backend) readerThread() {
+	r := bufio.NewReader(b.port)
+	var line []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			b.readErr = err
+			b.closeErr.Do(func() { close(b.done) })
+			b.mu.Lock()
+			closing := b.closing
+			b.mu.Unlock()
+			if !closing {
+				l, fields := b.logger()
+				l.Error("slcan port lost", append(fields, "op", "read", "error", err)...)
+			}
+			return
+		}
+		switch c {
+		case '\r', '\n':
+			if '\n' == c && 0 == len(line) {
+				continue // CR LF line ends
+			}
+			b.dispatch(string(line))
+			line = line[:0]
+		case '\a': // error answer
+			line = line[:0]
+			b.answer("\a")
+		default:
+			line = append(line, c)
+		}
+	}
+}
+
+func (b *Backend) dispatch(line string) {
+	if len(line) > 0 {
+		switch line[0] {
+		case 't', 'T', 'r', 'R':
+			frame, err := ParseFrame(line)
+			if err != nil {
+				return
+			}
+			select {
+			case b.rx <- frame:
+				b.mu.Lock()
+				b.overflow = false
+				b.mu.Unlock()
+			default:
+				b.mu.Lock()
+				b.overruns++
+				first := !b.overflow
+				b.overflow = true
+				b.mu.Unlock()
+				if first {
+					l, fields := b.logger()
+					l.Warn("slcan receive queue overflow", append(fields, "op", "receive", "queue", cap(b.rx))...)
+				}
+			}
+			return
+		}
+	}
+	b.answer(line)
+}
+
+func (b *Backend) answer(s string) {
+	select {
+	case b.answers <- s:
+	default: // nobody waits, e.g. "z" of a timed out transmission
+	}
+}
+
+// Command sends a command (without the trailing CR) and returns the answer without CR.
+// The adapter error answer (BEL) is returned as an error.
+func (b *Backend) Command(cmd string) (answer string, err error) {
+	b.muCmd.Lock()
+	defer b.muCmd.Unlock()
+
+	// drop late answers of earlier commands
+	for len(b.answers) > 0 {
+		<-b.answers
+	}
+	if _, err = io.WriteString(b.port, cmd+"\r"); err != nil {
+		return
+	}
+	timeout := b.CommandTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case answer = <-b.answers:
+		if "\a" == answer {
+			err = fmt.Errorf("slcan: command %q rejected", cmd)
+		}
+	case <-b.done:
+		err = ErrClosed
+	case <-timer.C:
+		err = fmt.Errorf("slcan: no answer to %q", cmd)
+	}
+	switch {
+	case "\a" == answer:
+		// e.g. closing a closed channel, callers handle rejections
+		l, fields := b.logger()
+		l.Debug("slcan command rejected", append(fields, "op", "command", "command", cmd)...)
+	case err != nil && err != ErrClosed:
+		l, fields := b.logger()
+		l.Warn("slcan command failed", append(fields, "op", "command", "command", cmd, "error", err)...)
+	}
+	return
+}
+
+// ReceiveFrame waits for a frame including its adapter timestamp.
+// Do not mix it with Receive on the same backend.
+func (b *Backend) ReceiveFrame() (f Frame, err error) {
+	select {
+	case f = <-b.rx:
+	case <-b.done:
+		err = ErrClosed
+	}
+	return
+}
+
+// Version returns hardware and firmware version answer of "V" command, e.g. "V1013".
+func (b *Backend) Version() (string, error) {
+	return b.Command("V")
+}
+
+// Flags reads status flags with the "F" command, see Flag* constants.
+func (b *Backend) Flags() (flags byte, err error) {
+	answer, err := b.Command("F")
+	if err != nil {
+		return
+	}
+	if len(answer) != 3 || answer[0] != 'F' {
+		err = fmt.Errorf("slcan: unexpected answer %q", answer)
+		return
+	}
+	v, err := strconv.ParseUint(answer[1:], 16, 8)
+	flags = byte(v)
+	return
+}
+
+// SelectDevice checks the adapter and closes its channel if it was left open.
+// The select dialog is not supported.
+func (b *Backend) SelectDevice(userselect bool, devnum uint8) (vcierr uint32) {
+	if userselect {
+		return ixxatvci3.VCI_E_NOT_IMPLEMENTED
+	}
+	b.mu.Lock()
+	b.devnum = devnum
+	b.mu.Unlock()
+	// flush a partial command and close a channel left open
+	io.WriteString(b.port, "\r\r\r")
+	time.Sleep(10 * time.Millisecond)
+	b.Command("C")
+	return ixxatvci3.VCI_OK
+}
+
+// SetOpMode enables listen only mode, standard and extended frames are always received.
+func (b *Backend) SetOpMode(devnum uint8, mode ixxatvci3.OpMode) (vcierr uint32) {
+	b.mu.Lock()
+	b.listenOnly = mode&ixxatvci3.OpModeListenOnly != 0
+	b.mu.Unlock()
+	return ixxatvci3.VCI_OK
+}
+
+// SupportedOpModes returns standard, extended and listen only modes.
+func (b *Backend) SupportedOpModes(devnum uint8) (modes ixxatvci3.OpMode, vcierr uint32) {
+	return ixxatvci3.OpModeStandard | ixxatvci3.OpModeExtended | ixxatvci3.OpModeListenOnly, ixxatvci3.VCI_OK
+}
+
+// OpenChannel sets up the bitrate with "Sn" for standard bitrates or "sxxyy" with
+// BTR0/BTR1 for others and opens the channel ("O", or "L" in listen only mode).
+func (b *Backend) OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
+	brp := ixxatvci3.BitrateRegisterPair{Btr0: btr0, Btr1: btr1}
+	b.mu.Lock()
+	listenOnly := b.listenOnly
+	b.mu.Unlock()
+	if err := b.setup(brp, listenOnly); err != nil {
+		return vciError(err)
+	}
+	return ixxatvci3.VCI_OK
+}
+
+func (b *Backend) setup(brp ixxatvci3.BitrateRegisterPair, listenOnly bool) (err error) {
+	cmd, ok := setupCommands[brp]
+	if !ok {
+		cmd = fmt.Sprintf("s%02X%02X", brp.Btr0, brp.Btr1)
+	}
+	if _, err = b.Command(cmd); err != nil {
+		return
+	}
+	if b.Timestamps {
+		if _, err = b.Command("Z1"); err != nil {
+			return
+		}
+	}
+	open := "O"
+	if listenOnly {
+		open = "L"
+	}
+	if _, err = b.Command(open); err != nil {
+		return
+	}
+	b.mu.Lock()
+	b.open, b.bitrate = true, brp
+	b.mu.Unlock()
+	return
+}
+
+// OpenChannelDetectBitrate opens the channel in listen only mode with every bitrate
+// until a frame is received. Each bitrate is tried for timeout/len(bitrate).
+// The channel stays open with the detected bitrate in the mode set by SetOpMode.
+func (b *Backend) OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []ixxatvci3.BitrateRegisterPair) (detected ixxatvci3.BitrateRegisterPair, err error) {
+	if 0 == len(bitrate) {
+		err = errors.New("bitrate array is empty")
+		return
+	}
+	step := timeout / time.Duration(len(bitrate))
+	for _, brp := range bitrate {
+		if err = b.setup(brp, true); err != nil {
+			return
+		}
+		found := false
+		select {
+		case <-b.rx:
+			found = true
+		case <-time.After(step):
+		}
+		b.Command("C")
+		if found {
+			b.mu.Lock()
+			listenOnly := b.listenOnly
+			b.mu.Unlock()
+			detected = brp
+			err = b.setup(brp, listenOnly)
+			return
+		}
+	}
+	b.mu.Lock()
+	b.open = false
+	b.mu.Unlock()
+	err = errors.New("bitrate not detected")
+	return
+}
+
+// Send transmits a frame. The high bit of msgid or msgid above 0x7FF selects 29-bit format.
+func (b *Backend) Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
+	if len(msgdata) > 8 {
+		return ixxatvci3.VCI_E_INVALIDARG
+	}
+	f := Frame{ID: msgid &^ (1 << 31), Ext: msgid&(1<<31) != 0 || msgid > 0x7FF, Rtr: rtr, Len: uint8(len(msgdata))}
+	if f.ID > 0x1FFFFFFF {
+		return ixxatvci3.VCI_E_INVALIDARG
+	}
+	copy(f.Data[:], msgdata)
+	if _, err := b.Command(FormatFrame(f)); err != nil {
+		return vciError(err)
+	}
+	return ixxatvci3.VCI_OK
+}
+
+// Receive waits for a frame. The high bit of msgid is set for 29-bit frames.
+func (b *Backend) Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+	f, err := b.ReceiveFrame()
+	if err != nil {
+		vcierr = ixxatvci3.VCI_E_NOT_INITIALIZED
+		return
+	}
+	msgid = f.ID
+	if f.Ext {
+		msgid |= 1 << 31
+	}
+	return ixxatvci3.VCI_OK, msgid, f.Rtr, f.Data, f.Len
+}
+
+// GetStatus maps adapter status flags to the CAN_STATUS_* bits.
+// Bus load is not reported by SLCAN adapters.
+func (b *Backend) GetStatus(devnum uint8) (status ixxatvci3.CANChanStatus, vcierr uint32) {
+	b.mu.Lock()
+	open, brp, overruns := b.open, b.bitrate, b.overruns
+	b.mu.Unlock()
+
+	status.LineStatus.BtReg0, status.LineStatus.BtReg1 = brp.Btr0, brp.Btr1
+	status.RxFifoLoad = uint8(100 * len(b.rx) / cap(b.rx))
+	if overruns > 0 {
+		status.RxOverrun = 1
+	}
+	if !open {
+		status.LineStatus.Status = ixxatvci3.CAN_STATUS_ININIT
+		return
+	}
+	status.Activated = 1
+
+	flags, err := b.Flags()
+	if err != nil {
+		vcierr = vciError(err)
+		return
+	}
+	if flags&(FlagErrorWarning|FlagErrorPassive) != 0 {
+		status.LineStatus.Status |= ixxatvci3.CAN_STATUS_ERRLIM
+	}
+	if flags&(FlagDataOverrun|FlagRxFull) != 0 {
+		status.LineStatus.Status |= ixxatvci3.CAN_STATUS_OVRRUN
+		status.RxOverrun = 1
+	}
+	if flags&FlagBusError != 0 {
+		// SLCAN has no separate bus-off flag, the bus error flag is the closest
+		status.LineStatus.Status |= ixxatvci3.CAN_STATUS_BUSOFF
+	}
+	if flags&FlagTxFull != 0 {
+		status.LineStatus.Status |= ixxatvci3.CAN_STATUS_TXPEND
+		status.TxFifoLoad = 100
+	}
+	if listenOnly := b.listenOnlyMode(); listenOnly {
+		status.LineStatus.OpMode = 0x08 // CAN_OPMODE_LISTONLY
+	}
+	return
+}
+
+func (b *Backend) listenOnlyMode() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.listenOnly
+}
+
+// CloseDevice closes the channel and the port.
+func (b *Backend) CloseDevice(devnum uint8) (vcierr uint32) {
+	b.Command("C")
+	b.mu.Lock()
+	b.open, b.closing = false, true
+	b.mu.Unlock()
+	if err := b.port.Close(); err != nil {
+		return ixxatvci3.VCI_E_FAIL
+	}
+	return ixxatvci3.VCI_OK
+}
+
+func vciError(err error) uint32 {
+	switch {
+	case ErrClosed == err:
+		return ixxatvci3.VCI_E_NOT_INITIALIZED
+	case strings.HasPrefix(err.Error(), "slcan: no answer"):
+		return ixxatvci3.VCI_E_TIMEOUT
+	}
+	return ixxatvci3.VCI_E_FAIL
+}
+
+// FormatFrame formats a frame as SLCAN transmit command without CR:
+// t1232DEAD, T1ABCDEF01FF, r1230, R1ABCDEF08.
+func FormatFrame(f Frame) string {
+	var sb strings.Builder
+	cmd := byte('t')
+	if f.Rtr {
+		cmd = 'r'
+	}
+	if f.Ext {
+		cmd -= 'a' - 'A'
+		fmt.Fprintf(&sb, "%c%08X%d", cmd, f.ID, f.Len)
+	} else {
+		fmt.Fprintf(&sb, "%c%03X%d", cmd, f.ID, f.Len)
+	}
+	if !f.Rtr {
+		for i := 0; i < int(f.Len) && i < len(f.Data); i++ {
+			fmt.Fprintf(&sb, "%02X", f.Data[i])
+		}
+	}
+	return sb.String()
+}
+
+// ParseFrame parses an SLCAN frame line without CR, with optional 4 digit timestamp.
+func ParseFrame(line string) (f Frame, err error) {
+	if len(line) < 1 {
+		err = errors.New("slcan: empty frame")
+		return
+	}
+	idLen := 3
+	switch line[0] {
+	case 't':
+	case 'r':
+		f.Rtr = true
+	case 'T':
+		f.Ext, idLen = true, 8
+	case 'R':
+		f.Ext, f.Rtr, idLen = true, true, 8
+	default:
+		err = fmt.Errorf("slcan: invalid frame %q", line)
+		return
+	}
+	if len(line) < 1+idLen+1 {
+		err = fmt.Errorf("slcan: short frame %q", line)
+		return
+	}
+	id, e := strconv.ParseUint(line[1:1+idLen], 16, 32)
+	dlc, e2 := strconv.ParseUint(line[1+idLen:2+idLen], 10, 8)
+	if e != nil || e2 != nil || dlc > 8 {
+		err = fmt.Errorf("slcan: invalid frame %q", line)
+		return
+	}
+	f.ID, f.Len = uint32(id), uint8(dlc)
+	rest := line[2+idLen:]
+	if !f.Rtr {
+		if len(rest) < 2*int(dlc) {
+			err = fmt.Errorf("slcan: short frame %q", line)
+			return
+		}
+		for i := 0; i < int(dlc); i++ {
+			v, e := strconv.ParseUint(rest[2*i:2*i+2], 16, 8)
+			if e != nil {
+				err = fmt.Errorf("slcan: invalid frame %q", line)
+				return
+			}
+			f.Data[i] = byte(v)
+		}
+		rest = rest[2*dlc:]
+	}
+	if 4 == len(rest) {
+		if ts, e := strconv.ParseUint(rest, 16, 16); nil == e {
+			f.Timestamp = uint16(ts)
+		}
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package slcan
+
+import "testing"
+
+func TestFormatFrame(t *testing.T) {
+	frames := []struct {
+		f    Frame
+		want string
+	}{
+		{Frame{ID: 0x123, Len: 2, Data: [8]byte{0xDE, 0xAD}}, "t1232DEAD"},
+		{Frame{ID: 0x1ABCDEF0, Ext: true, Len: 1, Data: [8]byte{0xFF}}, "T1ABCDEF01FF"},
+		{Frame{ID: 0x123, Rtr: true}, "r1230"},
+		{Frame{ID: 0x1ABCDEF0, Ext: true, Rtr: true, Len: 8}, "R1ABCDEF08"},
+		{Frame{ID: 0x010, Ext: true}, "T000000100"},
+	}
+	for _, fr := range frames {
+		if s := FormatFrame(fr.f); s != fr.want {
+			t.Errorf("FormatFrame(%+v) = %q, want %q", fr.f, s, fr.want)
+		}
+		f, err := ParseFrame(fr.want)
+		if err != nil || f != fr.f {
+			t.Errorf("ParseFrame(%q) = %+v, %v", fr.want, f, err)
+		}
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	f, err := ParseFrame("t12320102EA60")
+	if err != nil || f.ID != 0x123 || f.Len != 2 || f.Data[1] != 0x02 || f.Timestamp != 0xEA60 {
+		t.Errorf("frame with timestamp = %+v, %v", f, err)
+	}
+	for _, line := range []string{"", "x1230", "t12", "t1239", "t1232DE", "t12G0", "T1ABCDEF", "t1231ZZ"} {
+		if f, err := ParseFrame(line); nil == err {
+			t.Errorf("ParseFrame(%q) = %+v, want an error", line, f)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// Package slcantest emulates SLCAN adapters for tests of programs using the slcan backend.
+//
+// FakeDevice answers the SLCAN commands on a pseudo terminal (Linux only):
+//
+//	d, err := slcantest.NewFakeDevice()
+//	defer d.Close()
+//	b, err := slcan.Open(d.Path)
+//	d.Inject(slcan.Frame{ID: 0x100, Len: 1, Data: [8]byte{1}})
+//	f := <-d.Sent // frames sent by the program
+package slcantest
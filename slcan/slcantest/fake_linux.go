@@ -0,0 +1,161 @@
+package slcantest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/amdf/ixxatvci3/slcan"
+	"golang.org/x/sys/unix"
+)
+
+// sentQueueSize is a number of transmitted frames buffered in Sent.
+const sentQueueSize = 1024
+
+// FakeDevice emulates an SLCAN adapter on a pseudo terminal, so programs
+// can be tested without hardware: open Path with slcan.Open or slcan.OpenPort.
+type FakeDevice struct {
+	// Path is the slave side of the pseudo terminal, e.g. "/dev/pts/3".
+	Path string
+	// Sent receives frames transmitted by the program.
+	Sent chan slcan.Frame
+
+	master *os.File
+
+	mu     sync.Mutex
+	open   bool
+	listen bool
+	setup  string
+	flags  byte
+}
+
+// NewFakeDevice creates a pseudo terminal with an emulated adapter behind it.
+func NewFakeDevice() (d *FakeDevice, err error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return
+	}
+	rc, err := master.SyscallConn()
+	if err != nil {
+		master.Close()
+		return
+	}
+	var n uint32
+	rc.Control(func(fd uintptr) {
+		if err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); nil == err {
+			n, err = unix.IoctlGetUint32(int(fd), unix.TIOCGPTN)
+		}
+	})
+	if err != nil {
+		master.Close()
+		return
+	}
+	d = &FakeDevice{
+		Path:   fmt.Sprintf("/dev/pts/%d", n),
+		Sent:   make(chan slcan.Frame, sentQueueSize),
+		master: master,
+	}
+	go d.serve()
+	return
+}
+
+func (d *FakeDevice) serve() {
+	r := bufio.NewReader(d.master)
+	for {
+		line, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if "" == line {
+			continue
+		}
+		io.WriteString(d.master, d.execute(line))
+	}
+}
+
+// execute returns the answer to a command.
+func (d *FakeDevice) execute(cmd string) string {
+	const ok, fail = "\r", "\a"
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch cmd[0] {
+	case 'S', 's':
+		if d.open {
+			return fail
+		}
+		d.setup = cmd
+		return ok
+	case 'O', 'L':
+		if d.open || "" == d.setup {
+			return fail
+		}
+		d.open, d.listen = true, 'L' == cmd[0]
+		return ok
+	case 'C':
+		d.open = false
+		return ok
+	case 'Z', 'M', 'm':
+		return ok
+	case 'V':
+		return "V1013\r"
+	case 'N':
+		return "NFAKE\r"
+	case 'F':
+		if !d.open {
+			return fail
+		}
+		flags := d.flags
+		d.flags &^= slcan.FlagDataOverrun | slcan.FlagArbLost | slcan.FlagBusError // cleared on read
+		return fmt.Sprintf("F%02X\r", flags)
+	case 't', 'T', 'r', 'R':
+		f, err := slcan.ParseFrame(cmd)
+		if err != nil || !d.open || d.listen {
+			return fail
+		}
+		select {
+		case d.Sent <- f:
+		default:
+		}
+		if 'T' == cmd[0] || 'R' == cmd[0] {
+			return "Z\r"
+		}
+		return "z\r"
+	}
+	return fail
+}
+
+// Setup returns the last bitrate command, e.g. "S6".
+func (d *FakeDevice) Setup() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.setup
+}
+
+// SetFlags sets status flags returned by the "F" command, see slcan.Flag* constants.
+func (d *FakeDevice) SetFlags(flags byte) {
+	d.mu.Lock()
+	d.flags = flags
+	d.mu.Unlock()
+}
+
+// Inject makes the adapter receive a frame from the bus.
+// Frames are dropped while the channel is closed, like a real adapter does.
+func (d *FakeDevice) Inject(f slcan.Frame) (err error) {
+	d.mu.Lock()
+	open := d.open
+	d.mu.Unlock()
+	if !open {
+		return
+	}
+	_, err = io.WriteString(d.master, slcan.FormatFrame(f)+"\r")
+	return
+}
+
+// Close closes the pseudo terminal.
+func (d *FakeDevice) Close() error {
+	return d.master.Close()
+}
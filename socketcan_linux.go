@@ -0,0 +1,133 @@
+//go:build linux
+// +build linux
+
+package ixxatvci3
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// CAN_RAW socket options of linux/can/raw.h, missing in x/sys/unix.
+const (
+	solCANRaw         = 101 // SOL_CAN_BASE + CAN_RAW
+	canRawRecvOwnMsgs = 4   // CAN_RAW_RECV_OWN_MSGS
+)
+
+// canAddr is the address of a CAN_RAW socket, the interface name.
+type canAddr string
+
+func (canAddr) Network() string  { return "can" }
+func (a canAddr) String() string { return string(a) }
+
+// rawConn is a CAN_RAW socket with access to socket options,
+// which the socketcan package connection does not give.
+type rawConn struct {
+	f    *os.File
+	addr canAddr
+}
+
+var _ net.Conn = (*rawConn)(nil)
+
+// dialRaw opens a CAN_RAW socket bound to interface name.
+func dialRaw(name string) (c *rawConn, err error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return
+	}
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_RAW, unix.CAN_RAW)
+	if err != nil {
+		return
+	}
+	// non-blocking mode registers the file in the runtime poller, so deadlines and Close work
+	if err = unix.SetNonblock(fd, true); err == nil {
+		err = unix.Bind(fd, &unix.SockaddrCAN{Ifindex: ifi.Index})
+	}
+	if err != nil {
+		unix.Close(fd)
+		return
+	}
+	return &rawConn{f: os.NewFile(uintptr(fd), name), addr: canAddr(name)}, nil
+}
+
+// setsockoptInt sets an integer socket option.
+func (c *rawConn) setsockoptInt(level, opt, value int) (err error) {
+	sc, err := c.f.SyscallConn()
+	if err != nil {
+		return
+	}
+	if cerr := sc.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), level, opt, value)
+	}); cerr != nil {
+		return cerr
+	}
+	return
+}
+
+// getsockoptInt returns an integer socket option.
+func (c *rawConn) getsockoptInt(level, opt int) (value int, err error) {
+	sc, err := c.f.SyscallConn()
+	if err != nil {
+		return
+	}
+	if cerr := sc.Control(func(fd uintptr) {
+		value, err = unix.GetsockoptInt(int(fd), level, opt)
+	}); cerr != nil {
+		return 0, cerr
+	}
+	return
+}
+
+// tryWrite writes b without waiting if the socket is not writable.
+func (c *rawConn) tryWrite(b []byte) (err error) {
+	sc, err := c.f.SyscallConn()
+	if err != nil {
+		return
+	}
+	if werr := sc.Write(func(fd uintptr) bool {
+		_, err = unix.Write(int(fd), b)
+		return true
+	}); werr != nil {
+		return werr
+	}
+	return
+}
+
+func (c *rawConn) Read(b []byte) (int, error)         { return c.f.Read(b) }
+func (c *rawConn) Write(b []byte) (int, error)        { return c.f.Write(b) }
+func (c *rawConn) Close() error                       { return c.f.Close() }
+func (c *rawConn) LocalAddr() net.Addr                { return c.addr }
+func (c *rawConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *rawConn) SetDeadline(t time.Time) error      { return c.f.SetDeadline(t) }
+func (c *rawConn) SetReadDeadline(t time.Time) error  { return c.f.SetReadDeadline(t) }
+func (c *rawConn) SetWriteDeadline(t time.Time) error { return c.f.SetWriteDeadline(t) }
+
+// sendError converts a socket write error to a VCI error code.
+func sendError(err error) uint32 {
+	if os.IsTimeout(err) {
+		return VCI_E_TIMEOUT
+	}
+	var errno syscall.Errno
+	for e := err; e != nil; {
+		if en, ok := e.(syscall.Errno); ok {
+			errno = en
+			break
+		}
+		u, ok := e.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		e = u.Unwrap()
+	}
+	switch errno {
+	case unix.ENOBUFS, unix.EAGAIN:
+		return VCI_E_TXQUEUE_FULL
+	case unix.ENETDOWN, unix.ENODEV, unix.ENXIO:
+		return VCI_E_DISCONNECTED
+	}
+	return VCI_E_FAIL
+}
@@ -0,0 +1,213 @@
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ascTimeFormat is a date format of ASC header, e.g. "Sat Oct 18 11:22:33.123 am 2026".
+const ascTimeFormat = "Mon Jan 02 03:04:05.000 pm 2006"
+
+type ascWriter struct {
+	w             *bufio.Writer
+	start         time.Time
+	headerWritten bool
+}
+
+// NewASCWriter creates Vector ASC writer with hex identifiers and timestamps relative to start.
+func NewASCWriter(w io.Writer, start time.Time) Writer {
+	return &ascWriter{w: bufio.NewWriter(w), start: start}
+}
+
+func (aw *ascWriter) header() (err error) {
+	if aw.headerWritten {
+		return
+	}
+	aw.headerWritten = true
+	date := aw.start.Format(ascTimeFormat)
+	_, err = fmt.Fprintf(aw.w, "date %s\nbase hex  timestamps absolute\ninternal events logged\n// version 9.0.0\n"+
+		"Begin Triggerblock %s\n   0.000000 Start of measurement\n", date, date)
+	return
+}
+
+func (aw *ascWriter) WriteRecord(r Record) (err error) {
+	if err = aw.header(); err != nil {
+		return
+	}
+	ts := recordTime(r).Sub(aw.start).Seconds()
+	channel := int(r.Channel) + 1
+
+	if r.Error {
+		_, err = fmt.Fprintf(aw.w, "%11.6f %d  ErrorFrame\n", ts, channel)
+		return
+	}
+
+	id := fmt.Sprintf("%X", r.Msg.ID)
+	if r.Msg.Ext || r.Msg.ID > 0x7FF {
+		id += "x"
+	}
+	if r.Msg.Rtr {
+		_, err = fmt.Fprintf(aw.w, "%11.6f %d  %-15s %s   r %X\n", ts, channel, id, r.Dir, r.Msg.Len)
+		return
+	}
+	_, err = fmt.Fprintf(aw.w, "%11.6f %d  %-15s %s   d %X", ts, channel, id, r.Dir, r.Msg.Len)
+	if err != nil {
+		return
+	}
+	for i := 0; i < int(r.Msg.Len) && i < len(r.Msg.Data); i++ {
+		fmt.Fprintf(aw.w, " %02X", r.Msg.Data[i])
+	}
+	_, err = aw.w.WriteString("\n")
+	return
+}
+
+func (aw *ascWriter) Close() (err error) {
+	if err = aw.header(); err != nil {
+		return
+	}
+	if _, err = aw.w.WriteString("End TriggerBlock\n"); err != nil {
+		return
+	}
+	err = aw.w.Flush()
+	return
+}
+
+// ascDateFormats are accepted layouts of the ASC header date with lowercased am/pm.
+var ascDateFormats = []string{
+	"Mon Jan 2 03:04:05.000 pm 2006",
+	"Mon Jan 2 03:04:05 pm 2006",
+	"Mon Jan 2 15:04:05.000 2006",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+type ascReader struct {
+	s        *bufio.Scanner
+	line     int
+	start    time.Time
+	dec      bool
+	relative bool
+	last     float64
+}
+
+// NewASCReader creates Vector ASC reader. CAN data frames, remote frames and
+// error frames are read, other events are skipped.
+func NewASCReader(r io.Reader) Reader {
+	return &ascReader{s: bufio.NewScanner(r)}
+}
+
+func (ar *ascReader) ReadRecord() (rec Record, err error) {
+	for ar.s.Scan() {
+		ar.line++
+		fields := strings.Fields(ar.s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "date":
+			date := strings.ToLower(strings.Join(fields[1:], " "))
+			for _, layout := range ascDateFormats {
+				if t, e := time.ParseInLocation(layout, date, time.Local); nil == e {
+					ar.start = t
+					break
+				}
+			}
+			continue
+		case "base":
+			ar.dec = "dec" == strings.ToLower(fields[1])
+			if len(fields) > 3 {
+				ar.relative = "relative" == strings.ToLower(fields[3])
+			}
+			continue
+		}
+
+		ts, e := strconv.ParseFloat(fields[0], 64)
+		if e != nil {
+			continue
+		}
+		channel, e := strconv.ParseUint(fields[1], 10, 8)
+		if e != nil || 0 == channel || len(fields) < 3 {
+			continue // CAN FD, statistics and other events
+		}
+		if ar.relative {
+			ts += ar.last
+		}
+		ar.last = ts
+		rec = Record{Time: ar.start.Add(time.Duration(ts * float64(time.Second))), Channel: uint8(channel - 1)}
+
+		if "ErrorFrame" == fields[2] {
+			rec.Error = true
+			return
+		}
+		if len(fields) < 5 {
+			continue
+		}
+		if err = ar.parseFrame(&rec, fields[2:]); err != nil {
+			err = fmt.Errorf("trace: ASC line %d: %v", ar.line, err)
+		}
+		return
+	}
+	if err = ar.s.Err(); nil == err {
+		err = io.EOF
+	}
+	return
+}
+
+// parseFrame parses "ID Dir d DLC data..." or "ID Dir r [DLC]".
+func (ar *ascReader) parseFrame(rec *Record, fields []string) (err error) {
+	base := 16
+	if ar.dec {
+		base = 10
+	}
+	idStr := fields[0]
+	if strings.HasSuffix(idStr, "x") || strings.HasSuffix(idStr, "X") {
+		rec.Msg.Ext = true
+		idStr = idStr[:len(idStr)-1]
+	}
+	id, err := strconv.ParseUint(idStr, base, 32)
+	if err != nil {
+		return fmt.Errorf("invalid identifier %q", fields[0])
+	}
+	rec.Msg.ID = uint32(id)
+	if "Tx" == fields[1] {
+		rec.Dir = Tx
+	}
+
+	switch fields[2] {
+	case "r":
+		rec.Msg.Rtr = true
+		if len(fields) > 3 {
+			if dlc, e := strconv.ParseUint(fields[3], 16, 8); nil == e && dlc <= 8 {
+				rec.Msg.Len = uint8(dlc)
+			}
+		}
+		return
+	case "d":
+	default:
+		return fmt.Errorf("unknown frame type %q", fields[2])
+	}
+
+	if len(fields) < 4 {
+		return errors.New("no DLC")
+	}
+	dlc, err := strconv.ParseUint(fields[3], 16, 8)
+	if err != nil || dlc > 8 {
+		return fmt.Errorf("invalid DLC %q", fields[3])
+	}
+	if len(fields) < 4+int(dlc) {
+		return errors.New("not enough data bytes")
+	}
+	rec.Msg.Len = uint8(dlc)
+	for i := 0; i < int(dlc); i++ {
+		b, e := strconv.ParseUint(fields[4+i], base, 8)
+		if e != nil {
+			return fmt.Errorf("invalid data byte %q", fields[4+i])
+		}
+		rec.Msg.Data[i] = byte(b)
+	}
+	return
+}
@@ -0,0 +1,382 @@
+package trace
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// BLF layout constants.
+const (
+	blfFileHeaderSize   = 144
+	blfObjHeaderBase    = 16 // "LOBJ", header size, header version, object size, object type
+	blfObjHeaderV1      = 16 // flags, client index, object version, timestamp
+	blfContainerHeader  = 16 // compression method, uncompressed size
+	blfMaxContainerSize = 128 * 1024
+
+	blfTypeCANMessage  = 1
+	blfTypeContainer   = 10
+	blfTypeCANErrorExt = 73
+
+	blfTimeOneNans  = 2
+	blfCompressZlib = 2
+
+	blfCANDirTx   = 0x01
+	blfCANRemote  = 0x80
+	blfCANIDExtFl = 0x80000000
+
+	blfApplicationID = 5
+)
+
+type blfWriter struct {
+	w            io.WriteSeeker
+	start        time.Time
+	stop         time.Time
+	buf          bytes.Buffer // uncompressed objects of the current container
+	objectCount  uint32
+	uncompressed uint64
+	err          error
+}
+
+// NewBLFWriter creates Vector BLF writer with zlib-compressed containers.
+// The file header is rewritten on Close, so w must be seekable.
+func NewBLFWriter(w io.WriteSeeker, start time.Time) (Writer, error) {
+	bw := &blfWriter{w: w, start: start, stop: start, uncompressed: blfFileHeaderSize}
+	// placeholder, the final header is written by Close
+	if err := bw.writeHeader(0); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+func (bw *blfWriter) WriteRecord(r Record) (err error) {
+	if bw.err != nil {
+		return bw.err
+	}
+	t := recordTime(r)
+	if t.After(bw.stop) {
+		bw.stop = t
+	}
+	channel := uint16(r.Channel) + 1
+	id := r.Msg.ID
+	if r.Msg.Ext || id > 0x7FF {
+		id |= blfCANIDExtFl
+	}
+
+	var payload []byte
+	var objType uint32
+	if r.Error {
+		objType = blfTypeCANErrorExt
+		payload = make([]byte, 32)
+		binary.LittleEndian.PutUint16(payload[0:], channel)
+		payload[10] = r.Msg.Len
+		binary.LittleEndian.PutUint32(payload[16:], id)
+		copy(payload[24:], r.Msg.Data[:])
+	} else {
+		objType = blfTypeCANMessage
+		payload = make([]byte, 16)
+		binary.LittleEndian.PutUint16(payload[0:], channel)
+		if Tx == r.Dir {
+			payload[2] |= blfCANDirTx
+		}
+		if r.Msg.Rtr {
+			payload[2] |= blfCANRemote
+		}
+		payload[3] = r.Msg.Len
+		binary.LittleEndian.PutUint32(payload[4:], id)
+		copy(payload[8:], r.Msg.Data[:])
+	}
+
+	ts := t.Sub(bw.start)
+	if ts < 0 {
+		ts = 0
+	}
+	bw.addObject(objType, uint64(ts.Nanoseconds()), payload)
+	if bw.buf.Len() >= blfMaxContainerSize {
+		err = bw.flushContainer()
+	}
+	return
+}
+
+func (bw *blfWriter) addObject(objType uint32, timestamp uint64, payload []byte) {
+	size := blfObjHeaderBase + blfObjHeaderV1 + len(payload)
+	var hdr [blfObjHeaderBase + blfObjHeaderV1]byte
+	copy(hdr[0:], "LOBJ")
+	binary.LittleEndian.PutUint16(hdr[4:], blfObjHeaderBase+blfObjHeaderV1)
+	binary.LittleEndian.PutUint16(hdr[6:], 1)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(size))
+	binary.LittleEndian.PutUint32(hdr[12:], objType)
+	binary.LittleEndian.PutUint32(hdr[16:], blfTimeOneNans)
+	binary.LittleEndian.PutUint64(hdr[24:], timestamp)
+	bw.buf.Write(hdr[:])
+	bw.buf.Write(payload)
+	bw.buf.Write(make([]byte, size%4))
+	bw.objectCount++
+}
+
+func (bw *blfWriter) flushContainer() (err error) {
+	if 0 == bw.buf.Len() {
+		return
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(bw.buf.Bytes())
+	if err = zw.Close(); err != nil {
+		bw.err = err
+		return
+	}
+
+	size := blfObjHeaderBase + blfContainerHeader + compressed.Len()
+	var hdr [blfObjHeaderBase + blfContainerHeader]byte
+	copy(hdr[0:], "LOBJ")
+	binary.LittleEndian.PutUint16(hdr[4:], blfObjHeaderBase)
+	binary.LittleEndian.PutUint16(hdr[6:], 1)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(size))
+	binary.LittleEndian.PutUint32(hdr[12:], blfTypeContainer)
+	binary.LittleEndian.PutUint16(hdr[16:], blfCompressZlib)
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(bw.buf.Len()))
+
+	bw.uncompressed += uint64(len(hdr) + bw.buf.Len())
+	bw.buf.Reset()
+
+	for _, part := range [][]byte{hdr[:], compressed.Bytes(), make([]byte, size%4)} {
+		if _, err = bw.w.Write(part); err != nil {
+			bw.err = err
+			return
+		}
+	}
+	return
+}
+
+func (bw *blfWriter) writeHeader(fileSize uint64) (err error) {
+	var hdr [blfFileHeaderSize]byte
+	copy(hdr[0:], "LOGG")
+	binary.LittleEndian.PutUint32(hdr[4:], blfFileHeaderSize)
+	hdr[8] = blfApplicationID
+	copy(hdr[12:16], []byte{2, 6, 8, 1}) // binary log format version
+	binary.LittleEndian.PutUint64(hdr[16:], fileSize)
+	binary.LittleEndian.PutUint64(hdr[24:], bw.uncompressed)
+	binary.LittleEndian.PutUint32(hdr[32:], bw.objectCount)
+	putSystemTime(hdr[40:56], bw.start)
+	putSystemTime(hdr[56:72], bw.stop)
+	_, err = bw.w.Write(hdr[:])
+	return
+}
+
+// putSystemTime encodes t as Windows SYSTEMTIME.
+func putSystemTime(b []byte, t time.Time) {
+	for i, v := range []int{t.Year(), int(t.Month()), int(t.Weekday()), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond() / int(time.Millisecond)} {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(v))
+	}
+}
+
+func (bw *blfWriter) Close() (err error) {
+	if err = bw.flushContainer(); err != nil {
+		return
+	}
+	size, err := bw.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	if _, err = bw.w.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if err = bw.writeHeader(uint64(size)); err != nil {
+		return
+	}
+	_, err = bw.w.Seek(size, io.SeekStart)
+	return
+}
+
+// Object types read in addition to the written ones.
+const (
+	blfTypeCANError    = 2
+	blfTypeCANMessage2 = 86
+
+	blfTimeTenMics   = 1
+	blfCompressNone  = 0
+	blfMaxObjectSize = 16 * 1024 * 1024
+)
+
+type blfReader struct {
+	r       io.Reader
+	start   time.Time
+	pending []byte // uncompressed container data not parsed yet
+	records []Record
+}
+
+// NewBLFReader creates Vector BLF reader. CAN messages and error frames are read,
+// other objects are skipped.
+func NewBLFReader(r io.Reader) (Reader, error) {
+	var hdr [blfFileHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+		return nil, err
+	}
+	if "LOGG" != string(hdr[:4]) {
+		return nil, errors.New("trace: not a BLF file")
+	}
+	size := binary.LittleEndian.Uint32(hdr[4:])
+	if size < 72 || size > blfFileHeaderSize {
+		return nil, errors.New("trace: invalid BLF header size")
+	}
+	if _, err := io.ReadFull(r, hdr[8:size]); err != nil {
+		return nil, err
+	}
+	return &blfReader{r: r, start: getSystemTime(hdr[40:56])}, nil
+}
+
+// getSystemTime decodes Windows SYSTEMTIME as a local time.
+func getSystemTime(b []byte) time.Time {
+	v := func(i int) int { return int(binary.LittleEndian.Uint16(b[2*i:])) }
+	return time.Date(v(0), time.Month(v(1)), v(3), v(4), v(5), v(6), v(7)*int(time.Millisecond), time.Local)
+}
+
+func (br *blfReader) ReadRecord() (rec Record, err error) {
+	for 0 == len(br.records) {
+		if err = br.readObject(); err != nil {
+			return
+		}
+	}
+	rec = br.records[0]
+	br.records = br.records[1:]
+	return
+}
+
+// readObject reads the next top level object.
+func (br *blfReader) readObject() (err error) {
+	var hdr [blfObjHeaderBase]byte
+	if _, err = io.ReadFull(br.r, hdr[:]); err != nil {
+		if io.ErrUnexpectedEOF == err {
+			err = io.EOF // trailing padding
+		}
+		return
+	}
+	if "LOBJ" != string(hdr[:4]) {
+		return errors.New("trace: BLF object signature not found")
+	}
+	size := binary.LittleEndian.Uint32(hdr[8:])
+	if size < blfObjHeaderBase || size > blfMaxObjectSize {
+		return errors.New("trace: invalid BLF object size")
+	}
+	obj := make([]byte, size+size%4)
+	copy(obj, hdr[:])
+	if _, err = io.ReadFull(br.r, obj[blfObjHeaderBase:]); err != nil {
+		if io.ErrUnexpectedEOF == err && len(obj) > int(size) {
+			err = nil // last object without padding
+		} else {
+			return
+		}
+	}
+	obj = obj[:size]
+
+	if blfTypeContainer != binary.LittleEndian.Uint32(hdr[12:]) {
+		br.parseObject(obj)
+		return
+	}
+	if size < blfObjHeaderBase+blfContainerHeader {
+		return errors.New("trace: invalid BLF container")
+	}
+	data := obj[blfObjHeaderBase+blfContainerHeader:]
+	switch binary.LittleEndian.Uint16(obj[blfObjHeaderBase:]) {
+	case blfCompressNone:
+	case blfCompressZlib:
+		zr, e := zlib.NewReader(bytes.NewReader(data))
+		if e != nil {
+			return e
+		}
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return
+		}
+	default:
+		return errors.New("trace: unknown BLF compression method")
+	}
+
+	br.pending = append(br.pending, data...)
+	for len(br.pending) >= blfObjHeaderBase {
+		size := int(binary.LittleEndian.Uint32(br.pending[8:]))
+		if size < blfObjHeaderBase {
+			return errors.New("trace: invalid BLF object size")
+		}
+		if len(br.pending) < size+size%4 {
+			break // continued in the next container
+		}
+		br.parseObject(br.pending[:size])
+		br.pending = br.pending[size+size%4:]
+	}
+	return
+}
+
+// parseObject appends a record for CAN objects.
+func (br *blfReader) parseObject(obj []byte) {
+	headerSize := int(binary.LittleEndian.Uint16(obj[4:]))
+	if headerSize < blfObjHeaderBase+blfObjHeaderV1 || headerSize > len(obj) {
+		return
+	}
+	ts := time.Duration(binary.LittleEndian.Uint64(obj[24:]))
+	if blfTimeTenMics == binary.LittleEndian.Uint32(obj[16:]) {
+		ts *= 10 * time.Microsecond
+	}
+	rec := Record{Time: br.start.Add(ts)}
+	payload := obj[headerSize:]
+
+	switch binary.LittleEndian.Uint32(obj[12:]) {
+	case blfTypeCANMessage, blfTypeCANMessage2:
+		if len(payload) < 16 {
+			return
+		}
+		flags := payload[2]
+		if flags&blfCANDirTx != 0 {
+			rec.Dir = Tx
+		}
+		rec.Msg.Rtr = flags&blfCANRemote != 0
+		rec.Msg.Len = payload[3]
+		if rec.Msg.Len > 8 {
+			rec.Msg.Len = 8
+		}
+		setBLFID(&rec, binary.LittleEndian.Uint32(payload[4:]))
+		if !rec.Msg.Rtr {
+			copy(rec.Msg.Data[:], payload[8:16])
+		}
+		rec.Channel = blfChannel(payload)
+	case blfTypeCANError:
+		if len(payload) < 2 {
+			return
+		}
+		rec.Error = true
+		rec.Channel = blfChannel(payload)
+	case blfTypeCANErrorExt:
+		if len(payload) < 32 {
+			return
+		}
+		rec.Error = true
+		rec.Channel = blfChannel(payload)
+		rec.Msg.Len = payload[10]
+		if rec.Msg.Len > 8 {
+			rec.Msg.Len = 8
+		}
+		setBLFID(&rec, binary.LittleEndian.Uint32(payload[16:]))
+		copy(rec.Msg.Data[:], payload[24:32])
+	default:
+		return
+	}
+	br.records = append(br.records, rec)
+}
+
+func setBLFID(rec *Record, id uint32) {
+	rec.Msg.Ext = id&blfCANIDExtFl != 0
+	rec.Msg.ID = id &^ blfCANIDExtFl
+}
+
+// blfChannel converts 1-based BLF channel to Record.Channel.
+func blfChannel(payload []byte) uint8 {
+	channel := binary.LittleEndian.Uint16(payload)
+	if channel > 0 {
+		channel--
+	}
+	return uint8(channel)
+}
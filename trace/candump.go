@@ -0,0 +1,202 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CAN_ERR_FLAG of SocketCAN, set in identifiers of error frames.
+const socketcanErrFlag = 0x20000000
+
+type candumpWriter struct {
+	w     *bufio.Writer
+	iface string
+}
+
+// NewCandumpWriter creates writer of candump -l log lines:
+//
+//	(1436509052.249713) can0 123#DEADBEEF
+func NewCandumpWriter(w io.Writer, iface string) Writer {
+	if "" == iface {
+		iface = "can0"
+	}
+	return &candumpWriter{w: bufio.NewWriter(w), iface: iface}
+}
+
+func (cw *candumpWriter) WriteRecord(r Record) (err error) {
+	t := recordTime(r)
+	_, err = fmt.Fprintf(cw.w, "(%d.%06d) %s %s\n", t.Unix(), t.Nanosecond()/1000, cw.iface, FormatFrame(r))
+	return
+}
+
+func (cw *candumpWriter) Close() error {
+	return cw.w.Flush()
+}
+
+// FormatFrame formats a record in can-utils "ID#DATA" syntax:
+// 123#DEADBEEF, 1ABCDEF0#01, 123#R, error frames as 20000000#...
+func FormatFrame(r Record) string {
+	var sb strings.Builder
+	msg := r.Msg
+	if r.Error {
+		fmt.Fprintf(&sb, "%08X#", socketcanErrFlag|msg.ID)
+		for i := 0; i < 8; i++ {
+			fmt.Fprintf(&sb, "%02X", msg.Data[i])
+		}
+		return sb.String()
+	}
+	sb.WriteString(idString(msg))
+	sb.WriteByte('#')
+	if msg.Rtr {
+		sb.WriteByte('R')
+		if msg.Len > 0 {
+			fmt.Fprintf(&sb, "%d", msg.Len)
+		}
+		return sb.String()
+	}
+	for i := 0; i < int(msg.Len) && i < len(msg.Data); i++ {
+		fmt.Fprintf(&sb, "%02X", msg.Data[i])
+	}
+	return sb.String()
+}
+
+type candumpReader struct {
+	s        *bufio.Scanner
+	line     int
+	channels map[string]uint8
+}
+
+// NewCandumpReader creates reader of candump -l log lines.
+// Interfaces get channel numbers in order of their first appearance.
+func NewCandumpReader(r io.Reader) Reader {
+	return &candumpReader{s: bufio.NewScanner(r), channels: make(map[string]uint8)}
+}
+
+func (cr *candumpReader) ReadRecord() (rec Record, err error) {
+	for cr.s.Scan() {
+		cr.line++
+		fields := strings.Fields(cr.s.Text())
+		if 0 == len(fields) {
+			continue
+		}
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "(") || !strings.HasSuffix(fields[0], ")") {
+			err = fmt.Errorf("trace: candump line %d: invalid syntax", cr.line)
+			return
+		}
+		if rec, err = ParseFrame(fields[2]); err != nil {
+			err = fmt.Errorf("trace: candump line %d: %v", cr.line, err)
+			return
+		}
+		if rec.Time, err = parseUnixTime(fields[0][1 : len(fields[0])-1]); err != nil {
+			err = fmt.Errorf("trace: candump line %d: %v", cr.line, err)
+			return
+		}
+		channel, ok := cr.channels[fields[1]]
+		if !ok {
+			channel = uint8(len(cr.channels))
+			cr.channels[fields[1]] = channel
+		}
+		rec.Channel = channel
+		if len(fields) > 3 && "T" == fields[3] {
+			rec.Dir = Tx
+		}
+		return
+	}
+	if err = cr.s.Err(); nil == err {
+		err = io.EOF
+	}
+	return
+}
+
+// parseUnixTime parses "seconds.fraction" time.
+func parseUnixTime(s string) (t time.Time, err error) {
+	parts := strings.SplitN(s, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return
+	}
+	var nsec int64
+	if 2 == len(parts) {
+		frac := parts[1]
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return
+		}
+		for i := len(frac); i < 9; i++ {
+			nsec *= 10
+		}
+	}
+	t = time.Unix(sec, nsec)
+	return
+}
+
+// ParseFrame parses a frame in can-utils cansend syntax:
+// 123#DEADBEEF, 123#DE.AD.BE.EF, 1ABCDEF0#01 (29-bit with 8 digits), 123#R, 123#R4.
+func ParseFrame(s string) (rec Record, err error) {
+	pos := strings.IndexByte(s, '#')
+	if pos < 0 {
+		err = fmt.Errorf("no '#' in frame %q", s)
+		return
+	}
+	idStr, dataStr := s[:pos], s[pos+1:]
+	if len(idStr) != 3 && len(idStr) != 8 {
+		err = fmt.Errorf("identifier %q must have 3 or 8 hex digits", idStr)
+		return
+	}
+	id, err := strconv.ParseUint(idStr, 16, 32)
+	if err != nil {
+		err = fmt.Errorf("invalid identifier %q", idStr)
+		return
+	}
+	rec.Msg.ID = uint32(id)
+	if 8 == len(idStr) {
+		if rec.Msg.ID&socketcanErrFlag != 0 {
+			rec.Error = true
+			rec.Msg.ID &^= socketcanErrFlag
+		} else {
+			rec.Msg.Ext = true
+		}
+		if rec.Msg.ID > 0x1FFFFFFF {
+			err = fmt.Errorf("identifier %q out of range", idStr)
+			return
+		}
+	} else if rec.Msg.ID > 0x7FF {
+		err = fmt.Errorf("identifier %q out of range", idStr)
+		return
+	}
+
+	if strings.HasPrefix(dataStr, "R") || strings.HasPrefix(dataStr, "r") {
+		rec.Msg.Rtr = true
+		if len(dataStr) > 1 {
+			dlc, e := strconv.ParseUint(dataStr[1:], 10, 8)
+			if e != nil || dlc > 8 {
+				err = fmt.Errorf("invalid RTR length %q", dataStr[1:])
+				return
+			}
+			rec.Msg.Len = uint8(dlc)
+		}
+		return
+	}
+
+	dataStr = strings.Replace(dataStr, ".", "", -1)
+	if len(dataStr)%2 != 0 || len(dataStr) > 16 {
+		err = fmt.Errorf("invalid data %q", dataStr)
+		return
+	}
+	for i := 0; i < len(dataStr); i += 2 {
+		b, e := strconv.ParseUint(dataStr[i:i+2], 16, 8)
+		if e != nil {
+			err = fmt.Errorf("invalid data %q", dataStr)
+			return
+		}
+		rec.Msg.Data[i/2] = byte(b)
+	}
+	rec.Msg.Len = uint8(len(dataStr) / 2)
+	return
+}
@@ -0,0 +1,254 @@
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Bus is a CAN bus whose traffic can be logged, implemented by *candev.Device.
+type Bus interface {
+	Send(msg candev.Message) error
+	GetMsgChannelCopy() (<-chan candev.Message, uint)
+	CloseMsgChannelCopy(id uint)
+}
+
+// Options of a Logger.
+type Options struct {
+	Format Format
+	// Path of the log file. With rotation enabled every file gets
+	// the time of its first record appended to the name, e.g. "bus_20261018-112233.asc".
+	Path string
+	// MaxSize starts a new file after this many bytes were written (0 - unlimited).
+	// Sizes of gzipped files are counted before compression.
+	MaxSize int64
+	// MaxAge starts a new file after this time (0 - unlimited).
+	MaxAge time.Duration
+	// Compress gzips the files, ".gz" is appended to names. Not supported for BLF, which is compressed itself.
+	Compress bool
+	// Interface name of candump format.
+	Interface string
+}
+
+// Logger writes CAN records to files with optional rotation and compression.
+type Logger struct {
+	opts Options
+
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	counter *countingWriter
+	buf     *bufio.Writer
+	writer  Writer
+	opened  time.Time
+	err     error
+}
+
+// ErrLoggerClosed is returned when writing to a closed logger.
+var ErrLoggerClosed = errors.New("trace: logger closed")
+
+// Create creates a logger. The first file is created on the first record.
+func Create(opts Options) (l *Logger, err error) {
+	if "" == opts.Path {
+		err = errors.New("trace: empty path")
+		return
+	}
+	if opts.Compress && BLF == opts.Format {
+		err = errors.New("trace: BLF files can not be gzipped")
+		return
+	}
+	if _, ok := formatNames[opts.Format]; !ok {
+		err = fmt.Errorf("trace: unknown format %d", opts.Format)
+		return
+	}
+	l = &Logger{opts: opts}
+	return
+}
+
+func (l *Logger) rotating() bool {
+	return l.opts.MaxSize > 0 || l.opts.MaxAge > 0
+}
+
+// fileName returns a name of the file started at t.
+// Files rotated within the same second get a "-N" suffix.
+func (l *Logger) fileName(t time.Time) string {
+	name := l.opts.Path
+	if l.rotating() {
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext) + t.Format("_20060102-150405")
+		name = base + ext
+		for n := 1; l.exists(name); n++ {
+			name = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+	}
+	if l.opts.Compress {
+		name += ".gz"
+	}
+	return name
+}
+
+func (l *Logger) exists(name string) bool {
+	if l.opts.Compress {
+		name += ".gz"
+	}
+	_, err := os.Stat(name)
+	return nil == err
+}
+
+func (l *Logger) open(t time.Time) (err error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if BLF == l.opts.Format {
+		flags = os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	}
+	f, err := os.OpenFile(l.fileName(t), flags, 0644)
+	if err != nil {
+		return
+	}
+
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if l.opts.Compress {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	// text writers reuse buf instead of their own buffers, so its size is known
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	w = buf
+	if BLF == l.opts.Format {
+		// BLF seeks back to the header, size is taken from the file itself
+		w = f
+	}
+	tw, err := NewWriter(l.opts.Format, w, t, l.opts.Interface)
+	if err != nil {
+		f.Close()
+		return
+	}
+	l.file, l.gz, l.counter, l.buf, l.writer, l.opened = f, gz, counter, buf, tw, t
+	return
+}
+
+func (l *Logger) closeFile() (err error) {
+	if nil == l.file {
+		return
+	}
+	err = l.writer.Close()
+	if l.gz != nil {
+		if e := l.gz.Close(); nil == err {
+			err = e
+		}
+	}
+	if e := l.file.Close(); nil == err {
+		err = e
+	}
+	l.file, l.gz, l.counter, l.buf, l.writer = nil, nil, nil, nil, nil
+	return
+}
+
+func (l *Logger) size() int64 {
+	if BLF == l.opts.Format {
+		pos, _ := l.file.Seek(0, io.SeekCurrent)
+		return pos
+	}
+	return l.counter.n + int64(l.buf.Buffered())
+}
+
+// Log writes a record, rotating the file if needed.
+func (l *Logger) Log(r Record) (err error) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+
+	if l.file != nil {
+		if (l.opts.MaxAge > 0 && r.Time.Sub(l.opened) >= l.opts.MaxAge) ||
+			(l.opts.MaxSize > 0 && l.size() >= l.opts.MaxSize) {
+			if err = l.closeFile(); err != nil {
+				return
+			}
+		}
+	}
+	if nil == l.file {
+		if err = l.open(r.Time); err != nil {
+			return
+		}
+	}
+	err = l.writer.WriteRecord(r)
+	return
+}
+
+// Attach logs all frames received from bus as channel channel.
+// Logging stops when the returned function is called.
+func (l *Logger) Attach(bus Bus, channel uint8) (detach func()) {
+	ch, id := bus.GetMsgChannelCopy()
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-done:
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				l.Log(Record{Time: msg.Time, Channel: channel, Dir: Rx, Msg: msg})
+			}
+		}
+	}()
+	var once sync.Once
+	detach = func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+			bus.CloseMsgChannelCopy(id)
+		})
+	}
+	return
+}
+
+// Transmit sends msg to bus and logs it as a transmitted frame of channel channel.
+func (l *Logger) Transmit(bus Bus, channel uint8, msg candev.Message) (err error) {
+	if err = bus.Send(msg); err != nil {
+		return
+	}
+	err = l.Log(Record{Time: time.Now(), Channel: channel, Dir: Tx, Msg: msg})
+	return
+}
+
+// Close closes the current file. Attached buses must be detached before.
+func (l *Logger) Close() (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ErrLoggerClosed == l.err {
+		return
+	}
+	err = l.closeFile()
+	l.err = ErrLoggerClosed
+	return
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reader reads records from a trace file. ReadRecord returns io.EOF at the end of the trace.
+type Reader interface {
+	ReadRecord() (Record, error)
+}
+
+// NewReader creates a trace reader of format f.
+func NewReader(f Format, r io.Reader) (tr Reader, err error) {
+	switch f {
+	case Candump:
+		tr = NewCandumpReader(r)
+	case ASC:
+		tr = NewASCReader(r)
+	case TRC:
+		tr = NewTRCReader(r)
+	case BLF:
+		tr, err = NewBLFReader(r)
+	default:
+		err = fmt.Errorf("trace: unknown format %d", f)
+	}
+	return
+}
+
+// File is a trace file opened for reading.
+type File struct {
+	Reader
+	Format Format
+	file   *os.File
+	gz     *gzip.Reader
+}
+
+// Open opens a trace file, the format is detected by the file name extension.
+// Files with additional ".gz" extension are decompressed.
+func Open(path string) (tf *File, err error) {
+	name := path
+	compressed := strings.EqualFold(filepath.Ext(name), ".gz")
+	if compressed {
+		name = name[:len(name)-3]
+	}
+	format, err := ParseFormat(filepath.Ext(name))
+	if err != nil {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	tf = &File{Format: format, file: f}
+	var r io.Reader = f
+	if compressed {
+		if tf.gz, err = gzip.NewReader(f); err != nil {
+			f.Close()
+			tf = nil
+			return
+		}
+		r = tf.gz
+	}
+	if tf.Reader, err = NewReader(format, r); err != nil {
+		tf.Close()
+		tf = nil
+	}
+	return
+}
+
+// Close closes the file.
+func (tf *File) Close() error {
+	if tf.gz != nil {
+		tf.gz.Close()
+	}
+	return tf.file.Close()
+}
+
+// ReadAll reads all remaining records.
+func ReadAll(r Reader) (records []Record, err error) {
+	for {
+		var rec Record
+		rec, err = r.ReadRecord()
+		if io.EOF == err {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		records = append(records, rec)
+	}
+}
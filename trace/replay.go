@@ -0,0 +1,215 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Sender transmits CAN messages, implemented by *candev.Device.
+type Sender interface {
+	Send(msg candev.Message) error
+}
+
+// SendFunc adapts a function to Sender, e.g. to send with ixxatvci3.Send directly.
+type SendFunc func(msg candev.Message) error
+
+// Send calls f(msg).
+func (f SendFunc) Send(msg candev.Message) error {
+	return f(msg)
+}
+
+// ReplayOptions control a replay. The zero value replays all frames once in original timing.
+type ReplayOptions struct {
+	Speed float64 // time scale, 2 - twice as fast; 0 means 1
+	Loop  int     // number of additional passes, negative - endless
+
+	// Start and Stop are offsets from the first record of the trace;
+	// frames before Start are skipped, replay ends at Stop (0 - the end of the trace).
+	Start time.Duration
+	Stop  time.Duration
+
+	IDs      []uint32          // replay only these identifiers (all if empty)
+	Exclude  []uint32          // do not replay these identifiers
+	Channels []uint8           // replay only records of these channels (all if empty)
+	Remap    map[uint32]uint32 // identifier replacements, applied after filtering
+	TxOnly   bool              // replay only records logged as transmitted
+
+	// StopOnError ends the replay on the first send error.
+	StopOnError bool
+	// OnSend is called after each transmission with timing drift and send error.
+	OnSend func(rec Record, drift time.Duration, err error)
+
+	// Now and Sleep are the time base of the schedule, time.Now and a timer by default.
+	// Sleep waits for d or until ctx is done and returns ctx.Err() then.
+	Now   func() time.Time
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// ReplayReport summarizes a replay.
+type ReplayReport struct {
+	Sent      uint // frames sent successfully
+	Errors    uint // send errors
+	Skipped   uint // records filtered out, error frames
+	Passes    uint
+	MaxDrift  time.Duration // maximal lateness of a frame against its schedule
+	MeanDrift time.Duration
+	LastError error
+	Duration  time.Duration
+}
+
+// Replay sends records from traces created by open to bus, preserving their relative timing.
+// open is called once for every pass; readers implementing io.Closer are closed.
+// It returns when the trace ends, Stop is reached or ctx is cancelled.
+func Replay(ctx context.Context, bus Sender, open func() (Reader, error), opts ReplayOptions) (report ReplayReport, err error) {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if nil == opts.Now {
+		opts.Now = time.Now
+	}
+	if nil == opts.Sleep {
+		timer := time.NewTimer(time.Hour)
+		timer.Stop()
+		defer timer.Stop()
+		opts.Sleep = func(ctx context.Context, d time.Duration) error {
+			timer.Reset(d)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+				return nil
+			}
+		}
+	}
+	ids := idSet(opts.IDs)
+	exclude := idSet(opts.Exclude)
+
+	var driftSum time.Duration
+	begin := opts.Now()
+	defer func() {
+		report.Duration = opts.Now().Sub(begin)
+		if report.Sent+report.Errors > 0 {
+			report.MeanDrift = driftSum / time.Duration(report.Sent+report.Errors)
+		}
+	}()
+
+	for pass := 0; opts.Loop < 0 || pass <= opts.Loop; pass++ {
+		var r Reader
+		if r, err = open(); err != nil {
+			return
+		}
+		report.Passes++
+
+		var first time.Time
+		passStart := opts.Now()
+		for {
+			var rec Record
+			rec, err = r.ReadRecord()
+			if io.EOF == err {
+				err = nil
+				break
+			}
+			if err != nil {
+				break
+			}
+			if first.IsZero() {
+				first = rec.Time
+			}
+			offset := rec.Time.Sub(first)
+			if opts.Stop > 0 && offset > opts.Stop {
+				break
+			}
+			if offset < opts.Start || !opts.match(rec, ids, exclude) {
+				report.Skipped++
+				continue
+			}
+			if newID, ok := opts.Remap[rec.Msg.ID]; ok {
+				rec.Msg.ID = newID
+				if newID > 0x7FF {
+					rec.Msg.Ext = true
+				}
+			}
+
+			at := time.Duration(float64(offset-opts.Start) / opts.Speed)
+			if wait := at - opts.Now().Sub(passStart); wait > 0 {
+				if err = opts.Sleep(ctx, wait); err != nil {
+					break
+				}
+			}
+
+			sendErr := bus.Send(rec.Msg)
+			drift := opts.Now().Sub(passStart) - at
+			driftSum += drift
+			if drift > report.MaxDrift {
+				report.MaxDrift = drift
+			}
+			if sendErr != nil {
+				report.Errors++
+				report.LastError = sendErr
+			} else {
+				report.Sent++
+			}
+			if opts.OnSend != nil {
+				opts.OnSend(rec, drift, sendErr)
+			}
+			if sendErr != nil && opts.StopOnError {
+				err = sendErr
+				break
+			}
+		}
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ReplayFile replays a trace file, see Replay.
+func ReplayFile(ctx context.Context, bus Sender, path string, opts ReplayOptions) (ReplayReport, error) {
+	return Replay(ctx, bus, func() (r Reader, err error) {
+		tf, err := Open(path)
+		if err != nil {
+			return
+		}
+		r = tf
+		return
+	}, opts)
+}
+
+func (opts *ReplayOptions) match(rec Record, ids, exclude map[uint32]bool) bool {
+	if rec.Error || (opts.TxOnly && rec.Dir != Tx) {
+		return false
+	}
+	if len(ids) > 0 && !ids[rec.Msg.ID] {
+		return false
+	}
+	if exclude[rec.Msg.ID] {
+		return false
+	}
+	if len(opts.Channels) > 0 {
+		for _, ch := range opts.Channels {
+			if ch == rec.Channel {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func idSet(ids []uint32) map[uint32]bool {
+	set := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
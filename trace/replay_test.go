@@ -0,0 +1,265 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+// sliceReader reads records from a slice.
+type sliceReader []Record
+
+func (r *sliceReader) ReadRecord() (rec Record, err error) {
+	if 0 == len(*r) {
+		return rec, io.EOF
+	}
+	rec, *r = (*r)[0], (*r)[1:]
+	return
+}
+
+// replayer replays records on a test clock and records the send times.
+type replayer struct {
+	clock    *cantest.Clock
+	start    time.Time
+	sent     []candev.Message
+	at       []time.Duration
+	sendTime time.Duration // the clock advances by it in every send
+	fail     func(n int) error
+}
+
+func newReplayer() *replayer {
+	clock := cantest.NewClock(testStart)
+	return &replayer{clock: clock, start: clock.Now()}
+}
+
+func (p *replayer) Send(msg candev.Message) (err error) {
+	p.at = append(p.at, p.clock.Now().Sub(p.start))
+	p.sent = append(p.sent, msg)
+	p.clock.Advance(p.sendTime)
+	if p.fail != nil {
+		err = p.fail(len(p.sent))
+	}
+	return
+}
+
+func (p *replayer) replay(ctx context.Context, records []Record, opts ReplayOptions) (ReplayReport, error) {
+	opts.Now = p.clock.Now
+	opts.Sleep = func(ctx context.Context, d time.Duration) error {
+		p.clock.Advance(d)
+		return ctx.Err()
+	}
+	return Replay(ctx, p, func() (Reader, error) {
+		r := sliceReader(records)
+		return &r, nil
+	}, opts)
+}
+
+func schedule(offsets ...time.Duration) (records []Record) {
+	for i, d := range offsets {
+		records = append(records, Record{Time: testStart.Add(time.Second + d),
+			Msg: candev.Message{ID: 0x100 + uint32(i), Len: 1, Data: [8]byte{byte(i)}}})
+	}
+	return
+}
+
+func sameTimes(got, want []time.Duration) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReplayTiming(t *testing.T) {
+	ms := time.Millisecond
+	records := schedule(0, 10*ms, 30*ms, 100*ms)
+	tests := []struct {
+		speed    float64
+		sendTime time.Duration
+		at       []time.Duration
+		drift    time.Duration
+		duration time.Duration
+	}{
+		{0, 0, []time.Duration{0, 10 * ms, 30 * ms, 100 * ms}, 0, 100 * ms},
+		{2, 0, []time.Duration{0, 5 * ms, 15 * ms, 50 * ms}, 0, 50 * ms},
+		{0.5, 0, []time.Duration{0, 20 * ms, 60 * ms, 200 * ms}, 0, 200 * ms},
+		// a slow bus delays every frame by its send time, the schedule stays
+		{1, 4 * ms, []time.Duration{0, 10 * ms, 30 * ms, 100 * ms}, 4 * ms, 104 * ms},
+		// sends longer than the gaps make frames late
+		{1, 15 * ms, []time.Duration{0, 15 * ms, 30 * ms, 100 * ms}, 20 * ms, 115 * ms},
+	}
+	for _, tt := range tests {
+		p := newReplayer()
+		p.sendTime = tt.sendTime
+		report, err := p.replay(context.Background(), records, ReplayOptions{Speed: tt.speed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameTimes(p.at, tt.at) {
+			t.Errorf("speed %g, send time %v: sent at %v, want %v", tt.speed, tt.sendTime, p.at, tt.at)
+		}
+		if report.Sent != 4 || report.Passes != 1 || report.MaxDrift != tt.drift || report.Duration != tt.duration {
+			t.Errorf("speed %g, send time %v: report %+v", tt.speed, tt.sendTime, report)
+		}
+	}
+}
+
+func TestReplayOptions(t *testing.T) {
+	ms := time.Millisecond
+	records := schedule(0, 10*ms, 20*ms, 30*ms, 40*ms)
+	records[1].Dir = Tx
+	records[2].Channel = 1
+	records[3].Error = true
+	records[4].Dir = Tx
+
+	tests := []struct {
+		name    string
+		opts    ReplayOptions
+		ids     []uint32
+		at      []time.Duration
+		skipped uint
+	}{
+		{"all", ReplayOptions{}, []uint32{0x100, 0x101, 0x102, 0x104}, []time.Duration{0, 10 * ms, 20 * ms, 40 * ms}, 1},
+		{"loop", ReplayOptions{Loop: 1, Stop: 15 * ms}, []uint32{0x100, 0x101, 0x100, 0x101},
+			[]time.Duration{0, 10 * ms, 10 * ms, 20 * ms}, 0},
+		{"start", ReplayOptions{Start: 15 * ms}, []uint32{0x102, 0x104}, []time.Duration{5 * ms, 25 * ms}, 3},
+		{"tx", ReplayOptions{TxOnly: true}, []uint32{0x101, 0x104}, []time.Duration{10 * ms, 40 * ms}, 3},
+		{"ids", ReplayOptions{IDs: []uint32{0x100, 0x102, 0x103}, Exclude: []uint32{0x100}}, []uint32{0x102},
+			[]time.Duration{20 * ms}, 4},
+		{"channels", ReplayOptions{Channels: []uint8{1}}, []uint32{0x102}, []time.Duration{20 * ms}, 4},
+		{"remap", ReplayOptions{Channels: []uint8{1}, Remap: map[uint32]uint32{0x102: 0x18DAF110}},
+			[]uint32{0x18DAF110}, []time.Duration{20 * ms}, 4},
+	}
+	for _, tt := range tests {
+		p := newReplayer()
+		report, err := p.replay(context.Background(), records, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var ids []uint32
+		for _, msg := range p.sent {
+			ids = append(ids, msg.ID)
+		}
+		if len(ids) != len(tt.ids) || !sameTimes(p.at, tt.at) || report.Skipped != tt.skipped {
+			t.Errorf("%s: sent %X at %v, %d skipped, want %X at %v, %d skipped",
+				tt.name, ids, p.at, report.Skipped, tt.ids, tt.at, tt.skipped)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.ids[i] {
+				t.Errorf("%s: sent %X, want %X", tt.name, ids, tt.ids)
+				break
+			}
+		}
+		if "remap" == tt.name && !p.sent[0].Ext {
+			t.Errorf("remapped identifier %X is not extended", p.sent[0].ID)
+		}
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	records := schedule(0, time.Millisecond, 2*time.Millisecond)
+	errSend := errors.New("send failed")
+
+	p := newReplayer()
+	p.fail = func(n int) error {
+		if 2 == n {
+			return errSend
+		}
+		return nil
+	}
+	report, err := p.replay(context.Background(), records, ReplayOptions{})
+	if err != nil || report.Sent != 2 || report.Errors != 1 || report.LastError != errSend {
+		t.Errorf("send error: %+v, %v", report, err)
+	}
+	p = newReplayer()
+	p.fail = func(n int) error { return errSend }
+	if report, err = p.replay(context.Background(), records, ReplayOptions{StopOnError: true}); err != errSend || len(p.sent) != 1 {
+		t.Errorf("stop on error: %+v, %v", report, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p = newReplayer()
+	p.fail = func(n int) error {
+		cancel()
+		return nil
+	}
+	if report, err = p.replay(ctx, records, ReplayOptions{Loop: -1}); err != context.Canceled || report.Sent != 1 {
+		t.Errorf("cancelled replay: %+v, %v", report, err)
+	}
+
+	// a reader error ends the replay
+	p = newReplayer()
+	_, err = Replay(context.Background(), p, func() (Reader, error) {
+		return NewCandumpReader(strings.NewReader("(1000.000000) can0 123#01\n(1000.001000) can0 123#0")), nil
+	}, ReplayOptions{Now: p.clock.Now, Sleep: func(context.Context, time.Duration) error { return nil }})
+	if nil == err || len(p.sent) != 1 {
+		t.Errorf("truncated trace: %d sent, %v", len(p.sent), err)
+	}
+}
+
+// TestTruncated reads traces cut in the middle of a frame.
+func TestTruncated(t *testing.T) {
+	records := testRecords()[:2]
+	write := func(f Format) []byte {
+		var buf writeSeeker
+		w, err := NewWriter(f, &buf, testStart, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			w.WriteRecord(r)
+		}
+		w.Close()
+		return buf.data
+	}
+	cut := func(data []byte, after string) []byte {
+		i := bytes.Index(data, []byte(after))
+		if i < 0 {
+			t.Fatalf("no %q in %s", after, data)
+		}
+		return data[:i+len(after)]
+	}
+
+	tests := []struct {
+		format Format
+		data   []byte
+	}{
+		{Candump, cut(write(Candump), "#0102030")},
+		{ASC, cut(write(ASC), " 04 05")},
+		{TRC, cut(write(TRC), "18DAF110")},
+		{BLF, write(BLF)[:len(write(BLF))-8]},
+	}
+	for _, tt := range tests {
+		r, err := NewReader(tt.format, bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		read, err := ReadAll(r)
+		if nil == err {
+			t.Errorf("%s: %d records read without error", tt.format, len(read))
+		}
+		if tt.format != BLF && len(read) != 1 {
+			t.Errorf("%s: %d records read before the error %v", tt.format, len(read), err)
+		}
+	}
+
+	blf := write(BLF)
+	for _, data := range [][]byte{blf[:4], blf[:100], []byte("LOGX" + string(blf[4:]))} {
+		if _, err := NewBLFReader(bytes.NewReader(data)); nil == err {
+			t.Errorf("BLF header of %d bytes accepted", len(data))
+		}
+	}
+}
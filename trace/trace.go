@@ -0,0 +1,130 @@
+// Package trace records CAN traffic to log files in Linux candump,
+// Vector ASC, PEAK TRC and Vector BLF formats.
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// Direction of a recorded frame.
+type Direction uint8
+
+// Directions.
+const (
+	Rx Direction = iota
+	Tx
+)
+
+func (d Direction) String() string {
+	if Tx == d {
+		return "Tx"
+	}
+	return "Rx"
+}
+
+// Record is a single logged frame.
+type Record struct {
+	Time    time.Time
+	Channel uint8 // device number, logged as channel Channel+1 in ASC/TRC/BLF
+	Dir     Direction
+	Error   bool // error frame, Msg holds error details if available
+	Msg     candev.Message
+}
+
+// Writer writes records in a trace file format.
+type Writer interface {
+	// WriteRecord writes a single record. Records must be written in time order.
+	WriteRecord(r Record) error
+	// Close writes the file trailer. It does not close the underlying writer.
+	Close() error
+}
+
+// Format is a trace file format.
+type Format int
+
+// Formats.
+const (
+	Candump Format = iota // Linux can-utils candump -l format
+	ASC                   // Vector ASCII log
+	TRC                   // PEAK trace version 1.1
+	BLF                   // Vector binary log
+)
+
+var formatNames = map[Format]string{
+	Candump: "log",
+	ASC:     "asc",
+	TRC:     "trc",
+	BLF:     "blf",
+}
+
+// Extension returns the usual file name extension of format f.
+func (f Format) Extension() string {
+	return "." + formatNames[f]
+}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// ParseFormat returns a format by name or file extension ("asc", ".blf", "candump"...).
+func ParseFormat(name string) (f Format, err error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "."))
+	if "candump" == name {
+		return Candump, nil
+	}
+	for format, n := range formatNames {
+		if n == name {
+			f = format
+			return
+		}
+	}
+	err = fmt.Errorf("trace: unknown format %q", name)
+	return
+}
+
+// ErrNotSeekable is returned when BLF is written to a stream that cannot seek back to update its header.
+var ErrNotSeekable = errors.New("trace: BLF needs a seekable writer")
+
+// NewWriter creates a trace writer of format f.
+// start is the measurement start time for relative timestamps.
+// iface is the interface name written by candump format ("can0" if empty).
+func NewWriter(f Format, w io.Writer, start time.Time, iface string) (tw Writer, err error) {
+	switch f {
+	case Candump:
+		tw = NewCandumpWriter(w, iface)
+	case ASC:
+		tw = NewASCWriter(w, start)
+	case TRC:
+		tw = NewTRCWriter(w, start)
+	case BLF:
+		ws, ok := w.(io.WriteSeeker)
+		if !ok {
+			err = ErrNotSeekable
+			return
+		}
+		tw, err = NewBLFWriter(ws, start)
+	default:
+		err = fmt.Errorf("trace: unknown format %d", f)
+	}
+	return
+}
+
+func recordTime(r Record) time.Time {
+	if r.Time.IsZero() {
+		return time.Now()
+	}
+	return r.Time
+}
+
+func idString(msg candev.Message) string {
+	if msg.Ext || msg.ID > 0x7FF {
+		return fmt.Sprintf("%08X", msg.ID)
+	}
+	return fmt.Sprintf("%03X", msg.ID)
+}
@@ -0,0 +1,219 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+)
+
+// testStart has the millisecond resolution of the ASC and BLF start times.
+var testStart = time.Date(2026, 10, 18, 11, 22, 33, 123000000, time.Local)
+
+// testRecords are frames of every kind, at offsets of the 0.1 ms resolution of TRC.
+func testRecords() []Record {
+	at := func(d time.Duration) time.Time { return testStart.Add(d) }
+	return []Record{
+		{Time: at(0), Msg: candev.Message{ID: 0x123, Len: 2, Data: [8]byte{0xDE, 0xAD}}},
+		{Time: at(1500 * time.Microsecond), Dir: Tx, Msg: candev.Message{ID: 0x18DAF110, Ext: true, Len: 8,
+			Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+		{Time: at(2500 * time.Microsecond), Msg: candev.Message{ID: 0x010, Ext: true, Len: 1, Data: [8]byte{0xFF}}},
+		{Time: at(100 * time.Millisecond), Msg: candev.Message{ID: 0x7FF, Rtr: true, Len: 3}},
+		{Time: at(100*time.Millisecond + 100*time.Microsecond), Dir: Tx, Channel: 1,
+			Msg: candev.Message{ID: 0x1ABCDEF0, Ext: true, Rtr: true, Len: 8}},
+		{Time: at(time.Second), Msg: candev.Message{ID: 0x000}},
+		{Time: at(61*time.Second + 700*time.Microsecond), Error: true, Msg: candev.Message{ID: 0x004, Len: 8,
+			Data: [8]byte{0, 0x08}}},
+	}
+}
+
+// kept converts a record to what format f stores of it.
+func kept(f Format, r Record) Record {
+	switch f {
+	case Candump:
+		r.Dir, r.Channel = Rx, 0 // one interface, no direction
+	case TRC:
+		r.Channel = 0
+		if r.Error {
+			r.Msg = candev.Message{}
+		}
+	case ASC:
+		if r.Error {
+			r.Msg = candev.Message{}
+		}
+	}
+	return r
+}
+
+// precision is the time resolution of format f: the TRC start time is
+// an OLE date with 10 decimals, about 9 µs.
+func precision(f Format) time.Duration {
+	if TRC == f {
+		return 10 * time.Microsecond
+	}
+	return time.Microsecond
+}
+
+func sameRecord(a, b Record, precision time.Duration) bool {
+	d := a.Time.Sub(b.Time)
+	if d < -precision || d > precision {
+		return false
+	}
+	a.Time, b.Time = time.Time{}, time.Time{}
+	return a == b
+}
+
+// roundTrip writes records in format f into a temporary file and reads them back.
+func roundTrip(t *testing.T, f Format, records []Record) []Record {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := os.Create(filepath.Join(dir, "trace"+f.Extension()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := NewWriter(f, file, testStart, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.WriteRecord(r); err != nil {
+			t.Fatalf("%s: WriteRecord: %v", f, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s: Close: %v", f, err)
+	}
+	file.Close()
+
+	tf, err := Open(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tf.Close()
+	read, err := ReadAll(tf)
+	if err != nil {
+		t.Fatalf("%s: ReadAll: %v", f, err)
+	}
+	return read
+}
+
+func TestRoundTrip(t *testing.T) {
+	records := testRecords()
+	for _, f := range []Format{Candump, ASC, TRC, BLF} {
+		read := roundTrip(t, f, records)
+		if len(read) != len(records) {
+			t.Errorf("%s: %d records read, %d written", f, len(read), len(records))
+			continue
+		}
+		for i, r := range records {
+			if want := kept(f, r); !sameRecord(read[i], want, precision(f)) {
+				t.Errorf("%s: record %d = %+v, want %+v", f, i, read[i], want)
+			}
+		}
+	}
+}
+
+// TestBLFContainers writes more records than fit into one BLF container.
+func TestBLFContainers(t *testing.T) {
+	const objectSize = blfObjHeaderBase + blfObjHeaderV1 + 16
+	var records []Record
+	for i := 0; i < 3*blfMaxContainerSize/objectSize; i++ {
+		msg := candev.Message{ID: uint32(i) & 0x7FF, Len: uint8(i % 9)}
+		if i%3 == 0 {
+			msg.ID, msg.Ext = uint32(i)<<10, true
+		}
+		binary.LittleEndian.PutUint32(msg.Data[:], uint32(i))
+		records = append(records, Record{Time: testStart.Add(time.Duration(i) * time.Millisecond), Msg: msg})
+	}
+
+	var buf writeSeeker
+	w, err := NewBLFWriter(&buf, testStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		w.WriteRecord(r)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	containers := 0
+	for pos := blfFileHeaderSize; pos+blfObjHeaderBase <= len(buf.data); {
+		size := int(binary.LittleEndian.Uint32(buf.data[pos+8:]))
+		if blfTypeContainer == binary.LittleEndian.Uint32(buf.data[pos+12:]) {
+			containers++
+		}
+		pos += size + size%4
+	}
+	if containers < 3 {
+		t.Errorf("%d containers", containers)
+	}
+	if n := binary.LittleEndian.Uint32(buf.data[32:]); n != uint32(len(records)) {
+		t.Errorf("header counts %d objects, %d written", n, len(records))
+	}
+	if size := binary.LittleEndian.Uint64(buf.data[16:]); size != uint64(len(buf.data)) {
+		t.Errorf("header file size %d, file has %d bytes", size, len(buf.data))
+	}
+
+	r, err := NewBLFReader(bytes.NewReader(buf.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(records) {
+		t.Fatalf("%d records read, %d written", len(read), len(records))
+	}
+	for i, r := range records {
+		if !sameRecord(read[i], r, precision(BLF)) {
+			t.Fatalf("record %d = %+v, want %+v", i, read[i], r)
+		}
+	}
+}
+
+func TestBLFNotSeekable(t *testing.T) {
+	if _, err := NewWriter(BLF, new(bytes.Buffer), testStart, ""); err != ErrNotSeekable {
+		t.Errorf("BLF to a buffer: %v", err)
+	}
+}
+
+// writeSeeker is an in-memory io.WriteSeeker.
+type writeSeeker struct {
+	data []byte
+	pos  int
+}
+
+func (ws *writeSeeker) Write(p []byte) (int, error) {
+	if end := ws.pos + len(p); end > len(ws.data) {
+		ws.data = append(ws.data, make([]byte, end-len(ws.data))...)
+	}
+	copy(ws.data[ws.pos:], p)
+	ws.pos += len(p)
+	return len(p), nil
+}
+
+func (ws *writeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		ws.pos = int(offset)
+	case io.SeekCurrent:
+		ws.pos += int(offset)
+	case io.SeekEnd:
+		ws.pos = len(ws.data) + int(offset)
+	}
+	return int64(ws.pos), nil
+}
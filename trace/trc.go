@@ -0,0 +1,254 @@
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oleEpoch is the zero of OLE automation dates used by TRC $STARTTIME.
+var oleEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+type trcWriter struct {
+	w             *bufio.Writer
+	start         time.Time
+	number        int
+	headerWritten bool
+}
+
+// NewTRCWriter creates PEAK TRC version 1.1 writer with time offsets relative to start.
+func NewTRCWriter(w io.Writer, start time.Time) Writer {
+	return &trcWriter{w: bufio.NewWriter(w), start: start}
+}
+
+func (tw *trcWriter) header() (err error) {
+	if tw.headerWritten {
+		return
+	}
+	tw.headerWritten = true
+	_, local := tw.start.Zone()
+	days := tw.start.Add(time.Duration(local)*time.Second).Sub(oleEpoch).Hours() / 24
+	_, err = fmt.Fprintf(tw.w, ";$FILEVERSION=1.1\n;$STARTTIME=%.10f\n;\n;   Start time: %s\n"+
+		";-------------------------------------------------------------------------------\n"+
+		";   Message Number\n;   |         Time Offset (ms)\n;   |         |        Type\n"+
+		";   |         |        |        ID (hex)\n;   |         |        |        |     Data Length Code\n"+
+		";   |         |        |        |     |   Data Bytes (hex) ...\n;   |         |        |        |     |   |\n"+
+		";---+--   ----+----  --+--  ----+---  +  -+ -- -- -- -- -- -- --\n",
+		days, tw.start.Format("02.01.2006 15:04:05.000.0"))
+	return
+}
+
+func (tw *trcWriter) WriteRecord(r Record) (err error) {
+	if err = tw.header(); err != nil {
+		return
+	}
+	tw.number++
+	offset := float64(recordTime(r).Sub(tw.start)) / float64(time.Millisecond)
+
+	if r.Error {
+		_, err = fmt.Fprintf(tw.w, "%6d)%11.1f  Error %8s  0\n", tw.number, offset, "")
+		return
+	}
+
+	id := fmt.Sprintf("%04X", r.Msg.ID)
+	if r.Msg.Ext || r.Msg.ID > 0x7FF {
+		id = fmt.Sprintf("%08X", r.Msg.ID)
+	}
+	_, err = fmt.Fprintf(tw.w, "%6d)%11.1f  %-4s %12s  %d ", tw.number, offset, r.Dir, id, r.Msg.Len)
+	if err != nil {
+		return
+	}
+	if r.Msg.Rtr {
+		_, err = tw.w.WriteString(" RTR\n")
+		return
+	}
+	for i := 0; i < int(r.Msg.Len) && i < len(r.Msg.Data); i++ {
+		fmt.Fprintf(tw.w, " %02X", r.Msg.Data[i])
+	}
+	_, err = tw.w.WriteString("\n")
+	return
+}
+
+func (tw *trcWriter) Close() (err error) {
+	if err = tw.header(); err != nil {
+		return
+	}
+	err = tw.w.Flush()
+	return
+}
+
+// trcColumns are the line layouts of TRC versions without $COLUMNS:
+// N - number, O - offset ms, T - type, B - bus, I - ID, d - direction,
+// R - reserved, L - DLC, l - data length, D - data bytes.
+var trcColumns = map[string]string{
+	"1.0": "NOILD",
+	"1.1": "NOTILD",
+	"1.2": "NOBTILD",
+	"1.3": "NOBTIRLD",
+	"2.0": "NOTIdlD",
+	"2.1": "NOTBIdRLD",
+}
+
+type trcReader struct {
+	s       *bufio.Scanner
+	line    int
+	version string
+	columns string
+	start   time.Time
+}
+
+// NewTRCReader creates PEAK TRC reader of versions 1.0 - 2.1.
+// CAN data frames, remote frames and error frames are read, other events are skipped.
+func NewTRCReader(r io.Reader) Reader {
+	return &trcReader{s: bufio.NewScanner(r), version: "1.1"}
+}
+
+func (tr *trcReader) ReadRecord() (rec Record, err error) {
+	for tr.s.Scan() {
+		tr.line++
+		text := strings.TrimSpace(tr.s.Text())
+		if strings.HasPrefix(text, ";$") {
+			tr.parseHeader(text[2:])
+			continue
+		}
+		if "" == text || strings.HasPrefix(text, ";") {
+			continue
+		}
+		var ok bool
+		if rec, ok, err = tr.parseLine(strings.Fields(text)); err != nil {
+			err = fmt.Errorf("trace: TRC line %d: %v", tr.line, err)
+			return
+		}
+		if ok {
+			return
+		}
+	}
+	if err = tr.s.Err(); nil == err {
+		err = io.EOF
+	}
+	return
+}
+
+func (tr *trcReader) parseHeader(h string) {
+	kv := strings.SplitN(h, "=", 2)
+	if len(kv) != 2 {
+		return
+	}
+	switch kv[0] {
+	case "FILEVERSION":
+		tr.version = kv[1]
+	case "STARTTIME":
+		days, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return
+		}
+		t := oleEpoch.Add(time.Duration(days * 24 * float64(time.Hour))).Round(time.Microsecond)
+		// OLE date is a local wall clock time
+		tr.start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+	case "COLUMNS":
+		tr.columns = strings.Replace(kv[1], ",", "", -1)
+	}
+}
+
+// parseLine parses a record line, ok is false for skipped events.
+func (tr *trcReader) parseLine(fields []string) (rec Record, ok bool, err error) {
+	columns := tr.columns
+	if "" == columns {
+		if columns = trcColumns[tr.version]; "" == columns {
+			err = fmt.Errorf("unsupported version %s", tr.version)
+			return
+		}
+	}
+
+	var typ string
+	dlc := -1
+	for i, c := range columns {
+		if i >= len(fields) {
+			if 'D' == c || "Error" == typ || "ER" == typ {
+				break
+			}
+			err = errors.New("not enough columns")
+			return
+		}
+		f := fields[i]
+		switch c {
+		case 'O':
+			var ms float64
+			if ms, err = strconv.ParseFloat(f, 64); err != nil {
+				err = fmt.Errorf("invalid time offset %q", f)
+				return
+			}
+			rec.Time = tr.start.Add(time.Duration(ms * float64(time.Millisecond)))
+		case 'T':
+			typ = f
+		case 'B':
+			var bus uint64
+			if bus, err = strconv.ParseUint(f, 10, 8); err != nil || 0 == bus {
+				err = fmt.Errorf("invalid bus %q", f)
+				return
+			}
+			rec.Channel = uint8(bus - 1)
+		case 'I':
+			if "-" == f {
+				continue
+			}
+			var id uint64
+			if id, err = strconv.ParseUint(f, 16, 32); err != nil {
+				err = fmt.Errorf("invalid identifier %q", f)
+				return
+			}
+			rec.Msg.ID = uint32(id)
+			rec.Msg.Ext = len(f) > 4
+		case 'd':
+			if "Tx" == f {
+				rec.Dir = Tx
+			}
+		case 'L', 'l':
+			var n uint64
+			if n, err = strconv.ParseUint(f, 10, 8); err != nil || n > 8 {
+				err = fmt.Errorf("invalid data length %q", f)
+				return
+			}
+			dlc = int(n)
+		case 'D':
+			rest := fields[i:]
+			if len(rest) > 0 && "RTR" == rest[0] {
+				rec.Msg.Rtr = true
+				rest = nil
+			}
+			for j := 0; j < len(rest) && j < 8; j++ {
+				var b uint64
+				if b, err = strconv.ParseUint(rest[j], 16, 8); err != nil {
+					err = fmt.Errorf("invalid data byte %q", rest[j])
+					return
+				}
+				rec.Msg.Data[j] = byte(b)
+			}
+		}
+	}
+	if dlc >= 0 {
+		rec.Msg.Len = uint8(dlc)
+	}
+
+	switch typ {
+	case "", "Rx", "Tx", "DT":
+		if "Tx" == typ {
+			rec.Dir = Tx
+		}
+	case "RR":
+		rec.Msg.Rtr = true
+	case "Error", "ER":
+		rec.Error = true
+	default:
+		return // warnings, status, CAN FD and other events
+	}
+	if rec.Msg.Rtr {
+		rec.Msg.Data = [8]byte{}
+	}
+	ok = true
+	return
+}
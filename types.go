@@ -1,5 +1,11 @@
 package ixxatvci3
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 //BitrateRegisterPair Two CAN bitrate registers.
 type BitrateRegisterPair struct {
 	Btr0 byte
@@ -48,3 +54,198 @@ const (
 	opmodeLISTONLY  = 0x08 // listen only mode (TX passive)
 	opmodeLOWSPEED  = 0x10 // use low speed bus interface
 )
+
+//FifoConfig is the channel buffer configuration for SetFifoConfig.
+//Zero fields select the defaults, each backend uses its own fields.
+type FifoConfig struct {
+	RxFifoSize  uint16 // VCI3 receive FIFO size in frames, 1024 by default
+	RxThreshold uint16 // VCI3 frames in the receive FIFO signalling the receive event, 1 by default
+	TxFifoSize  uint16 // VCI3 transmit FIFO size in frames, 128 by default
+	TxThreshold uint16 // VCI3 free transmit FIFO entries signalling the transmit event, 1 by default
+
+	SocketRcvBuf int // SocketCAN SO_RCVBUF in bytes, limited by net.core.rmem_max
+	SocketSndBuf int // SocketCAN SO_SNDBUF in bytes, limited by net.core.wmem_max
+	TxQueueLen   int // SocketCAN interface txqueuelen in frames
+}
+
+//FifoStatus is the buffer configuration in effect and the number of receive overruns.
+type FifoStatus struct {
+	FifoConfig
+	RxOverruns uint32 // VCI3: frames flagged with a data overrun; SocketCAN: interface rx_over_errors and rx_fifo_errors
+}
+
+//AccessMode is the channel access mode for SetAccessMode.
+type AccessMode uint8
+
+//Access modes of the VCI backend
+const (
+	//AccessShared shares the channel and accepts a controller initialized by another application
+	//if its bitrate is the desired one. It is the default.
+	AccessShared AccessMode = 0
+	//AccessExclusive opens the channel exclusively and fails with VCI_E_ACCESSDENIED
+	//if another application uses the channel or the controller.
+	AccessExclusive AccessMode = 1
+	//AccessMonitor shares the channel and never initializes, starts or resets the controller:
+	//it only receives while another application runs the bus. Bitrate 0:0 accepts any bitrate.
+	AccessMonitor AccessMode = 2
+)
+
+func (m AccessMode) String() string {
+	switch m {
+	case AccessShared:
+		return "shared"
+	case AccessExclusive:
+		return "exclusive"
+	case AccessMonitor:
+		return "monitor"
+	}
+	return fmt.Sprintf("AccessMode(%d)", uint8(m))
+}
+
+//ParseAccessMode parses "shared", "exclusive" or "monitor".
+func ParseAccessMode(s string) (mode AccessMode, err error) {
+	for _, m := range []AccessMode{AccessShared, AccessExclusive, AccessMonitor} {
+		if strings.EqualFold(strings.TrimSpace(s), m.String()) {
+			return m, nil
+		}
+	}
+	err = fmt.Errorf("invalid access mode %q, want shared, exclusive or monitor", s)
+	return
+}
+
+//Ownership reports how this process uses the channel of a device.
+type Ownership struct {
+	Mode           AccessMode // access mode the channel is opened with
+	Opened         bool       // the channel is open
+	OwnsController bool       // this process initialized and started the controller and resets it on close
+}
+
+//CAN controller status bits of CANLineStatus.Status
+const (
+	CAN_STATUS_TXPEND  = 0x01 // transmission pending
+	CAN_STATUS_OVRRUN  = 0x02 // data overrun occurred
+	CAN_STATUS_ERRLIM  = 0x04 // error warning limit exceeded
+	CAN_STATUS_BUSOFF  = 0x08 // bus off status
+	CAN_STATUS_ININIT  = 0x10 // init mode active
+	CAN_STATUS_BUSCERR = 0x20 // bus coupling error
+)
+
+//Error frame types, indexes of ErrorFrameCounts (CAN_ERROR_* of cantype.h)
+const (
+	CAN_ERROR_STUFF = 1 // stuff error
+	CAN_ERROR_FORM  = 2 // form error
+	CAN_ERROR_ACK   = 3 // acknowledgment error
+	CAN_ERROR_BIT   = 4 // bit error
+	CAN_ERROR_CRC   = 6 // CRC error
+	CAN_ERROR_OTHER = 7 // other (unspecified) error
+)
+
+//ErrorFrameCounts are numbers of received error frames by CAN_ERROR_* type.
+//Error frames are received in "err" operating mode only.
+type ErrorFrameCounts [8]uint32
+
+//ErrorFrameName returns a short name of CAN_ERROR_* type: "stuff", "form", "ack", "bit", "crc" or "other".
+func ErrorFrameName(kind int) string {
+	switch kind {
+	case CAN_ERROR_STUFF:
+		return "stuff"
+	case CAN_ERROR_FORM:
+		return "form"
+	case CAN_ERROR_ACK:
+		return "ack"
+	case CAN_ERROR_BIT:
+		return "bit"
+	case CAN_ERROR_CRC:
+		return "crc"
+	}
+	return "other"
+}
+
+//DeviceInfo describes an available CAN device
+type DeviceInfo struct {
+	Description  string // device description, e.g. "USB-to-CAN V2"; interface name on Linux
+	HardwareID   string // unique hardware identifier
+	Manufacturer string // manufacturer; driver name on Linux
+}
+
+//StandardBitrates is a list of predefined bitrates with their values in bit/s
+var StandardBitrates = []struct {
+	Bitrate       BitrateRegisterPair
+	BitsPerSecond uint32
+}{
+	{Bitrate10kbps, 10000},
+	{Bitrate20kbps, 20000},
+	{Bitrate25kbps, 25000},
+	{Bitrate50kbps, 50000},
+	{Bitrate100kbps, 100000},
+	{Bitrate125kbps, 125000},
+	{Bitrate250kbps, 250000},
+	{Bitrate500kbps, 500000},
+	{Bitrate800kbps, 800000},
+	{Bitrate1000kbps, 1000000},
+}
+
+//BitsPerSecond returns the bitrate of a predefined register pair, 0 for others
+func (brp BitrateRegisterPair) BitsPerSecond() uint32 {
+	for _, b := range StandardBitrates {
+		if b.Bitrate == brp {
+			return b.BitsPerSecond
+		}
+	}
+	return 0
+}
+
+func (brp BitrateRegisterPair) String() string {
+	if bps := brp.BitsPerSecond(); bps != 0 {
+		return fmt.Sprintf("%dkbps", bps/1000)
+	}
+	return fmt.Sprintf("%02X:%02X", brp.Btr0, brp.Btr1)
+}
+
+//ParseBitrate parses a bitrate.
+//Accepted values are predefined bitrates in kbit/s ("125", "125k", "125kbps", "1M")
+//and register values "BTR0:BTR1" in hex ("03:1C").
+func ParseBitrate(s string) (brp BitrateRegisterPair, err error) {
+	s = strings.TrimSpace(s)
+	if parts := strings.Split(s, ":"); 2 == len(parts) {
+		var btr0, btr1 uint64
+		btr0, err = strconv.ParseUint(parts[0], 16, 8)
+		if nil == err {
+			btr1, err = strconv.ParseUint(parts[1], 16, 8)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid bit timing registers %q", s)
+			return
+		}
+		brp = BitrateRegisterPair{Btr0: byte(btr0), Btr1: byte(btr1)}
+		return
+	}
+
+	num := strings.ToLower(s)
+	num = strings.TrimSuffix(num, "bps")
+	num = strings.TrimSuffix(num, "bit/s")
+	mult := uint64(1000)
+	switch {
+	case strings.HasSuffix(num, "k"):
+		num = num[:len(num)-1]
+	case strings.HasSuffix(num, "m"):
+		num = num[:len(num)-1]
+		mult = 1000000
+	}
+	v, e := strconv.ParseUint(num, 10, 32)
+	if e != nil {
+		err = fmt.Errorf("invalid bitrate %q", s)
+		return
+	}
+	if v >= 10000 {
+		mult = 1 // plain bit/s
+	}
+	for _, b := range StandardBitrates {
+		if uint64(b.BitsPerSecond) == v*mult {
+			brp = b.Bitrate
+			return
+		}
+	}
+	err = fmt.Errorf("unsupported bitrate %q", s)
+	return
+}
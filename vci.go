@@ -0,0 +1,544 @@
+package ixxatvci3
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// vciDevMax is the number of device numbers of the VCI backend.
+const vciDevMax = 10
+
+// vciInfinite is the INFINITE timeout of the VCI wait functions.
+const vciInfinite = 0xFFFFFFFF
+
+// vciDefaultFifo are the rx FIFO size, rx threshold, tx FIFO size and tx threshold used for zero values.
+var vciDefaultFifo = [4]uint16{1024, 1, 128, 1}
+
+// vciDevice is the state of a device number: the handles and the settings applied by OpenChannel.
+type vciDevice struct {
+	device, ctl, chn vciHandle
+	opMode           uint8            // CAN_OPMODE_*
+	errorFrames      ErrorFrameCounts // received error frames by CAN_ERROR_* type
+	txEcho           bool             // request self reception of sent frames
+	fifo             [4]uint16        // rx FIFO size, rx threshold, tx FIFO size, tx threshold; 0 selects the default
+	rxOverruns       uint32           // received messages flagged with a data overrun
+	access           AccessMode
+	ownsController   bool // the controller is initialized and started by this process
+}
+
+// vciBackend is the native Windows backend: the table of device numbers, the opening sequence
+// of channel and controller and the frame conversion on top of the vcinpl calls of api.
+// It is platform independent, the api is the DLL or a replacement.
+type vciBackend struct {
+	api  vciAPI
+	mu   sync.Mutex
+	devs [vciDevMax]vciDevice
+}
+
+func newVCIBackend(api vciAPI) *vciBackend {
+	return &vciBackend{api: api}
+}
+
+// errorText returns the VCI text of an error code.
+func (b *vciBackend) errorText(vcierr uint32) string {
+	if text := b.api.FormatError(vcierr); text != "" {
+		return text
+	}
+	return fmt.Sprintf("VCI error 0x%08X", vcierr)
+}
+
+func (b *vciBackend) listDevices() (list []DeviceInfo, vcierr uint32) {
+	hEnum, vcierr := b.api.EnumDeviceOpen()
+	if vcierr != VCI_OK {
+		return
+	}
+	defer b.api.EnumDeviceClose(hEnum)
+	for {
+		info, ret := b.api.EnumDeviceNext(hEnum)
+		if VCI_E_NO_MORE_ITEMS == ret {
+			return
+		}
+		if ret != VCI_OK {
+			vcierr = ret
+			return
+		}
+		list = append(list, DeviceInfo{Description: info.Description, HardwareID: info.HardwareID, Manufacturer: info.Manufacturer})
+	}
+}
+
+// device returns the state of devnum, nil for invalid numbers. Call it with b.mu locked.
+func (b *vciBackend) device(devnum uint8) *vciDevice {
+	if devnum >= vciDevMax {
+		return nil
+	}
+	return &b.devs[devnum]
+}
+
+// SelectDevice opens a device selected in the dialog or the first one found.
+// A device opened before on assignnumber is closed and its settings are reset.
+func (b *vciBackend) SelectDevice(userselect bool, assignnumber uint8) (vcierr uint32) {
+	if assignnumber >= vciDevMax {
+		return VCI_E_INVALIDARG
+	}
+	var id vciID
+	if userselect {
+		id, vcierr = b.api.SelectDeviceDlg()
+	} else {
+		var hEnum vciHandle
+		if hEnum, vcierr = b.api.EnumDeviceOpen(); VCI_OK == vcierr {
+			var info vciDeviceInfo
+			info, vcierr = b.api.EnumDeviceNext(hEnum)
+			id = info.ObjectID
+			b.api.EnumDeviceClose(hEnum)
+		}
+	}
+	if vcierr != VCI_OK {
+		return
+	}
+	hDevice, vcierr := b.api.DeviceOpen(id)
+	if vcierr != VCI_OK {
+		return
+	}
+	b.mu.Lock()
+	d := b.device(assignnumber)
+	b.closeDevice(d) // a device opened before on this number
+	d.device = hDevice
+	d.opMode = opmodeSTANDARD // default value is 11-bit standard mode
+	b.mu.Unlock()
+	return
+}
+
+// SetOpMode sets CAN_OPMODE_* flags for OpenChannel.
+func (b *vciBackend) SetOpMode(devnum uint8, mode OpMode) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return VCI_E_INVALIDARG
+	}
+	d.opMode = uint8(mode)
+	return VCI_OK
+}
+
+// SupportedOpModes converts the controller capabilities to operating modes, with a temporary
+// channel before OpenChannel. Standard and extended frames together need simultaneous 11 and 29 bit support.
+func (b *vciBackend) SupportedOpModes(devnum uint8) (modes OpMode, vcierr uint32) {
+	b.mu.Lock()
+	d := b.device(devnum)
+	if nil == d {
+		b.mu.Unlock()
+		return 0, VCI_E_INVALIDARG
+	}
+	hDevice, hChn := d.device, d.chn
+	b.mu.Unlock()
+	if 0 == hDevice {
+		return 0, VCI_E_INVHANDLE
+	}
+
+	if 0 == hChn {
+		if hChn, vcierr = b.api.ChannelOpen(hDevice, 0, false); vcierr != VCI_OK {
+			return
+		}
+		defer b.api.ChannelClose(hChn)
+	}
+	caps, vcierr := b.api.ChannelGetCaps(hChn)
+	if vcierr != VCI_OK {
+		return
+	}
+
+	modes = OpModeStandard
+	if caps.Features&(canFeatureStdOrExt|canFeatureStdAndExt) != 0 {
+		modes |= OpModeExtended
+	}
+	if caps.Features&canFeatureErrFrame != 0 {
+		modes |= OpModeErrFrame
+	}
+	if caps.Features&canFeatureListOnly != 0 {
+		modes |= OpModeListenOnly
+	}
+	if caps.BusCoupling&canBusCouplingLow != 0 {
+		modes |= OpModeLowSpeed
+	}
+	return
+}
+
+// openChannel opens, initializes and activates the channel and opens the controller.
+// A channel opened before is closed first; on errors the handles opened here are closed again.
+func (b *vciBackend) openChannel(devnum uint8) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return VCI_E_INVALIDARG
+	}
+	if 0 == d.device {
+		return VCI_E_INVHANDLE
+	}
+	b.closeChannel(d)
+	defer func() {
+		if vcierr != VCI_OK {
+			b.closeChannel(d)
+		}
+	}()
+	if d.chn, vcierr = b.api.ChannelOpen(d.device, 0, AccessExclusive == d.access); vcierr != VCI_OK {
+		d.chn = 0
+		return
+	}
+	for i, size := range d.fifo {
+		if 0 == size {
+			d.fifo[i] = vciDefaultFifo[i]
+		}
+	}
+	if vcierr = b.api.ChannelInitialize(d.chn, d.fifo[0], d.fifo[1], d.fifo[2], d.fifo[3]); vcierr != VCI_OK {
+		return
+	}
+	if vcierr = b.api.ChannelActivate(d.chn, true); vcierr != VCI_OK {
+		return
+	}
+	if d.ctl, vcierr = b.api.ControlOpen(d.device, 0); vcierr != VCI_OK {
+		d.ctl = 0
+	}
+	return
+}
+
+// closeChannel resets a controller started by this process and closes the controller
+// and the channel, in reverse order of opening. Call it with b.mu locked.
+func (b *vciBackend) closeChannel(d *vciDevice) {
+	if d.ownsController {
+		b.api.ControlReset(d.ctl)
+	}
+	if d.ctl != 0 {
+		b.api.ControlClose(d.ctl)
+	}
+	if d.chn != 0 {
+		b.api.ChannelClose(d.chn)
+	}
+	d.ctl, d.chn, d.ownsController = 0, 0, false
+}
+
+// closeDevice closes the channel and the device and resets the settings. Call it with b.mu locked.
+func (b *vciBackend) closeDevice(d *vciDevice) {
+	b.closeChannel(d)
+	if d.device != 0 {
+		b.api.DeviceClose(d.device)
+	}
+	*d = vciDevice{}
+}
+
+// startController initializes and starts the controller according to the access mode.
+// A controller initialized by someone else is accepted in shared and monitor modes if its bitrate
+// is the desired one; in monitor mode 0:0 accepts any bitrate and the controller is never initialized.
+func (b *vciBackend) startController(devnum uint8, btr0, btr1 uint8) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+
+	vcierr = VCI_E_ACCESSDENIED
+	if d.access != AccessMonitor {
+		vcierr = b.api.ControlInitialize(d.ctl, d.opMode, btr0, btr1)
+	}
+	if VCI_E_ACCESSDENIED == vcierr { //initialized by someone else
+		if AccessExclusive == d.access {
+			return
+		}
+		// get current bitrate. is it equal to desired one?
+		st, ret := b.api.ControlGetStatus(d.ctl)
+		switch {
+		case ret != VCI_OK:
+			return ret
+		case AccessMonitor == d.access && 0 == btr0 && 0 == btr1:
+			return VCI_OK
+		case st.BtReg0 != btr0 || st.BtReg1 != btr1:
+			return VCI_E_BUSY
+		}
+		return VCI_OK
+	}
+	if VCI_OK == vcierr {
+		d.ownsController = true                                // initialized here, so reset by closeChannel
+		vcierr = b.api.ControlSetAccFilter(d.ctl, false, 0, 0) // CAN_ACC_CODE_ALL, CAN_ACC_MASK_ALL
+	}
+	if VCI_OK == vcierr {
+		vcierr = b.api.ControlStart(d.ctl, true)
+	}
+	return
+}
+
+// OpenChannel opens and starts the channel and the controller.
+// If the controller cannot be started, the channel is closed again.
+func (b *vciBackend) OpenChannel(devnum uint8, btr0 uint8, btr1 uint8) (vcierr uint32) {
+	if vcierr = b.openChannel(devnum); vcierr != VCI_OK {
+		return
+	}
+	if vcierr = b.startController(devnum, btr0, btr1); vcierr != VCI_OK {
+		b.abortChannel(devnum)
+	}
+	return
+}
+
+// abortChannel closes the channel of devnum after a failed start.
+func (b *vciBackend) abortChannel(devnum uint8) {
+	b.mu.Lock()
+	b.closeChannel(b.device(devnum))
+	b.mu.Unlock()
+}
+
+// OpenChannelDetectBitrate opens the channel with canControlDetectBitrate.
+// In monitor mode the bitrate of the running controller must be in the list.
+func (b *vciBackend) OpenChannelDetectBitrate(devnum uint8, timeout time.Duration, bitrate []BitrateRegisterPair) (detected BitrateRegisterPair, err error) {
+	if len(bitrate) <= 0 {
+		err = fmt.Errorf("%s", "bitrate array is empty")
+		return
+	}
+	btr0 := make([]byte, len(bitrate))
+	btr1 := make([]byte, len(bitrate))
+	for i, brp := range bitrate {
+		btr0[i], btr1[i] = brp.Btr0, brp.Btr1
+	}
+
+	vcierr := b.openChannel(devnum)
+	if vcierr != VCI_OK {
+		err = fmt.Errorf("%s", b.errorText(vcierr))
+		return
+	}
+	b.mu.Lock()
+	d := b.device(devnum)
+	hCtl, access := d.ctl, d.access
+	b.mu.Unlock()
+
+	index := int32(-1)
+	if AccessMonitor == access {
+		st, ret := b.api.ControlGetStatus(hCtl)
+		vcierr = ret
+		for i, brp := range bitrate {
+			if VCI_OK == vcierr && brp.Btr0 == st.BtReg0 && brp.Btr1 == st.BtReg1 {
+				index = int32(i)
+				break
+			}
+		}
+		if VCI_OK == vcierr && index < 0 {
+			vcierr = VCI_E_BUSY
+		}
+	} else {
+		index, vcierr = b.api.ControlDetectBitrate(hCtl, uint16(timeout/time.Millisecond), btr0, btr1)
+		if VCI_OK == vcierr && (index < 0 || int(index) >= len(bitrate)) {
+			b.abortChannel(devnum)
+			err = fmt.Errorf("%s", "wrong index of bitrate array")
+			return
+		}
+		if VCI_OK == vcierr {
+			vcierr = b.startController(devnum, btr0[index], btr1[index])
+		}
+	}
+	if vcierr != VCI_OK {
+		b.abortChannel(devnum)
+		err = fmt.Errorf("%s", b.errorText(vcierr))
+		return
+	}
+	detected = bitrate[index]
+	return
+}
+
+// Send transmits a frame, the high bit of msgid selects 29-bit format.
+func (b *vciBackend) Send(devnum uint8, msgid uint32, rtr bool, msgdata []byte) (vcierr uint32) {
+	return b.SendTimeout(devnum, msgid, rtr, msgdata, -1)
+}
+
+// SendTimeout transmits a frame waiting at most timeout for space in the transmit FIFO,
+// 0 fails with VCI_E_TXQUEUE_FULL at once if the FIFO is full.
+func (b *vciBackend) SendTimeout(devnum uint8, msgid uint32, rtr bool, msgdata []byte, timeout time.Duration) (vcierr uint32) {
+	b.mu.Lock()
+	d := b.device(devnum)
+	if nil == d {
+		b.mu.Unlock()
+		return VCI_E_INVALIDARG
+	}
+	hChn, access, echo := d.chn, d.access, d.txEcho
+	b.mu.Unlock()
+	if AccessMonitor == access {
+		return VCI_E_ACCESSDENIED
+	}
+
+	msg, vcierr := txMessage(msgid, rtr, msgdata, echo)
+	if vcierr != VCI_OK {
+		return
+	}
+	if 0 == timeout {
+		return b.api.ChannelPostMessage(hChn, msg)
+	}
+	ms := uint32(vciInfinite)
+	if timeout > 0 && timeout < time.Duration(ms)*time.Millisecond {
+		ms = uint32((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+	return b.api.ChannelSendMessage(hChn, ms, msg)
+}
+
+// txMessage converts a frame to CANMSG. Identifiers above 0x7FF or with the high bit set are extended.
+// The length of msgdata is the DLC, also of a remote frame, which carries no data.
+func txMessage(msgid uint32, rtr bool, msgdata []byte, echo bool) (msg canMsg, vcierr uint32) {
+	if len(msgdata) > 8 {
+		return msg, VCI_E_INVALIDARG
+	}
+	msg.MsgID = msgid & 0x1FFFFFFF
+	msg.Type = canMsgTypeData
+	msg.Flags |= uint8(len(msgdata))
+	if rtr {
+		msg.Flags |= canMsgFlagsRTR
+	} else {
+		copy(msg.Data[:], msgdata)
+	}
+	if msgid > 0x7FF {
+		msg.Flags |= canMsgFlagsEXT
+	}
+	if echo {
+		msg.Flags |= canMsgFlagsSRR // the controller receives the frame after transmission
+	}
+	return
+}
+
+// Receive reads a frame from the receive FIFO, VCI_E_RXQUEUE_EMPTY if there is none.
+// Error frames are counted and reported as VCI_E_NO_DATA.
+func (b *vciBackend) Receive(devnum uint8) (vcierr uint32, msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+	b.mu.Lock()
+	d := b.device(devnum)
+	if nil == d {
+		b.mu.Unlock()
+		vcierr = VCI_E_INVALIDARG
+		return
+	}
+	hChn := d.chn
+	b.mu.Unlock()
+
+	msg, vcierr := b.api.ChannelPeekMessage(hChn)
+	if vcierr != VCI_OK {
+		return
+	}
+	b.mu.Lock()
+	if msg.Flags&canMsgFlagsOVR != 0 {
+		d.rxOverruns++
+	}
+	if canMsgTypeError == msg.Type {
+		d.errorFrames[msg.Data[0]&7]++
+	}
+	b.mu.Unlock()
+
+	if msg.Type != canMsgTypeData {
+		vcierr = VCI_E_NO_DATA
+		return
+	}
+	msgid, rtr, msgdata, msgdatasize = rxMessage(msg)
+	return
+}
+
+// rxMessage converts a received data frame, data beyond the length is zero.
+// Extended frames have the high bit of msgid set.
+func rxMessage(msg canMsg) (msgid uint32, rtr bool, msgdata [8]byte, msgdatasize uint8) {
+	msgid, rtr, msgdatasize = msg.MsgID, msg.Flags&canMsgFlagsRTR != 0, msg.dlc()
+	if msg.Flags&canMsgFlagsEXT != 0 {
+		msgid |= 1 << 31
+	}
+	if msgdatasize > 8 {
+		msgdatasize = 8
+	}
+	copy(msgdata[:], msg.Data[:msgdatasize])
+	return
+}
+
+// GetStatus returns the channel status.
+func (b *vciBackend) GetStatus(devnum uint8) (status CANChanStatus, vcierr uint32) {
+	b.mu.Lock()
+	d := b.device(devnum)
+	if nil == d {
+		b.mu.Unlock()
+		vcierr = VCI_E_INVALIDARG
+		return
+	}
+	hChn := d.chn
+	b.mu.Unlock()
+	return b.api.ChannelGetStatus(hChn)
+}
+
+// ErrorFrameCounts returns error frames counted by Receive.
+func (b *vciBackend) ErrorFrameCounts(devnum uint8) (counts ErrorFrameCounts, vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return counts, VCI_E_INVALIDARG
+	}
+	return d.errorFrames, VCI_OK
+}
+
+// SetFifoConfig sets FIFO sizes and thresholds used by OpenChannel.
+func (b *vciBackend) SetFifoConfig(devnum uint8, cfg FifoConfig) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return VCI_E_INVALIDARG
+	}
+	d.fifo = [4]uint16{cfg.RxFifoSize, cfg.RxThreshold, cfg.TxFifoSize, cfg.TxThreshold}
+	return VCI_OK
+}
+
+// FifoStatus returns FIFO sizes and thresholds of the open channel and overruns counted by Receive.
+func (b *vciBackend) FifoStatus(devnum uint8) (status FifoStatus, vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return status, VCI_E_INVALIDARG
+	}
+	status.RxFifoSize, status.RxThreshold = d.fifo[0], d.fifo[1]
+	status.TxFifoSize, status.TxThreshold = d.fifo[2], d.fifo[3]
+	status.RxOverruns = d.rxOverruns
+	return status, VCI_OK
+}
+
+// SetAccessMode sets the channel access mode used by OpenChannel.
+func (b *vciBackend) SetAccessMode(devnum uint8, mode AccessMode) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d || mode > AccessMonitor {
+		return VCI_E_INVALIDARG
+	}
+	d.access = mode
+	return VCI_OK
+}
+
+// Ownership reports the access mode and whether the controller is started by this process.
+func (b *vciBackend) Ownership(devnum uint8) (ownership Ownership, vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return ownership, VCI_E_INVALIDARG
+	}
+	return Ownership{Mode: d.access, Opened: d.chn != 0, OwnsController: d.ownsController}, VCI_OK
+}
+
+// SetTxEcho requests self reception of sent frames.
+func (b *vciBackend) SetTxEcho(devnum uint8, echo bool) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return VCI_E_INVALIDARG
+	}
+	d.txEcho = echo
+	return VCI_OK
+}
+
+// CloseDevice closes the channel, the controller and the device and resets the device number
+// to the defaults. A controller of another application is not reset.
+func (b *vciBackend) CloseDevice(devnum uint8) (vcierr uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.device(devnum)
+	if nil == d {
+		return VCI_E_INVALIDARG
+	}
+	b.closeDevice(d)
+	return VCI_OK
+}
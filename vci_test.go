@@ -0,0 +1,635 @@
+package ixxatvci3
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeVCI is a vciAPI recording the calls, with a device list, a running
+// controller of another application and errors injected per function.
+type fakeVCI struct {
+	calls   []string
+	fail    map[string]uint32      // function name -> HRESULT
+	devices []vciDeviceInfo        // enumerated devices
+	open    map[vciHandle]string   // open handles and their kinds
+	next    vciHandle              // last handle returned
+	enumPos map[vciHandle]int      // devices returned by the device lists
+	ctlInit bool                   // the controller is initialized, ControlInitialize is denied
+	ctlBtr  [2]uint8               // bitrate of the initialized controller
+	rx      map[vciHandle][]canMsg // receive FIFOs of the channels
+	tx      map[vciHandle][]canMsg // sent messages of the channels
+	index   int32                  // result of ControlDetectBitrate
+}
+
+func newFakeVCI(devices int) *fakeVCI {
+	f := &fakeVCI{fail: map[string]uint32{}, open: map[vciHandle]string{}, enumPos: map[vciHandle]int{},
+		rx: map[vciHandle][]canMsg{}, tx: map[vciHandle][]canMsg{}}
+	for i := 0; i < devices; i++ {
+		f.devices = append(f.devices, vciDeviceInfo{ObjectID: vciID(100 + i),
+			HardwareID: fmt.Sprintf("HW%06d", i), Description: "USB-to-CAN V2", Manufacturer: "IXXAT"})
+	}
+	return f
+}
+
+func (f *fakeVCI) call(name string, args ...interface{}) uint32 {
+	s := name
+	if len(args) > 0 {
+		s += " " + fmt.Sprintf("%v", args)
+	}
+	f.calls = append(f.calls, s)
+	return f.fail[name]
+}
+
+func (f *fakeVCI) openHandle(kind string) vciHandle {
+	f.next++
+	f.open[f.next] = kind
+	return f.next
+}
+
+func (f *fakeVCI) closeHandle(h vciHandle, kind string) uint32 {
+	if f.open[h] != kind {
+		return VCI_E_INVHANDLE
+	}
+	delete(f.open, h)
+	return VCI_OK
+}
+
+// names returns the called function names.
+func (f *fakeVCI) names() (names []string) {
+	for _, s := range f.calls {
+		names = append(names, strings.SplitN(s, " ", 2)[0])
+	}
+	return
+}
+
+func (f *fakeVCI) EnumDeviceOpen() (vciHandle, uint32) {
+	if hr := f.call("EnumDeviceOpen"); hr != VCI_OK {
+		return 0, hr
+	}
+	return f.openHandle("enum"), VCI_OK
+}
+
+func (f *fakeVCI) EnumDeviceNext(hEnum vciHandle) (info vciDeviceInfo, hr uint32) {
+	if hr = f.call("EnumDeviceNext"); hr != VCI_OK {
+		return
+	}
+	n := f.enumPos[hEnum]
+	if n >= len(f.devices) {
+		return info, VCI_E_NO_MORE_ITEMS
+	}
+	f.enumPos[hEnum]++
+	return f.devices[n], VCI_OK
+}
+
+func (f *fakeVCI) EnumDeviceClose(hEnum vciHandle) uint32 {
+	f.call("EnumDeviceClose")
+	return f.closeHandle(hEnum, "enum")
+}
+
+func (f *fakeVCI) SelectDeviceDlg() (vciID, uint32) {
+	return f.devices[len(f.devices)-1].ObjectID, f.call("SelectDeviceDlg")
+}
+
+func (f *fakeVCI) DeviceOpen(id vciID) (vciHandle, uint32) {
+	if hr := f.call("DeviceOpen", id); hr != VCI_OK {
+		return 0, hr
+	}
+	return f.openHandle("device"), VCI_OK
+}
+
+func (f *fakeVCI) DeviceClose(h vciHandle) uint32 {
+	f.call("DeviceClose")
+	return f.closeHandle(h, "device")
+}
+
+func (f *fakeVCI) ControlOpen(hDevice vciHandle, canNo uint32) (vciHandle, uint32) {
+	if hr := f.call("ControlOpen"); hr != VCI_OK {
+		return 0, hr
+	}
+	return f.openHandle("control"), VCI_OK
+}
+
+func (f *fakeVCI) ControlClose(h vciHandle) uint32 {
+	f.call("ControlClose")
+	return f.closeHandle(h, "control")
+}
+
+func (f *fakeVCI) ControlGetStatus(hCtl vciHandle) (CANLineStatus, uint32) {
+	return CANLineStatus{BtReg0: f.ctlBtr[0], BtReg1: f.ctlBtr[1]}, f.call("ControlGetStatus")
+}
+
+func (f *fakeVCI) ControlDetectBitrate(hCtl vciHandle, timeoutMs uint16, btr0, btr1 []byte) (int32, uint32) {
+	return f.index, f.call("ControlDetectBitrate", timeoutMs, len(btr0))
+}
+
+func (f *fakeVCI) ControlInitialize(hCtl vciHandle, mode, btr0, btr1 uint8) uint32 {
+	if hr := f.call("ControlInitialize", mode, btr0, btr1); hr != VCI_OK {
+		return hr
+	}
+	if f.ctlInit {
+		return VCI_E_ACCESSDENIED
+	}
+	f.ctlBtr = [2]uint8{btr0, btr1}
+	return VCI_OK
+}
+
+func (f *fakeVCI) ControlReset(hCtl vciHandle) uint32 {
+	return f.call("ControlReset")
+}
+
+func (f *fakeVCI) ControlStart(hCtl vciHandle, start bool) uint32 {
+	return f.call("ControlStart", start)
+}
+
+func (f *fakeVCI) ControlSetAccFilter(hCtl vciHandle, extended bool, code, mask uint32) uint32 {
+	return f.call("ControlSetAccFilter")
+}
+
+func (f *fakeVCI) ChannelOpen(hDevice vciHandle, canNo uint32, exclusive bool) (vciHandle, uint32) {
+	if hr := f.call("ChannelOpen", exclusive); hr != VCI_OK {
+		return 0, hr
+	}
+	return f.openHandle("channel"), VCI_OK
+}
+
+func (f *fakeVCI) ChannelClose(h vciHandle) uint32 {
+	f.call("ChannelClose")
+	return f.closeHandle(h, "channel")
+}
+
+func (f *fakeVCI) ChannelGetCaps(hChn vciHandle) (canCapabilities, uint32) {
+	return canCapabilities{Features: canFeatureStdAndExt | canFeatureErrFrame}, f.call("ChannelGetCaps")
+}
+
+func (f *fakeVCI) ChannelGetStatus(hChn vciHandle) (CANChanStatus, uint32) {
+	return CANChanStatus{Activated: 1}, f.call("ChannelGetStatus")
+}
+
+func (f *fakeVCI) ChannelInitialize(hChn vciHandle, rxSize, rxThreshold, txSize, txThreshold uint16) uint32 {
+	return f.call("ChannelInitialize", rxSize, rxThreshold, txSize, txThreshold)
+}
+
+func (f *fakeVCI) ChannelActivate(hChn vciHandle, enable bool) uint32 {
+	return f.call("ChannelActivate", enable)
+}
+
+func (f *fakeVCI) ChannelPeekMessage(hChn vciHandle) (msg canMsg, hr uint32) {
+	if 0 == len(f.rx[hChn]) {
+		return msg, VCI_E_RXQUEUE_EMPTY
+	}
+	msg, f.rx[hChn] = f.rx[hChn][0], f.rx[hChn][1:]
+	return msg, VCI_OK
+}
+
+func (f *fakeVCI) ChannelReadMessage(hChn vciHandle, timeoutMs uint32) (canMsg, uint32) {
+	return f.ChannelPeekMessage(hChn)
+}
+
+func (f *fakeVCI) ChannelPostMessage(hChn vciHandle, msg canMsg) uint32 {
+	if hr := f.call("ChannelPostMessage"); hr != VCI_OK {
+		return hr
+	}
+	f.tx[hChn] = append(f.tx[hChn], msg)
+	return VCI_OK
+}
+
+func (f *fakeVCI) ChannelSendMessage(hChn vciHandle, timeoutMs uint32, msg canMsg) uint32 {
+	if hr := f.call("ChannelSendMessage", timeoutMs); hr != VCI_OK {
+		return hr
+	}
+	f.tx[hChn] = append(f.tx[hChn], msg)
+	return VCI_OK
+}
+
+func (f *fakeVCI) FormatError(hr uint32) string {
+	return ""
+}
+
+// channel returns the channel handle of devnum.
+func channel(b *vciBackend, devnum uint8) vciHandle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.devs[devnum].chn
+}
+
+// openFake opens device number devnum of a new backend on the first device.
+func openFake(t *testing.T, f *fakeVCI, devnum uint8) *vciBackend {
+	t.Helper()
+	b := newVCIBackend(f)
+	if hr := b.SelectDevice(false, devnum); hr != VCI_OK {
+		t.Fatalf("SelectDevice: 0x%08X", hr)
+	}
+	return b
+}
+
+func TestVCIOpenSequence(t *testing.T) {
+	f := newFakeVCI(1)
+	b := openFake(t, f, 0)
+	if hr := b.OpenChannel(0, 0x00, 0x1C); hr != VCI_OK {
+		t.Fatalf("OpenChannel: 0x%08X", hr)
+	}
+	want := []string{
+		"EnumDeviceOpen", "EnumDeviceNext", "EnumDeviceClose", "DeviceOpen [100]",
+		"ChannelOpen [false]", "ChannelInitialize [1024 1 128 1]", "ChannelActivate [true]", "ControlOpen",
+		"ControlInitialize [1 0 28]", "ControlSetAccFilter", "ControlStart [true]",
+	}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls\n got %q\nwant %q", f.calls, want)
+	}
+	own, _ := b.Ownership(0)
+	if !own.Opened || !own.OwnsController {
+		t.Errorf("Ownership = %+v", own)
+	}
+
+	f.calls = nil
+	if hr := b.CloseDevice(0); hr != VCI_OK {
+		t.Fatalf("CloseDevice: 0x%08X", hr)
+	}
+	want = []string{"ControlReset", "ControlClose", "ChannelClose", "DeviceClose"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("close calls\n got %q\nwant %q", f.calls, want)
+	}
+	if len(f.open) != 0 {
+		t.Errorf("handles left open: %v", f.open)
+	}
+}
+
+func TestVCIFifoConfig(t *testing.T) {
+	f := newFakeVCI(1)
+	b := openFake(t, f, 0)
+	b.SetFifoConfig(0, FifoConfig{RxFifoSize: 4096, TxThreshold: 16})
+	b.OpenChannel(0, 0x00, 0x1C)
+	if !contains(f.calls, "ChannelInitialize [4096 1 128 16]") {
+		t.Errorf("calls %q", f.calls)
+	}
+	st, _ := b.FifoStatus(0)
+	if st.RxFifoSize != 4096 || st.RxThreshold != 1 || st.TxFifoSize != 128 || st.TxThreshold != 16 {
+		t.Errorf("FifoStatus = %+v", st)
+	}
+}
+
+func TestVCIOpenErrorsCloseHandles(t *testing.T) {
+	for _, step := range []string{"ChannelOpen", "ChannelInitialize", "ChannelActivate", "ControlOpen",
+		"ControlInitialize", "ControlSetAccFilter", "ControlStart"} {
+		f := newFakeVCI(1)
+		b := openFake(t, f, 0)
+		f.fail[step] = VCI_E_FAIL
+		if hr := b.OpenChannel(0, 0x00, 0x1C); hr != VCI_E_FAIL {
+			t.Errorf("%s: OpenChannel = 0x%08X", step, hr)
+		}
+		if !onlyDevice(f.open) {
+			t.Errorf("%s: open handles %v, want the device only", step, f.open)
+		}
+		initialized := "ControlSetAccFilter" == step || "ControlStart" == step
+		if initialized != contains(f.calls, "ControlReset") {
+			t.Errorf("%s: controller reset %v, calls %q", step, !initialized, f.calls)
+		}
+		if own, _ := b.Ownership(0); own.Opened || own.OwnsController {
+			t.Errorf("%s: Ownership = %+v", step, own)
+		}
+
+		// the channel opens again and closing leaves nothing open
+		delete(f.fail, step)
+		if hr := b.OpenChannel(0, 0x00, 0x1C); hr != VCI_OK {
+			t.Errorf("%s: reopen = 0x%08X", step, hr)
+		}
+		b.CloseDevice(0)
+		if len(f.open) != 0 {
+			t.Errorf("%s: handles left open: %v", step, f.open)
+		}
+	}
+}
+
+func TestVCIReopen(t *testing.T) {
+	f := newFakeVCI(1)
+	b := openFake(t, f, 0)
+	b.OpenChannel(0, 0x00, 0x1C)
+	if hr := b.OpenChannel(0, 0x00, 0x14); hr != VCI_OK {
+		t.Fatalf("second OpenChannel: 0x%08X", hr)
+	}
+	if n := len(f.open); n != 3 {
+		t.Errorf("%d handles open after reopening the channel: %v", n, f.open)
+	}
+	if hr := b.SelectDevice(false, 0); hr != VCI_OK {
+		t.Fatalf("second SelectDevice: 0x%08X", hr)
+	}
+	if !onlyDevice(f.open) {
+		t.Errorf("handles open after reopening the device: %v", f.open)
+	}
+	b.CloseDevice(0)
+	if len(f.open) != 0 {
+		t.Errorf("handles left open: %v", f.open)
+	}
+}
+
+func TestVCIAccessDenied(t *testing.T) {
+	tests := []struct {
+		name       string
+		access     AccessMode
+		ctlInit    bool     // controller initialized by another application
+		ctlBtr     [2]uint8 // its bitrate
+		btr        [2]uint8 // requested bitrate
+		want       uint32
+		owns, init bool // owns the controller, ControlInitialize called
+	}{
+		{"shared free", AccessShared, false, [2]uint8{}, [2]uint8{0x00, 0x1C}, VCI_OK, true, true},
+		{"shared same bitrate", AccessShared, true, [2]uint8{0x00, 0x1C}, [2]uint8{0x00, 0x1C}, VCI_OK, false, true},
+		{"shared other bitrate", AccessShared, true, [2]uint8{0x00, 0x14}, [2]uint8{0x00, 0x1C}, VCI_E_BUSY, false, true},
+		{"exclusive free", AccessExclusive, false, [2]uint8{}, [2]uint8{0x00, 0x1C}, VCI_OK, true, true},
+		{"exclusive same bitrate", AccessExclusive, true, [2]uint8{0x00, 0x1C}, [2]uint8{0x00, 0x1C}, VCI_E_ACCESSDENIED, false, true},
+		{"monitor same bitrate", AccessMonitor, true, [2]uint8{0x00, 0x1C}, [2]uint8{0x00, 0x1C}, VCI_OK, false, false},
+		{"monitor other bitrate", AccessMonitor, true, [2]uint8{0x00, 0x14}, [2]uint8{0x00, 0x1C}, VCI_E_BUSY, false, false},
+		{"monitor any bitrate", AccessMonitor, true, [2]uint8{0x00, 0x14}, [2]uint8{0, 0}, VCI_OK, false, false},
+	}
+	for _, tt := range tests {
+		f := newFakeVCI(1)
+		f.ctlInit, f.ctlBtr = tt.ctlInit, tt.ctlBtr
+		b := openFake(t, f, 0)
+		b.SetAccessMode(0, tt.access)
+		if hr := b.OpenChannel(0, tt.btr[0], tt.btr[1]); hr != tt.want {
+			t.Errorf("%s: OpenChannel = 0x%08X, want 0x%08X", tt.name, hr, tt.want)
+		}
+		if exclusive := AccessExclusive == tt.access; !contains(f.calls, fmt.Sprintf("ChannelOpen [%v]", exclusive)) {
+			t.Errorf("%s: calls %q", tt.name, f.calls)
+		}
+		if init := contains(f.calls, fmt.Sprintf("ControlInitialize [1 %d %d]", tt.btr[0], tt.btr[1])); init != tt.init {
+			t.Errorf("%s: ControlInitialize called %v", tt.name, init)
+		}
+		own, _ := b.Ownership(0)
+		if own.Mode != tt.access || own.OwnsController != tt.owns || own.Opened != (VCI_OK == tt.want) {
+			t.Errorf("%s: Ownership = %+v", tt.name, own)
+		}
+		b.CloseDevice(0)
+		if reset := contains(f.calls, "ControlReset"); reset != tt.owns {
+			t.Errorf("%s: controller reset %v", tt.name, reset)
+		}
+		if len(f.open) != 0 {
+			t.Errorf("%s: handles left open: %v", tt.name, f.open)
+		}
+	}
+}
+
+func TestVCIDetectBitrate(t *testing.T) {
+	list := []BitrateRegisterPair{Bitrate125kbps, Bitrate250kbps, Bitrate500kbps}
+
+	f := newFakeVCI(1)
+	f.index = 2
+	b := openFake(t, f, 0)
+	found, err := b.OpenChannelDetectBitrate(0, 0, list)
+	if err != nil || found != Bitrate500kbps {
+		t.Errorf("detect = %v, %v", found, err)
+	}
+	if !contains(f.calls, fmt.Sprintf("ControlInitialize [1 %d %d]", Bitrate500kbps.Btr0, Bitrate500kbps.Btr1)) {
+		t.Errorf("calls %q", f.calls)
+	}
+
+	f = newFakeVCI(1)
+	f.index = 7
+	b = openFake(t, f, 0)
+	if _, err = b.OpenChannelDetectBitrate(0, 0, list); err == nil {
+		t.Error("index out of the list accepted")
+	}
+	if !onlyDevice(f.open) {
+		t.Errorf("handles open after a failed detection: %v", f.open)
+	}
+
+	f = newFakeVCI(1)
+	f.ctlInit, f.ctlBtr = true, [2]uint8{Bitrate250kbps.Btr0, Bitrate250kbps.Btr1}
+	b = openFake(t, f, 0)
+	b.SetAccessMode(0, AccessMonitor)
+	if found, err = b.OpenChannelDetectBitrate(0, 0, list); err != nil || found != Bitrate250kbps {
+		t.Errorf("monitor detect = %v, %v", found, err)
+	}
+	if contains(f.names(), "ControlDetectBitrate") {
+		t.Error("monitor mode ran the bitrate detection")
+	}
+}
+
+func TestVCITxConversion(t *testing.T) {
+	tests := []struct {
+		id   uint32
+		rtr  bool
+		data []byte
+		echo bool
+		want canMsg
+		hr   uint32
+	}{
+		{0x123, false, []byte{1, 2, 3}, false, canMsg{MsgID: 0x123, Flags: 3, Data: [8]byte{1, 2, 3}}, VCI_OK},
+		{0x7FF, false, nil, false, canMsg{MsgID: 0x7FF}, VCI_OK},
+		{0x800, false, []byte{1}, false, canMsg{MsgID: 0x800, Flags: 1 | canMsgFlagsEXT, Data: [8]byte{1}}, VCI_OK},
+		{0x80000012, false, nil, false, canMsg{MsgID: 0x12, Flags: canMsgFlagsEXT}, VCI_OK},
+		{0x1FFFFFFF, true, []byte{9, 9}, false, canMsg{MsgID: 0x1FFFFFFF, Flags: 2 | canMsgFlagsRTR | canMsgFlagsEXT}, VCI_OK},
+		{0x7DF, true, make([]byte, 8), false, canMsg{MsgID: 0x7DF, Flags: 8 | canMsgFlagsRTR}, VCI_OK},
+		{0x7DF, true, make([]byte, 9), false, canMsg{}, VCI_E_INVALIDARG},
+		{0x100, true, nil, true, canMsg{MsgID: 0x100, Flags: canMsgFlagsRTR | canMsgFlagsSRR}, VCI_OK},
+		{0x100, false, make([]byte, 8), false, canMsg{MsgID: 0x100, Flags: 8}, VCI_OK},
+		{0x100, false, make([]byte, 9), false, canMsg{}, VCI_E_INVALIDARG},
+	}
+	for _, tt := range tests {
+		f := newFakeVCI(1)
+		b := openFake(t, f, 0)
+		b.OpenChannel(0, 0x00, 0x1C)
+		b.SetTxEcho(0, tt.echo)
+		if hr := b.SendTimeout(0, tt.id, tt.rtr, tt.data, 0); hr != tt.hr {
+			t.Errorf("send %X: 0x%08X, want 0x%08X", tt.id, hr, tt.hr)
+			continue
+		}
+		if tt.hr != VCI_OK {
+			continue
+		}
+		if sent := f.tx[channel(b, 0)]; len(sent) != 1 || sent[0] != tt.want {
+			t.Errorf("send %X: %+v, want %+v", tt.id, sent, tt.want)
+		}
+	}
+}
+
+func TestVCISendTimeout(t *testing.T) {
+	f := newFakeVCI(1)
+	b := openFake(t, f, 0)
+	b.OpenChannel(0, 0x00, 0x1C)
+	b.Send(0, 1, false, nil)
+	b.SendTimeout(0, 1, false, nil, 1500000) // 1.5 ms
+	b.SendTimeout(0, 1, false, nil, 0)
+	want := []string{"ChannelSendMessage [4294967295]", "ChannelSendMessage [2]", "ChannelPostMessage"}
+	if got := f.calls[len(f.calls)-3:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls %q, want %q", got, want)
+	}
+
+	f.fail["ChannelPostMessage"] = VCI_E_TXQUEUE_FULL
+	if hr := b.SendTimeout(0, 1, false, nil, 0); hr != VCI_E_TXQUEUE_FULL {
+		t.Errorf("full FIFO: 0x%08X", hr)
+	}
+	b.SetAccessMode(0, AccessMonitor)
+	if hr := b.Send(0, 1, false, nil); hr != VCI_E_ACCESSDENIED {
+		t.Errorf("monitor send: 0x%08X", hr)
+	}
+}
+
+func TestVCIRxConversion(t *testing.T) {
+	tests := []struct {
+		msg  canMsg
+		id   uint32
+		rtr  bool
+		data [8]byte
+		n    uint8
+	}{
+		{canMsg{MsgID: 0x123, Flags: 2, Data: [8]byte{0xAA, 0xBB, 0xCC}}, 0x123, false, [8]byte{0xAA, 0xBB}, 2},
+		{canMsg{MsgID: 0x18DAF110, Flags: 8 | canMsgFlagsEXT, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, 0x98DAF110, false, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}, 8},
+		{canMsg{MsgID: 0x123, Flags: 1 | canMsgFlagsEXT, Data: [8]byte{9}}, 0x80000123, false, [8]byte{9}, 1},
+		{canMsg{MsgID: 0x7DF, Flags: 3 | canMsgFlagsRTR}, 0x7DF, true, [8]byte{}, 3},
+		{canMsg{MsgID: 0x100, Flags: 0x0F, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, 0x100, false, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}, 8},
+		{canMsg{MsgID: 0x1FFFFFFF, Flags: 12 | canMsgFlagsRTR | canMsgFlagsEXT}, 0x9FFFFFFF, true, [8]byte{}, 8},
+	}
+	f := newFakeVCI(1)
+	b := openFake(t, f, 0)
+	b.OpenChannel(0, 0x00, 0x1C)
+	hChn := channel(b, 0)
+	for _, tt := range tests {
+		f.rx[hChn] = append(f.rx[hChn], tt.msg)
+	}
+	for _, tt := range tests {
+		hr, id, rtr, data, n := b.Receive(0)
+		if hr != VCI_OK || id != tt.id || rtr != tt.rtr || data != tt.data || n != tt.n {
+			t.Errorf("receive %+v: 0x%08X %X %v %v %d", tt.msg, hr, id, rtr, data, n)
+		}
+	}
+	if hr, _, _, _, _ := b.Receive(0); hr != VCI_E_RXQUEUE_EMPTY {
+		t.Errorf("empty FIFO: 0x%08X", hr)
+	}
+}
+
+func TestVCIErrorFrames(t *testing.T) {
+	f := newFakeVCI(1)
+	b := openFake(t, f, 0)
+	b.OpenChannel(0, 0x00, 0x1C)
+	hChn := channel(b, 0)
+	f.rx[hChn] = []canMsg{
+		{Type: canMsgTypeError, Data: [8]byte{CAN_ERROR_STUFF}},
+		{Type: canMsgTypeError, Data: [8]byte{CAN_ERROR_ACK}},
+		{Type: canMsgTypeError, Data: [8]byte{CAN_ERROR_ACK}},
+		{MsgID: 0x10, Flags: 1 | canMsgFlagsOVR},
+		{Type: canMsgTypeError, Data: [8]byte{CAN_ERROR_CRC}},
+	}
+	var results []uint32
+	for range f.rx[hChn] {
+		hr, _, _, _, _ := b.Receive(0)
+		results = append(results, hr)
+	}
+	want := []uint32{VCI_E_NO_DATA, VCI_E_NO_DATA, VCI_E_NO_DATA, VCI_OK, VCI_E_NO_DATA}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results %X, want %X", results, want)
+	}
+	counts, _ := b.ErrorFrameCounts(0)
+	if counts[CAN_ERROR_STUFF] != 1 || counts[CAN_ERROR_ACK] != 2 || counts[CAN_ERROR_CRC] != 1 || counts[CAN_ERROR_FORM] != 0 {
+		t.Errorf("ErrorFrameCounts = %v", counts)
+	}
+	if st, _ := b.FifoStatus(0); st.RxOverruns != 1 {
+		t.Errorf("RxOverruns = %d", st.RxOverruns)
+	}
+}
+
+func TestVCIDeviceTable(t *testing.T) {
+	f := newFakeVCI(2)
+	b := newVCIBackend(f)
+	if hr := b.SelectDevice(false, 2); hr != VCI_OK {
+		t.Fatalf("SelectDevice 2: 0x%08X", hr)
+	}
+	if hr := b.SelectDevice(true, 5); hr != VCI_OK {
+		t.Fatalf("SelectDevice 5: 0x%08X", hr)
+	}
+	if !contains(f.calls, "DeviceOpen [100]") || !contains(f.calls, "DeviceOpen [101]") {
+		t.Errorf("calls %q", f.calls)
+	}
+	if hr := b.SelectDevice(false, vciDevMax); hr != VCI_E_INVALIDARG {
+		t.Errorf("SelectDevice %d: 0x%08X", vciDevMax, hr)
+	}
+	if hr := b.OpenChannel(0, 0x00, 0x1C); hr != VCI_E_INVHANDLE {
+		t.Errorf("OpenChannel of a device not selected: 0x%08X", hr)
+	}
+
+	b.SetAccessMode(5, AccessExclusive)
+	b.OpenChannel(2, 0x00, 0x1C)
+	b.OpenChannel(5, 0x00, 0x14)
+	b.Send(2, 0x222, false, []byte{2})
+	b.Send(5, 0x555, false, []byte{5})
+
+	b.mu.Lock()
+	d2, d5 := b.devs[2], b.devs[5]
+	b.mu.Unlock()
+	if d2.device == d5.device || d2.chn == d5.chn || d2.ctl == d5.ctl {
+		t.Fatalf("devices share handles: %+v %+v", d2, d5)
+	}
+	if tx := f.tx[d2.chn]; len(tx) != 1 || tx[0].MsgID != 0x222 {
+		t.Errorf("device 2 sent %+v", tx)
+	}
+	if tx := f.tx[d5.chn]; len(tx) != 1 || tx[0].MsgID != 0x555 {
+		t.Errorf("device 5 sent %+v", tx)
+	}
+	f.rx[d5.chn] = []canMsg{{MsgID: 0x55, Flags: 1}}
+	if hr, _, _, _, _ := b.Receive(2); hr != VCI_E_RXQUEUE_EMPTY {
+		t.Errorf("device 2 received a frame of device 5: 0x%08X", hr)
+	}
+	if hr, id, _, _, _ := b.Receive(5); hr != VCI_OK || id != 0x55 {
+		t.Errorf("device 5 receive: 0x%08X %X", hr, id)
+	}
+	if own, _ := b.Ownership(5); own.Mode != AccessExclusive {
+		t.Errorf("device 5 Ownership = %+v", own)
+	}
+	if own, _ := b.Ownership(2); own.Mode != AccessShared {
+		t.Errorf("device 2 Ownership = %+v", own)
+	}
+
+	b.CloseDevice(2)
+	if own, _ := b.Ownership(5); !own.Opened {
+		t.Error("closing device 2 closed device 5")
+	}
+	if hr := b.Send(5, 0x555, false, nil); hr != VCI_OK {
+		t.Errorf("device 5 send after closing device 2: 0x%08X", hr)
+	}
+	b.CloseDevice(5)
+	if len(f.open) != 0 {
+		t.Errorf("handles left open: %v", f.open)
+	}
+}
+
+func TestVCIListDevices(t *testing.T) {
+	f := newFakeVCI(3)
+	list, hr := newVCIBackend(f).listDevices()
+	if hr != VCI_OK || len(list) != 3 {
+		t.Fatalf("listDevices = %v, 0x%08X", list, hr)
+	}
+	if list[1] != (DeviceInfo{Description: "USB-to-CAN V2", HardwareID: "HW000001", Manufacturer: "IXXAT"}) {
+		t.Errorf("device 1 = %+v", list[1])
+	}
+	if len(f.open) != 0 {
+		t.Errorf("handles left open: %v", f.open)
+	}
+}
+
+func TestVCIErrorText(t *testing.T) {
+	want := fmt.Sprintf("VCI error 0x%08X", uint32(VCI_E_TIMEOUT))
+	if s := newVCIBackend(newFakeVCI(0)).errorText(VCI_E_TIMEOUT); s != want {
+		t.Errorf("errorText = %q", s)
+	}
+}
+
+// onlyDevice reports whether the device handle is the only open one.
+func onlyDevice(open map[vciHandle]string) bool {
+	for _, kind := range open {
+		if kind != "device" {
+			return false
+		}
+	}
+	return 1 == len(open)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
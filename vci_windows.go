@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package ixxatvci3
+
+// vciNative is the VCI backend on the vcinpl of the build: linked with cgo or loaded without it.
+var vciNative = newVCIBackend(vcinplAPI)
+
+var native Backend = vciNative
+
+// ListDevices returns available USB-to-CAN devices.
+func ListDevices() (list []DeviceInfo, vcierr uint32) {
+	return vciNative.listDevices()
+}
+
+// GetErrorText returns VCI error text by code
+func GetErrorText(vcierr uint32) string {
+	return vciNative.errorText(vcierr)
+}
@@ -0,0 +1,162 @@
+package ixxatvci3
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// vciHandle is a VCI object handle: device, controller, channel or device list.
+type vciHandle uintptr
+
+// vciID is the unique VCI object identifier of a device (VCIID).
+type vciID int64
+
+// vciAPI is the table of vcinpl functions used by the VCI backend, with the
+// structures already converted to Go types. Results are the HRESULT codes.
+type vciAPI interface {
+	EnumDeviceOpen() (hEnum vciHandle, hr uint32)
+	EnumDeviceNext(hEnum vciHandle) (info vciDeviceInfo, hr uint32)
+	EnumDeviceClose(hEnum vciHandle) (hr uint32)
+	SelectDeviceDlg() (id vciID, hr uint32)
+	DeviceOpen(id vciID) (hDevice vciHandle, hr uint32)
+	DeviceClose(hDevice vciHandle) (hr uint32)
+
+	ControlOpen(hDevice vciHandle, canNo uint32) (hCtl vciHandle, hr uint32)
+	ControlClose(hCtl vciHandle) (hr uint32)
+	ControlGetStatus(hCtl vciHandle) (status CANLineStatus, hr uint32)
+	ControlDetectBitrate(hCtl vciHandle, timeoutMs uint16, btr0, btr1 []byte) (index int32, hr uint32)
+	ControlInitialize(hCtl vciHandle, mode, btr0, btr1 uint8) (hr uint32)
+	ControlReset(hCtl vciHandle) (hr uint32)
+	ControlStart(hCtl vciHandle, start bool) (hr uint32)
+	ControlSetAccFilter(hCtl vciHandle, extended bool, code, mask uint32) (hr uint32)
+
+	ChannelOpen(hDevice vciHandle, canNo uint32, exclusive bool) (hChn vciHandle, hr uint32)
+	ChannelClose(hChn vciHandle) (hr uint32)
+	ChannelGetCaps(hChn vciHandle) (caps canCapabilities, hr uint32)
+	ChannelGetStatus(hChn vciHandle) (status CANChanStatus, hr uint32)
+	ChannelInitialize(hChn vciHandle, rxSize, rxThreshold, txSize, txThreshold uint16) (hr uint32)
+	ChannelActivate(hChn vciHandle, enable bool) (hr uint32)
+	ChannelPeekMessage(hChn vciHandle) (msg canMsg, hr uint32)
+	ChannelReadMessage(hChn vciHandle, timeoutMs uint32) (msg canMsg, hr uint32)
+	ChannelPostMessage(hChn vciHandle, msg canMsg) (hr uint32)
+	ChannelSendMessage(hChn vciHandle, timeoutMs uint32, msg canMsg) (hr uint32)
+
+	FormatError(hr uint32) string
+}
+
+// Sizes of the byte packed VCI structures.
+const (
+	canMsgSize          = 20
+	canLineStatusSize   = 8
+	canChanStatusSize   = 18
+	canCapabilitiesSize = 32
+	vciDeviceInfoSize   = 304
+)
+
+// CANMSGINFO message types and flags
+const (
+	canMsgTypeData  = 0 // CAN_MSGTYPE_DATA
+	canMsgTypeError = 2 // CAN_MSGTYPE_ERROR
+
+	canMsgFlagsDLC = 0x0F // CAN_MSGFLAGS_DLC
+	canMsgFlagsOVR = 0x10 // CAN_MSGFLAGS_OVR
+	canMsgFlagsSRR = 0x20 // CAN_MSGFLAGS_SRR
+	canMsgFlagsRTR = 0x40 // CAN_MSGFLAGS_RTR
+	canMsgFlagsEXT = 0x80 // CAN_MSGFLAGS_EXT
+)
+
+// CANCAPABILITIES features and bus coupling
+const (
+	canFeatureStdOrExt  = 0x0001 // CAN_FEATURE_STDOREXT
+	canFeatureStdAndExt = 0x0002 // CAN_FEATURE_STDANDEXT
+	canFeatureErrFrame  = 0x0008 // CAN_FEATURE_ERRFRAME
+	canFeatureListOnly  = 0x0040 // CAN_FEATURE_LISTONLY
+	canBusCouplingLow   = 0x0001 // CAN_BUSC_LOWSPEED
+)
+
+// canMsg is CANMSG with the CANMSGINFO bytes.
+type canMsg struct {
+	Time     uint32
+	MsgID    uint32
+	Type     uint8 // CAN_MSGTYPE_*
+	AddFlags uint8 // CAN_MSGADDFLAGS_*
+	Flags    uint8 // CAN_MSGFLAGS_*: DLC, overrun, self reception, remote, extended
+	Accept   uint8
+	Data     [8]byte
+}
+
+func (m *canMsg) dlc() uint8 {
+	return m.Flags & canMsgFlagsDLC
+}
+
+func (m *canMsg) marshal(b *[canMsgSize]byte) {
+	binary.LittleEndian.PutUint32(b[0:], m.Time)
+	binary.LittleEndian.PutUint32(b[4:], m.MsgID)
+	b[8], b[9], b[10], b[11] = m.Type, m.AddFlags, m.Flags, m.Accept
+	copy(b[12:], m.Data[:])
+}
+
+func (m *canMsg) unmarshal(b *[canMsgSize]byte) {
+	m.Time = binary.LittleEndian.Uint32(b[0:])
+	m.MsgID = binary.LittleEndian.Uint32(b[4:])
+	m.Type, m.AddFlags, m.Flags, m.Accept = b[8], b[9], b[10], b[11]
+	copy(m.Data[:], b[12:])
+}
+
+func unmarshalLineStatus(b []byte) (s CANLineStatus) {
+	s.OpMode, s.BtReg0, s.BtReg1, s.BusLoad = b[0], b[1], b[2], b[3]
+	s.Status = binary.LittleEndian.Uint32(b[4:])
+	return
+}
+
+func unmarshalChanStatus(b *[canChanStatusSize]byte) (s CANChanStatus) {
+	s.LineStatus = unmarshalLineStatus(b[:canLineStatusSize])
+	s.Activated = binary.LittleEndian.Uint32(b[8:])
+	s.RxOverrun = binary.LittleEndian.Uint32(b[12:])
+	s.RxFifoLoad, s.TxFifoLoad = b[16], b[17]
+	return
+}
+
+// canCapabilities is CANCAPABILITIES.
+type canCapabilities struct {
+	CtrlType    uint16
+	BusCoupling uint16 // CAN_BUSC_*
+	Features    uint32 // CAN_FEATURE_*
+	ClockFreq   uint32
+	TscDivisor  uint32
+	CmsDivisor  uint32
+	CmsMaxTicks uint32
+	DtxDivisor  uint32
+	DtxMaxTicks uint32
+}
+
+func (c *canCapabilities) unmarshal(b *[canCapabilitiesSize]byte) {
+	le := binary.LittleEndian
+	c.CtrlType, c.BusCoupling = le.Uint16(b[0:]), le.Uint16(b[2:])
+	c.Features, c.ClockFreq, c.TscDivisor = le.Uint32(b[4:]), le.Uint32(b[8:]), le.Uint32(b[12:])
+	c.CmsDivisor, c.CmsMaxTicks = le.Uint32(b[16:]), le.Uint32(b[20:])
+	c.DtxDivisor, c.DtxMaxTicks = le.Uint32(b[24:]), le.Uint32(b[28:])
+}
+
+// vciDeviceInfo is the part of VCIDEVICEINFO used by the backend.
+type vciDeviceInfo struct {
+	ObjectID     vciID
+	HardwareID   string // UniqueHardwareId as text
+	Description  string
+	Manufacturer string
+}
+
+func (d *vciDeviceInfo) unmarshal(b *[vciDeviceInfoSize]byte) {
+	d.ObjectID = vciID(binary.LittleEndian.Uint64(b[0:]))
+	d.HardwareID = cString(b[32:48])
+	d.Description = cString(b[48:176])
+	d.Manufacturer = cString(b[176:302])
+}
+
+// cString returns the text of b up to the first zero byte.
+func cString(b []byte) string {
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		b = b[:n]
+	}
+	return string(b)
+}
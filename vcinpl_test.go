@@ -0,0 +1,173 @@
+package ixxatvci3
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The fixtures are laid out by the byte packed structures of inc/CANtype.h and inc/vcitype.h.
+
+func TestVCIStructSizes(t *testing.T) {
+	sizes := []struct {
+		name       string
+		size, want int
+	}{
+		{"CANMSG", canMsgSize, 4 + 4 + 4 + 8},
+		{"CANLINESTATUS", canLineStatusSize, 1 + 1 + 1 + 1 + 4},
+		{"CANCHANSTATUS", canChanStatusSize, 8 + 4 + 4 + 1 + 1},
+		{"CANCAPABILITIES", canCapabilitiesSize, 2 + 2 + 7*4},
+		// VCIID, GUID, driver and hardware versions, UniqueHardwareId, Description, Manufacturer, DriverReleaseVersion
+		{"VCIDEVICEINFO", vciDeviceInfoSize, 8 + 16 + 4 + 4 + 16 + 128 + 126 + 2},
+	}
+	for _, s := range sizes {
+		if s.size != s.want {
+			t.Errorf("%s size %d, want %d", s.name, s.size, s.want)
+		}
+	}
+	if canMsgSize != 20 || canLineStatusSize != 8 || canChanStatusSize != 18 || canCapabilitiesSize != 32 || vciDeviceInfoSize != 304 {
+		t.Error("structure sizes differ from the VCI headers")
+	}
+}
+
+// canMsgFixture is CANMSG dwTime 0x11223344, dwMsgId 0x18DAF110,
+// uMsgInfo bType DATA, bAddFlags 0, bFlags DLC 8 | SRR | EXT, bAccept 0xFF, abData 1..8.
+var canMsgFixture = [canMsgSize]byte{
+	0x44, 0x33, 0x22, 0x11, // dwTime
+	0x10, 0xF1, 0xDA, 0x18, // dwMsgId
+	0x00, 0x00, 0xA8, 0xFF, // uMsgInfo.Bytes
+	1, 2, 3, 4, 5, 6, 7, 8, // abData
+}
+
+func TestCanMsgMarshal(t *testing.T) {
+	want := canMsg{Time: 0x11223344, MsgID: 0x18DAF110, Type: canMsgTypeData,
+		Flags: 8 | canMsgFlagsSRR | canMsgFlagsEXT, Accept: 0xFF, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	var m canMsg
+	m.unmarshal(&canMsgFixture)
+	if m != want {
+		t.Errorf("unmarshal = %+v, want %+v", m, want)
+	}
+	if m.dlc() != 8 {
+		t.Errorf("dlc = %d", m.dlc())
+	}
+
+	var b [canMsgSize]byte
+	want.marshal(&b)
+	if b != canMsgFixture {
+		t.Errorf("marshal = % X\nwant      % X", b, canMsgFixture)
+	}
+}
+
+// TestCanMsgInfoBits checks the flag constants against the CANMSGINFO.Bits bit field:
+// type 0-7, ssm 8, hi 9-10, res 11-15, dlc 16-19, ovr 20, srr 21, rtr 22, ext 23, afc 24-31.
+func TestCanMsgInfoBits(t *testing.T) {
+	bits := func(bit, n uint) uint8 { return uint8((uint32(1)<<n - 1) << bit >> 16) } // within bFlags, bits 16-23
+	flags := []struct {
+		name       string
+		flag, want uint8
+	}{
+		{"dlc", canMsgFlagsDLC, bits(16, 4)},
+		{"ovr", canMsgFlagsOVR, bits(20, 1)},
+		{"srr", canMsgFlagsSRR, bits(21, 1)},
+		{"rtr", canMsgFlagsRTR, bits(22, 1)},
+		{"ext", canMsgFlagsEXT, bits(23, 1)},
+	}
+	for _, f := range flags {
+		if f.flag != f.want {
+			t.Errorf("%s flag 0x%02X, want 0x%02X", f.name, f.flag, f.want)
+		}
+	}
+
+	// dlc above 8 as sent by the controller, rtr without data
+	m := canMsg{MsgID: 0x7FF, Flags: 0x0F | canMsgFlagsRTR | canMsgFlagsOVR}
+	var b [canMsgSize]byte
+	m.marshal(&b)
+	if b[10] != 0x5F || b[8] != canMsgTypeData {
+		t.Errorf("uMsgInfo = % X", b[8:12])
+	}
+	var back canMsg
+	back.unmarshal(&b)
+	if back != m || back.dlc() != 15 {
+		t.Errorf("round trip = %+v, dlc %d", back, back.dlc())
+	}
+}
+
+func TestUnmarshalLineStatus(t *testing.T) {
+	b := []byte{
+		0x03,       // bOpMode STANDARD | EXTENDED
+		0x00, 0x1C, // bBtReg0, bBtReg1
+		42,                     // bBusLoad
+		0x04, 0x03, 0x02, 0x01, // dwStatus
+	}
+	want := CANLineStatus{OpMode: 3, BtReg0: 0x00, BtReg1: 0x1C, BusLoad: 42, Status: 0x01020304}
+	if s := unmarshalLineStatus(b); s != want {
+		t.Errorf("unmarshalLineStatus = %+v, want %+v", s, want)
+	}
+}
+
+func TestUnmarshalChanStatus(t *testing.T) {
+	b := [canChanStatusSize]byte{
+		0x01, 0x03, 0x1C, 7, 0x10, 0x00, 0x00, 0x00, // sLineStatus
+		0x01, 0x00, 0x00, 0x00, // fActivated
+		0x01, 0x00, 0x00, 0x00, // fRxOverrun
+		25, 75, // bRxFifoLoad, bTxFifoLoad
+	}
+	want := CANChanStatus{
+		LineStatus: CANLineStatus{OpMode: 1, BtReg0: 0x03, BtReg1: 0x1C, BusLoad: 7, Status: 0x10},
+		Activated:  1, RxOverrun: 1, RxFifoLoad: 25, TxFifoLoad: 75,
+	}
+	if s := unmarshalChanStatus(&b); s != want {
+		t.Errorf("unmarshalChanStatus = %+v, want %+v", s, want)
+	}
+}
+
+func TestCanCapabilitiesUnmarshal(t *testing.T) {
+	b := [canCapabilitiesSize]byte{
+		0x07, 0x00, // wCtrlType
+		0x03, 0x00, // wBusCoupling
+		0x4B, 0x00, 0x00, 0x00, // dwFeatures
+		0x00, 0x1B, 0xB7, 0x00, // dwClockFreq 12000000
+		0x0C, 0x00, 0x00, 0x00, // dwTscDivisor
+		0x0D, 0x00, 0x00, 0x00, // dwCmsDivisor
+		0x0E, 0x00, 0x00, 0x00, // dwCmsMaxTicks
+		0x0F, 0x00, 0x00, 0x00, // dwDtxDivisor
+		0x10, 0x00, 0x01, 0x00, // dwDtxMaxTicks
+	}
+	want := canCapabilities{CtrlType: 7, BusCoupling: 3,
+		Features:  canFeatureStdOrExt | canFeatureStdAndExt | canFeatureErrFrame | canFeatureListOnly,
+		ClockFreq: 12000000, TscDivisor: 12, CmsDivisor: 13, CmsMaxTicks: 14, DtxDivisor: 15, DtxMaxTicks: 0x10010}
+	var c canCapabilities
+	c.unmarshal(&b)
+	if c != want {
+		t.Errorf("unmarshal = %+v, want %+v", c, want)
+	}
+}
+
+func TestVCIDeviceInfoUnmarshal(t *testing.T) {
+	var b [vciDeviceInfoSize]byte
+	copy(b[0:], []byte{0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11}) // VciObjectId
+	for i := 8; i < 24; i++ {
+		b[i] = 0xEE // DeviceClass
+	}
+	copy(b[24:], []byte{1, 2, 3, 0, 4, 5, 6, 7}) // driver and hardware versions
+	copy(b[32:48], "HW123456")                   // UniqueHardwareId.AsChar
+	copy(b[48:176], "USB-to-CAN V2 compact")     // Description
+	copy(b[176:302], "IXXAT Automation GmbH")    // Manufacturer
+	b[302], b[303] = 0xAA, 0xBB                  // DriverReleaseVersion
+
+	var d vciDeviceInfo
+	d.unmarshal(&b)
+	want := vciDeviceInfo{ObjectID: 0x1122334455667788, HardwareID: "HW123456",
+		Description: "USB-to-CAN V2 compact", Manufacturer: "IXXAT Automation GmbH"}
+	if d != want {
+		t.Errorf("unmarshal = %+v, want %+v", d, want)
+	}
+
+	// texts filling their fields have no terminating zero
+	copy(b[32:48], bytes.Repeat([]byte{'H'}, 16))
+	copy(b[176:302], bytes.Repeat([]byte{'M'}, 126))
+	d.unmarshal(&b)
+	if d.HardwareID != string(bytes.Repeat([]byte{'H'}, 16)) || len(d.Manufacturer) != 126 {
+		t.Errorf("unterminated texts: %q, %d bytes", d.HardwareID, len(d.Manufacturer))
+	}
+}
@@ -0,0 +1,211 @@
+//go:build windows && !cgo
+// +build windows,!cgo
+
+package ixxatvci3
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// vcinpl.dll is loaded on first use, so programs start without the VCI installed.
+var (
+	vcinpl = syscall.NewLazyDLL("vcinpl.dll")
+
+	procEnumDeviceOpen       = vcinpl.NewProc("vciEnumDeviceOpen")
+	procEnumDeviceNext       = vcinpl.NewProc("vciEnumDeviceNext")
+	procEnumDeviceClose      = vcinpl.NewProc("vciEnumDeviceClose")
+	procSelectDeviceDlg      = vcinpl.NewProc("vciSelectDeviceDlg")
+	procDeviceOpen           = vcinpl.NewProc("vciDeviceOpen")
+	procDeviceClose          = vcinpl.NewProc("vciDeviceClose")
+	procControlOpen          = vcinpl.NewProc("canControlOpen")
+	procControlClose         = vcinpl.NewProc("canControlClose")
+	procControlGetStatus     = vcinpl.NewProc("canControlGetStatus")
+	procControlDetectBitrate = vcinpl.NewProc("canControlDetectBitrate")
+	procControlInitialize    = vcinpl.NewProc("canControlInitialize")
+	procControlReset         = vcinpl.NewProc("canControlReset")
+	procControlStart         = vcinpl.NewProc("canControlStart")
+	procControlSetAccFilter  = vcinpl.NewProc("canControlSetAccFilter")
+	procChannelOpen          = vcinpl.NewProc("canChannelOpen")
+	procChannelClose         = vcinpl.NewProc("canChannelClose")
+	procChannelGetCaps       = vcinpl.NewProc("canChannelGetCaps")
+	procChannelGetStatus     = vcinpl.NewProc("canChannelGetStatus")
+	procChannelInitialize    = vcinpl.NewProc("canChannelInitialize")
+	procChannelActivate      = vcinpl.NewProc("canChannelActivate")
+	procChannelPeekMessage   = vcinpl.NewProc("canChannelPeekMessage")
+	procChannelReadMessage   = vcinpl.NewProc("canChannelReadMessage")
+	procChannelPostMessage   = vcinpl.NewProc("canChannelPostMessage")
+	procChannelSendMessage   = vcinpl.NewProc("canChannelSendMessage")
+	procFormatError          = vcinpl.NewProc("vciFormatError")
+)
+
+// dllAPI calls vcinpl.dll with syscall, without cgo.
+type dllAPI struct{}
+
+var vcinplAPI vciAPI = dllAPI{}
+
+// call returns the HRESULT of proc, VCI_E_NOT_IMPLEMENTED if vcinpl.dll or the function is missing.
+func call(proc *syscall.LazyProc, args ...uintptr) uint32 {
+	if proc.Find() != nil {
+		return VCI_E_NOT_IMPLEMENTED
+	}
+	r, _, _ := proc.Call(args...)
+	return uint32(r)
+}
+
+func boolArg(b bool) uintptr {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (dllAPI) EnumDeviceOpen() (hEnum vciHandle, hr uint32) {
+	hr = call(procEnumDeviceOpen, uintptr(unsafe.Pointer(&hEnum)))
+	return
+}
+
+func (dllAPI) EnumDeviceNext(hEnum vciHandle) (info vciDeviceInfo, hr uint32) {
+	var b [vciDeviceInfoSize]byte
+	hr = call(procEnumDeviceNext, uintptr(hEnum), uintptr(unsafe.Pointer(&b)))
+	if VCI_OK == hr {
+		info.unmarshal(&b)
+	}
+	return
+}
+
+func (dllAPI) EnumDeviceClose(hEnum vciHandle) uint32 {
+	return call(procEnumDeviceClose, uintptr(hEnum))
+}
+
+func (dllAPI) SelectDeviceDlg() (id vciID, hr uint32) {
+	hr = call(procSelectDeviceDlg, 0, uintptr(unsafe.Pointer(&id)))
+	return
+}
+
+func (dllAPI) DeviceOpen(id vciID) (hDevice vciHandle, hr uint32) {
+	hr = call(procDeviceOpen, uintptr(unsafe.Pointer(&id)), uintptr(unsafe.Pointer(&hDevice)))
+	return
+}
+
+func (dllAPI) DeviceClose(hDevice vciHandle) uint32 {
+	return call(procDeviceClose, uintptr(hDevice))
+}
+
+func (dllAPI) ControlOpen(hDevice vciHandle, canNo uint32) (hCtl vciHandle, hr uint32) {
+	hr = call(procControlOpen, uintptr(hDevice), uintptr(canNo), uintptr(unsafe.Pointer(&hCtl)))
+	return
+}
+
+func (dllAPI) ControlClose(hCtl vciHandle) uint32 {
+	return call(procControlClose, uintptr(hCtl))
+}
+
+func (dllAPI) ControlGetStatus(hCtl vciHandle) (status CANLineStatus, hr uint32) {
+	var b [canLineStatusSize]byte
+	hr = call(procControlGetStatus, uintptr(hCtl), uintptr(unsafe.Pointer(&b)))
+	if VCI_OK == hr {
+		status = unmarshalLineStatus(b[:])
+	}
+	return
+}
+
+func (dllAPI) ControlDetectBitrate(hCtl vciHandle, timeoutMs uint16, btr0, btr1 []byte) (index int32, hr uint32) {
+	if 0 == len(btr0) || len(btr0) != len(btr1) {
+		return -1, VCI_E_INVALIDARG
+	}
+	hr = call(procControlDetectBitrate, uintptr(hCtl), uintptr(timeoutMs), uintptr(len(btr0)),
+		uintptr(unsafe.Pointer(&btr0[0])), uintptr(unsafe.Pointer(&btr1[0])), uintptr(unsafe.Pointer(&index)))
+	return
+}
+
+func (dllAPI) ControlInitialize(hCtl vciHandle, mode, btr0, btr1 uint8) uint32 {
+	return call(procControlInitialize, uintptr(hCtl), uintptr(mode), uintptr(btr0), uintptr(btr1))
+}
+
+func (dllAPI) ControlReset(hCtl vciHandle) uint32 {
+	return call(procControlReset, uintptr(hCtl))
+}
+
+func (dllAPI) ControlStart(hCtl vciHandle, start bool) uint32 {
+	return call(procControlStart, uintptr(hCtl), boolArg(start))
+}
+
+func (dllAPI) ControlSetAccFilter(hCtl vciHandle, extended bool, code, mask uint32) uint32 {
+	return call(procControlSetAccFilter, uintptr(hCtl), boolArg(extended), uintptr(code), uintptr(mask))
+}
+
+func (dllAPI) ChannelOpen(hDevice vciHandle, canNo uint32, exclusive bool) (hChn vciHandle, hr uint32) {
+	hr = call(procChannelOpen, uintptr(hDevice), uintptr(canNo), boolArg(exclusive), uintptr(unsafe.Pointer(&hChn)))
+	return
+}
+
+func (dllAPI) ChannelClose(hChn vciHandle) uint32 {
+	return call(procChannelClose, uintptr(hChn))
+}
+
+func (dllAPI) ChannelGetCaps(hChn vciHandle) (caps canCapabilities, hr uint32) {
+	var b [canCapabilitiesSize]byte
+	hr = call(procChannelGetCaps, uintptr(hChn), uintptr(unsafe.Pointer(&b)))
+	if VCI_OK == hr {
+		caps.unmarshal(&b)
+	}
+	return
+}
+
+func (dllAPI) ChannelGetStatus(hChn vciHandle) (status CANChanStatus, hr uint32) {
+	var b [canChanStatusSize]byte
+	hr = call(procChannelGetStatus, uintptr(hChn), uintptr(unsafe.Pointer(&b)))
+	if VCI_OK == hr {
+		status = unmarshalChanStatus(&b)
+	}
+	return
+}
+
+func (dllAPI) ChannelInitialize(hChn vciHandle, rxSize, rxThreshold, txSize, txThreshold uint16) uint32 {
+	return call(procChannelInitialize, uintptr(hChn),
+		uintptr(rxSize), uintptr(rxThreshold), uintptr(txSize), uintptr(txThreshold))
+}
+
+func (dllAPI) ChannelActivate(hChn vciHandle, enable bool) uint32 {
+	return call(procChannelActivate, uintptr(hChn), boolArg(enable))
+}
+
+func (dllAPI) ChannelPeekMessage(hChn vciHandle) (msg canMsg, hr uint32) {
+	var b [canMsgSize]byte
+	hr = call(procChannelPeekMessage, uintptr(hChn), uintptr(unsafe.Pointer(&b)))
+	if VCI_OK == hr {
+		msg.unmarshal(&b)
+	}
+	return
+}
+
+func (dllAPI) ChannelReadMessage(hChn vciHandle, timeoutMs uint32) (msg canMsg, hr uint32) {
+	var b [canMsgSize]byte
+	hr = call(procChannelReadMessage, uintptr(hChn), uintptr(timeoutMs), uintptr(unsafe.Pointer(&b)))
+	if VCI_OK == hr {
+		msg.unmarshal(&b)
+	}
+	return
+}
+
+func (dllAPI) ChannelPostMessage(hChn vciHandle, msg canMsg) uint32 {
+	var b [canMsgSize]byte
+	msg.marshal(&b)
+	return call(procChannelPostMessage, uintptr(hChn), uintptr(unsafe.Pointer(&b)))
+}
+
+func (dllAPI) ChannelSendMessage(hChn vciHandle, timeoutMs uint32, msg canMsg) uint32 {
+	var b [canMsgSize]byte
+	msg.marshal(&b)
+	return call(procChannelSendMessage, uintptr(hChn), uintptr(timeoutMs), uintptr(unsafe.Pointer(&b)))
+}
+
+func (dllAPI) FormatError(hr uint32) string {
+	var b [vciMaxErrStrLen]byte
+	if procFormatError.Find() != nil {
+		return ""
+	}
+	procFormatError.Call(uintptr(hr), uintptr(unsafe.Pointer(&b)), uintptr(len(b)))
+	return cString(b[:])
+}
@@ -0,0 +1,398 @@
+// Package watchdog supervises cyclic CAN messages: it raises events when a message
+// stops (timeout), arrives out of its period, or carries a wrong alive counter or checksum.
+//
+//	w := watchdog.New(dev, watchdog.Options{OnEvent: func(e watchdog.Event) { log.Println(e) }})
+//	w.Watch(watchdog.Spec{ID: 0x100, Period: 10 * time.Millisecond, Tolerance: 2 * time.Millisecond,
+//		Counter: &e2e.Counter{Byte: 6, Bits: 4}, Checksum: &e2e.Checksum{Byte: 7, Func: e2e.CRC8SAEJ1850}})
+//	w.Start()
+//	defer w.Stop()
+//	fmt.Print(w.Report())
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/e2e"
+)
+
+// Bus is a supervised bus: *candev.Device or *candev.Loopback.
+type Bus interface {
+	GetMsgChannelCopy() (ch <-chan candev.Message, idx uint)
+	CloseMsgChannelCopy(idx uint)
+}
+
+// Spec is the expected timing and protection of a message.
+type Spec struct {
+	ID        uint32
+	Ext       bool          // 29-bit ID, implied by IDs above 0x7FF
+	Period    time.Duration // expected interval between frames
+	Tolerance time.Duration // allowed deviation of an interval from Period, Period/10 by default
+	Timeout   time.Duration // time without frames raising Timeout, 3 periods by default
+	Counter   *e2e.Counter  // alive counter increasing by one per frame, nil if none
+	Checksum  *e2e.Checksum // checksum, nil if none
+}
+
+// Kind is a type of event.
+type Kind int
+
+// Event kinds
+const (
+	Timeout         Kind = iota // no frame for Spec.Timeout
+	Recovered                   // frames again after a timeout
+	PeriodViolation             // an interval outside Period ± Tolerance
+	CounterError                // the alive counter did not advance by one
+	ChecksumError               // wrong checksum or data too short for it
+)
+
+var kindNames = []string{"timeout", "recovered", "period violation", "counter error", "checksum error"}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Event is a detected violation.
+type Event struct {
+	Kind     Kind
+	ID       uint32
+	Ext      bool
+	Time     time.Time
+	Interval time.Duration  // time since the previous frame for Timeout, Recovered and PeriodViolation
+	Expected uint8          // expected counter or checksum
+	Got      uint8          // received counter or checksum
+	Msg      candev.Message // the frame, zero for Timeout
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%s %s %s", e.Time.Format("15:04:05.000"), formatID(e.ID, e.Ext), e.Kind)
+	switch e.Kind {
+	case Timeout, Recovered, PeriodViolation:
+		s += fmt.Sprintf(" after %v", e.Interval)
+	case CounterError, ChecksumError:
+		s += fmt.Sprintf(" expected 0x%02X got 0x%02X", e.Expected, e.Got)
+	}
+	return s
+}
+
+// Options configure a Watchdog.
+type Options struct {
+	OnEvent    func(e Event) // called from the watchdog goroutine, must not block for long
+	Events     int           // capacity of the Events channel, events are dropped when it is full; 0 - no channel
+	CheckEvery time.Duration // timeout check interval, 5 ms by default
+	// Now is the time base of intervals and timeouts, time.Now by default. Frame timestamps
+	// are not used, so set it to the clock of a device built with candev.Builder.Clock,
+	// e.g. cantest.Clock.Now, for timeouts following that clock.
+	Now func() time.Time
+}
+
+// IDReport are the counts of a watched message.
+type IDReport struct {
+	Spec             Spec
+	Frames           uint64
+	Timeouts         uint64
+	PeriodViolations uint64
+	CounterErrors    uint64
+	ChecksumErrors   uint64
+	MinInterval      time.Duration
+	MaxInterval      time.Duration
+	LastSeen         time.Time // zero if never received
+	TimedOut         bool      // currently in timeout
+}
+
+// Report is the summary of all watched messages.
+type Report struct {
+	IDs     []IDReport // sorted by ID, 11-bit first
+	Dropped uint64     // events not delivered to the full Events channel
+}
+
+// OK reports whether no violation was detected.
+func (r Report) OK() bool {
+	for _, id := range r.IDs {
+		if id.Timeouts+id.PeriodViolations+id.CounterErrors+id.ChecksumErrors != 0 || id.TimedOut {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Report) String() string {
+	var sb strings.Builder
+	for _, id := range r.IDs {
+		status := "ok"
+		if id.TimedOut {
+			status = "TIMEOUT"
+		}
+		fmt.Fprintf(&sb, "%s period %v: %d frames, interval %v..%v, %d timeouts, %d period, %d counter, %d checksum errors, %s\n",
+			formatID(id.Spec.ID, id.Spec.Ext), id.Spec.Period, id.Frames, id.MinInterval, id.MaxInterval,
+			id.Timeouts, id.PeriodViolations, id.CounterErrors, id.ChecksumErrors, status)
+	}
+	if r.Dropped != 0 {
+		fmt.Fprintf(&sb, "%d events dropped\n", r.Dropped)
+	}
+	return sb.String()
+}
+
+// key identifies a watched message: 11-bit and 29-bit IDs of the same number differ.
+type key struct {
+	id  uint32
+	ext bool
+}
+
+func keyOf(id uint32, ext bool) key {
+	return key{id, ext || id > 0x7FF}
+}
+
+func formatID(id uint32, ext bool) string {
+	if ext || id > 0x7FF {
+		return fmt.Sprintf("%08X", id)
+	}
+	return fmt.Sprintf("%03X", id)
+}
+
+type watched struct {
+	report      IDReport
+	since       time.Time // last frame or the start of watching
+	counter     uint8
+	haveCounter bool
+}
+
+// Watchdog supervises messages received from a bus.
+type Watchdog struct {
+	bus    Bus
+	opts   Options
+	events chan Event
+
+	mu      sync.Mutex
+	ids     map[key]*watched
+	dropped uint64
+	running bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates a watchdog of bus, add messages with Watch and start it with Start.
+func New(bus Bus, opts Options) *Watchdog {
+	if opts.CheckEvery <= 0 {
+		opts.CheckEvery = 5 * time.Millisecond
+	}
+	if nil == opts.Now {
+		opts.Now = time.Now
+	}
+	w := &Watchdog{bus: bus, opts: opts, ids: make(map[key]*watched)}
+	if opts.Events > 0 {
+		w.events = make(chan Event, opts.Events)
+	}
+	return w
+}
+
+// Watch starts supervising a message, replacing an earlier spec of the ID and its counts.
+// IDs above 0x7FF are 29-bit, set Spec.Ext for smaller 29-bit IDs.
+// The timeout runs from now, so a message never received times out too.
+func (w *Watchdog) Watch(spec Spec) error {
+	if spec.Period <= 0 {
+		return errors.New("watchdog: period must be positive")
+	}
+	if 0 == spec.Tolerance {
+		spec.Tolerance = spec.Period / 10
+	}
+	if 0 == spec.Timeout {
+		spec.Timeout = 3 * spec.Period
+	}
+	spec.Ext = spec.Ext || spec.ID > 0x7FF
+	w.mu.Lock()
+	w.ids[keyOf(spec.ID, spec.Ext)] = &watched{report: IDReport{Spec: spec}, since: w.opts.Now()}
+	w.mu.Unlock()
+	return nil
+}
+
+// Unwatch stops supervising a message, IDs above 0x7FF are 29-bit.
+func (w *Watchdog) Unwatch(id uint32) {
+	w.unwatch(keyOf(id, false))
+}
+
+// UnwatchExt stops supervising a 29-bit message.
+func (w *Watchdog) UnwatchExt(id uint32) {
+	w.unwatch(keyOf(id, true))
+}
+
+func (w *Watchdog) unwatch(k key) {
+	w.mu.Lock()
+	delete(w.ids, k)
+	w.mu.Unlock()
+}
+
+// Events returns the channel of events if Options.Events is set, nil otherwise.
+func (w *Watchdog) Events() <-chan Event {
+	return w.events
+}
+
+// Start subscribes to the bus and starts the timeout checks.
+func (w *Watchdog) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running {
+		return
+	}
+	w.running = true
+	w.done = make(chan struct{})
+	now := w.opts.Now()
+	for _, wd := range w.ids {
+		if wd.report.LastSeen.IsZero() {
+			wd.since = now
+		}
+	}
+	ch, idx := w.bus.GetMsgChannelCopy()
+	w.wg.Add(1)
+	go w.run(ch, idx, w.done)
+}
+
+// Stop unsubscribes from the bus. The counts are kept for Report.
+func (w *Watchdog) Stop() {
+	w.mu.Lock()
+	if !w.running {
+		w.mu.Unlock()
+		return
+	}
+	w.running = false
+	close(w.done)
+	w.mu.Unlock()
+	w.wg.Wait()
+}
+
+func (w *Watchdog) run(ch <-chan candev.Message, idx uint, done chan struct{}) {
+	defer w.wg.Done()
+	defer w.bus.CloseMsgChannelCopy(idx)
+	ticker := time.NewTicker(w.opts.CheckEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			w.receive(msg)
+		case <-ticker.C:
+			w.checkTimeouts(w.opts.Now())
+		case <-done:
+			return
+		}
+	}
+}
+
+// receive checks a received frame at Options.Now, the same time base as the timeouts.
+func (w *Watchdog) receive(msg candev.Message) {
+	t := w.opts.Now()
+	var events []Event
+
+	w.mu.Lock()
+	wd, ok := w.ids[keyOf(msg.ID, msg.Ext)]
+	if !ok || msg.Rtr {
+		w.mu.Unlock()
+		return
+	}
+	r := &wd.report
+	spec := r.Spec
+	interval := t.Sub(wd.since)
+	if r.TimedOut {
+		r.TimedOut = false
+		events = append(events, Event{Kind: Recovered, ID: msg.ID, Ext: spec.Ext, Time: t, Interval: interval, Msg: msg})
+	} else if !r.LastSeen.IsZero() {
+		if 1 == r.Frames || interval < r.MinInterval {
+			r.MinInterval = interval
+		}
+		if interval > r.MaxInterval {
+			r.MaxInterval = interval
+		}
+		if deviation := interval - spec.Period; deviation > spec.Tolerance || -deviation > spec.Tolerance {
+			r.PeriodViolations++
+			events = append(events, Event{Kind: PeriodViolation, ID: msg.ID, Ext: spec.Ext, Time: t, Interval: interval, Msg: msg})
+		}
+	}
+	r.Frames++
+	r.LastSeen, wd.since = t, t
+
+	data := msg.Data[:msg.Len]
+	if c := spec.Counter; c != nil {
+		got := c.Get(data)
+		if !c.Inside(data) {
+			r.CounterErrors++
+			events = append(events, Event{Kind: CounterError, ID: msg.ID, Ext: spec.Ext, Time: t, Msg: msg})
+		} else if wd.haveCounter && got != c.Next(wd.counter) {
+			r.CounterErrors++
+			events = append(events, Event{Kind: CounterError, ID: msg.ID, Ext: spec.Ext, Time: t,
+				Expected: c.Next(wd.counter), Got: got, Msg: msg})
+		}
+		wd.counter, wd.haveCounter = got, c.Inside(data)
+	}
+	if s := spec.Checksum; s != nil && !s.Verify(msg.ID, data) {
+		r.ChecksumErrors++
+		e := Event{Kind: ChecksumError, ID: msg.ID, Ext: spec.Ext, Time: t, Expected: s.Compute(msg.ID, data), Msg: msg}
+		if s.Byte >= 0 && s.Byte < len(data) {
+			e.Got = data[s.Byte]
+		}
+		events = append(events, e)
+	}
+	w.mu.Unlock()
+
+	w.emit(events)
+}
+
+// checkTimeouts raises Timeout for messages silent for longer than their timeout.
+func (w *Watchdog) checkTimeouts(now time.Time) {
+	var events []Event
+	w.mu.Lock()
+	for k, wd := range w.ids {
+		r := &wd.report
+		if silent := now.Sub(wd.since); !r.TimedOut && silent > r.Spec.Timeout {
+			r.TimedOut = true
+			r.Timeouts++
+			wd.haveCounter = false // a restarted sender may start the counter anew
+			events = append(events, Event{Kind: Timeout, ID: k.id, Ext: k.ext, Time: now, Interval: silent})
+		}
+	}
+	w.mu.Unlock()
+	w.emit(events)
+}
+
+func (w *Watchdog) emit(events []Event) {
+	for _, e := range events {
+		if w.opts.OnEvent != nil {
+			w.opts.OnEvent(e)
+		}
+		if w.events != nil {
+			select {
+			case w.events <- e:
+			default:
+				w.mu.Lock()
+				w.dropped++
+				w.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Report returns the counts of all watched messages.
+func (w *Watchdog) Report() (r Report) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, wd := range w.ids {
+		r.IDs = append(r.IDs, wd.report)
+	}
+	sort.Slice(r.IDs, func(i, j int) bool {
+		a, b := r.IDs[i].Spec, r.IDs[j].Spec
+		if a.Ext != b.Ext {
+			return b.Ext
+		}
+		return a.ID < b.ID
+	})
+	r.Dropped = w.dropped
+	return
+}
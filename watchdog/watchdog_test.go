@@ -0,0 +1,115 @@
+package watchdog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amdf/ixxatvci3/candev"
+	"github.com/amdf/ixxatvci3/cantest"
+)
+
+// waitFor polls cond for a second of real time.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func frames(w *Watchdog, i int) func() bool {
+	return func() bool { return w.Report().IDs[i].Frames > 0 }
+}
+
+func TestClockTimeBase(t *testing.T) {
+	clock := cantest.NewClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	bus := new(candev.Loopback)
+	w := New(bus, Options{Now: clock.Now, Events: 16, CheckEvery: time.Millisecond})
+	w.Watch(Spec{ID: 0x100, Period: 10 * time.Millisecond})
+	w.Start()
+	defer w.Stop()
+
+	for i := 1; i <= 5; i++ {
+		clock.Advance(10 * time.Millisecond)
+		bus.Send(candev.Message{ID: 0x100, Len: 1, Time: clock.Now()})
+		want := uint64(i)
+		waitFor(t, "the frame", func() bool { return w.Report().IDs[0].Frames == want })
+	}
+	time.Sleep(5 * time.Millisecond) // timeout checks at the same clock time
+	if r := w.Report(); !r.OK() {
+		t.Fatalf("violations with frames on time:\n%s", r)
+	}
+
+	clock.Advance(31 * time.Millisecond)
+	select {
+	case e := <-w.Events():
+		if e.Kind != Timeout || e.ID != 0x100 || e.Interval != 31*time.Millisecond || !e.Time.Equal(clock.Now()) {
+			t.Errorf("event %v, want a timeout after 31ms", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no timeout")
+	}
+}
+
+func TestExtendedIDs(t *testing.T) {
+	clock := cantest.NewClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	bus := new(candev.Loopback)
+	w := New(bus, Options{Now: clock.Now, CheckEvery: time.Millisecond})
+	w.Watch(Spec{ID: 0x100, Period: 10 * time.Millisecond})
+	w.Watch(Spec{ID: 0x100, Ext: true, Period: 10 * time.Millisecond, Timeout: time.Hour})
+	w.Watch(Spec{ID: 0x18DAF110, Period: 10 * time.Millisecond, Timeout: time.Hour})
+	w.Start()
+	defer w.Stop()
+
+	r := w.Report()
+	if len(r.IDs) != 3 || r.IDs[0].Spec.Ext || !r.IDs[1].Spec.Ext || !r.IDs[2].Spec.Ext {
+		t.Fatalf("report order:\n%s", r)
+	}
+
+	bus.Send(candev.Message{ID: 0x100, Ext: true, Len: 1})
+	bus.Send(candev.Message{ID: 0x18DAF110, Len: 1})
+	waitFor(t, "the 29-bit frames", func() bool { return frames(w, 1)() && frames(w, 2)() })
+	clock.Advance(40 * time.Millisecond)
+	waitFor(t, "the 11-bit timeout", func() bool { return w.Report().IDs[0].TimedOut })
+
+	r = w.Report()
+	if r.IDs[0].Frames != 0 {
+		t.Errorf("29-bit frame counted for the 11-bit ID:\n%s", r)
+	}
+	if r.IDs[1].TimedOut || r.IDs[2].TimedOut {
+		t.Errorf("29-bit IDs timed out:\n%s", r)
+	}
+
+	w.UnwatchExt(0x100)
+	if r = w.Report(); len(r.IDs) != 2 || r.IDs[0].Spec.Ext {
+		t.Errorf("UnwatchExt removed the wrong ID:\n%s", r)
+	}
+}
+
+// TestExtendedIDsOnDevice watches a 29-bit ID below 0x800 received by a device,
+// which keeps the frame format reported by the backend.
+func TestExtendedIDsOnDevice(t *testing.T) {
+	b := cantest.New(nil)
+	dev, err := b.Builder().Number(204).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Run()
+	defer dev.Stop()
+
+	w := New(dev, Options{Now: b.Clock().Now, CheckEvery: time.Millisecond})
+	w.Watch(Spec{ID: 0x100, Period: 10 * time.Millisecond, Timeout: time.Hour})
+	w.Watch(Spec{ID: 0x100, Ext: true, Period: 10 * time.Millisecond, Timeout: time.Hour})
+	w.Start()
+	defer w.Stop()
+
+	b.Inject(0, candev.Message{ID: 0x100, Ext: true, Len: 1})
+	waitFor(t, "the 29-bit frame", frames(w, 1))
+	b.Inject(0, candev.Message{ID: 0x100, Len: 1})
+	waitFor(t, "the 11-bit frame", frames(w, 0))
+	if r := w.Report(); r.IDs[0].Frames != 1 || r.IDs[1].Frames != 1 {
+		t.Errorf("frames counted for the wrong format:\n%s", r)
+	}
+}